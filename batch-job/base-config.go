@@ -10,18 +10,38 @@ import (
 // It defines common settings such as job scheduling intervals and retry policies.
 type BaseConfig struct {
 	// Enabled determines if the batch job is active and should be processed
-	Enabled bool `json:"enabled"`
+	Enabled bool `json:"enabled" yaml:"enabled"`
 
 	// Interval specifies the frequency at which the job should run.
-	// Supports cron-like syntax and duration strings (e.g., "@daily", "10s", "1m", "1h")
-	Interval string `json:"interval"`
+	// Supports cron expressions with an optional leading seconds field (e.g., "0 30 9 * * MON-FRI"),
+	// descriptors (e.g., "@daily", "@every 90s") and the taskprocessor.ProcessingInterval constants
+	Interval string `json:"interval" yaml:"interval"`
 
 	// MaxRetries defines the maximum number of retry attempts for failed jobs
-	MaxRetries int64 `json:"maxRetries"`
+	MaxRetries int64 `json:"maxRetries" yaml:"maxRetries"`
+
+	// TimeZone is the IANA time zone the interval is evaluated in (e.g., "America/New_York").
+	// Defaults to UTC
+	TimeZone string `json:"timeZone" yaml:"timeZone"`
+
+	// Jitter is the upper bound of a start offset added to every run (e.g., "5m"), so that jobs
+	// scheduled at the same time across services do not start together. The offset is not random
+	// per run: the scheduler enqueues every run with the options the job was registered with, so
+	// the offset is derived from the jitter seed, task type, queue and job id instead
+	Jitter string `json:"jitter" yaml:"jitter"`
+
+	// JitterSeed is mixed into the start offset of the job, so that jobs sharing a task type, queue
+	// and id in different deployments get different offsets. Changing it moves the offset
+	JitterSeed string `json:"jitterSeed" yaml:"jitterSeed"`
+
+	// MissedRuns tells whether the runs missed while no scheduler was running are skipped, the
+	// default, or run once on start. Running them once is only supported by Batcher.Start
+	MissedRuns MissedRunsPolicy `json:"missedRuns" yaml:"missedRuns"`
 }
 
 // ProcessingInterval converts the string interval configuration into a structured
-// taskprocessor.ProcessingInterval value.
+// taskprocessor.ProcessingInterval value. Intervals other than the predefined ones, such as cron
+// expressions, are returned as they are.
 //
 // Example:
 //
@@ -31,20 +51,25 @@ func (c *BaseConfig) ProcessingInterval() taskprocessor.ProcessingInterval {
 	return toProcessingInterval(&c.Interval)
 }
 
+// Schedule parses the interval, time zone, jitter and missed runs policy of the configuration.
+func (c *BaseConfig) Schedule() (*Schedule, error) {
+	return ParseSchedule(*c)
+}
+
 // Validate checks the correctness of the base configuration values.
 // Returns an error if any values are invalid.
 //
 // Validation rules:
 // - MaxRetries must be non-negative
-// - Interval must not be empty
+// - Interval must be a valid cron expression or descriptor
+// - TimeZone must be an IANA time zone, Jitter a non-negative duration
 func (c *BaseConfig) Validate() error {
 	if c.MaxRetries < 0 {
 		return fmt.Errorf("maxRetries should be a non-negative integer")
 	}
 
-	// Check interval format validity (this doesn't validate every possible string but is a start)
-	if c.Interval == "" {
-		return fmt.Errorf("interval is empty")
+	if _, err := c.Schedule(); err != nil {
+		return err
 	}
 
 	return nil
@@ -96,8 +121,7 @@ func toProcessingInterval(str *string) taskprocessor.ProcessingInterval {
 	case "@every 30s":
 		return taskprocessor.Every30Seconds
 	default:
-		// If the string doesn't match any of the predefined intervals, handle it accordingly.
-		// I'll return EveryDay as a default for this example.
-		return taskprocessor.EveryDay
+		// cron expressions and other descriptors are validated by ParseSchedule
+		return taskprocessor.ProcessingInterval(*str)
 	}
 }
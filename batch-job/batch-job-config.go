@@ -2,6 +2,7 @@ package batch_job // import "github.com/SolomonAIEngineering/backend-core-librar
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/hibiken/asynq"
 )
@@ -12,6 +13,7 @@ type BatchJob struct {
 	BaseConfig
 	task   *asynq.Task
 	taskId *string
+	queue  string
 }
 
 // BatchJobConfigOption defines a function type for configuring BatchJob instances.
@@ -78,6 +80,74 @@ func WithTaskId(taskId *string) BatchJobConfigOption {
 	}
 }
 
+// WithQueue sets the queue the tasks of the batch job are enqueued to.
+//
+// Example:
+//
+//	job := NewBatchJob(WithQueue("low"))
+func WithQueue(queue string) BatchJobConfigOption {
+	return func(b *BatchJob) {
+		b.queue = queue
+	}
+}
+
+// ID returns the unique identifier of the batch job, or an empty string when it is not set.
+func (c *BatchJob) ID() string {
+	if c.taskId == nil {
+		return ""
+	}
+
+	return *c.taskId
+}
+
+// options returns the asynq options the tasks of the batch job are enqueued with.
+func (c *BatchJob) options(taskID string, delay time.Duration) []asynq.Option {
+	opts := []asynq.Option{
+		asynq.TaskID(taskID),
+		asynq.MaxRetry(int(c.MaxRetries)),
+	}
+
+	if c.queue != "" {
+		opts = append(opts, asynq.Queue(c.queue))
+	}
+
+	if delay > 0 {
+		opts = append(opts, asynq.ProcessIn(delay))
+	}
+
+	return opts
+}
+
+// recurringTask is a cron expression of the asynq scheduler and the options of the tasks it
+// enqueues for a batch job
+type recurringTask struct {
+	cronspec string
+	taskID   string
+	opts     []asynq.Option
+}
+
+// recurringTasks returns the cron expressions registering the batch job with the asynq scheduler.
+// The job must be valid.
+func (c *BatchJob) recurringTasks() ([]recurringTask, error) {
+	schedule, err := c.Schedule()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := schedule.entries(schedule.OffsetFor(c.ID(), c.task.Type(), c.queue))
+	tasks := make([]recurringTask, 0, len(entries))
+	for _, entry := range entries {
+		taskID := entry.taskID(c.ID(), len(entries))
+		tasks = append(tasks, recurringTask{
+			cronspec: entry.cronspec,
+			taskID:   taskID,
+			opts:     c.options(taskID, entry.delay),
+		})
+	}
+
+	return tasks, nil
+}
+
 // NewBatchJob creates a new BatchJob instance with the provided options.
 // It uses the functional options pattern to allow flexible configuration.
 //
@@ -0,0 +1,153 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigProvider is the source of the batch jobs of a Batcher. The Batcher reads it on start and
+// then periodically, so that jobs can be added, removed or rescheduled at runtime without a
+// redeploy.
+type ConfigProvider interface {
+	// BatchJobs returns the batch jobs to schedule. Disabled jobs are not scheduled.
+	BatchJobs(ctx context.Context) ([]*BatchJob, error)
+}
+
+// ConfigProviderFunc adapts a function to a ConfigProvider, for example to read batch jobs from a
+// Postgres table.
+type ConfigProviderFunc func(ctx context.Context) ([]*BatchJob, error)
+
+// BatchJobs implements ConfigProvider.
+func (f ConfigProviderFunc) BatchJobs(ctx context.Context) ([]*BatchJob, error) {
+	return f(ctx)
+}
+
+// NewStaticConfigProvider returns a ConfigProvider of the batch jobs of the collection.
+//
+// Example:
+//
+//	provider := NewStaticConfigProvider(NewBatchJobs(WithBatchJob(job)))
+func NewStaticConfigProvider(batchJobs *BatchJobs) ConfigProvider {
+	return ConfigProviderFunc(func(ctx context.Context) ([]*BatchJob, error) {
+		return batchJobs.jobs, nil
+	})
+}
+
+// JobDefinition is the serializable definition of a batch job read by the document config
+// providers.
+type JobDefinition struct {
+	BaseConfig `yaml:",inline"`
+
+	// ID is the unique identifier of the batch job
+	ID string `json:"id" yaml:"id"`
+	// TaskType is the type of the task enqueued on every run
+	TaskType string `json:"taskType" yaml:"taskType"`
+	// Payload is the payload of the task, usually JSON
+	Payload string `json:"payload" yaml:"payload"`
+	// Queue is the queue the task is enqueued to, the default queue when empty
+	Queue string `json:"queue" yaml:"queue"`
+}
+
+// BatchJob returns the batch job of the definition.
+func (d *JobDefinition) BatchJob() *BatchJob {
+	id := d.ID
+
+	var task *asynq.Task
+	if d.TaskType != "" {
+		task = asynq.NewTask(d.TaskType, []byte(d.Payload))
+	}
+
+	return NewBatchJob(
+		WithBaseConfig(d.BaseConfig),
+		WithTask(task),
+		WithTaskId(&id),
+		WithQueue(d.Queue),
+	)
+}
+
+// jobDocument is the document read by the document config providers.
+type jobDocument struct {
+	Jobs []JobDefinition `json:"jobs" yaml:"jobs"`
+}
+
+// NewDocumentConfigProvider returns a ConfigProvider of the batch jobs defined in the YAML or JSON
+// document returned by read.
+//
+// Example document:
+//
+//	jobs:
+//	  - id: daily-cleanup
+//	    taskType: cleanup
+//	    payload: '{"batchSize": 1000}'
+//	    enabled: true
+//	    interval: "@daily"
+//	    maxRetries: 3
+//	    queue: low
+func NewDocumentConfigProvider(read func(ctx context.Context) ([]byte, error)) ConfigProvider {
+	return ConfigProviderFunc(func(ctx context.Context) ([]*BatchJob, error) {
+		data, err := read(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return parseJobDocument(data)
+	})
+}
+
+// NewFileConfigProvider returns a ConfigProvider of the batch jobs defined in a YAML or JSON file,
+// read again on every sync.
+//
+// Example:
+//
+//	provider := NewFileConfigProvider("/etc/batch-jobs.yaml")
+func NewFileConfigProvider(path string) ConfigProvider {
+	return NewDocumentConfigProvider(func(ctx context.Context) ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch jobs file %s | %s", path, err.Error())
+		}
+
+		return data, nil
+	})
+}
+
+// KeyReader reads the value of a key, such as the client of the database/redis package.
+type KeyReader interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// NewRedisConfigProvider returns a ConfigProvider of the batch jobs defined in the YAML or JSON
+// document stored at a redis key.
+//
+// Example:
+//
+//	provider := NewRedisConfigProvider(redisClient, "config:batch-jobs")
+func NewRedisConfigProvider(client KeyReader, key string) ConfigProvider {
+	return NewDocumentConfigProvider(func(ctx context.Context) ([]byte, error) {
+		data, err := client.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch jobs key %s | %s", key, err.Error())
+		}
+
+		return data, nil
+	})
+}
+
+// parseJobDocument parses a YAML or JSON document of batch jobs.
+func parseJobDocument(data []byte) ([]*BatchJob, error) {
+	var document jobDocument
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse batch jobs | %s", err.Error())
+	}
+
+	jobs := make([]*BatchJob, 0, len(document.Jobs))
+	for i := range document.Jobs {
+		jobs = append(jobs, document.Jobs[i].BatchJob())
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,76 @@
+package batch_job
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jobsDocument = `
+jobs:
+  - id: daily-cleanup
+    taskType: cleanup
+    payload: '{"batchSize": 1000}'
+    enabled: true
+    interval: "@daily"
+    maxRetries: 3
+    queue: low
+  - id: hourly-report
+    taskType: report
+    enabled: false
+    interval: "@every 1h"
+`
+
+type keyReader map[string][]byte
+
+func (r keyReader) Get(ctx context.Context, key string) ([]byte, error) {
+	value, ok := r[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestDocumentConfigProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch-jobs.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(jobsDocument), 0o600))
+
+	tests := []struct {
+		name     string
+		provider ConfigProvider
+		wantErr  bool
+	}{
+		{name: "file", provider: NewFileConfigProvider(path)},
+		{name: "missing file", provider: NewFileConfigProvider(filepath.Join(t.TempDir(), "missing.yaml")), wantErr: true},
+		{name: "redis", provider: NewRedisConfigProvider(keyReader{"config:batch-jobs": []byte(jobsDocument)}, "config:batch-jobs")},
+		{name: "missing key", provider: NewRedisConfigProvider(keyReader{}, "config:batch-jobs"), wantErr: true},
+		{name: "invalid document", provider: NewDocumentConfigProvider(func(ctx context.Context) ([]byte, error) {
+			return []byte("jobs: {"), nil
+		}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := tt.provider.BatchJobs(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, jobs, 2)
+
+			job := jobs[0]
+			assert.Equal(t, "daily-cleanup", job.ID())
+			assert.Equal(t, "cleanup", job.task.Type())
+			assert.JSONEq(t, `{"batchSize": 1000}`, string(job.task.Payload()))
+			assert.Equal(t, BaseConfig{Enabled: true, Interval: "@daily", MaxRetries: 3}, job.BaseConfig)
+			assert.Equal(t, "low", job.queue)
+			assert.False(t, jobs[1].Enabled)
+		})
+	}
+}
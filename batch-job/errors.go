@@ -0,0 +1,73 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrDuplicateJobID is returned for a batch job whose identifier is used by another job
+	ErrDuplicateJobID = errors.New("duplicate batch job id")
+	// ErrConfigProviderNotSet is returned when starting a Batcher without a config provider
+	ErrConfigProviderNotSet = errors.New("config provider not set")
+	// ErrTaskProcessorNotSet is returned when using a Batcher without a task processor
+	ErrTaskProcessorNotSet = errors.New("task processor not set")
+	// ErrMissedRunsUnsupported is returned by RegisterRecurringBatchJobs for a batch job with the
+	// MissedRunsRunOnce policy, which only Start supports
+	ErrMissedRunsUnsupported = errors.New("missed runs policy not supported")
+)
+
+// JobError is the error of a single batch job, such as an invalid configuration or a failed
+// registration.
+type JobError struct {
+	// JobID is the identifier of the batch job
+	JobID string
+	// Err is the error of the batch job
+	Err error
+}
+
+// Error implements error.
+func (e *JobError) Error() string {
+	return fmt.Sprintf("batch job %s | %s", e.JobID, e.Err.Error())
+}
+
+// Unwrap returns the error of the batch job.
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// RegistrationError holds the errors of the batch jobs that could not be registered. The other
+// jobs are registered.
+type RegistrationError struct {
+	Errors []*JobError
+}
+
+// Error implements error.
+func (e *RegistrationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("%d batch jobs failed to register: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the batch jobs.
+func (e *RegistrationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// registrationError returns a RegistrationError holding the errors, or nil without errors.
+func registrationError(errs []*JobError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &RegistrationError{Errors: errs}
+}
@@ -0,0 +1,111 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+// lastRunKeyPrefix prefixes the redis keys holding the time a batch job was last enqueued
+const lastRunKeyPrefix = "batchjob:last-run:"
+
+// schedulerOpts returns the scheduler options of the task processor, recording the time each batch
+// job is enqueued so that missed runs can be detected on the next start.
+func (b *Batcher) schedulerOpts() *asynq.SchedulerOpts {
+	opts := b.processor.SchedulerOpts()
+
+	postEnqueue := opts.PostEnqueueFunc
+	opts.PostEnqueueFunc = func(info *asynq.TaskInfo, err error) {
+		if postEnqueue != nil {
+			postEnqueue(info, err)
+		}
+
+		if err != nil {
+			return
+		}
+
+		if jobID, ok := b.jobID(info.ID); ok {
+			if err := b.recordRun(context.Background(), jobID, time.Now()); err != nil {
+				b.reportError(&JobError{JobID: jobID, Err: err})
+			}
+			b.recordEnqueued(context.Background(), jobID, info.ID, RunTriggerScheduled)
+		}
+	}
+
+	return opts
+}
+
+// jobID returns the batch job enqueueing tasks with the task id.
+func (b *Batcher) jobID(taskID string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	jobID, ok := b.taskJobs[taskID]
+	return jobID, ok
+}
+
+// recordRun records the time the batch job was last enqueued.
+func (b *Batcher) recordRun(ctx context.Context, jobID string, at time.Time) error {
+	if err := b.redisClient.Set(ctx, lastRunKeyPrefix+jobID, at.Unix(), 0).Err(); err != nil {
+		return fmt.Errorf("failed to record the last run | %s", err.Error())
+	}
+
+	return nil
+}
+
+// lastRun returns the time the batch job was last enqueued, and false when it never was.
+func (b *Batcher) lastRun(ctx context.Context, jobID string) (time.Time, bool, error) {
+	value, err := b.redisClient.Get(ctx, lastRunKeyPrefix+jobID).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read the last run | %s", err.Error())
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid last run %q | %s", value, err.Error())
+	}
+
+	return time.Unix(seconds, 0), true, nil
+}
+
+// runMissed enqueues once the batch jobs with the MissedRunsRunOnce policy that missed a run since
+// they were last enqueued. Every instance of a service may do so on start: the task id of the job
+// keeps the run from being enqueued twice while it is pending.
+func (b *Batcher) runMissed(ctx context.Context, jobs []*BatchJob, now time.Time) {
+	for _, job := range jobs {
+		schedule, err := job.Schedule()
+		if err != nil || schedule.MissedRuns() != MissedRunsRunOnce {
+			continue
+		}
+
+		last, ok, err := b.lastRun(ctx, job.ID())
+		if err != nil {
+			b.reportError(&JobError{JobID: job.ID(), Err: err})
+			continue
+		}
+
+		// a job never enqueued before has not missed any run
+		if ok && schedule.Next(last).Before(now) {
+			_, err := b.processor.EnqueueTask(ctx, job.task, job.options(job.ID(), 0)...)
+			switch {
+			case err == nil:
+				b.recordEnqueued(ctx, job.ID(), job.ID(), RunTriggerCatchUp)
+			case !errors.Is(err, asynq.ErrTaskIDConflict):
+				b.reportError(&JobError{JobID: job.ID(), Err: fmt.Errorf("failed to run the missed run | %w", err)})
+				continue
+			}
+		}
+
+		if err := b.recordRun(ctx, job.ID(), now); err != nil {
+			b.reportError(&JobError{JobID: job.ID(), Err: err})
+		}
+	}
+}
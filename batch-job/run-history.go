@@ -0,0 +1,534 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+// RunStatus is the state of a batch job run.
+type RunStatus string
+
+const (
+	// RunStatusPending is the status of a run enqueued and not started yet
+	RunStatusPending RunStatus = "pending"
+	// RunStatusRunning is the status of a run in progress
+	RunStatusRunning RunStatus = "running"
+	// RunStatusRetrying is the status of a run whose last attempt failed and that will be retried
+	RunStatusRetrying RunStatus = "retrying"
+	// RunStatusSucceeded is the status of a run that succeeded
+	RunStatusSucceeded RunStatus = "succeeded"
+	// RunStatusFailed is the status of a run that failed and will not be retried
+	RunStatusFailed RunStatus = "failed"
+)
+
+// Finished tells whether the run is over.
+func (s RunStatus) Finished() bool {
+	return s == RunStatusSucceeded || s == RunStatusFailed
+}
+
+// RunTrigger tells what triggered a batch job run.
+type RunTrigger string
+
+const (
+	// RunTriggerScheduled triggers the runs enqueued by the scheduler
+	RunTriggerScheduled RunTrigger = "scheduled"
+	// RunTriggerCatchUp triggers the runs enqueued on start for missed runs
+	RunTriggerCatchUp RunTrigger = "catchUp"
+	// RunTriggerManual triggers the runs enqueued by RunNow
+	RunTriggerManual RunTrigger = "manual"
+)
+
+var (
+	// ErrRunNotFound is returned for a batch job run missing from the run store
+	ErrRunNotFound = errors.New("batch job run not found")
+	// ErrRunStoreNotSet is returned when querying the runs of a Batcher without a run store
+	ErrRunStoreNotSet = errors.New("run store not set")
+	// ErrJobNotFound is returned for a batch job unknown to the Batcher
+	ErrJobNotFound = errors.New("batch job not found")
+)
+
+// JobRun is a run of a batch job, from the time it is enqueued to the time it succeeds or fails
+// for the last time.
+type JobRun struct {
+	// ID is the identifier of the run
+	ID string `json:"id" gorm:"primaryKey"`
+	// JobID is the identifier of the batch job
+	JobID string `json:"jobId" gorm:"index:idx_batch_job_runs_job,priority:1"`
+	// TaskID is the identifier of the asynq task of the run
+	TaskID string `json:"taskId" gorm:"index"`
+	// Trigger tells what triggered the run
+	Trigger RunTrigger `json:"trigger"`
+	// InstanceID is the identifier of the instance that triggered the run
+	InstanceID string `json:"instanceId"`
+	// Status is the state of the run
+	Status RunStatus `json:"status" gorm:"index"`
+	// Attempts is the number of times the task of the run was processed
+	Attempts int `json:"attempts"`
+	// EnqueuedAt is the time the run was enqueued
+	EnqueuedAt time.Time `json:"enqueuedAt" gorm:"index:idx_batch_job_runs_job,priority:2"`
+	// StartedAt is the time the first attempt of the run started
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// FinishedAt is the time the last attempt of the run ended
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Error is the error of the last attempt of the run
+	Error string `json:"error,omitempty"`
+	// ItemsProcessed is the number of items the last attempt of the run reported processed with
+	// AddItemsProcessed
+	ItemsProcessed int64 `json:"itemsProcessed"`
+}
+
+// TableName implements the gorm tabler interface.
+func (JobRun) TableName() string {
+	return "batch_job_runs"
+}
+
+// Duration returns the time between the start of the run and the end of its last attempt, or
+// zero for a run that has not ended.
+func (r *JobRun) Duration() time.Duration {
+	if r.StartedAt == nil || r.FinishedAt == nil {
+		return 0
+	}
+
+	return r.FinishedAt.Sub(*r.StartedAt)
+}
+
+// RunQuery filters the runs returned by a run store. The runs are returned from the most recently
+// enqueued.
+type RunQuery struct {
+	// JobID keeps the runs of a batch job
+	JobID string
+	// TaskID keeps the runs of an asynq task
+	TaskID string
+	// Statuses keeps the runs in one of the statuses
+	Statuses []RunStatus
+	// Since keeps the runs enqueued at or after the time
+	Since time.Time
+	// Until keeps the runs enqueued before the time
+	Until time.Time
+	// Limit caps the number of runs returned. Zero returns every run
+	Limit int
+}
+
+// RunStore records the runs of the batch jobs. NewInMemoryRunStore and NewPostgresRunStore
+// provide implementations.
+type RunStore interface {
+	// SaveRun creates or updates a run
+	SaveRun(ctx context.Context, run *JobRun) error
+	// GetRun returns a run, or ErrRunNotFound
+	GetRun(ctx context.Context, id string) (*JobRun, error)
+	// ListRuns returns the runs matching the query, from the most recently enqueued
+	ListRuns(ctx context.Context, query RunQuery) ([]*JobRun, error)
+}
+
+// AlertFunc is called when a batch job failed a number of runs in a row, with the failed runs
+// from the most recent.
+type AlertFunc func(ctx context.Context, jobID string, runs []*JobRun)
+
+// WithRunStore sets the store recording the runs of the batch jobs.
+//
+// Example:
+//
+//	store, err := NewPostgresRunStore(postgresClient)
+//	if err != nil {
+//	    return err
+//	}
+//	batcher := NewBatcher(WithRunStore(store))
+func WithRunStore(store RunStore) BatcherOption {
+	return func(b *Batcher) {
+		b.runStore = store
+	}
+}
+
+// WithInstanceID sets the identifier of the instance recorded on the runs it triggers. It defaults
+// to the host name and the process id.
+func WithInstanceID(id string) BatcherOption {
+	return func(b *Batcher) {
+		b.instanceID = id
+	}
+}
+
+// WithFailureAlert calls the alert once a batch job failed threshold runs in a row. The alert is
+// called again only after the job succeeded.
+//
+// Example:
+//
+//	batcher := NewBatcher(WithFailureAlert(3, func(ctx context.Context, jobID string, runs []*JobRun) {
+//	    logger.Error("batch job keeps failing", zap.String("job", jobID), zap.String("error", runs[0].Error))
+//	}))
+func WithFailureAlert(threshold int, alert AlertFunc) BatcherOption {
+	return func(b *Batcher) {
+		b.alertThreshold = threshold
+		b.alert = alert
+	}
+}
+
+// defaultInstanceID identifies the instance by its host name and process id.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// itemsProcessedKey is the context key of the items counter of a run
+type itemsProcessedKey struct{}
+
+// AddItemsProcessed adds to the number of items processed recorded on the run of the batch job
+// processed with the context. Each attempt of a run counts its items from zero, so that a retried
+// run records the items of its last attempt only. It does nothing for a task that is not tracked
+// by a Batcher.
+//
+// Example:
+//
+//	func (h *Handler) ProcessTask(ctx context.Context, t *asynq.Task) error {
+//	    for _, batch := range batches {
+//	        // ...
+//	        batchjob.AddItemsProcessed(ctx, int64(len(batch)))
+//	    }
+//	    return nil
+//	}
+func AddItemsProcessed(ctx context.Context, n int64) {
+	if counter, ok := ctx.Value(itemsProcessedKey{}).(*atomic.Int64); ok {
+		counter.Add(n)
+	}
+}
+
+// TrackRuns is an asynq middleware recording the runs of the batch jobs in the run store: the
+// start, end, outcome, error and items processed of each run. Tasks that are not batch jobs known
+// to the Batcher or enqueued by it are processed untracked.
+//
+// Example:
+//
+//	func (h *TaskHandler) RegisterTaskHandler() *asynq.ServeMux {
+//	    mux := asynq.NewServeMux()
+//	    mux.Use(batcher.TrackRuns)
+//	    mux.Handle("cleanup", h.cleanup)
+//	    return mux
+//	}
+func (b *Batcher) TrackRuns(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		if b.runStore == nil {
+			return next.ProcessTask(ctx, t)
+		}
+
+		taskID, _ := asynq.GetTaskID(ctx)
+		run, err := b.startRun(ctx, taskID)
+		if err != nil {
+			b.reportError(err)
+		}
+		if run == nil {
+			return next.ProcessTask(ctx, t)
+		}
+
+		// a retried attempt processes the items again, so it does not add to the previous count
+		counter := &atomic.Int64{}
+		processErr := next.ProcessTask(context.WithValue(ctx, itemsProcessedKey{}, counter), t)
+
+		b.finishRun(ctx, run, counter.Load(), processErr)
+
+		return processErr
+	})
+}
+
+// startRun marks the pending run of the task as running, creating it when the task was not
+// enqueued by a Batcher with a run store. It returns nil for a task that is not a batch job.
+func (b *Batcher) startRun(ctx context.Context, taskID string) (*JobRun, error) {
+	runs, err := b.runStore.ListRuns(ctx, RunQuery{
+		TaskID:   taskID,
+		Statuses: []RunStatus{RunStatusPending, RunStatusRunning, RunStatusRetrying},
+		Limit:    1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the run of task %s | %w", taskID, err)
+	}
+
+	var run *JobRun
+	if len(runs) > 0 {
+		run = runs[0]
+	} else if jobID, ok := b.jobIDForTask(taskID); ok {
+		// the task was enqueued by a scheduler without run store, so the triggering instance is
+		// unknown
+		run = b.newRun(jobID, taskID, RunTriggerScheduled)
+		run.InstanceID = ""
+	} else {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if run.StartedAt == nil {
+		run.StartedAt = &now
+	}
+	run.Status = RunStatusRunning
+	run.Attempts++
+
+	if err := b.runStore.SaveRun(ctx, run); err != nil {
+		return run, &JobError{JobID: run.JobID, Err: fmt.Errorf("failed to record the run | %w", err)}
+	}
+
+	return run, nil
+}
+
+// finishRun records the outcome of an attempt of the run, and alerts when it is the last failure
+// of a series.
+func (b *Batcher) finishRun(ctx context.Context, run *JobRun, itemsProcessed int64, err error) {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.ItemsProcessed = itemsProcessed
+	run.Error = ""
+
+	switch {
+	case err == nil:
+		run.Status = RunStatusSucceeded
+	case willRetry(ctx, err):
+		run.Status = RunStatusRetrying
+		run.Error = err.Error()
+	default:
+		run.Status = RunStatusFailed
+		run.Error = err.Error()
+	}
+
+	if err := b.runStore.SaveRun(ctx, run); err != nil {
+		b.reportError(&JobError{JobID: run.JobID, Err: fmt.Errorf("failed to record the run | %w", err)})
+		return
+	}
+
+	if run.Status == RunStatusFailed {
+		b.alertFailures(ctx, run.JobID)
+	}
+}
+
+// willRetry tells whether asynq retries a task that failed with the error.
+func willRetry(ctx context.Context, err error) bool {
+	if errors.Is(err, asynq.SkipRetry) {
+		return false
+	}
+
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+
+	return retried < maxRetry
+}
+
+// alertFailures calls the alert when the last runs of the batch job failed as many times in a row
+// as the alert threshold, but not the run before them.
+func (b *Batcher) alertFailures(ctx context.Context, jobID string) {
+	if b.alert == nil || b.alertThreshold <= 0 {
+		return
+	}
+
+	runs, err := b.runStore.ListRuns(ctx, RunQuery{
+		JobID:    jobID,
+		Statuses: []RunStatus{RunStatusSucceeded, RunStatusFailed},
+		Limit:    b.alertThreshold + 1,
+	})
+	if err != nil {
+		b.reportError(&JobError{JobID: jobID, Err: fmt.Errorf("failed to read the runs | %w", err)})
+		return
+	}
+
+	if len(runs) < b.alertThreshold {
+		return
+	}
+
+	for _, run := range runs[:b.alertThreshold] {
+		if run.Status != RunStatusFailed {
+			return
+		}
+	}
+
+	if len(runs) > b.alertThreshold && runs[b.alertThreshold].Status == RunStatusFailed {
+		return
+	}
+
+	b.alert(ctx, jobID, runs[:b.alertThreshold])
+}
+
+// newRun returns a pending run of the batch job triggered by the instance.
+func (b *Batcher) newRun(jobID, taskID string, trigger RunTrigger) *JobRun {
+	return &JobRun{
+		ID:         uuid.NewString(),
+		JobID:      jobID,
+		TaskID:     taskID,
+		Trigger:    trigger,
+		InstanceID: b.instanceID,
+		Status:     RunStatusPending,
+		EnqueuedAt: time.Now(),
+	}
+}
+
+// recordEnqueued records the pending run of a task enqueued for the batch job.
+func (b *Batcher) recordEnqueued(ctx context.Context, jobID, taskID string, trigger RunTrigger) {
+	if b.runStore == nil {
+		return
+	}
+
+	run := b.newRun(jobID, taskID, trigger)
+	b.closeStaleRuns(ctx, run)
+
+	if err := b.runStore.SaveRun(ctx, run); err != nil {
+		b.reportError(&JobError{JobID: jobID, Err: fmt.Errorf("failed to record the run | %w", err)})
+	}
+}
+
+// closeStaleRuns marks as failed the runs of the task of a new run that never finished, such as
+// the run of a worker that crashed. Scheduled runs reuse the task id of their job, and asynq only
+// enqueues a task once the previous task with its id is gone, so those runs will not finish.
+func (b *Batcher) closeStaleRuns(ctx context.Context, run *JobRun) {
+	runs, err := b.runStore.ListRuns(ctx, RunQuery{
+		TaskID:   run.TaskID,
+		Statuses: []RunStatus{RunStatusPending, RunStatusRunning, RunStatusRetrying},
+	})
+	if err != nil {
+		b.reportError(&JobError{JobID: run.JobID, Err: fmt.Errorf("failed to find the stale runs | %w", err)})
+		return
+	}
+
+	now := time.Now()
+	for _, stale := range runs {
+		stale.Status = RunStatusFailed
+		stale.FinishedAt = &now
+		stale.Error = fmt.Sprintf("run superseded by run %s before it finished", run.ID)
+
+		if err := b.runStore.SaveRun(ctx, stale); err != nil {
+			b.reportError(&JobError{JobID: run.JobID, Err: fmt.Errorf("failed to close the stale run %s | %w", stale.ID, err)})
+		}
+	}
+}
+
+// jobIDForTask returns the batch job of a task enqueued by its schedule.
+func (b *Batcher) jobIDForTask(taskID string) (string, bool) {
+	if jobID, ok := b.jobID(taskID); ok {
+		return jobID, true
+	}
+
+	if job, ok := b.job(taskID); ok {
+		return job.ID(), true
+	}
+
+	// tasks of a cron expression with a seconds field are suffixed by the second
+	if i := strings.LastIndex(taskID, ":"); i > 0 {
+		if job, ok := b.job(taskID[:i]); ok {
+			return job.ID(), true
+		}
+	}
+
+	return "", false
+}
+
+// job returns the batch job with the identifier, among the jobs of the config provider and the
+// batch jobs of the Batcher.
+func (b *Batcher) job(id string) (*BatchJob, bool) {
+	b.mu.Lock()
+	jobs := b.jobs
+	b.mu.Unlock()
+
+	if b.batchJobs != nil {
+		jobs = append(jobs[:len(jobs):len(jobs)], b.batchJobs.jobs...)
+	}
+
+	for _, job := range jobs {
+		if job.ID() == id {
+			return job, true
+		}
+	}
+
+	return nil, false
+}
+
+// RunNow enqueues a run of the batch job right away, outside of its schedule, and returns it. The
+// run is recorded in the run store when there is one.
+//
+// Example:
+//
+//	run, err := batcher.RunNow(ctx, "daily-cleanup")
+//	if err != nil {
+//	    return err
+//	}
+//	log.Printf("enqueued run %s", run.ID)
+func (b *Batcher) RunNow(ctx context.Context, jobID string) (*JobRun, error) {
+	if b.processor == nil {
+		return nil, ErrTaskProcessorNotSet
+	}
+
+	job, ok := b.job(jobID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+
+	if err := job.Validate(); err != nil {
+		return nil, &JobError{JobID: jobID, Err: err}
+	}
+
+	run := b.newRun(jobID, "", RunTriggerManual)
+	run.TaskID = jobID + ":manual:" + run.ID
+
+	if b.runStore != nil {
+		if err := b.runStore.SaveRun(ctx, run); err != nil {
+			return nil, &JobError{JobID: jobID, Err: fmt.Errorf("failed to record the run | %w", err)}
+		}
+	}
+
+	if _, err := b.processor.EnqueueTask(ctx, job.task, job.options(run.TaskID, 0)...); err != nil {
+		if b.runStore != nil {
+			now := time.Now()
+			run.Status = RunStatusFailed
+			run.FinishedAt = &now
+			run.Error = err.Error()
+			_ = b.runStore.SaveRun(ctx, run)
+		}
+
+		return nil, &JobError{JobID: jobID, Err: fmt.Errorf("failed to enqueue the run | %w", err)}
+	}
+
+	return run, nil
+}
+
+// Run returns a run of a batch job.
+func (b *Batcher) Run(ctx context.Context, id string) (*JobRun, error) {
+	if b.runStore == nil {
+		return nil, ErrRunStoreNotSet
+	}
+
+	return b.runStore.GetRun(ctx, id)
+}
+
+// Runs returns the runs matching the query, from the most recently enqueued.
+//
+// Example:
+//
+//	runs, err := batcher.Runs(ctx, RunQuery{
+//	    JobID:    "daily-cleanup",
+//	    Statuses: []RunStatus{RunStatusFailed},
+//	    Since:    time.Now().Add(-7 * 24 * time.Hour),
+//	})
+func (b *Batcher) Runs(ctx context.Context, query RunQuery) ([]*JobRun, error) {
+	if b.runStore == nil {
+		return nil, ErrRunStoreNotSet
+	}
+
+	return b.runStore.ListRuns(ctx, query)
+}
+
+// LatestRun returns the most recently enqueued run of the batch job, or ErrRunNotFound.
+func (b *Batcher) LatestRun(ctx context.Context, jobID string) (*JobRun, error) {
+	runs, err := b.Runs(ctx, RunQuery{JobID: jobID, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runs) == 0 {
+		return nil, ErrRunNotFound
+	}
+
+	return runs[0], nil
+}
@@ -0,0 +1,237 @@
+package batch_job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestServer processes the tasks of the task processor with the handler, tracking the runs
+// of the batcher
+func startTestServer(t *testing.T, batcher *Batcher, handler asynq.HandlerFunc) {
+	t.Helper()
+
+	srv := asynq.NewServer(batcher.processor.RedisConnOpt(), asynq.Config{
+		Concurrency:              1,
+		RetryDelayFunc:           func(int, error, *asynq.Task) time.Duration { return 0 },
+		TaskCheckInterval:        10 * time.Millisecond,
+		DelayedTaskCheckInterval: 100 * time.Millisecond,
+	})
+
+	mux := asynq.NewServeMux()
+	mux.Use(batcher.TrackRuns)
+	mux.Handle("cleanup:cleanup", handler)
+
+	require.NoError(t, srv.Start(mux))
+	t.Cleanup(srv.Shutdown)
+}
+
+// waitForRun waits for the run to finish
+func waitForRun(t *testing.T, batcher *Batcher, id string) *JobRun {
+	t.Helper()
+
+	var run *JobRun
+	require.Eventually(t, func() bool {
+		var err error
+		run, err = batcher.Run(context.Background(), id)
+		return err == nil && run.Status.Finished()
+	}, 10*time.Second, 10*time.Millisecond)
+
+	return run
+}
+
+func TestRunNowRecordsRun(t *testing.T) {
+	jobs := NewBatchJobs(WithBatchJob(newTestJob("cleanup", "@daily", true)))
+	batcher := NewBatcher(
+		WithBatchJobsRef(jobs),
+		WithTaskProcessor(newTestTaskProcessor(t)),
+		WithRunStore(NewInMemoryRunStore()),
+		WithInstanceID("instance-1"),
+	)
+
+	startTestServer(t, batcher, func(ctx context.Context, _ *asynq.Task) error {
+		AddItemsProcessed(ctx, 40)
+		AddItemsProcessed(ctx, 2)
+		return nil
+	})
+
+	run, err := batcher.RunNow(context.Background(), "cleanup")
+	require.NoError(t, err)
+	assert.Equal(t, RunTriggerManual, run.Trigger)
+	assert.Equal(t, "instance-1", run.InstanceID)
+
+	run = waitForRun(t, batcher, run.ID)
+	assert.Equal(t, RunStatusSucceeded, run.Status)
+	assert.Equal(t, int64(42), run.ItemsProcessed)
+	assert.Equal(t, 1, run.Attempts)
+	assert.Empty(t, run.Error)
+	assert.GreaterOrEqual(t, run.Duration(), time.Duration(0))
+
+	latest, err := batcher.LatestRun(context.Background(), "cleanup")
+	require.NoError(t, err)
+	assert.Equal(t, run.ID, latest.ID)
+
+	_, err = batcher.RunNow(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestTrackRunsRecordsRetriesAndAlerts(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		alerts [][]*JobRun
+	)
+
+	jobs := NewBatchJobs(WithBatchJob(newTestJob("cleanup", "@daily", true)))
+	batcher := NewBatcher(
+		WithBatchJobsRef(jobs),
+		WithTaskProcessor(newTestTaskProcessor(t)),
+		WithRunStore(NewInMemoryRunStore()),
+		WithFailureAlert(2, func(_ context.Context, jobID string, runs []*JobRun) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, "cleanup", jobID)
+			alerts = append(alerts, runs)
+		}),
+	)
+
+	startTestServer(t, batcher, func(context.Context, *asynq.Task) error {
+		return errors.New("database unavailable")
+	})
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		run, err := batcher.RunNow(context.Background(), "cleanup")
+		require.NoError(t, err)
+
+		run = waitForRun(t, batcher, run.ID)
+		assert.Equal(t, RunStatusFailed, run.Status)
+		assert.Equal(t, "database unavailable", run.Error)
+		// the job is retried once before failing
+		assert.Equal(t, 2, run.Attempts)
+		ids = append(ids, run.ID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, alerts, 1)
+	require.Len(t, alerts[0], 2)
+	assert.Equal(t, ids[1], alerts[0][0].ID)
+	assert.Equal(t, ids[0], alerts[0][1].ID)
+}
+
+func TestTrackRunsCountsItemsOfLastAttempt(t *testing.T) {
+	jobs := NewBatchJobs(WithBatchJob(newTestJob("cleanup", "@daily", true)))
+	batcher := NewBatcher(
+		WithBatchJobsRef(jobs),
+		WithTaskProcessor(newTestTaskProcessor(t)),
+		WithRunStore(NewInMemoryRunStore()),
+	)
+
+	startTestServer(t, batcher, func(ctx context.Context, _ *asynq.Task) error {
+		AddItemsProcessed(ctx, 1000)
+		if retried, _ := asynq.GetRetryCount(ctx); retried == 0 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+
+	run, err := batcher.RunNow(context.Background(), "cleanup")
+	require.NoError(t, err)
+
+	run = waitForRun(t, batcher, run.ID)
+	assert.Equal(t, RunStatusSucceeded, run.Status)
+	assert.Equal(t, 2, run.Attempts)
+	assert.Equal(t, int64(1000), run.ItemsProcessed)
+}
+
+func TestRecordEnqueuedClosesStaleRuns(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryRunStore()
+	started := time.Now().Add(-time.Hour)
+	require.NoError(t, store.SaveRun(ctx, &JobRun{
+		ID:         "crashed",
+		JobID:      "cleanup",
+		TaskID:     "cleanup",
+		Status:     RunStatusRunning,
+		EnqueuedAt: started,
+		StartedAt:  &started,
+	}))
+	require.NoError(t, store.SaveRun(ctx, &JobRun{
+		ID:         "other-task",
+		JobID:      "cleanup",
+		TaskID:     "cleanup:manual:1",
+		Status:     RunStatusRunning,
+		EnqueuedAt: started,
+	}))
+
+	batcher := NewBatcher(WithRunStore(store))
+	batcher.recordEnqueued(ctx, "cleanup", "cleanup", RunTriggerScheduled)
+
+	stale, err := store.GetRun(ctx, "crashed")
+	require.NoError(t, err)
+	assert.Equal(t, RunStatusFailed, stale.Status)
+	assert.NotNil(t, stale.FinishedAt)
+	assert.Contains(t, stale.Error, "superseded")
+
+	other, err := store.GetRun(ctx, "other-task")
+	require.NoError(t, err)
+	assert.Equal(t, RunStatusRunning, other.Status)
+
+	runs, err := store.ListRuns(ctx, RunQuery{TaskID: "cleanup", Statuses: []RunStatus{RunStatusPending}})
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, RunTriggerScheduled, runs[0].Trigger)
+}
+
+func TestAlertFailures(t *testing.T) {
+	base := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		statuses []RunStatus
+		alerted  bool
+	}{
+		{name: "too few failures", statuses: []RunStatus{RunStatusFailed, RunStatusSucceeded}},
+		{name: "threshold reached", statuses: []RunStatus{RunStatusFailed, RunStatusFailed, RunStatusSucceeded}, alerted: true},
+		{name: "first runs", statuses: []RunStatus{RunStatusFailed, RunStatusFailed}, alerted: true},
+		{name: "already alerted", statuses: []RunStatus{RunStatusFailed, RunStatusFailed, RunStatusFailed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryRunStore()
+			for i, status := range tt.statuses {
+				require.NoError(t, store.SaveRun(context.Background(), &JobRun{
+					ID:         string(rune('a' + i)),
+					JobID:      "cleanup",
+					Status:     status,
+					EnqueuedAt: base.Add(-time.Duration(i) * time.Hour),
+				}))
+			}
+
+			alerted := false
+			batcher := NewBatcher(WithRunStore(store), WithFailureAlert(2, func(context.Context, string, []*JobRun) {
+				alerted = true
+			}))
+
+			batcher.alertFailures(context.Background(), "cleanup")
+			assert.Equal(t, tt.alerted, alerted)
+		})
+	}
+}
+
+func TestRunQueriesWithoutStore(t *testing.T) {
+	batcher := NewBatcher()
+
+	_, err := batcher.Runs(context.Background(), RunQuery{})
+	assert.ErrorIs(t, err, ErrRunStoreNotSet)
+
+	_, err = batcher.LatestRun(context.Background(), "cleanup")
+	assert.ErrorIs(t, err, ErrRunStoreNotSet)
+}
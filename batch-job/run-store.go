@@ -0,0 +1,192 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/SolomonAIEngineering/backend-core-library/database/postgres"
+	"gorm.io/gorm"
+)
+
+// InMemoryRunStore keeps the runs of the batch jobs in memory. It suits tests and single instance
+// services; the runs are lost on restart.
+type InMemoryRunStore struct {
+	runs map[string]JobRun
+	mu   sync.RWMutex
+}
+
+var _ RunStore = (*InMemoryRunStore)(nil)
+
+// NewInMemoryRunStore creates an empty in-memory run store.
+func NewInMemoryRunStore() *InMemoryRunStore {
+	return &InMemoryRunStore{runs: make(map[string]JobRun)}
+}
+
+// SaveRun implements RunStore.
+func (s *InMemoryRunStore) SaveRun(_ context.Context, run *JobRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs[run.ID] = *run
+	return nil
+}
+
+// GetRun implements RunStore.
+func (s *InMemoryRunStore) GetRun(_ context.Context, id string) (*JobRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return nil, ErrRunNotFound
+	}
+
+	return &run, nil
+}
+
+// ListRuns implements RunStore.
+func (s *InMemoryRunStore) ListRuns(_ context.Context, query RunQuery) ([]*JobRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := make([]*JobRun, 0)
+	for _, run := range s.runs {
+		if query.matches(&run) {
+			run := run
+			runs = append(runs, &run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].EnqueuedAt.After(runs[j].EnqueuedAt)
+	})
+
+	if query.Limit > 0 && len(runs) > query.Limit {
+		runs = runs[:query.Limit]
+	}
+
+	return runs, nil
+}
+
+// matches tells whether the run matches the query.
+func (q RunQuery) matches(run *JobRun) bool {
+	switch {
+	case q.JobID != "" && run.JobID != q.JobID:
+		return false
+	case q.TaskID != "" && run.TaskID != q.TaskID:
+		return false
+	case len(q.Statuses) > 0 && !slices.Contains(q.Statuses, run.Status):
+		return false
+	case !q.Since.IsZero() && run.EnqueuedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !run.EnqueuedAt.Before(q.Until):
+		return false
+	}
+
+	return true
+}
+
+// PostgresRunStore keeps the runs of the batch jobs in the batch_job_runs table of a Postgres
+// database.
+type PostgresRunStore struct {
+	client *postgres.Client
+}
+
+var _ RunStore = (*PostgresRunStore)(nil)
+
+// NewPostgresRunStore creates a run store on the database of the client, migrating the
+// batch_job_runs table.
+//
+// Example:
+//
+//	client, err := postgres.New(postgres.WithConnectionString(&connectionString), ...)
+//	if err != nil {
+//	    return err
+//	}
+//	store, err := NewPostgresRunStore(client)
+func NewPostgresRunStore(client *postgres.Client) (*PostgresRunStore, error) {
+	if client == nil || client.Engine == nil {
+		return nil, fmt.Errorf("postgres client not set")
+	}
+
+	if err := client.Engine.AutoMigrate(&JobRun{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate the batch job runs | %s", err.Error())
+	}
+
+	return &PostgresRunStore{client: client}, nil
+}
+
+// db returns the database session of a query, bounded by the query timeout of the client.
+func (s *PostgresRunStore) db(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	if s.client.QueryTimeout != nil && *s.client.QueryTimeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, *s.client.QueryTimeout)
+		return s.client.Engine.WithContext(ctx), cancel
+	}
+
+	return s.client.Engine.WithContext(ctx), func() {}
+}
+
+// SaveRun implements RunStore.
+func (s *PostgresRunStore) SaveRun(ctx context.Context, run *JobRun) error {
+	db, cancel := s.db(ctx)
+	defer cancel()
+
+	if err := db.Save(run).Error; err != nil {
+		return fmt.Errorf("failed to save run %s | %s", run.ID, err.Error())
+	}
+
+	return nil
+}
+
+// GetRun implements RunStore.
+func (s *PostgresRunStore) GetRun(ctx context.Context, id string) (*JobRun, error) {
+	db, cancel := s.db(ctx)
+	defer cancel()
+
+	run := &JobRun{}
+	if err := db.Where("id = ?", id).Take(run).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRunNotFound
+		}
+
+		return nil, fmt.Errorf("failed to get run %s | %s", id, err.Error())
+	}
+
+	return run, nil
+}
+
+// ListRuns implements RunStore.
+func (s *PostgresRunStore) ListRuns(ctx context.Context, query RunQuery) ([]*JobRun, error) {
+	db, cancel := s.db(ctx)
+	defer cancel()
+
+	if query.JobID != "" {
+		db = db.Where("job_id = ?", query.JobID)
+	}
+	if query.TaskID != "" {
+		db = db.Where("task_id = ?", query.TaskID)
+	}
+	if len(query.Statuses) > 0 {
+		db = db.Where("status IN ?", query.Statuses)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("enqueued_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("enqueued_at < ?", query.Until)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	var runs []*JobRun
+	if err := db.Order("enqueued_at DESC").Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list runs | %s", err.Error())
+	}
+
+	return runs, nil
+}
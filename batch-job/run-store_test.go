@@ -0,0 +1,104 @@
+package batch_job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/database/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestPostgresRunStore creates a run store on an in-memory sqlite database
+func newTestPostgresRunStore(t *testing.T) *PostgresRunStore {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	timeout := time.Second
+	store, err := NewPostgresRunStore(&postgres.Client{Engine: db, QueryTimeout: &timeout})
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestRunStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) RunStore{
+		"in memory": func(*testing.T) RunStore { return NewInMemoryRunStore() },
+		"postgres":  func(t *testing.T) RunStore { return newTestPostgresRunStore(t) },
+	}
+
+	base := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	runs := []*JobRun{
+		{ID: "1", JobID: "cleanup", TaskID: "cleanup", Status: RunStatusSucceeded, EnqueuedAt: base},
+		{ID: "2", JobID: "cleanup", TaskID: "cleanup", Status: RunStatusFailed, EnqueuedAt: base.Add(time.Hour)},
+		{ID: "3", JobID: "report", TaskID: "report", Status: RunStatusPending, EnqueuedAt: base.Add(2 * time.Hour)},
+		{ID: "4", JobID: "cleanup", TaskID: "cleanup:manual:4", Status: RunStatusRunning, EnqueuedAt: base.Add(3 * time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		query    RunQuery
+		expected []string
+	}{
+		{name: "all", query: RunQuery{}, expected: []string{"4", "3", "2", "1"}},
+		{name: "job", query: RunQuery{JobID: "cleanup"}, expected: []string{"4", "2", "1"}},
+		{name: "task", query: RunQuery{TaskID: "cleanup"}, expected: []string{"2", "1"}},
+		{
+			name:     "statuses",
+			query:    RunQuery{Statuses: []RunStatus{RunStatusSucceeded, RunStatusFailed}},
+			expected: []string{"2", "1"},
+		},
+		{name: "since", query: RunQuery{Since: base.Add(time.Hour)}, expected: []string{"4", "3", "2"}},
+		{name: "until", query: RunQuery{Until: base.Add(time.Hour)}, expected: []string{"1"}},
+		{name: "limit", query: RunQuery{JobID: "cleanup", Limit: 2}, expected: []string{"4", "2"}},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			for _, run := range runs {
+				require.NoError(t, store.SaveRun(ctx, run))
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					found, err := store.ListRuns(ctx, tt.query)
+					require.NoError(t, err)
+
+					ids := make([]string, 0, len(found))
+					for _, run := range found {
+						ids = append(ids, run.ID)
+					}
+					assert.Equal(t, tt.expected, ids)
+				})
+			}
+
+			finished := base.Add(4 * time.Hour)
+			update := *runs[3]
+			update.Status = RunStatusSucceeded
+			update.FinishedAt = &finished
+			update.ItemsProcessed = 42
+			require.NoError(t, store.SaveRun(ctx, &update))
+
+			run, err := store.GetRun(ctx, "4")
+			require.NoError(t, err)
+			assert.Equal(t, RunStatusSucceeded, run.Status)
+			assert.Equal(t, int64(42), run.ItemsProcessed)
+			assert.True(t, finished.Equal(*run.FinishedAt))
+
+			_, err = store.GetRun(ctx, "unknown")
+			assert.ErrorIs(t, err, ErrRunNotFound)
+		})
+	}
+}
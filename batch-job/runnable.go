@@ -2,10 +2,13 @@ package batch_job // import "github.com/SolomonAIEngineering/backend-core-librar
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	taskprocessor "github.com/SolomonAIEngineering/backend-core-library/task-processor"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
 // Runnable defines the interface for registering recurring batch jobs.
@@ -29,6 +32,30 @@ type Runnable interface {
 type Batcher struct {
 	batchJobs *BatchJobs
 	processor *taskprocessor.TaskProcessor
+
+	// provider is the source of the batch jobs scheduled by Start
+	provider ConfigProvider
+	// syncInterval is the interval at which the provider is read again
+	syncInterval time.Duration
+	// errorHandler receives the errors of the batch jobs and of the provider met while syncing
+	errorHandler func(err error)
+	// manager schedules the batch jobs of the provider once started
+	manager *asynq.PeriodicTaskManager
+	// redisClient records the last run of the batch jobs scheduled by Start
+	redisClient redis.UniversalClient
+	// jobs holds the batch jobs of the last sync and taskJobs the job of each of their task ids,
+	// guarded by mu
+	jobs     []*BatchJob
+	taskJobs map[string]string
+	mu       sync.Mutex
+
+	// runStore records the runs of the batch jobs
+	runStore RunStore
+	// instanceID identifies the instance on the runs it triggers
+	instanceID string
+	// alert is called once a batch job failed alertThreshold runs in a row
+	alert          AlertFunc
+	alertThreshold int
 }
 
 var _ Runnable = (*Batcher)(nil)
@@ -80,39 +107,254 @@ func NewBatcher(options ...BatcherOption) *Batcher {
 		option(batcher)
 	}
 
+	if batcher.instanceID == "" {
+		batcher.instanceID = defaultInstanceID()
+	}
+
 	return batcher
 }
 
+// WithConfigProvider sets the source of the batch jobs scheduled by Start.
+//
+// Example:
+//
+//	batcher := NewBatcher(WithConfigProvider(NewFileConfigProvider("/etc/batch-jobs.yaml")))
+func WithConfigProvider(provider ConfigProvider) BatcherOption {
+	return func(b *Batcher) {
+		b.provider = provider
+	}
+}
+
+// WithSyncInterval sets the interval at which the config provider is read again. It defaults to
+// 3 minutes.
+func WithSyncInterval(interval time.Duration) BatcherOption {
+	return func(b *Batcher) {
+		b.syncInterval = interval
+	}
+}
+
+// WithErrorHandler sets the function receiving the errors met while syncing the batch jobs: a
+// *JobError for each batch job that cannot be scheduled, or the error of the config provider, in
+// which case the scheduled jobs are left as they are.
+//
+// Example:
+//
+//	batcher := NewBatcher(WithErrorHandler(func(err error) {
+//	    logger.Error("failed to sync batch job", zap.Error(err))
+//	}))
+func WithErrorHandler(handler func(err error)) BatcherOption {
+	return func(b *Batcher) {
+		b.errorHandler = handler
+	}
+}
+
 // RegisterRecurringBatchJobs implements the Runnable interface.
-// It validates and registers each batch job with the task processor.
-// If a job with the same ID already exists, it will be skipped without error.
-// Any other registration errors will be returned immediately.
+// It validates and registers each enabled batch job with the task processor. A job that fails
+// validation or registration does not keep the other jobs from being registered: the errors of the
+// jobs are returned together as a *RegistrationError.
+//
+// The last run of the jobs is not recorded on this path, so a job with the MissedRunsRunOnce policy
+// is rejected with ErrMissedRunsUnsupported: schedule it with Start instead.
 func (b *Batcher) RegisterRecurringBatchJobs(ctx context.Context) error {
-	for _, job := range b.batchJobs.jobs {
-		// first validate the job
-		if err := job.Validate(); err != nil {
-			return err
+	if b.processor == nil {
+		return ErrTaskProcessorNotSet
+	}
+
+	jobs, errs := validateJobs(b.batchJobs.jobs)
+	for _, job := range jobs {
+		if err := b.registerRecurringBatchJob(ctx, job); err != nil {
+			errs = append(errs, &JobError{JobID: job.ID(), Err: err})
 		}
+	}
+
+	return registrationError(errs)
+}
+
+// registerRecurringBatchJob registers the cron expressions of a valid batch job with the scheduler
+// of the task processor.
+func (b *Batcher) registerRecurringBatchJob(ctx context.Context, job *BatchJob) error {
+	schedule, err := job.Schedule()
+	if err != nil {
+		return err
+	}
+
+	if schedule.MissedRuns() == MissedRunsRunOnce {
+		return fmt.Errorf("%w: %q is only supported by Start", ErrMissedRunsUnsupported, MissedRunsRunOnce)
+	}
+
+	tasks, err := job.recurringTasks()
+	if err != nil {
+		return err
+	}
 
-		// then register the job
-		_, err := b.processor.EnqueueRecurringTask(
+	for _, task := range tasks {
+		if _, err := b.processor.EnqueueRecurringTask(
 			ctx,
 			job.task,
-			job.ProcessingInterval(),
-			asynq.TaskID(*job.taskId),
-		)
+			taskprocessor.ProcessingInterval(task.cronspec),
+			task.opts...,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Start schedules the batch jobs of the config provider and keeps them in sync with it: jobs added
+// to the provider are scheduled, removed or disabled jobs are unscheduled and rescheduled jobs
+// follow their new interval. Start fails when the provider cannot be read; the batch jobs that
+// cannot be scheduled are reported to the error handler.
+//
+// Example:
+//
+//	batcher := NewBatcher(
+//	    WithTaskProcessor(processor),
+//	    WithConfigProvider(NewRedisConfigProvider(redisClient, "config:batch-jobs")),
+//	    WithSyncInterval(time.Minute),
+//	)
+//	if err := batcher.Start(); err != nil {
+//	    return err
+//	}
+//	defer batcher.Shutdown()
+func (b *Batcher) Start() error {
+	if b.processor == nil {
+		return ErrTaskProcessorNotSet
+	}
+
+	if b.provider == nil {
+		return ErrConfigProviderNotSet
+	}
+
+	redisClient, ok := b.processor.RedisConnOpt().MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		return fmt.Errorf("unsupported redis client %T", b.processor.RedisConnOpt().MakeRedisClient())
+	}
+	b.redisClient = redisClient
+
+	manager, err := asynq.NewPeriodicTaskManager(asynq.PeriodicTaskManagerOpts{
+		PeriodicTaskConfigProvider: periodicTaskConfigProvider{b},
+		RedisConnOpt:               b.processor.RedisConnOpt(),
+		SchedulerOpts:              b.schedulerOpts(),
+		SyncInterval:               b.syncInterval,
+	})
+	if err != nil {
+		_ = redisClient.Close()
+		return err
+	}
+
+	if err := manager.Start(); err != nil {
+		_ = redisClient.Close()
+		return err
+	}
+	b.manager = manager
+
+	b.mu.Lock()
+	jobs := b.jobs
+	b.mu.Unlock()
+	b.runMissed(context.Background(), jobs, time.Now())
+
+	return nil
+}
+
+// Shutdown stops scheduling the batch jobs of the config provider.
+func (b *Batcher) Shutdown() {
+	if b.manager != nil {
+		b.manager.Shutdown()
+		b.manager = nil
+	}
+
+	if b.redisClient != nil {
+		_ = b.redisClient.Close()
+		b.redisClient = nil
+	}
+}
+
+// periodicTaskConfigProvider adapts the config provider of a Batcher to the PeriodicTaskManager.
+type periodicTaskConfigProvider struct {
+	b *Batcher
+}
+
+// GetConfigs implements asynq.PeriodicTaskConfigProvider.
+func (p periodicTaskConfigProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error) {
+	return p.b.periodicTaskConfigs()
+}
 
+// periodicTaskConfigs reads the batch jobs of the config provider for the PeriodicTaskManager,
+// leaving out and reporting the jobs that cannot be scheduled so that they do not fail the sync of
+// the others.
+func (b *Batcher) periodicTaskConfigs() ([]*asynq.PeriodicTaskConfig, error) {
+	jobs, err := b.provider.BatchJobs(context.Background())
+	if err != nil {
+		b.reportError(err)
+		return nil, err
+	}
+
+	jobs, errs := validateJobs(jobs)
+	for _, err := range errs {
+		b.reportError(err)
+	}
+
+	var (
+		configs   = make([]*asynq.PeriodicTaskConfig, 0, len(jobs))
+		scheduled = make([]*BatchJob, 0, len(jobs))
+		taskJobs  = make(map[string]string)
+	)
+	for _, job := range jobs {
+		tasks, err := job.recurringTasks()
 		if err != nil {
-			switch {
-			case errors.Is(err, asynq.ErrTaskIDConflict):
-				return nil
-			case err != nil:
-				return err
-			}
-
-			return nil
+			b.reportError(&JobError{JobID: job.ID(), Err: err})
+			continue
+		}
+
+		for _, task := range tasks {
+			configs = append(configs, &asynq.PeriodicTaskConfig{Cronspec: task.cronspec, Task: job.task, Opts: task.opts})
+			taskJobs[task.taskID] = job.ID()
 		}
+		scheduled = append(scheduled, job)
 	}
 
-	return nil
+	b.mu.Lock()
+	b.jobs = scheduled
+	b.taskJobs = taskJobs
+	b.mu.Unlock()
+
+	return configs, nil
+}
+
+func (b *Batcher) reportError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler(err)
+	}
+}
+
+// validateJobs returns the enabled batch jobs that are valid, and the errors of the invalid ones.
+// A job whose identifier is used by a previous job is invalid.
+func validateJobs(jobs []*BatchJob) ([]*BatchJob, []*JobError) {
+	var (
+		valid []*BatchJob
+		errs  []*JobError
+		ids   = make(map[string]bool)
+	)
+
+	for _, job := range jobs {
+		if !job.Enabled {
+			continue
+		}
+
+		if err := job.Validate(); err != nil {
+			errs = append(errs, &JobError{JobID: job.ID(), Err: err})
+			continue
+		}
+
+		if ids[job.ID()] {
+			errs = append(errs, &JobError{JobID: job.ID(), Err: ErrDuplicateJobID})
+			continue
+		}
+		ids[job.ID()] = true
+
+		valid = append(valid, job)
+	}
+
+	return valid, errs
 }
@@ -0,0 +1,148 @@
+package batch_job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	taskprocessor "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type muxHandler struct{}
+
+func (muxHandler) RegisterTaskHandler() *asynq.ServeMux { return asynq.NewServeMux() }
+func (muxHandler) Validate() error                      { return nil }
+
+// newTestTaskProcessor creates a task processor backed by an in-memory redis server
+func newTestTaskProcessor(t *testing.T) *taskprocessor.TaskProcessor {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	concurrency := 1
+	tp, err := taskprocessor.NewTaskProcessor(
+		taskprocessor.WithRedisAddressOpt("redis://"+server.Addr()),
+		taskprocessor.WithLoggerOpt(zap.NewNop()),
+		taskprocessor.WithInstrumentationClientOpt(&instrumentation.Client{}),
+		taskprocessor.WithConcurrencyFactorOpt(&concurrency),
+		taskprocessor.WithTaskHandlerOpt(muxHandler{}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tp.Close() })
+
+	return tp
+}
+
+func newTestJob(id, interval string, enabled bool) *BatchJob {
+	return NewBatchJob(
+		WithBaseConfig(BaseConfig{Enabled: enabled, Interval: interval, MaxRetries: 1}),
+		WithTask(asynq.NewTask("cleanup:"+id, nil)),
+		WithTaskId(&id),
+	)
+}
+
+func TestRegisterRecurringBatchJobsReportsErrorsPerJob(t *testing.T) {
+	jobs := NewBatchJobs(
+		WithBatchJob(newTestJob("first", "@daily", true)),
+		WithBatchJob(newTestJob("invalid", "", true)),
+		WithBatchJob(newTestJob("first", "@daily", true)),
+		WithBatchJob(newTestJob("disabled", "", false)),
+		WithBatchJob(newTestJob("last", "@every 1h", true)),
+	)
+
+	batcher := NewBatcher(WithBatchJobsRef(jobs), WithTaskProcessor(newTestTaskProcessor(t)))
+	err := batcher.RegisterRecurringBatchJobs(context.Background())
+
+	var registrationErr *RegistrationError
+	require.ErrorAs(t, err, &registrationErr)
+	require.Len(t, registrationErr.Errors, 2)
+	assert.Equal(t, "invalid", registrationErr.Errors[0].JobID)
+	assert.Equal(t, "first", registrationErr.Errors[1].JobID)
+	assert.ErrorIs(t, err, ErrDuplicateJobID)
+}
+
+func TestRegisterRecurringBatchJobsRejectsRunOnce(t *testing.T) {
+	id := "catch-up"
+	job := NewBatchJob(
+		WithBaseConfig(BaseConfig{Enabled: true, Interval: "@daily", MissedRuns: MissedRunsRunOnce}),
+		WithTask(asynq.NewTask("cleanup:"+id, nil)),
+		WithTaskId(&id),
+	)
+
+	batcher := NewBatcher(
+		WithBatchJobsRef(NewBatchJobs(WithBatchJob(job), WithBatchJob(newTestJob("skip", "@daily", true)))),
+		WithTaskProcessor(newTestTaskProcessor(t)),
+	)
+	err := batcher.RegisterRecurringBatchJobs(context.Background())
+
+	var registrationErr *RegistrationError
+	require.ErrorAs(t, err, &registrationErr)
+	require.Len(t, registrationErr.Errors, 1)
+	assert.Equal(t, id, registrationErr.Errors[0].JobID)
+	assert.ErrorIs(t, err, ErrMissedRunsUnsupported)
+}
+
+func TestBatcherSyncsConfigProvider(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		jobs = []*BatchJob{newTestJob("first", "@daily", true), newTestJob("invalid", "", true)}
+		errs []error
+	)
+	errProvider := errors.New("provider unavailable")
+	failing := false
+
+	batcher := NewBatcher(
+		WithTaskProcessor(newTestTaskProcessor(t)),
+		WithConfigProvider(ConfigProviderFunc(func(ctx context.Context) ([]*BatchJob, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failing {
+				return nil, errProvider
+			}
+			return jobs, nil
+		})),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}),
+	)
+
+	require.NoError(t, batcher.Start())
+	defer batcher.Shutdown()
+
+	mu.Lock()
+	require.Len(t, errs, 1)
+	var jobErr *JobError
+	require.ErrorAs(t, errs[0], &jobErr)
+	assert.Equal(t, "invalid", jobErr.JobID)
+	failing = true
+	mu.Unlock()
+
+	configs, err := batcher.periodicTaskConfigs()
+	assert.ErrorIs(t, err, errProvider)
+	assert.Nil(t, configs)
+
+	mu.Lock()
+	failing = false
+	jobs = append(jobs, newTestJob("second", "@every 1h", true))
+	mu.Unlock()
+
+	configs, err = batcher.periodicTaskConfigs()
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "@every 1h", configs[1].Cronspec)
+	assert.Equal(t, "cleanup:second", configs[1].Task.Type())
+	assert.Contains(t, configs[1].Opts, asynq.TaskID("second"))
+}
+
+func TestBatcherStartRequiresProvider(t *testing.T) {
+	assert.ErrorIs(t, NewBatcher().Start(), ErrTaskProcessorNotSet)
+	assert.ErrorIs(t, NewBatcher(WithTaskProcessor(newTestTaskProcessor(t))).Start(), ErrConfigProviderNotSet)
+}
@@ -0,0 +1,210 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// MissedRunsPolicy tells what to do with the runs of a batch job missed while no scheduler was
+// running, for example during a deploy.
+type MissedRunsPolicy string
+
+const (
+	// MissedRunsSkip skips the missed runs. The job runs at its next scheduled time.
+	MissedRunsSkip MissedRunsPolicy = "skip"
+	// MissedRunsRunOnce runs the job once when the scheduler starts, however many runs were missed.
+	MissedRunsRunOnce MissedRunsPolicy = "runOnce"
+)
+
+// ErrInvalidSchedule is returned for a batch job whose interval, time zone, jitter or missed runs
+// policy is invalid
+var ErrInvalidSchedule = errors.New("invalid batch job schedule")
+
+// defaultQueue is the asynq queue of the tasks enqueued without a queue option
+const defaultQueue = "default"
+
+// scheduleParser parses cron expressions with an optional seconds field, descriptors such as
+// @daily and @every 30s, and a CRON_TZ= or TZ= time zone prefix.
+var scheduleParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// Schedule is the parsed schedule of a batch job.
+type Schedule struct {
+	// spec is the interval without its time zone prefix
+	spec string
+	// location is the time zone the schedule is evaluated in
+	location *time.Location
+	// jitter is the upper bound of the fixed start offset of the runs
+	jitter time.Duration
+	// jitterSeed is mixed into the start offset of the runs
+	jitterSeed string
+	// missedRuns is the policy of the runs missed while no scheduler was running
+	missedRuns MissedRunsPolicy
+	// schedule computes the run times
+	schedule cron.Schedule
+	// seconds holds the seconds of the minute the job runs at, for expressions with a seconds field
+	seconds []int
+}
+
+// scheduleEntry is a cron expression understood by the asynq scheduler, which has no seconds
+// field, and the delay of the tasks it enqueues.
+type scheduleEntry struct {
+	cronspec string
+	delay    time.Duration
+	// second is the second of the minute of the entry, -1 for expressions without seconds
+	second int
+}
+
+// ParseSchedule parses and validates the schedule of a base configuration. The interval is a
+// cron expression with an optional leading seconds field ("0 30 9 * * MON-FRI"), a descriptor
+// ("@daily", "@every 90s") or one of the taskprocessor.ProcessingInterval constants.
+//
+// Example:
+//
+//	schedule, err := ParseSchedule(BaseConfig{
+//	    Interval: "0 30 2 * * *",
+//	    TimeZone: "Europe/Paris",
+//	    Jitter:   "5m",
+//	})
+func ParseSchedule(cfg BaseConfig) (*Schedule, error) {
+	spec := strings.TrimSpace(cfg.Interval)
+	if spec == "" {
+		return nil, fmt.Errorf("%w: interval is empty", ErrInvalidSchedule)
+	}
+
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return nil, fmt.Errorf("%w: interval %q: set the time zone with timeZone", ErrInvalidSchedule, spec)
+	}
+
+	location := time.UTC
+	if cfg.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.TimeZone); err != nil {
+			return nil, fmt.Errorf("%w: time zone %q is not an IANA time zone | %s", ErrInvalidSchedule, cfg.TimeZone, err.Error())
+		}
+	}
+
+	schedule, err := scheduleParser.Parse("CRON_TZ=" + location.String() + " " + spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: interval %q | %s", ErrInvalidSchedule, spec, err.Error())
+	}
+
+	var jitter time.Duration
+	if cfg.Jitter != "" {
+		if jitter, err = time.ParseDuration(cfg.Jitter); err != nil || jitter < 0 {
+			return nil, fmt.Errorf("%w: jitter %q is not a non-negative duration", ErrInvalidSchedule, cfg.Jitter)
+		}
+	}
+
+	missedRuns := cfg.MissedRuns
+	switch missedRuns {
+	case "":
+		missedRuns = MissedRunsSkip
+	case MissedRunsSkip, MissedRunsRunOnce:
+	default:
+		return nil, fmt.Errorf("%w: missed runs policy %q is neither %q nor %q", ErrInvalidSchedule, missedRuns, MissedRunsSkip, MissedRunsRunOnce)
+	}
+
+	s := &Schedule{
+		spec:       spec,
+		location:   location,
+		jitter:     jitter,
+		jitterSeed: cfg.JitterSeed,
+		missedRuns: missedRuns,
+		schedule:   schedule,
+	}
+
+	if spec, ok := schedule.(*cron.SpecSchedule); ok && !strings.HasPrefix(s.spec, "@") && len(strings.Fields(s.spec)) == 6 {
+		for second := 0; second < 60; second++ {
+			if spec.Second&(1<<uint(second)) != 0 {
+				s.seconds = append(s.seconds, second)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Next returns the first run time after t, without jitter.
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.schedule.Next(t)
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+// MissedRuns returns the policy of the runs missed while no scheduler was running.
+func (s *Schedule) MissedRuns() MissedRunsPolicy {
+	return s.missedRuns
+}
+
+// OffsetFor returns the start delay of the runs of a job, between zero and the jitter. The asynq
+// scheduler enqueues every run of a recurring task with the options it was registered with, so the
+// delay cannot be drawn again for each run. The offset is instead a hash of the jitter seed, the
+// task type, the queue and the job id: it is the same on every run and every instance of a
+// service, while jobs that differ in any of them are spread over the jitter. An empty queue is the
+// default queue.
+func (s *Schedule) OffsetFor(jobID, taskType, queue string) time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	if queue == "" {
+		queue = defaultQueue
+	}
+
+	h := fnv.New64a()
+	for _, part := range []string{s.jitterSeed, taskType, queue, jobID} {
+		_, _ = h.Write([]byte(part))
+		// separate the parts so that moving characters between them changes the hash
+		_, _ = h.Write([]byte{0})
+	}
+
+	return time.Duration(h.Sum64() % uint64(s.jitter))
+}
+
+// entries returns the cron expressions registering the schedule with the asynq scheduler, whose
+// tasks are delayed by offset. An expression with a seconds field runs every minute it matches,
+// delaying its tasks by the seconds, with one entry per second of the minute.
+func (s *Schedule) entries(offset time.Duration) []scheduleEntry {
+	// a constant delay does not depend on the time zone
+	prefix := "CRON_TZ=" + s.location.String() + " "
+	if strings.HasPrefix(s.spec, "@every ") {
+		prefix = ""
+	}
+
+	if len(s.seconds) == 0 {
+		return []scheduleEntry{{cronspec: prefix + s.spec, delay: offset, second: -1}}
+	}
+
+	minuteSpec := strings.Join(strings.Fields(s.spec)[1:], " ")
+	entries := make([]scheduleEntry, 0, len(s.seconds))
+	for _, second := range s.seconds {
+		entries = append(entries, scheduleEntry{
+			cronspec: prefix + minuteSpec,
+			delay:    time.Duration(second)*time.Second + offset,
+			second:   second,
+		})
+	}
+
+	return entries
+}
+
+// taskID returns the id of the tasks enqueued by an entry of the job. Entries of an expression
+// with several seconds per minute get an id each, so that they do not conflict.
+func (e scheduleEntry) taskID(jobID string, entries int) string {
+	if entries <= 1 {
+		return jobID
+	}
+
+	return jobID + ":" + strconv.Itoa(e.second)
+}
@@ -0,0 +1,220 @@
+package batch_job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	taskprocessor "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     BaseConfig
+		wantErr bool
+	}{
+		{name: "descriptor", cfg: BaseConfig{Interval: "@daily"}},
+		{name: "every", cfg: BaseConfig{Interval: "@every 90s"}},
+		{name: "five fields", cfg: BaseConfig{Interval: "30 9 * * MON-FRI"}},
+		{name: "seconds field", cfg: BaseConfig{Interval: "*/15 * * * * *"}},
+		{name: "time zone", cfg: BaseConfig{Interval: "0 2 * * *", TimeZone: "Europe/Paris"}},
+		{name: "jitter and policy", cfg: BaseConfig{Interval: "@hourly", Jitter: "5m", MissedRuns: MissedRunsRunOnce}},
+		{name: "empty interval", cfg: BaseConfig{}, wantErr: true},
+		{name: "invalid expression", cfg: BaseConfig{Interval: "61 * * * *"}, wantErr: true},
+		{name: "legacy duration", cfg: BaseConfig{Interval: "10s"}, wantErr: true},
+		{name: "time zone prefix", cfg: BaseConfig{Interval: "CRON_TZ=UTC @daily"}, wantErr: true},
+		{name: "unknown time zone", cfg: BaseConfig{Interval: "@daily", TimeZone: "Mars/Olympus"}, wantErr: true},
+		{name: "invalid jitter", cfg: BaseConfig{Interval: "@daily", Jitter: "soon"}, wantErr: true},
+		{name: "negative jitter", cfg: BaseConfig{Interval: "@daily", Jitter: "-1m"}, wantErr: true},
+		{name: "unknown policy", cfg: BaseConfig{Interval: "@daily", MissedRuns: "catchUpAll"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(tt.cfg)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidSchedule))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseScheduleAcceptsProcessingIntervals(t *testing.T) {
+	intervals := []taskprocessor.ProcessingInterval{
+		taskprocessor.EveryYear, taskprocessor.EveryMonth, taskprocessor.EveryWeek,
+		taskprocessor.EveryDayAtMidnight, taskprocessor.EveryDay, taskprocessor.Every24Hours,
+		taskprocessor.Every12Hours, taskprocessor.Every6Hours, taskprocessor.Every3Hours,
+		taskprocessor.EveryHour, taskprocessor.Every30Minutes, taskprocessor.Every15Minutes,
+		taskprocessor.Every10Minutes, taskprocessor.Every5Minutes, taskprocessor.Every3Minutes,
+		taskprocessor.Every1Minutes, taskprocessor.Every30Seconds,
+	}
+
+	for _, interval := range intervals {
+		cfg := BaseConfig{Interval: string(interval)}
+		_, err := cfg.Schedule()
+		assert.NoError(t, err, interval)
+	}
+}
+
+func TestScheduleNextUsesTimeZone(t *testing.T) {
+	schedule, err := ParseSchedule(BaseConfig{Interval: "0 30 9 * * *", TimeZone: "America/New_York"})
+	require.NoError(t, err)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	next := schedule.Next(time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, time.January, 15, 9, 30, 0, 0, newYork).Unix(), next.Unix())
+	assert.Equal(t, newYork.String(), schedule.Location().String())
+	assert.Equal(t, MissedRunsSkip, schedule.MissedRuns())
+}
+
+func TestScheduleEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      BaseConfig
+		expected []scheduleEntry
+	}{
+		{
+			name:     "descriptor",
+			cfg:      BaseConfig{Interval: "@daily"},
+			expected: []scheduleEntry{{cronspec: "CRON_TZ=UTC @daily", second: -1}},
+		},
+		{
+			name:     "every",
+			cfg:      BaseConfig{Interval: "@every 1h", TimeZone: "Asia/Tokyo"},
+			expected: []scheduleEntry{{cronspec: "@every 1h", second: -1}},
+		},
+		{
+			name:     "five fields",
+			cfg:      BaseConfig{Interval: "30 9 * * MON-FRI", TimeZone: "Europe/Paris"},
+			expected: []scheduleEntry{{cronspec: "CRON_TZ=Europe/Paris 30 9 * * MON-FRI", second: -1}},
+		},
+		{
+			name: "seconds field",
+			cfg:  BaseConfig{Interval: "15,45 */5 * * * *"},
+			expected: []scheduleEntry{
+				{cronspec: "CRON_TZ=UTC */5 * * * *", delay: 15 * time.Second, second: 15},
+				{cronspec: "CRON_TZ=UTC */5 * * * *", delay: 45 * time.Second, second: 45},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.cfg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, schedule.entries(0))
+		})
+	}
+}
+
+func TestScheduleOffsetIsStablePerJob(t *testing.T) {
+	schedule, err := ParseSchedule(BaseConfig{Interval: "@hourly", Jitter: "10m"})
+	require.NoError(t, err)
+
+	offset := schedule.OffsetFor("cleanup", "batch:cleanup", "")
+	assert.Equal(t, offset, schedule.OffsetFor("cleanup", "batch:cleanup", ""))
+	assert.Equal(t, offset, schedule.OffsetFor("cleanup", "batch:cleanup", defaultQueue))
+	assert.GreaterOrEqual(t, offset, time.Duration(0))
+	assert.Less(t, offset, 10*time.Minute)
+
+	entries := schedule.entries(offset)
+	require.Len(t, entries, 1)
+	assert.Equal(t, offset, entries[0].delay)
+}
+
+func TestScheduleOffsetDependsOnSeedTaskTypeAndQueue(t *testing.T) {
+	// offsets returns the offsets of a few jobs, so that a single hash collision does not matter
+	offsets := func(seed, taskType, queue string) []time.Duration {
+		schedule, err := ParseSchedule(BaseConfig{Interval: "@hourly", Jitter: "1h", JitterSeed: seed})
+		require.NoError(t, err)
+
+		var offsets []time.Duration
+		for _, jobID := range []string{"cleanup", "report", "sync"} {
+			offsets = append(offsets, schedule.OffsetFor(jobID, taskType, queue))
+		}
+		return offsets
+	}
+
+	tests := []struct {
+		name     string
+		seed     string
+		taskType string
+		queue    string
+	}{
+		{name: "jitter seed", seed: "staging", taskType: "batch:run", queue: "default"},
+		{name: "task type", taskType: "batch:other", queue: "default"},
+		{name: "queue", taskType: "batch:run", queue: "low"},
+	}
+
+	base := offsets("", "batch:run", "default")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotEqual(t, base, offsets(tt.seed, tt.taskType, tt.queue))
+		})
+	}
+}
+
+func TestScheduleEntryTaskID(t *testing.T) {
+	assert.Equal(t, "job", scheduleEntry{second: -1}.taskID("job", 1))
+	assert.Equal(t, "job:30", scheduleEntry{second: 30}.taskID("job", 2))
+}
+
+func TestRunMissed(t *testing.T) {
+	tp := newTestTaskProcessor(t)
+	client := tp.RedisConnOpt().MakeRedisClient().(redis.UniversalClient)
+	t.Cleanup(func() { _ = client.Close() })
+
+	batcher := NewBatcher(WithTaskProcessor(tp))
+	batcher.redisClient = client
+
+	newJob := func(id string, policy MissedRunsPolicy) *BatchJob {
+		job := newTestJob(id, "@hourly", true)
+		job.MissedRuns = policy
+		return job
+	}
+
+	ctx := context.Background()
+	now := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, batcher.recordRun(ctx, "missed", now.Add(-2*time.Hour)))
+	require.NoError(t, batcher.recordRun(ctx, "skipped", now.Add(-2*time.Hour)))
+	require.NoError(t, batcher.recordRun(ctx, "recent", now.Add(-10*time.Minute)))
+
+	batcher.runMissed(ctx, []*BatchJob{
+		newJob("missed", MissedRunsRunOnce),
+		newJob("skipped", MissedRunsSkip),
+		newJob("recent", MissedRunsRunOnce),
+		newJob("new", MissedRunsRunOnce),
+	}, now)
+
+	inspector := asynq.NewInspector(tp.RedisConnOpt())
+
+	tasks, err := inspector.ListPendingTasks("default")
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "missed", tasks[0].ID)
+
+	for _, id := range []string{"missed", "recent", "new"} {
+		last, ok, err := batcher.lastRun(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, ok, id)
+		assert.Equal(t, now.Unix(), last.Unix(), id)
+	}
+
+	// the pending run is not enqueued twice by another instance
+	require.NoError(t, batcher.recordRun(ctx, "missed", now.Add(-2*time.Hour)))
+	batcher.runMissed(ctx, []*BatchJob{newJob("missed", MissedRunsRunOnce)}, now)
+
+	tasks, err = inspector.ListPendingTasks("default")
+	require.NoError(t, err)
+	assert.Len(t, tasks, 1)
+}
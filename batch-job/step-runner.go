@@ -0,0 +1,571 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	taskprocessor "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// StepTaskType is the type of the tasks starting an execution of a step
+	StepTaskType = "batchjob:step"
+	// ChunkTaskType is the type of the tasks processing a chunk of a step execution
+	ChunkTaskType = "batchjob:chunk"
+
+	// executionKeyPrefix prefixes the redis hashes holding the state of the step executions
+	executionKeyPrefix = "batchjob:execution:"
+	// stepStartKeyPrefix prefixes the redis keys holding the execution started by a step task, for
+	// its retries
+	stepStartKeyPrefix = "batchjob:step-start:"
+	// stepStartTTL is the time the execution of a step task is kept for its retries
+	stepStartTTL = 24 * time.Hour
+)
+
+var (
+	// ErrStepNotFound is returned for a step that is not registered with the StepRunner
+	ErrStepNotFound = errors.New("step not found")
+	// ErrDuplicateStep is returned when registering a step under the name of another step
+	ErrDuplicateStep = errors.New("duplicate step")
+	// ErrExecutionNotFound is returned for an unknown step execution
+	ErrExecutionNotFound = errors.New("step execution not found")
+	// ErrExecutionNotResumable is returned when resuming a step execution that did not fail
+	ErrExecutionNotResumable = errors.New("step execution is not resumable")
+)
+
+// ExecutionStatus is the state of a step execution.
+type ExecutionStatus string
+
+const (
+	// ExecutionStatusRunning is the status of an execution with chunks left to process
+	ExecutionStatusRunning ExecutionStatus = "running"
+	// ExecutionStatusCompleted is the status of an execution whose chunks were all processed
+	ExecutionStatusCompleted ExecutionStatus = "completed"
+	// ExecutionStatusFailed is the status of an execution with chunks that failed for the last
+	// time. It can be resumed.
+	ExecutionStatusFailed ExecutionStatus = "failed"
+)
+
+// StepExecution is the progress of an execution of a step.
+type StepExecution struct {
+	// ID is the identifier of the execution
+	ID string `json:"id"`
+	// Step is the name of the step
+	Step string `json:"step"`
+	// Status is the state of the execution
+	Status ExecutionStatus `json:"status"`
+	// Cursor is the checkpoint of the execution: the cursor of the next chunk to read
+	Cursor string `json:"cursor"`
+	// StartedAt is the time the execution started
+	StartedAt time.Time `json:"startedAt"`
+	// FinishedAt is the time the execution completed or failed
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// ChunksDispatched is the number of chunk tasks enqueued
+	ChunksDispatched int64 `json:"chunksDispatched"`
+	// ChunksInFlight is the number of chunks enqueued or in progress
+	ChunksInFlight int64 `json:"chunksInFlight"`
+	// ChunksCompleted is the number of chunks processed
+	ChunksCompleted int64 `json:"chunksCompleted"`
+	// ChunksFailed is the number of chunks that failed for the last time
+	ChunksFailed int64 `json:"chunksFailed"`
+	// ItemsRead is the number of items read
+	ItemsRead int64 `json:"itemsRead"`
+	// ItemsWritten is the number of items written
+	ItemsWritten int64 `json:"itemsWritten"`
+	// Error is the error of the last chunk that failed
+	Error string `json:"error,omitempty"`
+}
+
+// StepRunner runs the chunks of the registered steps as asynq tasks. The state of the executions
+// is kept in the redis server of the task processor, so that any worker can process any chunk.
+type StepRunner struct {
+	processor   *taskprocessor.TaskProcessor
+	redisClient redis.UniversalClient
+
+	steps map[string]step
+	mu    sync.RWMutex
+}
+
+// NewStepRunner creates a StepRunner enqueueing the chunks with the task processor.
+//
+// Example:
+//
+//	runner, err := NewStepRunner(processor)
+//	if err != nil {
+//	    return err
+//	}
+//	defer runner.Close()
+//
+//	if err := RegisterStep(runner, step); err != nil {
+//	    return err
+//	}
+//	runner.RegisterHandlers(mux)
+func NewStepRunner(processor *taskprocessor.TaskProcessor) (*StepRunner, error) {
+	if processor == nil {
+		return nil, ErrTaskProcessorNotSet
+	}
+
+	redisClient, ok := processor.RedisConnOpt().MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		return nil, fmt.Errorf("unsupported redis client %T", processor.RedisConnOpt().MakeRedisClient())
+	}
+
+	return &StepRunner{
+		processor:   processor,
+		redisClient: redisClient,
+		steps:       make(map[string]step),
+	}, nil
+}
+
+// Close closes the redis connection of the runner.
+func (r *StepRunner) Close() error {
+	return r.redisClient.Close()
+}
+
+// RegisterStep registers a step with the runner, so that its executions can be started and its
+// chunks processed.
+func RegisterStep[T, R any](runner *StepRunner, s *Step[T, R]) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+
+	if _, ok := runner.steps[s.Name()]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateStep, s.Name())
+	}
+	runner.steps[s.Name()] = s
+
+	return nil
+}
+
+// RegisterHandlers registers the handlers of the step and chunk tasks with the mux of the worker.
+func (r *StepRunner) RegisterHandlers(mux *asynq.ServeMux) {
+	mux.HandleFunc(StepTaskType, r.processStep)
+	mux.HandleFunc(ChunkTaskType, r.processChunk)
+}
+
+// NewStepTask returns a task starting an execution of the step, to schedule a step as a batch job.
+//
+// Example:
+//
+//	job := NewBatchJob(
+//	    WithBaseConfig(BaseConfig{Enabled: true, Interval: "0 2 * * *"}),
+//	    WithTask(NewStepTask("monthly-invoices")),
+//	    WithTaskId(&jobID),
+//	)
+func NewStepTask(name string) *asynq.Task {
+	payload, _ := json.Marshal(stepPayload{Step: name})
+	return asynq.NewTask(StepTaskType, payload)
+}
+
+// stepPayload is the payload of the tasks starting an execution of a step
+type stepPayload struct {
+	Step string `json:"step"`
+}
+
+// chunkPayload is the payload of the tasks processing a chunk
+type chunkPayload struct {
+	Execution string `json:"execution"`
+	Step      string `json:"step"`
+	Index     int64  `json:"index"`
+	Cursor    string `json:"cursor"`
+}
+
+// step returns the registered step with the name.
+func (r *StepRunner) step(name string) (step, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.steps[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStepNotFound, name)
+	}
+
+	return s, nil
+}
+
+// executionKey returns the key of the redis hash holding the state of the execution.
+func executionKey(id string) string {
+	return executionKeyPrefix + id
+}
+
+// StartExecution starts an execution of the step from its initial cursor, enqueueing its first
+// chunk.
+func (r *StepRunner) StartExecution(ctx context.Context, name string) (*StepExecution, error) {
+	id := uuid.NewString()
+	if err := r.startExecution(ctx, name, id); err != nil {
+		r.redisClient.Del(ctx, executionKey(id))
+		return nil, err
+	}
+
+	return r.Execution(ctx, id)
+}
+
+// startExecution starts the execution with the id, unless it exists, and enqueues its first chunk
+// when a previous start did not.
+func (r *StepRunner) startExecution(ctx context.Context, name, id string) error {
+	s, err := r.step(name)
+	if err != nil {
+		return err
+	}
+
+	config := s.settings()
+	if err := startScript.Run(ctx, r.redisClient, []string{executionKey(id)},
+		name, time.Now().UTC().Format(time.RFC3339Nano), config.initialCursor, config.parallelism,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to create the step execution | %s", err.Error())
+	}
+
+	return r.flushDispatch(ctx, s, id, "start")
+}
+
+// Execution returns the progress of a step execution.
+func (r *StepRunner) Execution(ctx context.Context, id string) (*StepExecution, error) {
+	fields, err := r.redisClient.HGetAll(ctx, executionKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+
+	execution := &StepExecution{
+		ID:               id,
+		Step:             fields["step"],
+		Status:           ExecutionStatus(fields["status"]),
+		Cursor:           fields["cursor"],
+		ChunksDispatched: parseCount(fields["chunksDispatched"]),
+		ChunksInFlight:   parseCount(fields["inFlight"]),
+		ChunksCompleted:  parseCount(fields["chunksCompleted"]),
+		ChunksFailed:     parseCount(fields["chunksFailed"]),
+		ItemsRead:        parseCount(fields["itemsRead"]),
+		ItemsWritten:     parseCount(fields["itemsWritten"]),
+		Error:            fields["error"],
+	}
+
+	execution.StartedAt, _ = time.Parse(time.RFC3339Nano, fields["startedAt"])
+	if finishedAt, err := time.Parse(time.RFC3339Nano, fields["finishedAt"]); err == nil {
+		execution.FinishedAt = &finishedAt
+	}
+
+	return execution, nil
+}
+
+// parseCount parses a counter of an execution, missing counters being zero.
+func parseCount(value string) int64 {
+	count, _ := strconv.ParseInt(value, 10, 64)
+	return count
+}
+
+// Resume enqueues again the chunks of a failed execution from their checkpointed cursors. The
+// chunks processed are not processed again; when the execution failed while reading, it goes on
+// reading from the cursor of the chunk that failed.
+func (r *StepRunner) Resume(ctx context.Context, id string) (*StepExecution, error) {
+	execution, err := r.Execution(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := r.step(execution.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := resumeScript.Run(ctx, r.redisClient, []string{executionKey(id)}).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: %s is %s", ErrExecutionNotResumable, id, execution.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resume the step execution | %s", err.Error())
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(chunks); i += 2 {
+		index, _ := strconv.ParseInt(chunks[i], 10, 64)
+		payload := chunkPayload{Execution: id, Step: execution.Step, Index: index, Cursor: chunks[i+1]}
+
+		if err := r.enqueueChunk(ctx, s, payload); err != nil {
+			// the chunk fails again so that the execution can be resumed once more
+			errs = append(errs, err)
+			if err := r.finishChunk(ctx, s, payload, 0, err); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return r.Execution(ctx, id)
+}
+
+// processStep handles the tasks starting an execution of a step. The id of the execution is kept
+// under the task id, so that a retried task goes on with the execution its first attempt started
+// instead of starting another one. Scheduled tasks reuse their task id, so the first attempt of a
+// task always starts an execution of its own.
+func (r *StepRunner) processStep(ctx context.Context, t *asynq.Task) error {
+	var payload stepPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("invalid step payload | %s | %w", err.Error(), asynq.SkipRetry)
+	}
+
+	taskID, ok := asynq.GetTaskID(ctx)
+	if !ok {
+		_, err := r.StartExecution(ctx, payload.Step)
+		return err
+	}
+
+	id, err := r.claimExecution(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	return r.startExecution(ctx, payload.Step, id)
+}
+
+// claimExecution returns the id of the execution started by the task: a new id on the first
+// attempt of the task, and the id of the first attempt on its retries.
+func (r *StepRunner) claimExecution(ctx context.Context, taskID string) (string, error) {
+	var (
+		key = stepStartKeyPrefix + taskID
+		id  = uuid.NewString()
+	)
+
+	if retried, _ := asynq.GetRetryCount(ctx); retried == 0 {
+		if err := r.redisClient.Set(ctx, key, id, stepStartTTL).Err(); err != nil {
+			return "", fmt.Errorf("failed to claim the step execution | %s", err.Error())
+		}
+		return id, nil
+	}
+
+	// the claim of the first attempt is kept, unless it expired
+	claimed, err := r.redisClient.SetNX(ctx, key, id, stepStartTTL).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to claim the step execution | %s", err.Error())
+	}
+	if claimed {
+		return id, nil
+	}
+
+	if id, err = r.redisClient.Get(ctx, key).Result(); err != nil {
+		return "", fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+
+	return id, nil
+}
+
+// processChunk handles the tasks processing a chunk. The cursor of the next chunk is checkpointed
+// as soon as the chunk is read, so that the next chunk is processed while this one is, up to the
+// parallelism of the step.
+func (r *StepRunner) processChunk(ctx context.Context, t *asynq.Task) error {
+	var payload chunkPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("invalid chunk payload | %s | %w", err.Error(), asynq.SkipRetry)
+	}
+
+	s, err := r.step(payload.Step)
+	if err != nil {
+		return err
+	}
+
+	var (
+		key  = executionKey(payload.Execution)
+		from = strconv.FormatInt(payload.Index, 10)
+	)
+
+	// a previous attempt may have left the next chunk to enqueue, or the completion callback to call
+	if err := r.flushDispatch(ctx, s, payload.Execution, from); err != nil {
+		return err
+	}
+
+	done, err := chunkDoneScript.Run(ctx, r.redisClient, []string{key}, from).Bool()
+	if err != nil {
+		return fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+	if done {
+		return r.complete(ctx, s, payload.Execution, from)
+	}
+
+	chunkCtx := ctx
+	if timeout := s.settings().timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		chunkCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	written, err := s.runChunk(chunkCtx, payload.Cursor, func(next string, read int) error {
+		index, err := publishReadScript.Run(ctx, r.redisClient, []string{key}, from, next, read).Int64()
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint the chunk | %s", err.Error())
+		}
+
+		if index < 0 {
+			return nil
+		}
+
+		return r.flushDispatch(ctx, s, payload.Execution, from)
+	})
+
+	if err != nil && willRetry(ctx, err) {
+		return err
+	}
+
+	if finishErr := r.finishChunk(ctx, s, payload, written, err); finishErr != nil {
+		return errors.Join(err, finishErr)
+	}
+
+	return err
+}
+
+// finishChunk records the outcome of a chunk, enqueues the next chunk when one is waiting for a
+// free slot and calls the completion callback when the execution is over.
+func (r *StepRunner) finishChunk(ctx context.Context, s step, payload chunkPayload, written int, chunkErr error) error {
+	var (
+		from    = strconv.FormatInt(payload.Index, 10)
+		failed  = "0"
+		message string
+	)
+
+	if chunkErr != nil {
+		failed = "1"
+		message = chunkErr.Error()
+	}
+
+	result, err := finishChunkScript.Run(ctx, r.redisClient, []string{executionKey(payload.Execution)},
+		from, failed, payload.Cursor, written, message, time.Now().UTC().Format(time.RFC3339Nano),
+	).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("failed to record the chunk | %s", err.Error())
+	}
+
+	if result[0] >= 0 {
+		if err := r.flushDispatch(ctx, s, payload.Execution, from); err != nil {
+			return err
+		}
+	}
+
+	if result[1] == 1 {
+		return r.complete(ctx, s, payload.Execution, from)
+	}
+
+	return nil
+}
+
+// complete calls the completion callback of the step when the chunk finished the execution and the
+// callback has not succeeded yet, then sets the retention of the execution.
+func (r *StepRunner) complete(ctx context.Context, s step, id, from string) error {
+	key := executionKey(id)
+
+	values, err := r.redisClient.HMGet(ctx, key, "finishedBy", "callbackDone").Result()
+	if err != nil {
+		return fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+
+	if finishedBy, _ := values[0].(string); finishedBy != from {
+		return nil
+	}
+	if callbackDone, _ := values[1].(string); callbackDone == "1" {
+		return nil
+	}
+
+	if onComplete := s.settings().onComplete; onComplete != nil {
+		execution, err := r.Execution(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := onComplete(ctx, execution); err != nil {
+			return fmt.Errorf("completion callback failed | %w", err)
+		}
+	}
+
+	if _, err := r.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "callbackDone", "1")
+		if retention := s.settings().retention; retention > 0 {
+			pipe.Expire(ctx, key, retention)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to record the completion | %s", err.Error())
+	}
+
+	return nil
+}
+
+// flushDispatch enqueues the chunk dispatched by a script on behalf of from, the chunk or the
+// start of the execution that freed the slot or read its cursor.
+func (r *StepRunner) flushDispatch(ctx context.Context, s step, id, from string) error {
+	key := executionKey(id)
+
+	values, err := r.redisClient.HMGet(ctx, key, "dispatchIndex:"+from, "dispatchCursor:"+from).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+
+	index, ok := values[0].(string)
+	if !ok {
+		return nil
+	}
+	cursor, _ := values[1].(string)
+
+	payload := chunkPayload{Execution: id, Step: s.Name(), Cursor: cursor}
+	if payload.Index, err = strconv.ParseInt(index, 10, 64); err != nil {
+		return fmt.Errorf("invalid chunk index %q | %s", index, err.Error())
+	}
+
+	if err := r.enqueueChunk(ctx, s, payload); err != nil {
+		return err
+	}
+
+	if err := r.redisClient.HDel(ctx, key, "dispatchIndex:"+from, "dispatchCursor:"+from).Err(); err != nil {
+		return fmt.Errorf("failed to record the dispatch | %s", err.Error())
+	}
+
+	return nil
+}
+
+// enqueueChunk enqueues the task of a chunk. The task id is derived from the chunk, so that a
+// chunk enqueued again by a retry is not processed twice.
+func (r *StepRunner) enqueueChunk(ctx context.Context, s step, payload chunkPayload) error {
+	generation, err := r.redisClient.HGet(ctx, executionKey(payload.Execution), "generation").Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to read the step execution | %s", err.Error())
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	config := s.settings()
+	opts := []asynq.Option{
+		asynq.TaskID(strings.Join([]string{
+			ChunkTaskType, payload.Execution,
+			strconv.FormatInt(payload.Index, 10), strconv.FormatInt(generation, 10),
+		}, ":")),
+		asynq.MaxRetry(config.maxRetry),
+	}
+	if config.queue != "" {
+		opts = append(opts, asynq.Queue(config.queue))
+	}
+
+	_, err = r.processor.EnqueueTask(ctx, asynq.NewTask(ChunkTaskType, data), opts...)
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to enqueue chunk %d | %w", payload.Index, err)
+	}
+
+	return nil
+}
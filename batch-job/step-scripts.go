@@ -0,0 +1,168 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import "github.com/redis/go-redis/v9"
+
+// The state of a step execution is a redis hash updated by the scripts below, so that the chunks
+// processed at the same time by several workers do not race. Its fields are:
+//
+//   - cursor: the cursor of the next chunk to read, the checkpoint of the execution
+//   - pending: "1" while the chunk at cursor waits to be dispatched
+//   - nextIndex, inFlight, parallelism: the index of the next chunk dispatched, the number of
+//     chunks dispatched and not finished, and its bound
+//   - read:<index>, done:<index>: set once a chunk was read and finished, so that a retried chunk
+//     does not checkpoint or count twice
+//   - doneBelow: the index below which every chunk finished without failing. The read:<index> and
+//     done:<index> fields of those chunks are dropped, so that the hash does not grow with the
+//     number of chunks
+//   - failed:<index>: the cursor of a chunk that failed for the last time, to resume it
+//   - dispatchIndex:<from>, dispatchCursor:<from>: a chunk dispatched by a script, left for the
+//     caller to enqueue
+//   - readDone, readFailed: set once the reader is exhausted, or a chunk failed while reading
+//   - status, finishedAt, finishedBy, callbackDone: the outcome of the execution
+
+// dispatchFunction dispatches the chunk at the checkpointed cursor when one is pending and the
+// parallelism allows it, returning its index or -1.
+const dispatchFunction = `
+local function dispatch(key, from)
+	if redis.call('HGET', key, 'pending') ~= '1' then
+		return -1
+	end
+	local inFlight = tonumber(redis.call('HGET', key, 'inFlight') or '0')
+	if inFlight >= tonumber(redis.call('HGET', key, 'parallelism')) then
+		return -1
+	end
+	local index = redis.call('HINCRBY', key, 'nextIndex', 1) - 1
+	redis.call('HSET', key, 'pending', '0')
+	redis.call('HINCRBY', key, 'inFlight', 1)
+	redis.call('HINCRBY', key, 'chunksDispatched', 1)
+	redis.call('HSET', key, 'dispatchIndex:' .. from, index, 'dispatchCursor:' .. from, redis.call('HGET', key, 'cursor'))
+	return index
+end
+`
+
+// chunkFunctions tell whether a chunk finished, and drop the fields of the chunks finished without
+// failing in a row from doneBelow. A failed chunk keeps its fields until it is resumed and
+// finishes.
+const chunkFunctions = `
+local function isDone(key, index)
+	if tonumber(index) < tonumber(redis.call('HGET', key, 'doneBelow') or '0') then
+		return true
+	end
+	return redis.call('HEXISTS', key, 'done:' .. index) == 1
+end
+
+local function compact(key)
+	local below = tonumber(redis.call('HGET', key, 'doneBelow') or '0')
+	local start = below
+	while redis.call('HEXISTS', key, 'done:' .. below) == 1 and redis.call('HEXISTS', key, 'failed:' .. below) == 0 do
+		redis.call('HDEL', key, 'done:' .. below, 'read:' .. below)
+		below = below + 1
+	end
+	if below > start then
+		redis.call('HSET', key, 'doneBelow', below)
+	end
+end
+`
+
+// chunkDoneScript tells whether a chunk finished, returning 1 when it did.
+//
+// ARGV: index
+var chunkDoneScript = redis.NewScript(chunkFunctions + `
+if isDone(KEYS[1], ARGV[1]) then
+	return 1
+end
+return 0
+`)
+
+// startScript creates an execution and dispatches its first chunk, returning its index, or -1
+// when the execution already exists.
+//
+// ARGV: step, time, initial cursor, parallelism
+var startScript = redis.NewScript(dispatchFunction + `
+local key = KEYS[1]
+if redis.call('EXISTS', key) == 1 then
+	return -1
+end
+redis.call('HSET', key, 'step', ARGV[1], 'status', 'running', 'startedAt', ARGV[2], 'cursor', ARGV[3], 'pending', '1', 'parallelism', ARGV[4])
+return dispatch(key, 'start')
+`)
+
+// publishReadScript checkpoints the cursor of the chunk following a chunk read, and dispatches it.
+//
+// ARGV: index, next cursor, items read
+var publishReadScript = redis.NewScript(dispatchFunction + chunkFunctions + `
+local key = KEYS[1]
+if isDone(key, ARGV[1]) or redis.call('HSETNX', key, 'read:' .. ARGV[1], '1') == 0 then
+	return -1
+end
+redis.call('HINCRBY', key, 'itemsRead', ARGV[3])
+if ARGV[2] == '' then
+	redis.call('HSET', key, 'readDone', '1')
+else
+	redis.call('HSET', key, 'cursor', ARGV[2], 'pending', '1')
+end
+return dispatch(key, ARGV[1])
+`)
+
+// finishChunkScript records the outcome of a chunk, dispatches the chunk waiting for its slot and
+// finishes the execution once no chunk is left. It returns the index of the chunk dispatched or -1,
+// and 1 when the chunk finished the execution.
+//
+// ARGV: index, failed, cursor, items written, error, time
+var finishChunkScript = redis.NewScript(dispatchFunction + chunkFunctions + `
+local key = KEYS[1]
+if isDone(key, ARGV[1]) or redis.call('HSETNX', key, 'done:' .. ARGV[1], '1') == 0 then
+	return {-1, 0}
+end
+redis.call('HINCRBY', key, 'inFlight', -1)
+if ARGV[2] == '1' then
+	redis.call('HINCRBY', key, 'chunksFailed', 1)
+	redis.call('HSET', key, 'failed:' .. ARGV[1], ARGV[3], 'error', ARGV[5])
+	if redis.call('HEXISTS', key, 'read:' .. ARGV[1]) == 0 then
+		redis.call('HSET', key, 'readFailed', '1')
+	end
+else
+	redis.call('HINCRBY', key, 'chunksCompleted', 1)
+	redis.call('HINCRBY', key, 'itemsWritten', ARGV[4])
+end
+compact(key)
+local index = dispatch(key, ARGV[1])
+if index >= 0 or tonumber(redis.call('HGET', key, 'inFlight')) > 0 or redis.call('HGET', key, 'pending') == '1' then
+	return {index, 0}
+end
+local readDone = redis.call('HGET', key, 'readDone') == '1'
+if not readDone and redis.call('HGET', key, 'readFailed') ~= '1' then
+	return {index, 0}
+end
+local status = 'completed'
+if not readDone or tonumber(redis.call('HGET', key, 'chunksFailed') or '0') > 0 then
+	status = 'failed'
+end
+redis.call('HSET', key, 'status', status, 'finishedAt', ARGV[6], 'finishedBy', ARGV[1])
+return {index, 1}
+`)
+
+// resumeScript sets a failed execution running again and removes its retention, returning the
+// index and cursor of each failed chunk to enqueue again, or nil when the execution did not fail.
+var resumeScript = redis.NewScript(`
+local key = KEYS[1]
+if redis.call('HGET', key, 'status') ~= 'failed' then
+	return nil
+end
+local fields = redis.call('HGETALL', key)
+local chunks = {}
+for i = 1, #fields, 2 do
+	if string.sub(fields[i], 1, 7) == 'failed:' then
+		local index = string.sub(fields[i], 8)
+		table.insert(chunks, index)
+		table.insert(chunks, fields[i + 1])
+		redis.call('HDEL', key, fields[i], 'done:' .. index)
+		redis.call('HINCRBY', key, 'inFlight', 1)
+	end
+end
+redis.call('HSET', key, 'status', 'running', 'chunksFailed', '0', 'readFailed', '0')
+redis.call('HDEL', key, 'finishedAt', 'finishedBy', 'callbackDone', 'error')
+redis.call('HINCRBY', key, 'generation', 1)
+redis.call('PERSIST', key)
+return chunks
+`)
@@ -0,0 +1,272 @@
+package batch_job // import "github.com/SolomonAIEngineering/backend-core-library/batch-job"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSkipItem is returned by a Processor to leave an item out of the chunk written
+var ErrSkipItem = errors.New("skip item")
+
+// Reader reads the items of a step one chunk at a time from a cursor-based source, such as a table
+// read by primary key or a paginated API.
+type Reader[T any] interface {
+	// Read returns at most limit items from the cursor, and the cursor of the next items. An empty
+	// next cursor ends the step. The empty cursor reads the first items, unless the step starts
+	// from another cursor. Reads from the same cursor must return the same items, since a chunk
+	// is read again when it is retried.
+	Read(ctx context.Context, cursor string, limit int) (items []T, next string, err error)
+}
+
+// Processor transforms an item read into the item written. It returns ErrSkipItem to leave the
+// item out.
+type Processor[T, R any] interface {
+	Process(ctx context.Context, item T) (R, error)
+}
+
+// Writer writes the processed items of a chunk. A chunk is written again when it is retried, so
+// writes should be idempotent.
+type Writer[R any] interface {
+	Write(ctx context.Context, items []R) error
+}
+
+// ReaderFunc adapts a function to a Reader.
+type ReaderFunc[T any] func(ctx context.Context, cursor string, limit int) ([]T, string, error)
+
+// Read implements Reader.
+func (f ReaderFunc[T]) Read(ctx context.Context, cursor string, limit int) ([]T, string, error) {
+	return f(ctx, cursor, limit)
+}
+
+// ProcessorFunc adapts a function to a Processor.
+type ProcessorFunc[T, R any] func(ctx context.Context, item T) (R, error)
+
+// Process implements Processor.
+func (f ProcessorFunc[T, R]) Process(ctx context.Context, item T) (R, error) {
+	return f(ctx, item)
+}
+
+// WriterFunc adapts a function to a Writer.
+type WriterFunc[R any] func(ctx context.Context, items []R) error
+
+// Write implements Writer.
+func (f WriterFunc[R]) Write(ctx context.Context, items []R) error {
+	return f(ctx, items)
+}
+
+// CompletionFunc is called once every chunk of a step execution is processed, or when the
+// execution cannot go on because of a chunk that failed for the last time.
+type CompletionFunc func(ctx context.Context, execution *StepExecution) error
+
+const (
+	// defaultChunkSize is the number of items read per chunk by default
+	defaultChunkSize = 100
+	// defaultChunkMaxRetry is the number of times a chunk is retried by default
+	defaultChunkMaxRetry = 3
+	// defaultExecutionRetention is the time the state of an execution is kept once it is over by
+	// default
+	defaultExecutionRetention = 7 * 24 * time.Hour
+)
+
+// stepConfig holds the settings of a step that do not depend on its item types.
+type stepConfig struct {
+	chunkSize     int
+	parallelism   int
+	maxRetry      int
+	queue         string
+	timeout       time.Duration
+	initialCursor string
+	onComplete    CompletionFunc
+	retention     time.Duration
+}
+
+// StepOption configures a Step.
+type StepOption func(*stepConfig)
+
+// WithChunkSize sets the number of items read per chunk. It defaults to 100.
+func WithChunkSize(size int) StepOption {
+	return func(c *stepConfig) {
+		c.chunkSize = size
+	}
+}
+
+// WithParallelism sets the number of chunks of an execution processed at the same time. It
+// defaults to 1, processing the chunks one after the other.
+func WithParallelism(parallelism int) StepOption {
+	return func(c *stepConfig) {
+		c.parallelism = parallelism
+	}
+}
+
+// WithChunkMaxRetry sets the number of times a failed chunk is retried. It defaults to 3.
+func WithChunkMaxRetry(maxRetry int) StepOption {
+	return func(c *stepConfig) {
+		c.maxRetry = maxRetry
+	}
+}
+
+// WithChunkQueue sets the queue the chunk tasks are enqueued to.
+func WithChunkQueue(queue string) StepOption {
+	return func(c *stepConfig) {
+		c.queue = queue
+	}
+}
+
+// WithChunkTimeout sets the time a chunk is given to be read, processed and written.
+func WithChunkTimeout(timeout time.Duration) StepOption {
+	return func(c *stepConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithInitialCursor sets the cursor the executions of the step start reading from. It defaults to
+// the empty cursor.
+func WithInitialCursor(cursor string) StepOption {
+	return func(c *stepConfig) {
+		c.initialCursor = cursor
+	}
+}
+
+// WithCompletionCallback sets the function called once an execution of the step is over.
+func WithCompletionCallback(onComplete CompletionFunc) StepOption {
+	return func(c *stepConfig) {
+		c.onComplete = onComplete
+	}
+}
+
+// WithExecutionRetention sets the time the state of an execution is kept in redis once it is over
+// and its completion callback succeeded. It defaults to 7 days; zero keeps it until it is deleted.
+// Resuming a failed execution keeps it again until it is over.
+func WithExecutionRetention(retention time.Duration) StepOption {
+	return func(c *stepConfig) {
+		c.retention = retention
+	}
+}
+
+// Step reads, processes and writes items in chunks, each processed by an asynq task of its own.
+// The cursor of every chunk is checkpointed, so that a crash or a failure only processes again the
+// chunks in progress rather than the whole step.
+type Step[T, R any] struct {
+	name      string
+	reader    Reader[T]
+	processor Processor[T, R]
+	writer    Writer[R]
+	config    stepConfig
+}
+
+// NewStep creates a step reading items with the reader, transforming them with the processor and
+// writing them with the writer.
+//
+// Example:
+//
+//	step := NewStep[User, Invoice](
+//	    "monthly-invoices",
+//	    ReaderFunc[User](func(ctx context.Context, cursor string, limit int) ([]User, string, error) {
+//	        return users.ListAfter(ctx, cursor, limit)
+//	    }),
+//	    ProcessorFunc[User, Invoice](billing.Invoice),
+//	    WriterFunc[Invoice](invoices.Save),
+//	    WithChunkSize(500),
+//	    WithParallelism(4),
+//	)
+func NewStep[T, R any](name string, reader Reader[T], processor Processor[T, R], writer Writer[R], options ...StepOption) *Step[T, R] {
+	config := stepConfig{
+		chunkSize:   defaultChunkSize,
+		parallelism: 1,
+		maxRetry:    defaultChunkMaxRetry,
+		retention:   defaultExecutionRetention,
+	}
+
+	for _, option := range options {
+		option(&config)
+	}
+
+	return &Step[T, R]{
+		name:      name,
+		reader:    reader,
+		processor: processor,
+		writer:    writer,
+		config:    config,
+	}
+}
+
+// Name returns the name of the step.
+func (s *Step[T, R]) Name() string {
+	return s.name
+}
+
+// Validate checks that the step can be run.
+func (s *Step[T, R]) Validate() error {
+	switch {
+	case s.name == "":
+		return fmt.Errorf("step name is empty")
+	case s.reader == nil:
+		return fmt.Errorf("step %s | reader is nil", s.name)
+	case s.processor == nil:
+		return fmt.Errorf("step %s | processor is nil", s.name)
+	case s.writer == nil:
+		return fmt.Errorf("step %s | writer is nil", s.name)
+	case s.config.chunkSize <= 0:
+		return fmt.Errorf("step %s | chunk size should be positive", s.name)
+	case s.config.parallelism <= 0:
+		return fmt.Errorf("step %s | parallelism should be positive", s.name)
+	case s.config.maxRetry < 0:
+		return fmt.Errorf("step %s | chunk max retry should be non-negative", s.name)
+	case s.config.retention < 0:
+		return fmt.Errorf("step %s | execution retention should be non-negative", s.name)
+	}
+
+	return nil
+}
+
+// settings implements step.
+func (s *Step[T, R]) settings() stepConfig {
+	return s.config
+}
+
+// runChunk implements step. It reads the chunk at the cursor, calls onRead with the cursor of the
+// next chunk, then processes and writes the items read.
+func (s *Step[T, R]) runChunk(ctx context.Context, cursor string, onRead func(next string, read int) error) (int, error) {
+	items, next, err := s.reader.Read(ctx, cursor, s.config.chunkSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the chunk | %w", err)
+	}
+
+	if err := onRead(next, len(items)); err != nil {
+		return 0, err
+	}
+
+	processed := make([]R, 0, len(items))
+	for _, item := range items {
+		result, err := s.processor.Process(ctx, item)
+		if errors.Is(err, ErrSkipItem) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to process the chunk | %w", err)
+		}
+		processed = append(processed, result)
+	}
+
+	if len(processed) == 0 {
+		return 0, nil
+	}
+
+	if err := s.writer.Write(ctx, processed); err != nil {
+		return 0, fmt.Errorf("failed to write the chunk | %w", err)
+	}
+
+	return len(processed), nil
+}
+
+// step is a Step regardless of its item types.
+type step interface {
+	Name() string
+	Validate() error
+	settings() stepConfig
+	runChunk(ctx context.Context, cursor string, onRead func(next string, read int) error) (int, error)
+}
+
+var _ step = (*Step[any, any])(nil)
@@ -0,0 +1,398 @@
+package batch_job
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceReader reads the integers up to size, the cursor being the index of the next one
+func sliceReader(size int, failAt *atomic.Int64) ReaderFunc[int] {
+	return func(_ context.Context, cursor string, limit int) ([]int, string, error) {
+		start := 0
+		if cursor != "" {
+			start, _ = strconv.Atoi(cursor)
+		}
+
+		if failAt != nil && int64(start) == failAt.Load() {
+			return nil, "", errors.New("source unavailable")
+		}
+
+		end := min(start+limit, size)
+		items := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			items = append(items, i)
+		}
+
+		if end == size {
+			return items, "", nil
+		}
+
+		return items, strconv.Itoa(end), nil
+	}
+}
+
+// recordingWriter records the items written
+type recordingWriter struct {
+	mu      sync.Mutex
+	items   []int
+	active  atomic.Int64
+	maxSeen atomic.Int64
+	failOn  atomic.Int64
+	delay   time.Duration
+}
+
+func newRecordingWriter() *recordingWriter {
+	w := &recordingWriter{}
+	w.failOn.Store(-1)
+	return w
+}
+
+func (w *recordingWriter) Write(_ context.Context, items []int) error {
+	active := w.active.Add(1)
+	defer w.active.Add(-1)
+	for {
+		seen := w.maxSeen.Load()
+		if active <= seen || w.maxSeen.CompareAndSwap(seen, active) {
+			break
+		}
+	}
+
+	time.Sleep(w.delay)
+
+	for _, item := range items {
+		if int64(item) == w.failOn.Load() {
+			return errors.New("sink unavailable")
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.items = append(w.items, items...)
+	return nil
+}
+
+func (w *recordingWriter) written() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	items := append([]int(nil), w.items...)
+	sort.Ints(items)
+	return items
+}
+
+// doubleOdd doubles the odd items and skips the even ones
+var doubleOdd = ProcessorFunc[int, int](func(_ context.Context, item int) (int, error) {
+	if item%2 == 0 {
+		return 0, ErrSkipItem
+	}
+	return item * 2, nil
+})
+
+// newTestStepRunner creates a step runner whose chunks are processed by a worker
+func newTestStepRunner(t *testing.T, steps ...func(*StepRunner) error) *StepRunner {
+	t.Helper()
+
+	runner, err := NewStepRunner(newTestTaskProcessor(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = runner.Close() })
+
+	for _, register := range steps {
+		require.NoError(t, register(runner))
+	}
+
+	srv := asynq.NewServer(runner.processor.RedisConnOpt(), asynq.Config{
+		Concurrency:              8,
+		RetryDelayFunc:           func(int, error, *asynq.Task) time.Duration { return 0 },
+		TaskCheckInterval:        10 * time.Millisecond,
+		DelayedTaskCheckInterval: 100 * time.Millisecond,
+	})
+
+	mux := asynq.NewServeMux()
+	runner.RegisterHandlers(mux)
+	require.NoError(t, srv.Start(mux))
+	t.Cleanup(srv.Shutdown)
+
+	return runner
+}
+
+// waitForExecution waits for the execution to complete or fail
+func waitForExecution(t *testing.T, runner *StepRunner, id string) *StepExecution {
+	t.Helper()
+
+	var execution *StepExecution
+	require.Eventually(t, func() bool {
+		var err error
+		execution, err = runner.Execution(context.Background(), id)
+		require.NoError(t, err)
+		return execution.Status != ExecutionStatusRunning
+	}, 10*time.Second, 10*time.Millisecond)
+
+	return execution
+}
+
+// assertExecutionCompacted checks that the execution holds no field of its finished chunks
+func assertExecutionCompacted(t *testing.T, runner *StepRunner, id string) {
+	t.Helper()
+
+	fields, err := runner.redisClient.HKeys(context.Background(), executionKey(id)).Result()
+	require.NoError(t, err)
+	for _, field := range fields {
+		assert.False(t, strings.HasPrefix(field, "read:") || strings.HasPrefix(field, "done:"), "field %s left", field)
+	}
+}
+
+func expectedDoubledOdds(size int) []int {
+	var items []int
+	for i := 1; i < size; i += 2 {
+		items = append(items, i*2)
+	}
+	return items
+}
+
+func TestStepRunsChunksInParallel(t *testing.T) {
+	var (
+		writer    = newRecordingWriter()
+		completed = make(chan *StepExecution, 2)
+	)
+	writer.delay = 20 * time.Millisecond
+
+	step := NewStep[int, int]("double", sliceReader(95, nil), doubleOdd, writer,
+		WithChunkSize(10),
+		WithParallelism(3),
+		WithCompletionCallback(func(_ context.Context, execution *StepExecution) error {
+			completed <- execution
+			return nil
+		}),
+	)
+
+	runner := newTestStepRunner(t, func(r *StepRunner) error { return RegisterStep(r, step) })
+
+	execution, err := runner.StartExecution(context.Background(), "double")
+	require.NoError(t, err)
+	assert.Equal(t, ExecutionStatusRunning, execution.Status)
+
+	execution = waitForExecution(t, runner, execution.ID)
+	assert.Equal(t, ExecutionStatusCompleted, execution.Status)
+	assert.Equal(t, int64(10), execution.ChunksDispatched)
+	assert.Equal(t, int64(10), execution.ChunksCompleted)
+	assert.Equal(t, int64(0), execution.ChunksInFlight)
+	assert.Equal(t, int64(95), execution.ItemsRead)
+	assert.Equal(t, int64(47), execution.ItemsWritten)
+	assert.Equal(t, "90", execution.Cursor)
+	assert.NotNil(t, execution.FinishedAt)
+
+	assert.Equal(t, expectedDoubledOdds(95), writer.written())
+	assert.LessOrEqual(t, writer.maxSeen.Load(), int64(3))
+	assert.Greater(t, writer.maxSeen.Load(), int64(1))
+
+	select {
+	case callback := <-completed:
+		assert.Equal(t, ExecutionStatusCompleted, callback.Status)
+		assert.Equal(t, execution.ID, callback.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("completion callback not called")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, completed, "completion callback called more than once")
+
+	// the fields of the finished chunks are dropped, and the execution expires
+	assertExecutionCompacted(t, runner, execution.ID)
+	ttl, err := runner.redisClient.TTL(context.Background(), executionKey(execution.ID)).Result()
+	require.NoError(t, err)
+	assert.Greater(t, ttl, 6*24*time.Hour)
+}
+
+func TestStepResumesFailedChunks(t *testing.T) {
+	var (
+		writer    = newRecordingWriter()
+		failAt    atomic.Int64
+		mu        sync.Mutex
+		callbacks []ExecutionStatus
+	)
+	// 25 is written doubled
+	writer.failOn.Store(50)
+	failAt.Store(70)
+
+	step := NewStep[int, int]("double", sliceReader(95, &failAt), doubleOdd, writer,
+		WithChunkSize(10),
+		WithParallelism(2),
+		WithChunkMaxRetry(1),
+		WithExecutionRetention(time.Hour),
+		WithCompletionCallback(func(_ context.Context, execution *StepExecution) error {
+			mu.Lock()
+			defer mu.Unlock()
+			callbacks = append(callbacks, execution.Status)
+			return nil
+		}),
+	)
+
+	runner := newTestStepRunner(t, func(r *StepRunner) error { return RegisterStep(r, step) })
+	ctx := context.Background()
+
+	execution, err := runner.StartExecution(ctx, "double")
+	require.NoError(t, err)
+
+	// the chunk writing 25 fails, and reading stops at 70
+	execution = waitForExecution(t, runner, execution.ID)
+	require.Eventually(t, func() bool {
+		ttl, err := runner.redisClient.TTL(ctx, executionKey(execution.ID)).Result()
+		return err == nil && ttl > 0 && ttl <= time.Hour
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, ExecutionStatusFailed, execution.Status)
+	assert.Equal(t, int64(2), execution.ChunksFailed)
+	assert.Equal(t, int64(6), execution.ChunksCompleted)
+	assert.Equal(t, "70", execution.Cursor)
+	assert.NotEmpty(t, execution.Error)
+
+	_, err = runner.Resume(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrExecutionNotFound)
+
+	writer.failOn.Store(-1)
+	failAt.Store(-1)
+
+	execution, err = runner.Resume(ctx, execution.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ExecutionStatusRunning, execution.Status)
+	ttl, err := runner.redisClient.TTL(ctx, executionKey(execution.ID)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), ttl, "a resumed execution does not expire")
+
+	execution = waitForExecution(t, runner, execution.ID)
+	assert.Equal(t, ExecutionStatusCompleted, execution.Status)
+	assert.Equal(t, int64(0), execution.ChunksFailed)
+	assert.Equal(t, int64(10), execution.ChunksCompleted)
+	assert.Equal(t, int64(95), execution.ItemsRead)
+
+	// the chunks processed before the failure are not processed again
+	assert.Equal(t, expectedDoubledOdds(95), writer.written())
+
+	_, err = runner.Resume(ctx, execution.ID)
+	assert.ErrorIs(t, err, ErrExecutionNotResumable)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(callbacks) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []ExecutionStatus{ExecutionStatusFailed, ExecutionStatusCompleted}, callbacks)
+
+	assertExecutionCompacted(t, runner, execution.ID)
+}
+
+func TestStepTaskStartsExecution(t *testing.T) {
+	writer := newRecordingWriter()
+	step := NewStep[int, int]("double", sliceReader(5, nil), doubleOdd, writer, WithInitialCursor("2"))
+
+	runner := newTestStepRunner(t, func(r *StepRunner) error { return RegisterStep(r, step) })
+
+	_, err := runner.processor.EnqueueTask(context.Background(), NewStepTask("double"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(writer.written()) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int{6}, writer.written())
+}
+
+func TestRetriedStepTaskKeepsExecution(t *testing.T) {
+	writer := newRecordingWriter()
+	step := NewStep[int, int]("double", sliceReader(25, nil), doubleOdd, writer, WithChunkSize(10))
+
+	runner, err := NewStepRunner(newTestTaskProcessor(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = runner.Close() })
+	require.NoError(t, RegisterStep(runner, step))
+
+	srv := asynq.NewServer(runner.processor.RedisConnOpt(), asynq.Config{
+		Concurrency:              4,
+		RetryDelayFunc:           func(int, error, *asynq.Task) time.Duration { return 0 },
+		TaskCheckInterval:        10 * time.Millisecond,
+		DelayedTaskCheckInterval: 100 * time.Millisecond,
+	})
+
+	var attempts atomic.Int64
+	mux := asynq.NewServeMux()
+	// the first attempt of the step task fails once the execution is started
+	mux.Use(func(next asynq.Handler) asynq.Handler {
+		return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+			err := next.ProcessTask(ctx, task)
+			if task.Type() != StepTaskType {
+				return err
+			}
+			if attempts.Add(1) == 1 && err == nil {
+				return errors.New("worker lost")
+			}
+			return err
+		})
+	})
+	runner.RegisterHandlers(mux)
+	require.NoError(t, srv.Start(mux))
+	t.Cleanup(srv.Shutdown)
+
+	ctx := context.Background()
+	_, err = runner.processor.EnqueueTask(ctx, NewStepTask("double"), asynq.TaskID("monthly-double"), asynq.MaxRetry(1))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return attempts.Load() == 2 && len(writer.written()) == len(expectedDoubledOdds(25))
+	}, 10*time.Second, 10*time.Millisecond)
+
+	keys, err := runner.redisClient.Keys(ctx, executionKeyPrefix+"*").Result()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	execution := waitForExecution(t, runner, strings.TrimPrefix(keys[0], executionKeyPrefix))
+	assert.Equal(t, ExecutionStatusCompleted, execution.Status)
+	assert.Equal(t, int64(3), execution.ChunksCompleted)
+	assert.Equal(t, expectedDoubledOdds(25), writer.written())
+}
+
+func TestRegisterStep(t *testing.T) {
+	runner, err := NewStepRunner(newTestTaskProcessor(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = runner.Close() })
+
+	writer := newRecordingWriter()
+	tests := []struct {
+		name    string
+		step    *Step[int, int]
+		wantErr error
+	}{
+		{name: "valid", step: NewStep[int, int]("double", sliceReader(1, nil), doubleOdd, writer)},
+		{name: "duplicate", step: NewStep[int, int]("double", sliceReader(1, nil), doubleOdd, writer), wantErr: ErrDuplicateStep},
+		{name: "no name", step: NewStep[int, int]("", sliceReader(1, nil), doubleOdd, writer)},
+		{name: "no reader", step: NewStep[int, int]("a", nil, doubleOdd, writer)},
+		{name: "no writer", step: NewStep[int, int]("b", sliceReader(1, nil), doubleOdd, nil)},
+		{name: "invalid chunk size", step: NewStep[int, int]("c", sliceReader(1, nil), doubleOdd, writer, WithChunkSize(0))},
+		{name: "invalid parallelism", step: NewStep[int, int]("d", sliceReader(1, nil), doubleOdd, writer, WithParallelism(0))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterStep(runner, tt.step)
+			switch {
+			case tt.name == "valid":
+				assert.NoError(t, err)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	_, err = runner.StartExecution(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrStepNotFound)
+}
@@ -0,0 +1,466 @@
+package mongo // import "github.com/SolomonAIEngineering/backend-core-library/database/mongo"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExplainVerbosity controls how much information an explain returns
+type ExplainVerbosity string
+
+const (
+	ExplainQueryPlanner      ExplainVerbosity = "queryPlanner"
+	ExplainExecutionStats    ExplainVerbosity = "executionStats"
+	ExplainAllPlansExecution ExplainVerbosity = "allPlansExecution"
+)
+
+// apiStrictErrorCode is returned for commands outside of the strict API version of the connection
+const apiStrictErrorCode = 323
+
+// ErrInvalidPipeline is wrapped by every error returned when a pipeline fails validation
+var ErrInvalidPipeline = errors.New("invalid aggregation pipeline")
+
+// terminalStages must be the last stage of a pipeline
+var terminalStages = map[string]struct{}{
+	"$out":   {},
+	"$merge": {},
+}
+
+// facetForbiddenStages cannot be used inside a $facet sub-pipeline
+var facetForbiddenStages = map[string]struct{}{
+	"$out":          {},
+	"$merge":        {},
+	"$facet":        {},
+	"$collStats":    {},
+	"$indexStats":   {},
+	"$geoNear":      {},
+	"$changeStream": {},
+}
+
+// firstStages must be the first stage of a pipeline
+var firstStages = map[string]struct{}{
+	"$geoNear":      {},
+	"$collStats":    {},
+	"$indexStats":   {},
+	"$changeStream": {},
+}
+
+// Pipeline is a fluent builder for aggregation pipelines. Stages are validated individually as they
+// are added and as a whole by Build, which reports every problem found.
+type Pipeline struct {
+	stages []bson.D
+	errs   []error
+}
+
+// Lookup describes a $lookup stage. Either LocalField and ForeignField, or Pipeline (optionally with
+// Let), must be set.
+type Lookup struct {
+	From         string
+	LocalField   string
+	ForeignField string
+	Let          bson.D
+	Pipeline     *Pipeline
+	As           string
+}
+
+// Unwind describes an $unwind stage
+type Unwind struct {
+	Path                       string
+	IncludeArrayIndex          string
+	PreserveNullAndEmptyArrays bool
+}
+
+// Facet is a named sub-pipeline of a $facet stage
+type Facet struct {
+	Name     string
+	Pipeline *Pipeline
+}
+
+// NewPipeline creates an empty pipeline
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Match appends a $match stage
+func (p *Pipeline) Match(filter any) *Pipeline {
+	if filter == nil {
+		return p.fail("$match: filter not set")
+	}
+
+	return p.Stage("$match", filter)
+}
+
+// Group appends a $group stage grouping by id with the given accumulators, e.g.
+// Group("$account_id", bson.E{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}})
+func (p *Pipeline) Group(id any, accumulators ...bson.E) *Pipeline {
+	group := bson.D{{Key: "_id", Value: id}}
+	for _, accumulator := range accumulators {
+		if accumulator.Key == "_id" {
+			return p.fail("$group: _id must be passed as the group id, not as an accumulator")
+		}
+
+		group = append(group, accumulator)
+	}
+
+	return p.Stage("$group", group)
+}
+
+// Lookup appends a $lookup stage
+func (p *Pipeline) Lookup(lookup Lookup) *Pipeline {
+	if lookup.From == "" || lookup.As == "" {
+		return p.fail("$lookup: from and as must be set")
+	}
+
+	stage := bson.D{{Key: "from", Value: lookup.From}}
+	hasFields := lookup.LocalField != "" || lookup.ForeignField != ""
+	if hasFields {
+		if lookup.LocalField == "" || lookup.ForeignField == "" {
+			return p.fail("$lookup: localField and foreignField must be set together")
+		}
+
+		stage = append(stage,
+			bson.E{Key: "localField", Value: lookup.LocalField},
+			bson.E{Key: "foreignField", Value: lookup.ForeignField})
+	}
+
+	if lookup.Pipeline != nil {
+		subPipeline, err := lookup.Pipeline.buildNested("$lookup")
+		if err != nil {
+			return p.failErr(err)
+		}
+
+		if len(lookup.Let) > 0 {
+			stage = append(stage, bson.E{Key: "let", Value: lookup.Let})
+		}
+
+		stage = append(stage, bson.E{Key: "pipeline", Value: subPipeline})
+	} else if !hasFields {
+		return p.fail("$lookup: either localField/foreignField or pipeline must be set")
+	}
+
+	stage = append(stage, bson.E{Key: "as", Value: lookup.As})
+	return p.Stage("$lookup", stage)
+}
+
+// Unwind appends an $unwind stage. The path may be given with or without the leading "$".
+func (p *Pipeline) Unwind(unwind Unwind) *Pipeline {
+	if unwind.Path == "" {
+		return p.fail("$unwind: path not set")
+	}
+
+	path := fieldPath(unwind.Path)
+	if unwind.IncludeArrayIndex == "" && !unwind.PreserveNullAndEmptyArrays {
+		return p.Stage("$unwind", path)
+	}
+
+	stage := bson.D{{Key: "path", Value: path}}
+	if unwind.IncludeArrayIndex != "" {
+		stage = append(stage, bson.E{Key: "includeArrayIndex", Value: unwind.IncludeArrayIndex})
+	}
+
+	if unwind.PreserveNullAndEmptyArrays {
+		stage = append(stage, bson.E{Key: "preserveNullAndEmptyArrays", Value: true})
+	}
+
+	return p.Stage("$unwind", stage)
+}
+
+// Facet appends a $facet stage running each sub-pipeline on the same input documents
+func (p *Pipeline) Facet(facets ...Facet) *Pipeline {
+	if len(facets) == 0 {
+		return p.fail("$facet: no facets set")
+	}
+
+	stage := bson.D{}
+	seen := make(map[string]struct{}, len(facets))
+	for _, facet := range facets {
+		if facet.Name == "" || facet.Pipeline == nil {
+			return p.fail("$facet: facets must have a name and a pipeline")
+		}
+
+		if _, ok := seen[facet.Name]; ok {
+			return p.fail(fmt.Sprintf("$facet: duplicate facet %s", facet.Name))
+		}
+		seen[facet.Name] = struct{}{}
+
+		subPipeline, err := facet.Pipeline.buildNested("$facet")
+		if err != nil {
+			return p.failErr(err)
+		}
+
+		stage = append(stage, bson.E{Key: facet.Name, Value: subPipeline})
+	}
+
+	return p.Stage("$facet", stage)
+}
+
+// Sort appends a $sort stage. Keys are applied in order; values must be 1, -1 or a $meta expression.
+func (p *Pipeline) Sort(keys bson.D) *Pipeline {
+	if len(keys) == 0 {
+		return p.fail("$sort: keys not set")
+	}
+
+	for _, key := range keys {
+		switch v := key.Value.(type) {
+		case int, int32, int64:
+			if n := fmt.Sprint(v); n != "1" && n != "-1" {
+				return p.fail(fmt.Sprintf("$sort: %s must be 1 or -1, got %s", key.Key, n))
+			}
+		case bson.D, bson.M:
+		default:
+			return p.fail(fmt.Sprintf("$sort: %s must be 1, -1 or a $meta expression", key.Key))
+		}
+	}
+
+	return p.Stage("$sort", keys)
+}
+
+// Project appends a $project stage
+func (p *Pipeline) Project(projection bson.D) *Pipeline {
+	if len(projection) == 0 {
+		return p.fail("$project: projection not set")
+	}
+
+	return p.Stage("$project", projection)
+}
+
+// AddFields appends an $addFields stage
+func (p *Pipeline) AddFields(fields bson.D) *Pipeline {
+	if len(fields) == 0 {
+		return p.fail("$addFields: fields not set")
+	}
+
+	return p.Stage("$addFields", fields)
+}
+
+// Limit appends a $limit stage
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	if n <= 0 {
+		return p.fail(fmt.Sprintf("$limit: must be positive, got %d", n))
+	}
+
+	return p.Stage("$limit", n)
+}
+
+// Skip appends a $skip stage
+func (p *Pipeline) Skip(n int64) *Pipeline {
+	if n < 0 {
+		return p.fail(fmt.Sprintf("$skip: must not be negative, got %d", n))
+	}
+
+	return p.Stage("$skip", n)
+}
+
+// Count appends a $count stage writing the number of documents to field
+func (p *Pipeline) Count(field string) *Pipeline {
+	if field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return p.fail(fmt.Sprintf("$count: invalid field %q", field))
+	}
+
+	return p.Stage("$count", field)
+}
+
+// Out appends an $out stage writing the results to a collection. It must be the last stage.
+func (p *Pipeline) Out(collectionName string) *Pipeline {
+	if collectionName == "" {
+		return p.fail("$out: collection not set")
+	}
+
+	return p.Stage("$out", collectionName)
+}
+
+// Stage appends an arbitrary stage. It is the escape hatch for stages without a typed helper.
+func (p *Pipeline) Stage(name string, value any) *Pipeline {
+	if !strings.HasPrefix(name, "$") {
+		return p.fail(fmt.Sprintf("stage %q must start with $", name))
+	}
+
+	p.stages = append(p.stages, bson.D{{Key: name, Value: value}})
+	return p
+}
+
+// Build validates the pipeline and returns it in the form accepted by the driver
+func (p *Pipeline) Build() (mongo.Pipeline, error) {
+	errs := append([]error{}, p.errs...)
+	last := len(p.stages) - 1
+
+	for i, stage := range p.stages {
+		name := stage[0].Key
+		if _, ok := terminalStages[name]; ok && i != last {
+			errs = append(errs, fmt.Errorf("%s must be the last stage, found at position %d of %d", name, i+1, last+1))
+		}
+
+		if _, ok := firstStages[name]; ok && i != 0 {
+			errs = append(errs, fmt.Errorf("%s must be the first stage, found at position %d", name, i+1))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPipeline, errors.Join(errs...))
+	}
+
+	return append(mongo.Pipeline{}, p.stages...), nil
+}
+
+// buildNested validates a sub-pipeline of a $lookup or $facet stage
+func (p *Pipeline) buildNested(parent string) (mongo.Pipeline, error) {
+	pipeline, err := p.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	if parent != "$facet" {
+		return pipeline, nil
+	}
+
+	for _, stage := range pipeline {
+		if _, ok := facetForbiddenStages[stage[0].Key]; ok {
+			return nil, fmt.Errorf("%w: %s cannot be used inside $facet", ErrInvalidPipeline, stage[0].Key)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// fail records a validation error and returns the pipeline so the chain can continue
+func (p *Pipeline) fail(message string) *Pipeline {
+	return p.failErr(errors.New(message))
+}
+
+func (p *Pipeline) failErr(err error) *Pipeline {
+	p.errs = append(p.errs, err)
+	return p
+}
+
+// fieldPath prefixes a field name with "$" if needed
+func fieldPath(path string) string {
+	if strings.HasPrefix(path, "$") {
+		return path
+	}
+
+	return "$" + path
+}
+
+// Aggregate runs the pipeline against the collection and decodes every result into T
+func Aggregate[T any](ctx context.Context, c *Client, collectionName string, pipeline *Pipeline, opts ...*options.AggregateOptions) ([]T, error) {
+	stages, err := pipeline.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := c.GetCollection(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if span := c.StartDbSegment(ctx, "aggregate", collectionName); span != nil {
+		defer span.End()
+	}
+
+	if c.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.QueryTimeout)
+		defer cancel()
+	}
+
+	cursor, err := collection.Aggregate(ctx, stages, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed running aggregation on %s | %s", collectionName, err.Error())
+	}
+
+	results := make([]T, 0)
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed decoding aggregation results | %s", err.Error())
+	}
+
+	return results, nil
+}
+
+// AggregateOne runs the pipeline and decodes the first result into T. It returns
+// mongo.ErrNoDocuments if the pipeline produced no documents. A pipeline ending with $out or $merge
+// writes its results instead of returning them, and a $limit after it would be invalid, so it is
+// rejected with an error wrapping ErrInvalidPipeline: run it with Aggregate.
+func AggregateOne[T any](ctx context.Context, c *Client, collectionName string, pipeline *Pipeline, opts ...*options.AggregateOptions) (*T, error) {
+	if name, ok := pipeline.terminalStage(); ok {
+		return nil, fmt.Errorf("%w: AggregateOne cannot run a pipeline ending with %s, use Aggregate", ErrInvalidPipeline, name)
+	}
+
+	results, err := Aggregate[T](ctx, c, collectionName, pipeline.clone().Limit(1), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return &results[0], nil
+}
+
+// terminalStage returns the name of the last stage of the pipeline if it is $out or $merge
+func (p *Pipeline) terminalStage() (string, bool) {
+	if len(p.stages) == 0 {
+		return "", false
+	}
+
+	name := p.stages[len(p.stages)-1][0].Key
+	_, ok := terminalStages[name]
+	return name, ok
+}
+
+// clone returns a copy of the pipeline that can be extended without modifying the original
+func (p *Pipeline) clone() *Pipeline {
+	return &Pipeline{
+		stages: append([]bson.D{}, p.stages...),
+		errs:   append([]error{}, p.errs...),
+	}
+}
+
+// Explain returns the query plan of the pipeline, running the explain command through the client
+// connection. Explain is not part of the stable API, so a server enforcing a strict API version
+// rejects it; this is intended for tests and connections without a strict API version.
+func (c *Client) Explain(ctx context.Context, collectionName string, pipeline *Pipeline, verbosity ExplainVerbosity) (bson.M, error) {
+	stages, err := pipeline.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Conn == nil {
+		return nil, fmt.Errorf("invalid input argument. conn: %v", c.Conn)
+	}
+
+	if c.DatabaseName == nil {
+		return nil, fmt.Errorf("invalid input argument. databaseName: %v", c.DatabaseName)
+	}
+
+	if verbosity == "" {
+		verbosity = ExplainQueryPlanner
+	}
+
+	cmd := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "aggregate", Value: collectionName},
+			{Key: "pipeline", Value: stages},
+			{Key: "cursor", Value: bson.D{}},
+		}},
+		{Key: "verbosity", Value: string(verbosity)},
+	}
+
+	var plan bson.M
+	if err := c.Conn.Database(*c.DatabaseName).RunCommand(ctx, cmd).Decode(&plan); err != nil {
+		var serverErr mongo.ServerError
+		if errors.As(err, &serverErr) && serverErr.HasErrorCode(apiStrictErrorCode) {
+			return nil, fmt.Errorf("failed explaining aggregation on %s, explain is not allowed with a strict API version | %s", collectionName, err.Error())
+		}
+
+		return nil, fmt.Errorf("failed explaining aggregation on %s | %s", collectionName, err.Error())
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,183 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPipeline_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       *Pipeline
+		want    mongo.Pipeline
+		wantErr bool
+	}{
+		{
+			name: "match group sort limit",
+			p: NewPipeline().
+				Match(bson.D{{Key: "status", Value: "settled"}}).
+				Group("$account_id", bson.E{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}}).
+				Sort(bson.D{{Key: "total", Value: -1}}).
+				Limit(10),
+			want: mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{{Key: "status", Value: "settled"}}}},
+				{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$account_id"},
+					{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
+				}}},
+				{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}}}},
+				{{Key: "$limit", Value: int64(10)}},
+			},
+		},
+		{
+			name: "lookup unwind project",
+			p: NewPipeline().
+				Lookup(Lookup{From: "accounts", LocalField: "account_id", ForeignField: "_id", As: "account"}).
+				Unwind(Unwind{Path: "account", PreserveNullAndEmptyArrays: true}).
+				Project(bson.D{{Key: "account.name", Value: 1}}),
+			want: mongo.Pipeline{
+				{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "accounts"},
+					{Key: "localField", Value: "account_id"},
+					{Key: "foreignField", Value: "_id"},
+					{Key: "as", Value: "account"},
+				}}},
+				{{Key: "$unwind", Value: bson.D{
+					{Key: "path", Value: "$account"},
+					{Key: "preserveNullAndEmptyArrays", Value: true},
+				}}},
+				{{Key: "$project", Value: bson.D{{Key: "account.name", Value: 1}}}},
+			},
+		},
+		{
+			name: "facet with sub-pipelines",
+			p: NewPipeline().Facet(
+				Facet{Name: "total", Pipeline: NewPipeline().Count("count")},
+				Facet{Name: "page", Pipeline: NewPipeline().Skip(20).Limit(10)},
+			),
+			want: mongo.Pipeline{
+				{{Key: "$facet", Value: bson.D{
+					{Key: "total", Value: mongo.Pipeline{{{Key: "$count", Value: "count"}}}},
+					{Key: "page", Value: mongo.Pipeline{
+						{{Key: "$skip", Value: int64(20)}},
+						{{Key: "$limit", Value: int64(10)}},
+					}},
+				}}},
+			},
+		},
+		{
+			name:    "out must be last",
+			p:       NewPipeline().Out("report").Match(bson.D{}),
+			wantErr: true,
+		},
+		{
+			name:    "out inside facet",
+			p:       NewPipeline().Facet(Facet{Name: "x", Pipeline: NewPipeline().Out("report")}),
+			wantErr: true,
+		},
+		{
+			name:    "geoNear must be first",
+			p:       NewPipeline().Match(bson.D{}).Stage("$geoNear", bson.D{}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid limit",
+			p:       NewPipeline().Limit(0),
+			wantErr: true,
+		},
+		{
+			name:    "invalid sort direction",
+			p:       NewPipeline().Sort(bson.D{{Key: "total", Value: 2}}),
+			wantErr: true,
+		},
+		{
+			name:    "lookup without join condition",
+			p:       NewPipeline().Lookup(Lookup{From: "accounts", As: "account"}),
+			wantErr: true,
+		},
+		{
+			name:    "stage name without $",
+			p:       NewPipeline().Stage("match", bson.D{}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.Build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pipeline.Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPipeline) {
+				t.Errorf("Pipeline.Build() error = %v, want it to wrap ErrInvalidPipeline", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pipeline.Build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline_clone(t *testing.T) {
+	p := NewPipeline().Match(bson.D{{Key: "status", Value: "settled"}})
+	_ = p.clone().Limit(1)
+
+	got, err := p.Build()
+	if err != nil {
+		t.Fatalf("Pipeline.Build() error = %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Errorf("extending a clone modified the original pipeline: %v", got)
+	}
+}
+
+func TestAggregateOne_terminalStage(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+	}{
+		{name: "out", pipeline: NewPipeline().Match(bson.D{}).Out("archive")},
+		{name: "merge", pipeline: NewPipeline().Match(bson.D{}).Stage("$merge", bson.D{{Key: "into", Value: "archive"}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AggregateOne[bson.M](context.Background(), &Client{}, "transactions", tt.pipeline)
+			if !errors.Is(err, ErrInvalidPipeline) {
+				t.Errorf("AggregateOne() error = %v, want it to wrap ErrInvalidPipeline", err)
+			}
+		})
+	}
+}
+
+func TestClient_Explain(t *testing.T) {
+	databaseName := "test"
+	tests := []struct {
+		name     string
+		c        *Client
+		pipeline *Pipeline
+	}{
+		{
+			name:     "invalid pipeline",
+			c:        &Client{DatabaseName: &databaseName},
+			pipeline: NewPipeline().Out("archive").Limit(1),
+		},
+		{
+			name:     "missing connection",
+			c:        &Client{DatabaseName: &databaseName},
+			pipeline: NewPipeline().Limit(1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.c.Explain(context.Background(), "transactions", tt.pipeline, ExplainQueryPlanner); err == nil {
+				t.Errorf("Client.Explain() expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,541 @@
+package mongo // import "github.com/SolomonAIEngineering/backend-core-library/database/mongo"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultChangeStreamInitialBackoff = 500 * time.Millisecond
+	defaultChangeStreamMaxBackoff     = 30 * time.Second
+	defaultChangeStreamMaxAwaitTime   = 5 * time.Second
+
+	// changeStreamHistoryLostCode is returned when the resume token is no longer in the oplog
+	changeStreamHistoryLostCode = 286
+)
+
+// OperationType is the type of change reported by a change stream event
+type OperationType string
+
+const (
+	OperationInsert     OperationType = "insert"
+	OperationUpdate     OperationType = "update"
+	OperationReplace    OperationType = "replace"
+	OperationDelete     OperationType = "delete"
+	OperationDrop       OperationType = "drop"
+	OperationRename     OperationType = "rename"
+	OperationInvalidate OperationType = "invalidate"
+)
+
+// ErrChangeStreamHistoryLost is returned when the persisted resume token has fallen out of the oplog.
+// The watcher cannot resume without skipping events, so it stops instead of silently dropping them.
+var ErrChangeStreamHistoryLost = errors.New("change stream history lost")
+
+// ChangeEvent is a decoded change stream event
+type ChangeEvent struct {
+	// ResumeToken identifies the event and is persisted once the event has been handled
+	ResumeToken bson.Raw `bson:"_id"`
+	// OperationType is the kind of change
+	OperationType OperationType `bson:"operationType"`
+	// Namespace is the database and collection the change applies to
+	Namespace struct {
+		Database   string `bson:"db"`
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	// DocumentKey holds the _id (and shard key) of the changed document
+	DocumentKey bson.Raw `bson:"documentKey,omitempty"`
+	// FullDocument holds the document for inserts and replaces, and for updates when full document
+	// lookup is enabled
+	FullDocument bson.Raw `bson:"fullDocument,omitempty"`
+	// UpdateDescription holds the updated and removed fields of an update
+	UpdateDescription bson.Raw `bson:"updateDescription,omitempty"`
+	// ClusterTime is the oplog time of the change
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+}
+
+// DecodeFullDocument decodes the full document of the event into v
+func (e *ChangeEvent) DecodeFullDocument(v any) error {
+	if len(e.FullDocument) == 0 {
+		return fmt.Errorf("change event %s has no full document", e.OperationType)
+	}
+
+	return bson.Unmarshal(e.FullDocument, v)
+}
+
+// ChangeHandlerFunc handles a single change event. Returning an error causes the event to be
+// redelivered after a backoff; the resume token is only advanced once the handler succeeds, or once
+// the event is skipped after the maximum number of handler retries.
+type ChangeHandlerFunc func(ctx context.Context, event *ChangeEvent) error
+
+// DeadLetterFunc receives an event the handler kept failing on before it is skipped, along with the
+// last handler error. Returning an error keeps the event from being skipped, and it is retried again.
+type DeadLetterFunc func(ctx context.Context, event *ChangeEvent, err error) error
+
+// ChangeStreamWatcher consumes a change stream and hands each event to a handler
+type ChangeStreamWatcher struct {
+	client         *Client
+	name           string
+	collectionName string
+	handler        ChangeHandlerFunc
+	store          ResumeTokenStore
+	operationTypes []OperationType
+	pipeline       mongo.Pipeline
+	fullDocument   options.FullDocument
+	batchSize      int32
+	maxAwaitTime   time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	// maxHandlerRetries is the number of times a failed event is retried before it is skipped. Zero
+	// retries failed events until they succeed
+	maxHandlerRetries int
+	deadLetter        DeadLetterFunc
+	// failedToken and failedAttempts count the handler failures of the event at failedToken. They
+	// are only used by the goroutine running the watcher
+	failedToken    string
+	failedAttempts int
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	lastErr error
+}
+
+// ChangeStreamOption configures a ChangeStreamWatcher
+type ChangeStreamOption func(*ChangeStreamWatcher)
+
+// WithChangeStreamCollection watches a single collection instead of the whole database
+func WithChangeStreamCollection(collectionName string) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.collectionName = collectionName
+	}
+}
+
+// WithResumeTokenStore sets the store used to persist resume tokens
+func WithResumeTokenStore(store ResumeTokenStore) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.store = store
+	}
+}
+
+// WithOperationTypes restricts the watcher to the given operation types
+func WithOperationTypes(operationTypes ...OperationType) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.operationTypes = operationTypes
+	}
+}
+
+// WithChangeStreamPipeline appends additional aggregation stages to the change stream
+func WithChangeStreamPipeline(pipeline mongo.Pipeline) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.pipeline = pipeline
+	}
+}
+
+// WithFullDocument sets the full document mode (e.g. options.UpdateLookup)
+func WithFullDocument(fullDocument options.FullDocument) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.fullDocument = fullDocument
+	}
+}
+
+// WithChangeStreamBatchSize sets the number of events fetched per batch
+func WithChangeStreamBatchSize(batchSize int32) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.batchSize = batchSize
+	}
+}
+
+// WithChangeStreamBackoff sets the initial and maximum backoff between retries
+func WithChangeStreamBackoff(initial, max time.Duration) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.initialBackoff = initial
+		w.maxBackoff = max
+	}
+}
+
+// WithMaxHandlerRetries skips an event once the handler failed on it maxRetries times after the
+// first attempt, handing it to the dead letter function first when one is set. By default failed
+// events are retried until the handler succeeds, which stops the watcher on an event that can never
+// be handled.
+func WithMaxHandlerRetries(maxRetries int) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.maxHandlerRetries = maxRetries
+	}
+}
+
+// WithDeadLetter sets the function receiving the events skipped after the maximum number of handler
+// retries, e.g. to store them for inspection
+func WithDeadLetter(deadLetter DeadLetterFunc) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.deadLetter = deadLetter
+	}
+}
+
+// NewChangeStreamWatcher creates a change stream watcher. The name identifies the watcher and is the
+// key under which its resume token is persisted, so it must be stable across restarts.
+func (c *Client) NewChangeStreamWatcher(name string, handler ChangeHandlerFunc, opts ...ChangeStreamOption) (*ChangeStreamWatcher, error) {
+	w := &ChangeStreamWatcher{
+		client:         c,
+		name:           name,
+		handler:        handler,
+		maxAwaitTime:   defaultChangeStreamMaxAwaitTime,
+		initialBackoff: defaultChangeStreamInitialBackoff,
+		maxBackoff:     defaultChangeStreamMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// validate ensures the watcher is correctly configured
+func (w *ChangeStreamWatcher) validate() error {
+	if w.client == nil || w.client.Conn == nil {
+		return fmt.Errorf("invalid change stream watcher. database connection not set")
+	}
+
+	if w.client.DatabaseName == nil {
+		return fmt.Errorf("invalid change stream watcher. database name not set")
+	}
+
+	if w.name == "" {
+		return fmt.Errorf("invalid change stream watcher. name not set")
+	}
+
+	if w.handler == nil {
+		return fmt.Errorf("invalid change stream watcher. handler not set")
+	}
+
+	if w.store == nil {
+		w.store = NewInMemoryResumeTokenStore()
+	}
+
+	if w.initialBackoff <= 0 {
+		w.initialBackoff = defaultChangeStreamInitialBackoff
+	}
+
+	if w.maxBackoff < w.initialBackoff {
+		w.maxBackoff = w.initialBackoff
+	}
+
+	if w.maxHandlerRetries < 0 {
+		return fmt.Errorf("invalid change stream watcher. max handler retries should be non-negative")
+	}
+
+	return nil
+}
+
+// Start runs the watcher in a separate goroutine until Stop is called
+func (w *ChangeStreamWatcher) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+
+		if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			w.logger().Error("change stream watcher stopped", zap.String("watcher", w.name), zap.Error(err))
+
+			w.mu.Lock()
+			w.lastErr = err
+			w.mu.Unlock()
+		}
+	}(w.done)
+}
+
+// Stop signals the watcher to stop and waits for the in-flight event to be handled or for ctx to
+// expire. It returns the error that stopped the watcher, if any.
+func (w *ChangeStreamWatcher) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	cancel, done := w.cancel, w.done
+	w.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cancel, w.done = nil, nil
+	return w.lastErr
+}
+
+// Run consumes the change stream until ctx is cancelled or a non-retryable error occurs. Transient
+// errors and handler failures are retried with exponential backoff from the last persisted token.
+func (w *ChangeStreamWatcher) Run(ctx context.Context) error {
+	backoff := w.initialBackoff
+
+	for {
+		handled, err := w.watch(ctx)
+		if err == nil || ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !isRetryableChangeStreamError(err) {
+			return err
+		}
+
+		// a stream that made progress before failing starts over with the smallest backoff
+		if handled > 0 {
+			backoff = w.initialBackoff
+		}
+
+		w.logger().Warn("change stream interrupted, retrying",
+			zap.String("watcher", w.name),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		w.recordMetric("mongo.change_stream.retry", 1)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// watch opens the change stream from the last persisted token and dispatches events until an error
+// occurs. It returns the number of events handled.
+func (w *ChangeStreamWatcher) watch(ctx context.Context) (int, error) {
+	token, err := w.store.Load(ctx, w.name)
+	if err != nil {
+		return 0, &handlerError{fmt.Errorf("failed loading resume token | %s", err.Error())}
+	}
+
+	opts := options.ChangeStream().SetMaxAwaitTime(w.maxAwaitTime)
+	if len(token) > 0 {
+		// StartAfter (rather than ResumeAfter) allows resuming after an invalidate event
+		opts.SetStartAfter(token)
+	}
+
+	if w.fullDocument != "" {
+		opts.SetFullDocument(w.fullDocument)
+	}
+
+	if w.batchSize > 0 {
+		opts.SetBatchSize(w.batchSize)
+	}
+
+	stream, err := w.open(ctx, opts)
+	if err != nil {
+		return 0, historyLostError(err)
+	}
+	defer stream.Close(context.Background())
+
+	// in-flight events are handled and checkpointed even if the watcher is stopped meanwhile
+	handlerCtx := context.WithoutCancel(ctx)
+
+	handled := 0
+	for stream.Next(ctx) {
+		var event ChangeEvent
+		if err := stream.Decode(&event); err != nil {
+			return handled, err
+		}
+
+		if err := w.handle(handlerCtx, &event); err != nil {
+			return handled, &handlerError{err}
+		}
+
+		if err := w.store.Save(handlerCtx, w.name, stream.ResumeToken()); err != nil {
+			return handled, &handlerError{fmt.Errorf("failed saving resume token | %s", err.Error())}
+		}
+
+		handled++
+	}
+
+	if err := stream.Err(); err != nil {
+		// the oplog can roll over the position of the stream while it is being iterated too
+		return handled, historyLostError(err)
+	}
+
+	// the stream closed without error (e.g. invalidate); reopen it after the last token
+	return handled, &handlerError{fmt.Errorf("change stream closed")}
+}
+
+// open starts the change stream against the collection or the database
+func (w *ChangeStreamWatcher) open(ctx context.Context, opts *options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	db := w.client.Conn.Database(*w.client.DatabaseName)
+	pipeline := w.buildPipeline()
+
+	if w.collectionName != "" {
+		return db.Collection(w.collectionName).Watch(ctx, pipeline, opts)
+	}
+
+	return db.Watch(ctx, pipeline, opts)
+}
+
+// buildPipeline combines the operation type filter with any user provided stages
+func (w *ChangeStreamWatcher) buildPipeline() mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(w.operationTypes) > 0 {
+		operationTypes := make(bson.A, 0, len(w.operationTypes))
+		for _, operationType := range w.operationTypes {
+			operationTypes = append(operationTypes, string(operationType))
+		}
+
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "operationType", Value: bson.D{{Key: "$in", Value: operationTypes}}},
+		}}})
+	}
+
+	return append(pipeline, w.pipeline...)
+}
+
+// handle dispatches the event to the handler. Once the handler failed on the event more than the
+// maximum number of handler retries, the event is handed to the dead letter function and skipped, so
+// that the watcher moves on; handle then returns nil and the resume token advances past the event.
+func (w *ChangeStreamWatcher) handle(ctx context.Context, event *ChangeEvent) error {
+	err := w.dispatch(ctx, event)
+	if err == nil {
+		w.failedToken, w.failedAttempts = "", 0
+		return nil
+	}
+
+	// the event is delivered again by a new stream after the backoff, so attempts are counted by token
+	if token := string(event.ResumeToken); token != w.failedToken {
+		w.failedToken, w.failedAttempts = token, 0
+	}
+	w.failedAttempts++
+
+	if w.maxHandlerRetries == 0 || w.failedAttempts <= w.maxHandlerRetries {
+		return err
+	}
+
+	if w.deadLetter != nil {
+		if dlErr := w.deadLetter(ctx, event, err); dlErr != nil {
+			return fmt.Errorf("%s | failed dead lettering event | %s", err.Error(), dlErr.Error())
+		}
+	}
+
+	w.logger().Error("skipping change stream event after handler retries",
+		zap.String("watcher", w.name),
+		zap.String("operationType", string(event.OperationType)),
+		zap.String("collection", event.Namespace.Collection),
+		zap.Int("attempts", w.failedAttempts),
+		zap.Error(err))
+	w.recordMetric("mongo.change_stream.skipped", 1)
+
+	w.failedToken, w.failedAttempts = "", 0
+	return nil
+}
+
+// dispatch calls the handler within a background transaction
+func (w *ChangeStreamWatcher) dispatch(ctx context.Context, event *ChangeEvent) error {
+	if w.client.Telemetry != nil && w.client.Telemetry.Client != nil {
+		txn := w.client.Telemetry.StartTransaction(fmt.Sprintf("mongo-change-stream-%s", w.name))
+		defer txn.End()
+
+		txn.AddAttribute("operationType", string(event.OperationType))
+		txn.AddAttribute("collection", event.Namespace.Collection)
+		ctx = w.client.Telemetry.WithContext(ctx, *txn)
+	}
+
+	if err := w.handler(ctx, event); err != nil {
+		w.recordMetric("mongo.change_stream.handler_error", 1)
+		return fmt.Errorf("failed handling %s event on %s | %s", event.OperationType, event.Namespace.Collection, err.Error())
+	}
+
+	w.recordMetric("mongo.change_stream.processed", 1)
+	return nil
+}
+
+func (w *ChangeStreamWatcher) logger() *zap.Logger {
+	if w.client.Logger == nil {
+		return zap.NewNop()
+	}
+
+	return w.client.Logger
+}
+
+func (w *ChangeStreamWatcher) recordMetric(metric string, value float64) {
+	if w.client.Telemetry != nil && w.client.Telemetry.Client != nil {
+		w.client.Telemetry.RecordMetric(metric, value)
+	}
+}
+
+// handlerError marks failures that are always retried, such as handler and token store errors
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string { return e.err.Error() }
+
+func (e *handlerError) Unwrap() error { return e.err }
+
+// historyLostError maps the ChangeStreamHistoryLost server error to ErrChangeStreamHistoryLost, and
+// returns other errors unchanged
+func historyLostError(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamHistoryLostCode) {
+		return fmt.Errorf("%w: %s", ErrChangeStreamHistoryLost, err.Error())
+	}
+
+	return err
+}
+
+// isRetryableChangeStreamError reports whether the watcher should reopen the stream after err
+func isRetryableChangeStreamError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrChangeStreamHistoryLost) {
+		return false
+	}
+
+	var hErr *handlerError
+	if errors.As(err, &hErr) {
+		return true
+	}
+
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.HasErrorLabel("ResumableChangeStreamError") ||
+			serverErr.HasErrorLabel("TransientTransactionError") ||
+			serverErr.HasErrorLabel("RetryableWriteError")
+	}
+
+	return false
+}
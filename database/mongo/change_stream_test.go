@@ -0,0 +1,254 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestClient_NewChangeStreamWatcher(t *testing.T) {
+	conn, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	if err != nil {
+		t.Fatalf("mongo.Connect() error = %v", err)
+	}
+	defer conn.Disconnect(context.Background())
+
+	databaseName := "test"
+	handler := func(ctx context.Context, event *ChangeEvent) error { return nil }
+
+	tests := []struct {
+		name        string
+		c           *Client
+		watcherName string
+		handler     ChangeHandlerFunc
+		wantErr     bool
+	}{
+		{
+			name:        "valid watcher",
+			c:           &Client{Conn: conn, DatabaseName: &databaseName},
+			watcherName: "algolia-sync",
+			handler:     handler,
+		},
+		{
+			name:        "missing connection",
+			c:           &Client{DatabaseName: &databaseName},
+			watcherName: "algolia-sync",
+			handler:     handler,
+			wantErr:     true,
+		},
+		{
+			name:        "missing name",
+			c:           &Client{Conn: conn, DatabaseName: &databaseName},
+			watcherName: "",
+			handler:     handler,
+			wantErr:     true,
+		},
+		{
+			name:        "missing handler",
+			c:           &Client{Conn: conn, DatabaseName: &databaseName},
+			watcherName: "algolia-sync",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.NewChangeStreamWatcher(tt.watcherName, tt.handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.NewChangeStreamWatcher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.store == nil {
+				t.Errorf("Client.NewChangeStreamWatcher() did not default the resume token store")
+			}
+		})
+	}
+}
+
+func TestChangeStreamWatcher_buildPipeline(t *testing.T) {
+	extra := bson.D{{Key: "$project", Value: bson.D{{Key: "fullDocument", Value: 1}}}}
+
+	tests := []struct {
+		name string
+		w    *ChangeStreamWatcher
+		want mongo.Pipeline
+	}{
+		{
+			name: "no filters",
+			w:    &ChangeStreamWatcher{},
+			want: mongo.Pipeline{},
+		},
+		{
+			name: "operation type filter and extra stage",
+			w: &ChangeStreamWatcher{
+				operationTypes: []OperationType{OperationInsert, OperationUpdate},
+				pipeline:       mongo.Pipeline{extra},
+			},
+			want: mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{
+					{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update"}}}},
+				}}},
+				extra,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.buildPipeline(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangeStreamWatcher.buildPipeline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isRetryableChangeStreamError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "handler error", err: &handlerError{errors.New("boom")}, want: true},
+		{name: "history lost", err: ErrChangeStreamHistoryLost, want: false},
+		{
+			name: "resumable server error",
+			err:  mongo.CommandError{Code: 6, Labels: []string{"ResumableChangeStreamError"}},
+			want: true,
+		},
+		{
+			name: "non resumable server error",
+			err:  mongo.CommandError{Code: 13, Message: "unauthorized"},
+			want: false,
+		},
+		{name: "unknown error", err: errors.New("boom"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableChangeStreamError(tt.err); got != tt.want {
+				t.Errorf("isRetryableChangeStreamError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_historyLostError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantLost      bool
+		wantRetryable bool
+	}{
+		{
+			name:     "history lost",
+			err:      mongo.CommandError{Code: changeStreamHistoryLostCode, Labels: []string{"ResumableChangeStreamError"}},
+			wantLost: true,
+		},
+		{
+			name:          "resumable server error",
+			err:           mongo.CommandError{Code: 6, Labels: []string{"ResumableChangeStreamError"}},
+			wantRetryable: true,
+		},
+		{name: "unknown error", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := historyLostError(tt.err)
+			if lost := errors.Is(got, ErrChangeStreamHistoryLost); lost != tt.wantLost {
+				t.Errorf("historyLostError() = %v, want history lost %v", got, tt.wantLost)
+			}
+			if retryable := isRetryableChangeStreamError(got); retryable != tt.wantRetryable {
+				t.Errorf("isRetryableChangeStreamError(historyLostError()) = %v, want %v", retryable, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestChangeStreamWatcher_handle(t *testing.T) {
+	token := func(id string) bson.Raw {
+		raw, _ := bson.Marshal(bson.D{{Key: "_data", Value: id}})
+		return raw
+	}
+
+	tests := []struct {
+		name           string
+		maxRetries     int
+		deadLetterErr  error
+		events         []string
+		wantErrs       []bool
+		wantDeadLetter []string
+	}{
+		{
+			name:     "retried until success by default",
+			events:   []string{"a", "a", "a", "a"},
+			wantErrs: []bool{true, true, true, true},
+		},
+		{
+			name:           "skipped after max retries",
+			maxRetries:     2,
+			events:         []string{"a", "a", "a", "b"},
+			wantErrs:       []bool{true, true, false, true},
+			wantDeadLetter: []string{"a"},
+		},
+		{
+			name:           "not skipped when dead lettering fails",
+			maxRetries:     1,
+			deadLetterErr:  errors.New("dlq unavailable"),
+			events:         []string{"a", "a", "a"},
+			wantErrs:       []bool{true, true, true},
+			wantDeadLetter: []string{"a", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadLettered []string
+			w := &ChangeStreamWatcher{
+				client: &Client{},
+				name:   "algolia-sync",
+				handler: func(ctx context.Context, event *ChangeEvent) error {
+					return errors.New("index unavailable")
+				},
+				maxHandlerRetries: tt.maxRetries,
+				deadLetter: func(ctx context.Context, event *ChangeEvent, err error) error {
+					deadLettered = append(deadLettered, event.ResumeToken.Lookup("_data").StringValue())
+					return tt.deadLetterErr
+				},
+			}
+
+			for i, id := range tt.events {
+				err := w.handle(context.Background(), &ChangeEvent{ResumeToken: token(id)})
+				if (err != nil) != tt.wantErrs[i] {
+					t.Errorf("ChangeStreamWatcher.handle() attempt %d error = %v, wantErr %v", i, err, tt.wantErrs[i])
+				}
+			}
+
+			if !reflect.DeepEqual(deadLettered, tt.wantDeadLetter) {
+				t.Errorf("dead lettered events = %v, want %v", deadLettered, tt.wantDeadLetter)
+			}
+		})
+	}
+}
+
+func TestChangeEvent_DecodeFullDocument(t *testing.T) {
+	raw, _ := bson.Marshal(bson.D{{Key: "email", Value: "a@b.c"}})
+
+	var doc struct {
+		Email string `bson:"email"`
+	}
+
+	event := &ChangeEvent{OperationType: OperationInsert, FullDocument: raw}
+	if err := event.DecodeFullDocument(&doc); err != nil {
+		t.Fatalf("ChangeEvent.DecodeFullDocument() error = %v", err)
+	}
+
+	if doc.Email != "a@b.c" {
+		t.Errorf("ChangeEvent.DecodeFullDocument() email = %s, want a@b.c", doc.Email)
+	}
+
+	empty := &ChangeEvent{OperationType: OperationDelete}
+	if err := empty.DecodeFullDocument(&doc); err == nil {
+		t.Errorf("ChangeEvent.DecodeFullDocument() expected an error for a delete event")
+	}
+}
@@ -7,7 +7,6 @@ import (
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
 	"github.com/newrelic/go-agent/v3/integrations/nrmongo"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -42,6 +41,13 @@ type Client struct {
 	collectionNameToCollectionObjectMap map[string]*mongo.Collection
 	// CollectionNames is a list of collection names
 	CollectionNames []string
+	// CollectionSpecs declares the indexes, validators and collation of the managed collections
+	CollectionSpecs []CollectionSpec
+	// DropDriftedIndexes drops and rebuilds indexes whose definition differs from their spec instead
+	// of only reporting the drift
+	DropDriftedIndexes bool
+	// schemaReport is the report produced by the last collection reconciliation
+	schemaReport *SchemaReport
 	// ClientOptions defines the options to use when connecting to the database
 	ClientOptions *options.ClientOptions
 	// ConnectionURI defines the connection string to use when connecting to the database
@@ -120,44 +126,13 @@ func (c *Client) connect() (*mongo.Client, error) {
 	return client, nil
 }
 
-// createCollections creates a database (mongodb) collection if it doesn't already exist
+// createCollections creates the configured collections if they don't already exist, reconciles
+// their indexes and validators, and registers every one of them with the client
 func (c *Client) createCollections() error {
-	ctx := context.Background()
-	if c.Conn == nil {
-		return fmt.Errorf("invalid input argument. database connection: %v", c.Conn)
-	}
-
-	if c.DatabaseName == nil {
-		return fmt.Errorf("invalid input argument. databaseName: %v", c.DatabaseName)
-	}
-
-	// check if collection already exists
-	db := c.Conn.Database(*c.DatabaseName)
-	collections, err := db.ListCollectionNames(ctx, bson.D{{}})
-	if err != nil {
+	if _, err := c.ReconcileCollections(context.Background()); err != nil {
 		return err
 	}
 
-	// create the collections
-	for _, collection := range c.CollectionNames {
-		// ensure the collection is not in the already existing collection set
-		if !contains(collections, collection) {
-			if err := db.CreateCollection(ctx, collection); err != nil {
-				return err
-			}
-
-			// update the collection map
-			if c.collectionNameToCollectionObjectMap == nil {
-				c.collectionNameToCollectionObjectMap = make(map[string]*mongo.Collection, 0)
-			}
-
-			// add the collection to the map
-			if _, ok := c.collectionNameToCollectionObjectMap[collection]; !ok {
-				c.collectionNameToCollectionObjectMap[collection] = db.Collection(collection)
-			}
-		}
-	}
-
 	return nil
 }
 
@@ -2,13 +2,17 @@ package mongo // import "github.com/SolomonAIEngineering/backend-core-library/da
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
-	"runtime"
-	"syscall"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -16,57 +20,188 @@ import (
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
 	mim "github.com/tryvium-travels/memongo"
+	"github.com/tryvium-travels/memongo/memongolog"
 )
 
 const (
-	mAX_DATABASE_CONNECTION_ATTEMPTS       = 3
-	mAX_DATABASE_RETRIES_PER_OPERATION     = 3
-	rETRY_TIMEOUT                          = 30 * time.Second
-	rETRY_SLEEP_INTERVAL                   = 5 * time.Second
-	qUERY_TIMEOUT                          = 1 * time.Minute
-	dATABASE_NAME                          = "database"
-	dEFAULT_PORT                       int = 27107
+	mAX_DATABASE_CONNECTION_ATTEMPTS   = 3
+	mAX_DATABASE_RETRIES_PER_OPERATION = 3
+	rETRY_TIMEOUT                      = 30 * time.Second
+	rETRY_SLEEP_INTERVAL               = 5 * time.Second
+	qUERY_TIMEOUT                      = 1 * time.Minute
+	dATABASE_NAME                      = "database"
+	sTARTUP_TIMEOUT                    = 30 * time.Second
+
+	// MongodBinEnv is the environment variable holding the path of the mongod binary used by test servers
+	MongodBinEnv = "MONGOD_BIN"
+	// MongodCacheDirEnv is the environment variable holding a directory searched for a mongod binary
+	MongodCacheDirEnv = "MONGOD_CACHE_DIR"
+	// MongodReplicaSetEnv enables replica set mode for NewInMemoryTestDbClient when set to a true value
+	MongodReplicaSetEnv = "MONGOD_REPLICA_SET"
 )
 
+// ErrMongodNotFound is returned when no local mongod binary can be found. Test servers never
+// download binaries, so tests should skip when they get this error.
+var ErrMongodNotFound = errors.New("mongod binary not found")
+
+var mongodVersionRegex = regexp.MustCompile(`db version v(\d+)\.(\d+)\.(\d+)`)
+
+// TestServerOptions configures a local mongod test server
+type TestServerOptions struct {
+	// MongodBin is the path of the mongod binary. Defaults to $MONGOD_BIN, then the binary found in
+	// CachePath, then mongod on the PATH
+	MongodBin string
+	// CachePath is a directory searched recursively for a mongod binary. Defaults to $MONGOD_CACHE_DIR
+	CachePath string
+	// ReplicaSet starts a single node replica set so transactions and change streams can be tested
+	ReplicaSet bool
+	// StartupTimeout is the maximum time to wait for the server to accept connections
+	StartupTimeout time.Duration
+}
+
+// TestServer is a mongod process listening on a random free port. A single server can be shared by
+// many tests, each using its own database through NewTestDbClient.
+type TestServer struct {
+	server     *mim.Server
+	replicaSet bool
+}
+
 type InMemoryTestDbClient struct {
 	DatabaseName string
 	Client       *Client
 	Server       *mim.Server
+	// ownsServer is true if the server was started for this client and must be stopped on teardown
+	ownsServer bool
 }
 
-// NewInMemoryTestDbClient creates a new in-memory MongoDB database and returns a client to it.
-func NewInMemoryTestDbClient(collectionNames []string) (*InMemoryTestDbClient, error) {
-	stopConflictingProcesses(dEFAULT_PORT)
-	server, err := mim.StartWithOptions(&mim.Options{MongoVersion: "6.0.0", ShouldUseReplica: false, DownloadURL: "https://fastdl.mongodb.org/osx/mongodb-macos-arm64-6.0.0.tgz"})
+// NewTestServer starts a mongod test server from a locally provided binary. It never downloads
+// binaries and returns ErrMongodNotFound if none is available.
+func NewTestServer(opts *TestServerOptions) (*TestServer, error) {
+	if opts == nil {
+		opts = &TestServerOptions{}
+	}
+
+	bin, err := ResolveMongodBinary(opts.MongodBin, opts.CachePath)
 	if err != nil {
 		return nil, err
 	}
-	uri := server.URI()
 
-	opts := []Option{
-		WithDatabaseName(dATABASE_NAME),
-		WithRetryTimeOut(rETRY_TIMEOUT),
-		WithOperationSleepInterval(rETRY_SLEEP_INTERVAL),
-		WithMaxConnectionAttempts(mAX_DATABASE_CONNECTION_ATTEMPTS),
-		WithMaxRetriesPerOperation(mAX_DATABASE_RETRIES_PER_OPERATION),
-		WithQueryTimeout(qUERY_TIMEOUT),
-		WithTelemetry(&instrumentation.Client{}),
-		WithLogger(zap.L()),
-		WithCollectionNames(collectionNames),
-		WithConnectionURI(uri),
-		WithClientOptions(options.Client().ApplyURI(uri).SetDirect(true)),
+	major, version, err := mongodVersion(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ephemeralForTest storage engine used for standalone servers was removed in mongo 7, and the
+	// underlying launcher only switches to wiredTiger for 7.x or replica sets
+	replicaSet := opts.ReplicaSet || major >= 8
+
+	startupTimeout := opts.StartupTimeout
+	if startupTimeout <= 0 {
+		startupTimeout = sTARTUP_TIMEOUT
 	}
-	conn, err := New(opts...)
+
+	server, err := mim.StartWithOptions(&mim.Options{
+		MongodBin:        bin,
+		MongoVersion:     version,
+		ShouldUseReplica: replicaSet,
+		StartupTimeout:   startupTimeout,
+		LogLevel:         memongolog.LogLevelWarn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TestServer{server: server, replicaSet: replicaSet}
+	if replicaSet {
+		if err := s.waitForPrimary(startupTimeout); err != nil {
+			server.Stop()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// URI returns the connection string of the server
+func (s *TestServer) URI() string {
+	return s.server.URI() + "/?directConnection=true"
+}
+
+// IsReplicaSet returns true if the server runs as a replica set and supports transactions
+func (s *TestServer) IsReplicaSet() bool {
+	return s.replicaSet
+}
+
+// Stop kills the server and removes its data directory
+func (s *TestServer) Stop() {
+	s.server.Stop()
+}
+
+// NewTestDbClient creates a client bound to a new database with a random name so tests sharing the
+// server are isolated from one another. Teardown drops the database but leaves the server running.
+func (s *TestServer) NewTestDbClient(collectionNames []string, opts ...Option) (*InMemoryTestDbClient, error) {
+	databaseName := fmt.Sprintf("%s_%s", dATABASE_NAME, mim.RandomDatabase())
+
+	conn, err := New(append(testClientOptions(s.URI(), databaseName, collectionNames), opts...)...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &InMemoryTestDbClient{
-		DatabaseName: dATABASE_NAME,
+		DatabaseName: databaseName,
 		Client:       conn,
-		Server:       server,
+		Server:       s.server,
 	}, nil
+}
+
+// waitForPrimary blocks until the single node replica set elected itself primary
+func (s *TestServer) waitForPrimary(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(s.URI()))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
 
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("replica set did not elect a primary within %s", timeout)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// NewInMemoryTestDbClient starts a dedicated mongod test server and returns a client to a new
+// database on it. The binary is resolved from $MONGOD_BIN, $MONGOD_CACHE_DIR or the PATH, and the
+// server runs as a replica set when $MONGOD_REPLICA_SET is true. Teardown stops the server.
+func NewInMemoryTestDbClient(collectionNames []string) (*InMemoryTestDbClient, error) {
+	replicaSet, _ := strconv.ParseBool(os.Getenv(MongodReplicaSetEnv))
+
+	server, err := NewTestServer(&TestServerOptions{ReplicaSet: replicaSet})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := server.NewTestDbClient(collectionNames)
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+
+	client.ownsServer = true
+	return client, nil
 }
 
 // Teardown cleans up resources initialized by Setup.
@@ -77,7 +212,9 @@ func (c *InMemoryTestDbClient) Teardown() error {
 	table := c.DatabaseName
 	inMemoryServer := c.Server
 
-	defer inMemoryServer.Stop()
+	if c.ownsServer {
+		defer inMemoryServer.Stop()
+	}
 
 	if err := conn.Database(table).Drop(context.Background()); err != nil {
 		return fmt.Errorf("error dropping test database: %v", err)
@@ -90,6 +227,109 @@ func (c *InMemoryTestDbClient) Teardown() error {
 	return nil
 }
 
+// ResolveMongodBinary returns the path of a local mongod binary. It checks, in order, the explicit
+// path, $MONGOD_BIN, the cache directory (or $MONGOD_CACHE_DIR) and the PATH.
+func ResolveMongodBinary(mongodBin, cachePath string) (string, error) {
+	if mongodBin == "" {
+		mongodBin = os.Getenv(MongodBinEnv)
+	}
+
+	if mongodBin != "" {
+		if !isExecutable(mongodBin) {
+			return "", fmt.Errorf("%w: %s is not an executable file", ErrMongodNotFound, mongodBin)
+		}
+
+		return mongodBin, nil
+	}
+
+	if cachePath == "" {
+		cachePath = os.Getenv(MongodCacheDirEnv)
+	}
+
+	if cachePath != "" {
+		if bin := findMongodInDir(cachePath); bin != "" {
+			return bin, nil
+		}
+	}
+
+	if bin, err := exec.LookPath("mongod"); err == nil {
+		return bin, nil
+	}
+
+	return "", fmt.Errorf("%w: set %s or %s, or add mongod to the PATH", ErrMongodNotFound, MongodBinEnv, MongodCacheDirEnv)
+}
+
+// findMongodInDir walks dir and returns the first executable named mongod
+func findMongodInDir(dir string) string {
+	var found string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if !d.IsDir() && d.Name() == "mongod" && isExecutable(path) {
+			found = path
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// isExecutable returns true if path is a regular file with an executable bit set
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
+
+// mongodVersion returns the major and full version reported by the binary
+func mongodVersion(bin string) (int, string, error) {
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed running %s --version | %s", bin, err.Error())
+	}
+
+	return parseMongodVersion(string(out))
+}
+
+// parseMongodVersion extracts the version from the output of mongod --version
+func parseMongodVersion(output string) (int, string, error) {
+	match := mongodVersionRegex.FindStringSubmatch(output)
+	if match == nil {
+		return 0, "", fmt.Errorf("unrecognized mongod version output: %q", output)
+	}
+
+	major, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return major, fmt.Sprintf("%s.%s.%s", match[1], match[2], match[3]), nil
+}
+
+// testClientOptions returns the client options used for test databases
+func testClientOptions(uri, databaseName string, collectionNames []string) []Option {
+	return []Option{
+		WithDatabaseName(databaseName),
+		WithRetryTimeOut(rETRY_TIMEOUT),
+		WithOperationSleepInterval(rETRY_SLEEP_INTERVAL),
+		WithMaxConnectionAttempts(mAX_DATABASE_CONNECTION_ATTEMPTS),
+		WithMaxRetriesPerOperation(mAX_DATABASE_RETRIES_PER_OPERATION),
+		WithQueryTimeout(qUERY_TIMEOUT),
+		WithTelemetry(&instrumentation.Client{}),
+		WithLogger(zap.L()),
+		WithCollectionNames(collectionNames),
+		WithConnectionURI(uri),
+		WithClientOptions(options.Client().ApplyURI(uri)),
+	}
+}
+
 func setupInMemoryMongoDB() (*mongo.Client, error) {
 	// Create an in-memory MongoDB database
 	mongoURI := "mongodb://localhost/test?authSource=$external&authMechanism=MONGODB-X509"
@@ -104,31 +344,3 @@ func setupInMemoryMongoDB() (*mongo.Client, error) {
 
 	return client, nil
 }
-
-// stopConflictingProcesses stops any conflicting process running on a the defined port
-func stopConflictingProcesses(port int) {
-	if runtime.GOOS == "windows" {
-		command := fmt.Sprintf("(Get-NetTCPConnection -LocalPort %d).OwningProcess -Force", port)
-		exec_cmd(exec.Command("Stop-Process", "-Id", command))
-	} else {
-		command := fmt.Sprintf("lsof -i tcp:%d | grep LISTEN | awk '{print $2}' | xargs kill -9", port)
-		exec_cmd(exec.Command("bash", "-c", command))
-	}
-}
-
-// Execute command and return exited code.
-func exec_cmd(cmd *exec.Cmd) {
-	var waitStatus syscall.WaitStatus
-	if err := cmd.Run(); err != nil {
-		if err != nil {
-			os.Stderr.WriteString(fmt.Sprintf("Error: %s\n", err.Error()))
-		}
-		if exitError, ok := err.(*exec.ExitError); ok {
-			waitStatus = exitError.Sys().(syscall.WaitStatus)
-			fmt.Printf("Error during killing (exit code: %s)\n", []byte(fmt.Sprintf("%d", waitStatus.ExitStatus())))
-		}
-	} else {
-		waitStatus = cmd.ProcessState.Sys().(syscall.WaitStatus)
-		fmt.Printf("Port successfully killed (exit code: %s)\n", []byte(fmt.Sprintf("%d", waitStatus.ExitStatus())))
-	}
-}
@@ -1,10 +1,14 @@
 package mongo
 
 import (
-	"os/exec"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -73,36 +77,137 @@ func Test_setupInMemoryMongoDB(t *testing.T) {
 	}
 }
 
-func Test_stopConflictingProcesses(t *testing.T) {
-	type args struct {
-		port int
+func TestResolveMongodBinary(t *testing.T) {
+	t.Setenv(MongodBinEnv, "")
+	t.Setenv(MongodCacheDirEnv, "")
+	t.Setenv("PATH", "")
+
+	cacheDir := t.TempDir()
+	nested := filepath.Join(cacheDir, "mongodb-linux-x86_64-7.0.2", "bin")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
 	}
+
+	bin := filepath.Join(nested, "mongod")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	notExecutable := filepath.Join(t.TempDir(), "mongod")
+	if err := os.WriteFile(notExecutable, []byte(""), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
-		name string
-		args args
+		name      string
+		mongodBin string
+		cachePath string
+		want      string
+		wantErr   error
 	}{
-		// TODO: Add test cases.
+		{name: "explicit binary", mongodBin: bin, want: bin},
+		{name: "binary found in cache directory", cachePath: cacheDir, want: bin},
+		{name: "explicit binary is not executable", mongodBin: notExecutable, wantErr: ErrMongodNotFound},
+		{name: "nothing configured", wantErr: ErrMongodNotFound},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			stopConflictingProcesses(tt.args.port)
+			got, err := ResolveMongodBinary(tt.mongodBin, tt.cachePath)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveMongodBinary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveMongodBinary() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
 
-func Test_exec_cmd(t *testing.T) {
-	type args struct {
-		cmd *exec.Cmd
-	}
+func Test_parseMongodVersion(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
+		name        string
+		output      string
+		wantMajor   int
+		wantVersion string
+		wantErr     bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:        "mongod 7",
+			output:      "db version v7.0.2\nBuild Info: {\n    \"version\": \"7.0.2\"\n}",
+			wantMajor:   7,
+			wantVersion: "7.0.2",
+		},
+		{
+			name:        "mongod 6",
+			output:      "db version v6.0.12",
+			wantMajor:   6,
+			wantVersion: "6.0.12",
+		},
+		{
+			name:    "unrecognized output",
+			output:  "command not found",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			exec_cmd(tt.args.cmd)
+			major, version, err := parseMongodVersion(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMongodVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if major != tt.wantMajor || version != tt.wantVersion {
+				t.Errorf("parseMongodVersion() = %d, %s, want %d, %s", major, version, tt.wantMajor, tt.wantVersion)
+			}
 		})
 	}
 }
+
+func TestTestServer_NewTestDbClient(t *testing.T) {
+	server, err := NewTestServer(&TestServerOptions{ReplicaSet: true})
+	if errors.Is(err, ErrMongodNotFound) {
+		t.Skipf("skipping: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("NewTestServer() error = %v", err)
+	}
+	defer server.Stop()
+
+	first, err := server.NewTestDbClient([]string{"users"})
+	if err != nil {
+		t.Fatalf("TestServer.NewTestDbClient() error = %v", err)
+	}
+	defer first.Teardown()
+
+	second, err := server.NewTestDbClient([]string{"users"})
+	if err != nil {
+		t.Fatalf("TestServer.NewTestDbClient() error = %v", err)
+	}
+	defer second.Teardown()
+
+	if first.DatabaseName == second.DatabaseName {
+		t.Fatalf("test clients share database %s", first.DatabaseName)
+	}
+
+	ctx := context.Background()
+	users, err := first.Client.GetCollection("users")
+	if err != nil {
+		t.Fatalf("Client.GetCollection() error = %v", err)
+	}
+
+	err = first.Client.StandardTransaction(ctx, func(sessCtx mongo.SessionContext) (any, error) {
+		return users.InsertOne(sessCtx, bson.D{{Key: "email", Value: "a@b.c"}})
+	})
+	if err != nil {
+		t.Fatalf("Client.StandardTransaction() error = %v", err)
+	}
+
+	otherUsers, _ := second.Client.GetCollection("users")
+	count, err := otherUsers.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		t.Fatalf("CountDocuments() error = %v", err)
+	}
+
+	if count != 0 {
+		t.Errorf("second database has %d documents, want 0", count)
+	}
+}
@@ -74,6 +74,20 @@ func WithCollectionNames(collectionNames []string) Option {
 	}
 }
 
+// WithCollectionSpecs sets the declarative collection specs reconciled when the client is created
+func WithCollectionSpecs(specs ...CollectionSpec) Option {
+	return func(c *Client) {
+		c.CollectionSpecs = append(c.CollectionSpecs, specs...)
+	}
+}
+
+// WithDropDriftedIndexes drops and rebuilds indexes that drifted from their spec
+func WithDropDriftedIndexes(drop bool) Option {
+	return func(c *Client) {
+		c.DropDriftedIndexes = drop
+	}
+}
+
 // WithClientOptions sets the client options
 func WithClientOptions(clientOptions *options.ClientOptions) Option {
 	return func(c *Client) {
@@ -122,10 +136,16 @@ func (c *Client) Validate() error {
 		return fmt.Errorf("logger not set")
 	}
 
-	if len(c.CollectionNames) == 0 {
+	if len(c.CollectionNames) == 0 && len(c.CollectionSpecs) == 0 {
 		return fmt.Errorf("collection names not set")
 	}
 
+	for _, spec := range c.CollectionSpecs {
+		if err := spec.validate(); err != nil {
+			return err
+		}
+	}
+
 	if c.ClientOptions == nil {
 		return fmt.Errorf("client options not set")
 	}
@@ -0,0 +1,166 @@
+package mongo // import "github.com/SolomonAIEngineering/backend-core-library/database/mongo"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/database/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultResumeTokenTTL = 7 * 24 * time.Hour
+
+// ResumeTokenStore persists change stream resume tokens so a watcher can pick up where it left off
+// after a restart. Load returns a nil token without an error when no token has been saved yet.
+type ResumeTokenStore interface {
+	Load(ctx context.Context, key string) (bson.Raw, error)
+	Save(ctx context.Context, key string, token bson.Raw) error
+	Delete(ctx context.Context, key string) error
+}
+
+// InMemoryResumeTokenStore keeps resume tokens in process memory. It is intended for tests and for
+// watchers that are fine with restarting from the current point in time.
+type InMemoryResumeTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]bson.Raw
+}
+
+var _ ResumeTokenStore = (*InMemoryResumeTokenStore)(nil)
+
+// NewInMemoryResumeTokenStore creates an empty in-memory resume token store
+func NewInMemoryResumeTokenStore() *InMemoryResumeTokenStore {
+	return &InMemoryResumeTokenStore{tokens: make(map[string]bson.Raw)}
+}
+
+// Load returns the token saved under key
+func (s *InMemoryResumeTokenStore) Load(_ context.Context, key string) (bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tokens[key], nil
+}
+
+// Save stores a copy of the token under key
+func (s *InMemoryResumeTokenStore) Save(_ context.Context, key string, token bson.Raw) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[key] = append(bson.Raw(nil), token...)
+	return nil
+}
+
+// Delete removes the token saved under key
+func (s *InMemoryResumeTokenStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, key)
+	return nil
+}
+
+// CollectionResumeTokenStore persists resume tokens as documents of a mongo collection keyed by
+// watcher name.
+type CollectionResumeTokenStore struct {
+	collection *mongo.Collection
+}
+
+var _ ResumeTokenStore = (*CollectionResumeTokenStore)(nil)
+
+type resumeTokenDocument struct {
+	Key       string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// NewCollectionResumeTokenStore creates a resume token store backed by the given collection
+func NewCollectionResumeTokenStore(collection *mongo.Collection) (*CollectionResumeTokenStore, error) {
+	if collection == nil {
+		return nil, fmt.Errorf("invalid input argument. collection: %v", collection)
+	}
+
+	return &CollectionResumeTokenStore{collection: collection}, nil
+}
+
+// Load returns the token saved under key
+func (s *CollectionResumeTokenStore) Load(ctx context.Context, key string) (bson.Raw, error) {
+	var doc resumeTokenDocument
+	if err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return doc.Token, nil
+}
+
+// Save upserts the token under key
+func (s *CollectionResumeTokenStore) Save(ctx context.Context, key string, token bson.Raw) error {
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"_id": key},
+		resumeTokenDocument{Key: key, Token: token, UpdatedAt: time.Now().UTC()},
+		options.Replace().SetUpsert(true))
+
+	return err
+}
+
+// Delete removes the token saved under key
+func (s *CollectionResumeTokenStore) Delete(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// RedisResumeTokenStore persists resume tokens in redis. Tokens expire after a TTL since a token
+// older than the oplog window cannot be resumed from anyway.
+type RedisResumeTokenStore struct {
+	client    *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+var _ ResumeTokenStore = (*RedisResumeTokenStore)(nil)
+
+// NewRedisResumeTokenStore creates a resume token store backed by redis. Keys are prefixed with
+// keyPrefix and expire after ttl (7 days when ttl is not positive).
+func NewRedisResumeTokenStore(client *redis.Client, keyPrefix string, ttl time.Duration) (*RedisResumeTokenStore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("invalid input argument. client: %v", client)
+	}
+
+	if ttl <= 0 {
+		ttl = defaultResumeTokenTTL
+	}
+
+	return &RedisResumeTokenStore{client: client, keyPrefix: keyPrefix, ttl: ttl}, nil
+}
+
+// Load returns the token saved under key
+func (s *RedisResumeTokenStore) Load(ctx context.Context, key string) (bson.Raw, error) {
+	value, err := s.client.Get(ctx, s.keyPrefix+key)
+	if err != nil {
+		if errors.Is(err, redigo.ErrNil) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return bson.Raw(value), nil
+}
+
+// Save stores the token under key
+func (s *RedisResumeTokenStore) Save(ctx context.Context, key string, token bson.Raw) error {
+	return s.client.WriteWithTTL(ctx, s.keyPrefix+key, token, int(s.ttl/time.Second))
+}
+
+// Delete removes the token saved under key
+func (s *RedisResumeTokenStore) Delete(ctx context.Context, key string) error {
+	return s.client.Delete(ctx, s.keyPrefix+key)
+}
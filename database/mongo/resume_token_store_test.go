@@ -0,0 +1,76 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/database/redis"
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/alicebob/miniredis/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+func TestResumeTokenStore(t *testing.T) {
+	redisTestServer := miniredis.RunT(t)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	redisClient, err := redis.New(stopCh,
+		redis.WithURI(fmt.Sprintf("redis://%s", redisTestServer.Addr())),
+		redis.WithServiceName("test"),
+		redis.WithLogger(zap.NewNop()),
+		redis.WithTelemetrySdk(&instrumentation.Client{}),
+		redis.WithCacheTTLInSeconds(60))
+	if err != nil {
+		t.Fatalf("redis.New() error = %v", err)
+	}
+	defer redisClient.Close()
+
+	redisStore, err := NewRedisResumeTokenStore(redisClient, "resume-token:", time.Hour)
+	if err != nil {
+		t.Fatalf("NewRedisResumeTokenStore() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		store ResumeTokenStore
+	}{
+		{name: "in memory", store: NewInMemoryResumeTokenStore()},
+		{name: "redis", store: redisStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			token, _ := bson.Marshal(bson.D{{Key: "_data", Value: "8263A1"}})
+
+			got, err := tt.store.Load(ctx, "watcher")
+			if err != nil || got != nil {
+				t.Fatalf("Load() on an empty store = %v, %v, want nil, nil", got, err)
+			}
+
+			if err := tt.store.Save(ctx, "watcher", token); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			got, err = tt.store.Load(ctx, "watcher")
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if string(got) != string(token) {
+				t.Errorf("Load() = %v, want %v", got, bson.Raw(token))
+			}
+
+			if err := tt.store.Delete(ctx, "watcher"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			if got, _ := tt.store.Load(ctx, "watcher"); got != nil {
+				t.Errorf("Load() after Delete() = %v, want nil", got)
+			}
+		})
+	}
+}
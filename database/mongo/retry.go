@@ -0,0 +1,150 @@
+package mongo // import "github.com/SolomonAIEngineering/backend-core-library/database/mongo"
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	transientTransactionErrorLabel      = "TransientTransactionError"
+	unknownTransactionCommitResultLabel = "UnknownTransactionCommitResult"
+	retryableWriteErrorLabel            = "RetryableWriteError"
+)
+
+// ErrorClass categorizes mongo driver errors by how they should be retried
+type ErrorClass int
+
+const (
+	// ErrorClassNone is returned for a nil error
+	ErrorClassNone ErrorClass = iota
+	// ErrorClassTransientTransaction errors abort the transaction; the whole transaction can be retried
+	ErrorClassTransientTransaction
+	// ErrorClassUnknownCommitResult errors leave the commit outcome unknown; the commit can be retried
+	ErrorClassUnknownCommitResult
+	// ErrorClassRetryable errors (network errors, timeouts, retryable writes) can be retried as is
+	ErrorClassRetryable
+	// ErrorClassPermanent errors must not be retried
+	ErrorClassPermanent
+)
+
+// String returns the name of the error class
+func (e ErrorClass) String() string {
+	switch e {
+	case ErrorClassNone:
+		return "none"
+	case ErrorClassTransientTransaction:
+		return "transient_transaction"
+	case ErrorClassUnknownCommitResult:
+		return "unknown_commit_result"
+	case ErrorClassRetryable:
+		return "retryable"
+	default:
+		return "permanent"
+	}
+}
+
+// ClassifyError returns the retry class of a mongo driver error. Error labels take precedence over
+// the error type since the server attaches them following the driver transactions specification.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassPermanent
+	}
+
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) {
+		switch {
+		case labeled.HasErrorLabel(unknownTransactionCommitResultLabel):
+			return ErrorClassUnknownCommitResult
+		case labeled.HasErrorLabel(transientTransactionErrorLabel):
+			return ErrorClassTransientTransaction
+		case labeled.HasErrorLabel(retryableWriteErrorLabel):
+			return ErrorClassRetryable
+		}
+	}
+
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return ErrorClassRetryable
+	}
+
+	return ErrorClassPermanent
+}
+
+// retryPolicy bounds retries by count and by total elapsed time
+type retryPolicy struct {
+	// maxRetries is the number of retries after the first attempt
+	maxRetries int
+	// timeout is the total time after which no new attempt is started
+	timeout time.Duration
+	// interval is the sleep between attempts
+	interval time.Duration
+}
+
+// retryPolicy builds the retry policy from the client configuration
+func (c *Client) retryPolicy() retryPolicy {
+	return retryPolicy{
+		maxRetries: c.MaxRetriesPerOperation,
+		timeout:    c.RetryTimeOut,
+		interval:   c.OperationSleepInterval,
+	}
+}
+
+// do calls fn until it succeeds, returns an error for which shouldRetry is false, or the policy is
+// exhausted. It returns the number of attempts made and the last error.
+func (p retryPolicy) do(ctx context.Context, shouldRetry func(error) bool, fn func(attempt int) error) (int, error) {
+	started := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !shouldRetry(err) || attempt > p.maxRetries {
+			return attempt, err
+		}
+
+		if p.timeout > 0 && time.Since(started)+p.interval > p.timeout {
+			return attempt, err
+		}
+
+		if p.interval > 0 {
+			select {
+			case <-ctx.Done():
+				return attempt, err
+			case <-time.After(p.interval):
+			}
+		}
+	}
+}
+
+// RetryOperation runs a single database operation, retrying it on network errors, timeouts and
+// retryable write errors within the MaxRetriesPerOperation and RetryTimeOut bounds of the client.
+// The operation must be idempotent.
+func (c *Client) RetryOperation(ctx context.Context, name string, operation func(ctx context.Context) error) error {
+	attempts, err := c.retryPolicy().do(ctx,
+		func(err error) bool { return ClassifyError(err) == ErrorClassRetryable },
+		func(int) error { return operation(ctx) })
+
+	c.recordAttempts(ctx, name, attempts, err)
+	return err
+}
+
+// recordAttempts adds the attempt count to the current transaction and records it as a metric
+func (c *Client) recordAttempts(ctx context.Context, name string, attempts int, err error) {
+	if c.Telemetry == nil {
+		return
+	}
+
+	txn := c.Telemetry.GetTraceFromContext(ctx)
+	txn.AddAttribute("mongo."+name+".attempts", attempts)
+	if err != nil {
+		txn.AddAttribute("mongo."+name+".error_class", ClassifyError(err).String())
+	}
+
+	if c.Telemetry.Client != nil {
+		c.Telemetry.RecordMetric("mongo."+name+".attempts", float64(attempts))
+	}
+}
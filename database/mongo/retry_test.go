@@ -0,0 +1,133 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "nil", err: nil, want: ErrorClassNone},
+		{
+			name: "transient transaction error",
+			err:  mongo.CommandError{Code: 112, Labels: []string{transientTransactionErrorLabel}},
+			want: ErrorClassTransientTransaction,
+		},
+		{
+			name: "unknown commit result",
+			err:  mongo.CommandError{Code: 91, Labels: []string{unknownTransactionCommitResultLabel, retryableWriteErrorLabel}},
+			want: ErrorClassUnknownCommitResult,
+		},
+		{
+			name: "retryable write error",
+			err:  mongo.CommandError{Code: 189, Labels: []string{retryableWriteErrorLabel}},
+			want: ErrorClassRetryable,
+		},
+		{
+			name: "wrapped transient transaction error",
+			err:  fmt.Errorf("insert failed: %w", mongo.CommandError{Code: 112, Labels: []string{transientTransactionErrorLabel}}),
+			want: ErrorClassTransientTransaction,
+		},
+		{
+			name: "duplicate key",
+			err:  mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}},
+			want: ErrorClassPermanent,
+		},
+		{name: "context cancelled", err: context.Canceled, want: ErrorClassPermanent},
+		{name: "plain error", err: errors.New("boom"), want: ErrorClassPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_retryPolicy_do(t *testing.T) {
+	transient := mongo.CommandError{Code: 112, Labels: []string{transientTransactionErrorLabel}}
+	permanent := errors.New("permanent")
+	isTransient := func(err error) bool { return ClassifyError(err) == ErrorClassTransientTransaction }
+
+	tests := []struct {
+		name         string
+		policy       retryPolicy
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "succeeds first time",
+			policy:       retryPolicy{maxRetries: 3},
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds after transient errors",
+			policy:       retryPolicy{maxRetries: 3, interval: time.Millisecond},
+			errs:         []error{transient, transient, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max retries",
+			policy:       retryPolicy{maxRetries: 2},
+			errs:         []error{transient, transient, transient, nil},
+			wantAttempts: 3,
+			wantErr:      transient,
+		},
+		{
+			name:         "does not retry permanent errors",
+			policy:       retryPolicy{maxRetries: 3},
+			errs:         []error{permanent, nil},
+			wantAttempts: 1,
+			wantErr:      permanent,
+		},
+		{
+			name:         "stops when the next attempt would exceed the timeout",
+			policy:       retryPolicy{maxRetries: 10, timeout: 15 * time.Millisecond, interval: 10 * time.Millisecond},
+			errs:         []error{transient, transient, transient, transient, nil},
+			wantAttempts: 2,
+			wantErr:      transient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.do(context.Background(), isTransient, func(attempt int) error {
+				return tt.errs[attempt-1]
+			})
+			if got != tt.wantAttempts {
+				t.Errorf("retryPolicy.do() attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("retryPolicy.do() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_RetryOperation(t *testing.T) {
+	c := &Client{MaxRetriesPerOperation: 2}
+	retryable := mongo.CommandError{Code: 189, Labels: []string{retryableWriteErrorLabel}}
+
+	calls := 0
+	err := c.RetryOperation(context.Background(), "insert", func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return retryable
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Client.RetryOperation() = %v after %d calls, want nil after 2 calls", err, calls)
+	}
+}
@@ -0,0 +1,641 @@
+package mongo // import "github.com/SolomonAIEngineering/backend-core-library/database/mongo"
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// CollectionSpec declares the desired state of a collection. Specs are reconciled against the
+// database when the client is created: missing collections are created with their validator and
+// collation, missing indexes are built and any difference between the declared and the actual
+// state is recorded in a SchemaReport.
+type CollectionSpec struct {
+	// Name is the name of the collection
+	Name string
+	// Indexes are the indexes the collection should have (the default _id index is implied)
+	Indexes []IndexSpec
+	// JSONSchema is the $jsonSchema document used to validate writes against the collection
+	JSONSchema bson.M
+	// ValidationLevel is the validation level ("off", "strict" or "moderate"). Defaults to "strict"
+	ValidationLevel string
+	// ValidationAction is the validation action ("error" or "warn"). Defaults to "error"
+	ValidationAction string
+	// Collation is the default collation of the collection. It can only be set on creation
+	Collation *options.Collation
+}
+
+// IndexSpec declares a single index. A spec with more than one key describes a compound index.
+type IndexSpec struct {
+	// Name is the index name. When empty, the name is derived from the keys the same way the
+	// mongo server does (e.g. "email_1_created_at_-1")
+	Name string
+	// Keys are the ordered index keys
+	Keys bson.D
+	// Unique rejects documents whose indexed values already exist in the collection
+	Unique bool
+	// Sparse only references documents that contain the indexed fields
+	Sparse bool
+	// ExpireAfter turns the index into a TTL index when greater than zero. TTL indexes have a
+	// precision of one second, so values below one second are rejected
+	ExpireAfter time.Duration
+	// TTL turns the index into a TTL index even when ExpireAfter is zero, which expires documents
+	// at the time stored in the indexed field (expireAfterSeconds: 0)
+	TTL bool
+	// PartialFilter restricts the index to documents matching the filter expression
+	PartialFilter bson.D
+}
+
+// SchemaReport summarizes the outcome of a collection reconciliation.
+type SchemaReport struct {
+	// Collections holds a report per configured collection
+	Collections []*CollectionReport
+}
+
+// CollectionReport describes what was changed on a single collection and what drifted from its
+// spec without being changed.
+type CollectionReport struct {
+	// Name is the name of the collection
+	Name string
+	// Created is true if the collection did not exist and was created
+	Created bool
+	// ValidatorUpdated is true if the validator of an existing collection was modified
+	ValidatorUpdated bool
+	// IndexesCreated lists the indexes that were built
+	IndexesCreated []string
+	// IndexesRebuilt lists drifted indexes that were dropped and built again
+	IndexesRebuilt []string
+	// Drift lists human readable differences between the spec and the database that were not reconciled
+	Drift []string
+	// UnmanagedIndexes lists indexes present in the database but absent from the spec
+	UnmanagedIndexes []string
+}
+
+// HasDrift returns true if any collection in the report has unreconciled drift.
+func (r *SchemaReport) HasDrift() bool {
+	if r == nil {
+		return false
+	}
+
+	for _, collection := range r.Collections {
+		if len(collection.Drift) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// existingIndex is the subset of a listIndexes result used to detect drift
+type existingIndex struct {
+	Name                    string   `bson:"name"`
+	Keys                    bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique,omitempty"`
+	Sparse                  bool     `bson:"sparse,omitempty"`
+	ExpireAfterSeconds      *int32   `bson:"expireAfterSeconds,omitempty"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression,omitempty"`
+}
+
+// SchemaReport returns the report produced by the last collection reconciliation.
+func (c *Client) SchemaReport() *SchemaReport {
+	return c.schemaReport
+}
+
+// ReconcileCollections brings the configured collection specs in line with the database. It creates
+// missing collections and indexes, updates drifted validators and, when enabled, rebuilds drifted
+// indexes. Every configured collection is registered with the client whether or not it was created.
+func (c *Client) ReconcileCollections(ctx context.Context) (*SchemaReport, error) {
+	if c.Conn == nil {
+		return nil, fmt.Errorf("invalid input argument. database connection: %v", c.Conn)
+	}
+
+	if c.DatabaseName == nil {
+		return nil, fmt.Errorf("invalid input argument. databaseName: %v", c.DatabaseName)
+	}
+
+	db := c.Conn.Database(*c.DatabaseName)
+	existing, err := db.ListCollectionSpecifications(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	existingByName := make(map[string]*mongo.CollectionSpecification, len(existing))
+	for _, spec := range existing {
+		existingByName[spec.Name] = spec
+	}
+
+	report := &SchemaReport{}
+	for _, spec := range c.collectionSpecs() {
+		collectionReport, err := c.reconcileCollection(ctx, db, spec, existingByName[spec.Name])
+		if err != nil {
+			return nil, fmt.Errorf("failed reconciling collection %s | %s", spec.Name, err.Error())
+		}
+
+		c.registerCollection(db, spec.Name)
+		report.Collections = append(report.Collections, collectionReport)
+	}
+
+	c.schemaReport = report
+	c.logSchemaReport(report)
+
+	return report, nil
+}
+
+// collectionSpecs merges the plain collection names with the declarative specs. Names without a
+// spec are treated as specs without indexes or validators.
+func (c *Client) collectionSpecs() []CollectionSpec {
+	specs := make([]CollectionSpec, 0, len(c.CollectionNames)+len(c.CollectionSpecs))
+	seen := make(map[string]struct{}, cap(specs))
+
+	for _, spec := range c.CollectionSpecs {
+		if _, ok := seen[spec.Name]; ok {
+			continue
+		}
+
+		seen[spec.Name] = struct{}{}
+		specs = append(specs, spec)
+	}
+
+	for _, name := range c.CollectionNames {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+		specs = append(specs, CollectionSpec{Name: name})
+	}
+
+	return specs
+}
+
+// registerCollection adds the collection to the name to collection object map
+func (c *Client) registerCollection(db *mongo.Database, name string) {
+	if c.collectionNameToCollectionObjectMap == nil {
+		c.collectionNameToCollectionObjectMap = make(map[string]*mongo.Collection, 0)
+	}
+
+	if _, ok := c.collectionNameToCollectionObjectMap[name]; !ok {
+		c.collectionNameToCollectionObjectMap[name] = db.Collection(name)
+	}
+}
+
+// reconcileCollection creates or updates a single collection based on its spec
+func (c *Client) reconcileCollection(ctx context.Context, db *mongo.Database, spec CollectionSpec, existing *mongo.CollectionSpecification) (*CollectionReport, error) {
+	report := &CollectionReport{Name: spec.Name}
+
+	if existing == nil {
+		opts := options.CreateCollection()
+		if validator := spec.validator(); validator != nil {
+			opts.SetValidator(validator).
+				SetValidationLevel(spec.validationLevel()).
+				SetValidationAction(spec.validationAction())
+		}
+
+		if spec.Collation != nil {
+			opts.SetCollation(spec.Collation)
+		}
+
+		if err := db.CreateCollection(ctx, spec.Name, opts); err != nil {
+			return nil, err
+		}
+
+		report.Created = true
+	} else {
+		if existing.Type == "view" {
+			return nil, fmt.Errorf("%s is a view and cannot be managed as a collection", spec.Name)
+		}
+
+		updated, drift, err := c.reconcileCollectionOptions(ctx, db, spec, existing.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		report.ValidatorUpdated = updated
+		report.Drift = append(report.Drift, drift...)
+	}
+
+	if err := c.reconcileIndexes(ctx, db.Collection(spec.Name), spec, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// reconcileCollectionOptions updates the validator of an existing collection if it differs from the
+// spec and reports collation drift, which cannot be changed after creation
+func (c *Client) reconcileCollectionOptions(ctx context.Context, db *mongo.Database, spec CollectionSpec, current bson.Raw) (bool, []string, error) {
+	var collectionOptions struct {
+		Validator        bson.Raw `bson:"validator,omitempty"`
+		ValidationLevel  string   `bson:"validationLevel,omitempty"`
+		ValidationAction string   `bson:"validationAction,omitempty"`
+		Collation        bson.Raw `bson:"collation,omitempty"`
+	}
+
+	if len(current) > 0 {
+		if err := bson.Unmarshal(current, &collectionOptions); err != nil {
+			return false, nil, err
+		}
+	}
+
+	drift := make([]string, 0)
+	if spec.Collation != nil {
+		var currentCollation struct {
+			Locale   string `bson:"locale"`
+			Strength int    `bson:"strength"`
+		}
+
+		if len(collectionOptions.Collation) > 0 {
+			if err := bson.Unmarshal(collectionOptions.Collation, &currentCollation); err != nil {
+				return false, nil, err
+			}
+		}
+
+		if currentCollation.Locale != spec.Collation.Locale ||
+			(spec.Collation.Strength != 0 && currentCollation.Strength != spec.Collation.Strength) {
+			drift = append(drift, fmt.Sprintf("collation: want locale=%s strength=%d, have locale=%s strength=%d",
+				spec.Collation.Locale, spec.Collation.Strength, currentCollation.Locale, currentCollation.Strength))
+		}
+	}
+
+	validator := spec.validator()
+	if validator == nil {
+		return false, drift, nil
+	}
+
+	validatorMatches, err := documentsEqual(validator, collectionOptions.Validator)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if validatorMatches &&
+		collectionOptions.ValidationLevel == spec.validationLevel() &&
+		collectionOptions.ValidationAction == spec.validationAction() {
+		return false, drift, nil
+	}
+
+	cmd := bson.D{
+		{Key: "collMod", Value: spec.Name},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: spec.validationLevel()},
+		{Key: "validationAction", Value: spec.validationAction()},
+	}
+
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return false, nil, err
+	}
+
+	return true, drift, nil
+}
+
+// reconcileIndexes builds missing indexes and reports (or rebuilds) drifted ones
+func (c *Client) reconcileIndexes(ctx context.Context, collection *mongo.Collection, spec CollectionSpec, report *CollectionReport) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var current []existingIndex
+	if err := cursor.All(ctx, &current); err != nil {
+		return err
+	}
+
+	currentByName := make(map[string]existingIndex, len(current))
+	for _, index := range current {
+		currentByName[index.Name] = index
+	}
+
+	managed := make(map[string]struct{}, len(spec.Indexes))
+	missing := make([]mongo.IndexModel, 0)
+	for _, index := range spec.Indexes {
+		name := index.name()
+		managed[name] = struct{}{}
+
+		existing, ok := currentByName[name]
+		if !ok {
+			missing = append(missing, index.model())
+			report.IndexesCreated = append(report.IndexesCreated, name)
+			continue
+		}
+
+		differences, err := diffIndex(index, existing)
+		if err != nil {
+			return err
+		}
+
+		if len(differences) == 0 {
+			continue
+		}
+
+		if !c.DropDriftedIndexes {
+			for _, difference := range differences {
+				report.Drift = append(report.Drift, fmt.Sprintf("index %s: %s", name, difference))
+			}
+			continue
+		}
+
+		if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+
+		missing = append(missing, index.model())
+		report.IndexesRebuilt = append(report.IndexesRebuilt, name)
+	}
+
+	for _, index := range current {
+		if _, ok := managed[index.Name]; !ok && index.Name != "_id_" {
+			report.UnmanagedIndexes = append(report.UnmanagedIndexes, index.Name)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, missing)
+	return err
+}
+
+// logSchemaReport emits the reconciliation report through the client logger
+func (c *Client) logSchemaReport(report *SchemaReport) {
+	if c.Logger == nil {
+		return
+	}
+
+	for _, collection := range report.Collections {
+		fields := []zap.Field{
+			zap.String("collection", collection.Name),
+			zap.Bool("created", collection.Created),
+			zap.Bool("validatorUpdated", collection.ValidatorUpdated),
+			zap.Strings("indexesCreated", collection.IndexesCreated),
+			zap.Strings("indexesRebuilt", collection.IndexesRebuilt),
+			zap.Strings("unmanagedIndexes", collection.UnmanagedIndexes),
+		}
+
+		if len(collection.Drift) > 0 {
+			c.Logger.Warn("collection drifted from spec", append(fields, zap.Strings("drift", collection.Drift))...)
+			continue
+		}
+
+		c.Logger.Info("collection reconciled", fields...)
+	}
+}
+
+// validate ensures the spec can be applied
+func (s CollectionSpec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("collection spec name not set")
+	}
+
+	names := make(map[string]struct{}, len(s.Indexes))
+	for _, index := range s.Indexes {
+		if len(index.Keys) == 0 {
+			return fmt.Errorf("collection %s: index keys not set", s.Name)
+		}
+
+		if index.ExpireAfter < 0 || (index.ExpireAfter > 0 && index.ExpireAfter < time.Second) {
+			return fmt.Errorf("collection %s: TTL index %s must expire after zero or at least one second, got %s", s.Name, index.name(), index.ExpireAfter)
+		}
+
+		if index.isTTL() && len(index.Keys) > 1 {
+			return fmt.Errorf("collection %s: TTL index %s must have a single key", s.Name, index.name())
+		}
+
+		if _, ok := names[index.name()]; ok {
+			return fmt.Errorf("collection %s: duplicate index %s", s.Name, index.name())
+		}
+
+		names[index.name()] = struct{}{}
+	}
+
+	return nil
+}
+
+// validator returns the validator document for the spec, or nil if no schema is declared
+func (s CollectionSpec) validator() bson.M {
+	if len(s.JSONSchema) == 0 {
+		return nil
+	}
+
+	return bson.M{"$jsonSchema": s.JSONSchema}
+}
+
+func (s CollectionSpec) validationLevel() string {
+	if s.ValidationLevel == "" {
+		return "strict"
+	}
+
+	return s.ValidationLevel
+}
+
+func (s CollectionSpec) validationAction() string {
+	if s.ValidationAction == "" {
+		return "error"
+	}
+
+	return s.ValidationAction
+}
+
+// name returns the configured index name or the name the server would generate for the keys
+func (i IndexSpec) name() string {
+	if i.Name != "" {
+		return i.Name
+	}
+
+	parts := make([]string, 0, len(i.Keys)*2)
+	for _, key := range i.Keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// isTTL returns true if the spec declares a TTL index
+func (i IndexSpec) isTTL() bool {
+	return i.TTL || i.ExpireAfter > 0
+}
+
+// expireAfterSeconds returns the expireAfterSeconds option of a TTL index
+func (i IndexSpec) expireAfterSeconds() int32 {
+	return int32(i.ExpireAfter / time.Second)
+}
+
+// model converts the spec into a driver index model
+func (i IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.name())
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+
+	if i.Sparse {
+		opts.SetSparse(true)
+	}
+
+	if i.isTTL() {
+		opts.SetExpireAfterSeconds(i.expireAfterSeconds())
+	}
+
+	if len(i.PartialFilter) > 0 {
+		opts.SetPartialFilterExpression(i.PartialFilter)
+	}
+
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+// diffIndex returns the differences between an index spec and the index present in the database
+func diffIndex(spec IndexSpec, existing existingIndex) ([]string, error) {
+	differences := make([]string, 0)
+
+	keysMatch, err := documentsEqual(spec.Keys, existing.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keysMatch || !keyOrderEqual(spec.Keys, existing.Keys) {
+		differences = append(differences, fmt.Sprintf("keys: want %v, have %v", spec.Keys, existing.Keys))
+	}
+
+	if spec.Unique != existing.Unique {
+		differences = append(differences, fmt.Sprintf("unique: want %t, have %t", spec.Unique, existing.Unique))
+	}
+
+	if spec.Sparse != existing.Sparse {
+		differences = append(differences, fmt.Sprintf("sparse: want %t, have %t", spec.Sparse, existing.Sparse))
+	}
+
+	// "none" stands for an index that is not a TTL index, which differs from expireAfterSeconds: 0
+	wantTTL, haveTTL := "none", "none"
+	if spec.isTTL() {
+		wantTTL = strconv.Itoa(int(spec.expireAfterSeconds()))
+	}
+
+	if existing.ExpireAfterSeconds != nil {
+		haveTTL = strconv.Itoa(int(*existing.ExpireAfterSeconds))
+	}
+
+	if wantTTL != haveTTL {
+		differences = append(differences, fmt.Sprintf("expireAfterSeconds: want %s, have %s", wantTTL, haveTTL))
+	}
+
+	var wantFilter any
+	if len(spec.PartialFilter) > 0 {
+		wantFilter = spec.PartialFilter
+	}
+
+	filterMatches, err := documentsEqual(wantFilter, existing.PartialFilterExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	if !filterMatches {
+		differences = append(differences, "partialFilterExpression differs")
+	}
+
+	return differences, nil
+}
+
+// documentsEqual compares two documents after a bson round trip so that equivalent documents with
+// different Go representations (bson.M vs bson.D, int vs int32 vs float64) compare as equal. Key
+// order is ignored; use keyOrderEqual where it matters.
+func documentsEqual(want, have any) (bool, error) {
+	wantDoc, err := toDocument(want)
+	if err != nil {
+		return false, err
+	}
+
+	haveDoc, err := toDocument(have)
+	if err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(normalizeValue(wantDoc), normalizeValue(haveDoc)), nil
+}
+
+// keyOrderEqual returns true if both documents list the same keys in the same order
+func keyOrderEqual(want, have bson.D) bool {
+	if len(want) != len(have) {
+		return false
+	}
+
+	for i := range want {
+		if want[i].Key != have[i].Key {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toDocument converts an arbitrary document into a bson.D, returning nil for empty documents
+func toDocument(value any) (bson.D, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	var raw bson.Raw
+	switch v := value.(type) {
+	case bson.Raw:
+		raw = v
+	default:
+		bytes, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		raw = bytes
+	}
+
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+
+	return doc, nil
+}
+
+// normalizeValue converts numbers to float64 and nested documents to maps so they can be compared
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case bson.D:
+		if v == nil {
+			return nil
+		}
+
+		values := make(map[string]any, len(v))
+		for _, element := range v {
+			values[element.Key] = normalizeValue(element.Value)
+		}
+
+		return values
+	case primitive.A:
+		out := make([]any, 0, len(v))
+		for _, element := range v {
+			out = append(out, normalizeValue(element))
+		}
+
+		return out
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,277 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexSpec_name(t *testing.T) {
+	tests := []struct {
+		name string
+		i    IndexSpec
+		want string
+	}{
+		{
+			name: "explicit name",
+			i:    IndexSpec{Name: "email_unique", Keys: bson.D{{Key: "email", Value: 1}}},
+			want: "email_unique",
+		},
+		{
+			name: "generated single key name",
+			i:    IndexSpec{Keys: bson.D{{Key: "email", Value: 1}}},
+			want: "email_1",
+		},
+		{
+			name: "generated compound key name",
+			i:    IndexSpec{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			want: "user_id_1_created_at_-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.i.name(); got != tt.want {
+				t.Errorf("IndexSpec.name() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectionSpec_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       CollectionSpec
+		wantErr bool
+	}{
+		{
+			name: "valid spec",
+			s: CollectionSpec{
+				Name: "users",
+				Indexes: []IndexSpec{
+					{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+					{Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: time.Hour},
+				},
+			},
+		},
+		{
+			name:    "missing name",
+			s:       CollectionSpec{},
+			wantErr: true,
+		},
+		{
+			name:    "missing index keys",
+			s:       CollectionSpec{Name: "users", Indexes: []IndexSpec{{Name: "empty"}}},
+			wantErr: true,
+		},
+		{
+			name: "zero TTL index",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true},
+			}},
+		},
+		{
+			name: "TTL below one second",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: 500 * time.Millisecond},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "negative TTL",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true, ExpireAfter: -time.Second},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "compound zero TTL index",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}}, TTL: true},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "compound TTL index",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}}, ExpireAfter: time.Hour},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate index names",
+			s: CollectionSpec{Name: "users", Indexes: []IndexSpec{
+				{Keys: bson.D{{Key: "email", Value: 1}}},
+				{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CollectionSpec.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_diffIndex(t *testing.T) {
+	ttl := int32(3600)
+	zero := int32(0)
+	partial, _ := bson.Marshal(bson.D{{Key: "deleted", Value: false}})
+
+	tests := []struct {
+		name     string
+		spec     IndexSpec
+		existing existingIndex
+		wantDiff int
+	}{
+		{
+			name:     "identical index with different integer types",
+			spec:     IndexSpec{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+			existing: existingIndex{Name: "email_1", Keys: bson.D{{Key: "email", Value: int32(1)}}, Unique: true},
+			wantDiff: 0,
+		},
+		{
+			name:     "unique drift",
+			spec:     IndexSpec{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+			existing: existingIndex{Name: "email_1", Keys: bson.D{{Key: "email", Value: int32(1)}}},
+			wantDiff: 1,
+		},
+		{
+			name:     "compound key order drift",
+			spec:     IndexSpec{Name: "compound", Keys: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}}},
+			existing: existingIndex{Name: "compound", Keys: bson.D{{Key: "b", Value: int32(1)}, {Key: "a", Value: int32(1)}}},
+			wantDiff: 1,
+		},
+		{
+			name:     "matching TTL",
+			spec:     IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: time.Hour},
+			existing: existingIndex{Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: int32(1)}}, ExpireAfterSeconds: &ttl},
+			wantDiff: 0,
+		},
+		{
+			name:     "matching zero TTL",
+			spec:     IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true},
+			existing: existingIndex{Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: int32(1)}}, ExpireAfterSeconds: &zero},
+			wantDiff: 0,
+		},
+		{
+			name:     "zero TTL drift",
+			spec:     IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true},
+			existing: existingIndex{Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: int32(1)}}},
+			wantDiff: 1,
+		},
+		{
+			name:     "TTL and partial filter drift",
+			spec:     IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+			existing: existingIndex{Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: int32(1)}}, ExpireAfterSeconds: &ttl, PartialFilterExpression: partial},
+			wantDiff: 2,
+		},
+		{
+			name:     "matching partial filter",
+			spec:     IndexSpec{Keys: bson.D{{Key: "email", Value: 1}}, PartialFilter: bson.D{{Key: "deleted", Value: false}}},
+			existing: existingIndex{Name: "email_1", Keys: bson.D{{Key: "email", Value: int32(1)}}, PartialFilterExpression: partial},
+			wantDiff: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diffIndex(tt.spec, tt.existing)
+			if err != nil {
+				t.Fatalf("diffIndex() error = %v", err)
+			}
+			if len(got) != tt.wantDiff {
+				t.Errorf("diffIndex() = %v, want %d differences", got, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestIndexSpec_model(t *testing.T) {
+	seconds := func(n int32) *int32 { return &n }
+	tests := []struct {
+		name string
+		spec IndexSpec
+		want *int32
+	}{
+		{name: "regular index", spec: IndexSpec{Keys: bson.D{{Key: "email", Value: 1}}}},
+		{name: "TTL index", spec: IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: time.Hour}, want: seconds(3600)},
+		{name: "zero TTL index", spec: IndexSpec{Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true}, want: seconds(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.model().Options.ExpireAfterSeconds; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IndexSpec.model() expireAfterSeconds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_documentsEqual(t *testing.T) {
+	raw, _ := bson.Marshal(bson.D{
+		{Key: "$jsonSchema", Value: bson.D{
+			{Key: "bsonType", Value: "object"},
+			{Key: "required", Value: bson.A{"email"}},
+		}},
+	})
+
+	tests := []struct {
+		name string
+		want any
+		have any
+		eq   bool
+	}{
+		{
+			name: "map and raw document with different key order",
+			want: bson.M{"$jsonSchema": bson.M{"required": bson.A{"email"}, "bsonType": "object"}},
+			have: bson.Raw(raw),
+			eq:   true,
+		},
+		{
+			name: "different values",
+			want: bson.M{"$jsonSchema": bson.M{"bsonType": "array"}},
+			have: bson.Raw(raw),
+			eq:   false,
+		},
+		{
+			name: "nil and empty document",
+			want: nil,
+			have: bson.Raw(nil),
+			eq:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := documentsEqual(tt.want, tt.have)
+			if err != nil {
+				t.Fatalf("documentsEqual() error = %v", err)
+			}
+			if got != tt.eq {
+				t.Errorf("documentsEqual() = %v, want %v", got, tt.eq)
+			}
+		})
+	}
+}
+
+func TestClient_collectionSpecs(t *testing.T) {
+	c := &Client{
+		CollectionNames: []string{"users", "accounts"},
+		CollectionSpecs: []CollectionSpec{{Name: "users", JSONSchema: bson.M{"bsonType": "object"}}},
+	}
+
+	specs := c.collectionSpecs()
+	if len(specs) != 2 {
+		t.Fatalf("Client.collectionSpecs() returned %d specs, want 2", len(specs))
+	}
+
+	if specs[0].Name != "users" || specs[0].JSONSchema == nil {
+		t.Errorf("Client.collectionSpecs() did not prefer the declarative spec for users: %+v", specs[0])
+	}
+
+	if specs[1].Name != "accounts" {
+		t.Errorf("Client.collectionSpecs()[1].Name = %s, want accounts", specs[1].Name)
+	}
+}
@@ -10,35 +10,71 @@ import (
 // MongoTx is a type alias for the `WithTransaction` method of the `mongo.Session` object.
 type MongoTx func(sessCtx mongo.SessionContext) (any, error)
 
-// ComplexTransaction is a wrapper around the `WithTransaction` method of the `mongo.Session` object.
+// ComplexTransaction runs the callback in a transaction and returns its result. The whole transaction
+// is retried on TransientTransactionError and the commit is retried on UnknownTransactionCommitResult,
+// within the MaxRetriesPerOperation and RetryTimeOut bounds of the client. The callback may therefore
+// run more than once.
 func (c *Client) ComplexTransaction(ctx context.Context, callback MongoTx) (any, error) {
-	session, err := c.Conn.StartSession()
+	res, err := c.runTransaction(ctx, callback)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating session | %s", err.Error())
+		return nil, err
 	}
 
-	defer session.EndSession(ctx)
+	return res, nil
+}
 
-	res, err := session.WithTransaction(ctx, callback)
-	if err != nil {
-		return nil, fmt.Errorf("failed executing transaction | %s", err.Error())
+// StandardTransaction runs the callback in a transaction with the same retry semantics as
+// ComplexTransaction, discarding the callback result.
+func (c *Client) StandardTransaction(ctx context.Context, callback MongoTx) error {
+	if _, err := c.runTransaction(ctx, callback); err != nil {
+		return err
 	}
 
-	return res, nil
+	return nil
 }
 
-// StandardTransaction is a wrapper around the `WithTransaction` method of the `mongo.Session` object.
-func (c *Client) StandardTransaction(ctx context.Context, callback MongoTx) error {
+// runTransaction executes the callback in a session following the driver guidance for retrying
+// transactions and commits
+func (c *Client) runTransaction(ctx context.Context, callback MongoTx) (any, error) {
 	session, err := c.Conn.StartSession()
 	if err != nil {
-		return fmt.Errorf("failed creating session | %s", err.Error())
+		return nil, fmt.Errorf("failed creating session | %s", err.Error())
 	}
 
 	defer session.EndSession(ctx)
 
-	if _, err = session.WithTransaction(ctx, callback); err != nil {
-		return fmt.Errorf("failed executing transaction | %s", err.Error())
+	policy := c.retryPolicy()
+	sessCtx := mongo.NewSessionContext(ctx, session)
+	commitAttempts := 0
+
+	var res any
+	attempts, err := policy.do(ctx,
+		func(err error) bool { return ClassifyError(err) == ErrorClassTransientTransaction },
+		func(int) error {
+			if err := session.StartTransaction(); err != nil {
+				return err
+			}
+
+			res, err = callback(sessCtx)
+			if err != nil {
+				_ = session.AbortTransaction(context.WithoutCancel(ctx))
+				return err
+			}
+
+			attempts, err := policy.do(ctx,
+				func(err error) bool { return ClassifyError(err) == ErrorClassUnknownCommitResult },
+				func(int) error { return session.CommitTransaction(sessCtx) })
+			commitAttempts += attempts
+
+			return err
+		})
+
+	c.recordAttempts(ctx, "transaction", attempts, err)
+	c.recordAttempts(ctx, "transaction_commit", commitAttempts, err)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed executing transaction after %d attempt(s) | %s", attempts, err.Error())
 	}
 
-	return nil
+	return res, nil
 }
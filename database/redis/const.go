@@ -30,4 +30,10 @@ const (
 	// value `"txn.redis.write-to-cache-with-ttl"`. This constant is used as a transaction name or identifier for
 	// write operations on a Redis cache with a TTL in a larger codebase.
 	RedisWriteToCacheWithTTLTxn DatastoreTxName = "txn.redis.write-to-cache-with-ttl"
+	// `RedisWriteIfNotExistsTxn` is used as a transaction name or identifier for conditional write
+	// operations on a Redis cache that only succeed when the key does not exist.
+	RedisWriteIfNotExistsTxn DatastoreTxName = "txn.redis.write-if-not-exists"
+	// `RedisDeleteIfEqualsTxn` is used as a transaction name or identifier for conditional delete
+	// operations on a Redis cache that only succeed when the key holds the expected value.
+	RedisDeleteIfEqualsTxn DatastoreTxName = "txn.redis.delete-if-equals"
 )
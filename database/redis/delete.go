@@ -3,8 +3,19 @@ package redis // import "github.com/SolomonAIEngineering/backend-core-library/da
 import (
 	"context"
 	"fmt"
+
+	"github.com/gomodule/redigo/redis"
 )
 
+// deleteIfEqualsScript deletes the key only if it holds the expected value, so that the value cannot
+// change between the comparison and the deletion
+var deleteIfEqualsScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 // Delete deletes a value from the cache
 func (c *Client) Delete(ctx context.Context, key string) error {
 	txn := c.telemetrySdk.GetTraceFromContext(ctx)
@@ -24,3 +35,25 @@ func (c *Client) Delete(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// DeleteIfEquals deletes a value from the cache only if it still holds value, such as the token of
+// the owner of a lock. It returns true if the value was deleted.
+func (c *Client) DeleteIfEquals(ctx context.Context, key string, value []byte) (bool, error) {
+	txn := c.telemetrySdk.GetTraceFromContext(ctx)
+	span := c.telemetrySdk.StartRedisDatastoreSegment(txn, RedisDeleteIfEqualsTxn.String())
+	defer span.End()
+
+	// validate the key
+	if key == "" {
+		return false, fmt.Errorf("empty key")
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+	deleted, err := redis.Int(deleteIfEqualsScript.Do(conn, key, string(value)))
+	if err != nil {
+		return false, err
+	}
+
+	return deleted == 1, nil
+}
@@ -40,3 +40,45 @@ func TestClient_Delete(t *testing.T) {
 	_, err = client.Get(ctx, "test-key")
 	require.Error(t, err)
 }
+
+func TestClient_DeleteIfEquals(t *testing.T) {
+	ctx := context.Background()
+
+	redisTestServer := miniredis.RunT(t)
+	defer redisTestServer.Close()
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", fmt.Sprintf(":%s", redisTestServer.Port()))
+		},
+	}
+
+	client := &Client{
+		pool:              pool,
+		cacheTTLInSeconds: 60,
+	}
+
+	require.NoError(t, client.Write(ctx, "lock", []byte("owner-1")))
+
+	// another owner cannot delete the key
+	deleted, err := client.DeleteIfEquals(ctx, "lock", []byte("owner-2"))
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	value, err := client.Get(ctx, "lock")
+	require.NoError(t, err)
+	require.Equal(t, "owner-1", string(value))
+
+	deleted, err = client.DeleteIfEquals(ctx, "lock", []byte("owner-1"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	require.False(t, redisTestServer.Exists("lock"))
+
+	// a missing key is not deleted
+	deleted, err = client.DeleteIfEquals(ctx, "lock", []byte("owner-1"))
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	_, err = client.DeleteIfEquals(ctx, "", []byte("owner-1"))
+	require.Error(t, err)
+}
@@ -28,6 +28,32 @@ func (c *Client) WriteWithTTL(ctx context.Context, key string, value []byte, cac
 	return nil
 }
 
+// WriteIfNotExists writes a value to the cache with time to live only if the key does not exist. It
+// returns true if the value was written.
+func (c *Client) WriteIfNotExists(ctx context.Context, key string, value []byte, cacheTTLInSeconds int) (bool, error) {
+	txn := c.telemetrySdk.GetTraceFromContext(ctx)
+	span := c.telemetrySdk.StartRedisDatastoreSegment(txn, RedisWriteIfNotExistsTxn.String())
+	defer span.End()
+
+	// validate the key
+	if key == "" {
+		return false, fmt.Errorf("empty key")
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+	reply, err := redis.String(conn.Do("SET", key, string(value), "NX", "EX", cacheTTLInSeconds))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return reply == "OK", nil
+}
+
 // WriteToCache writes a value to the cache
 func (c *Client) Write(ctx context.Context, key string, value []byte) error {
 	txn := c.telemetrySdk.GetTraceFromContext(ctx)
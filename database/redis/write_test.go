@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/gomodule/redigo/redis"
@@ -136,3 +137,43 @@ func getMapKeys(m map[string]interface{}) []string {
 	}
 	return keys
 }
+
+func TestClient_WriteIfNotExists(t *testing.T) {
+	redisTestServer := miniredis.RunT(t)
+	defer redisTestServer.Close()
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", fmt.Sprintf(":%s", redisTestServer.Port()))
+		},
+	}
+
+	client := &Client{
+		pool:              pool,
+		cacheTTLInSeconds: 60,
+	}
+
+	ctx := context.Background()
+
+	written, err := client.WriteIfNotExists(ctx, "lock", []byte("first"), 30)
+	require.NoError(t, err)
+	assert.True(t, written)
+
+	written, err = client.WriteIfNotExists(ctx, "lock", []byte("second"), 30)
+	require.NoError(t, err)
+	assert.False(t, written)
+
+	value, err := client.Get(ctx, "lock")
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(value))
+	assert.Equal(t, 30*time.Second, redisTestServer.TTL("lock"))
+
+	// the key can be written again once it expires
+	redisTestServer.FastForward(31 * time.Second)
+	written, err = client.WriteIfNotExists(ctx, "lock", []byte("third"), 30)
+	require.NoError(t, err)
+	assert.True(t, written)
+
+	_, err = client.WriteIfNotExists(ctx, "", []byte("value"), 30)
+	assert.Error(t, err)
+}
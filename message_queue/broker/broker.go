@@ -0,0 +1,84 @@
+package broker // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidReceiptHandle is returned when acknowledging a delivery whose receipt handle expired,
+	// for example because the message was redelivered after its visibility timeout
+	ErrInvalidReceiptHandle = errors.New("invalid receipt handle")
+	// ErrMissingGroupID is returned when publishing to a FIFO queue without a message group id
+	ErrMissingGroupID = errors.New("message group id is required for FIFO queues")
+	// ErrMissingDeduplicationID is returned when publishing to a FIFO queue without a deduplication id
+	// and without content based deduplication
+	ErrMissingDeduplicationID = errors.New("deduplication id is required for FIFO queues without content based deduplication")
+)
+
+// Message is a message published to a queue
+type Message struct {
+	// ID is the id assigned by the broker, set on delivered messages
+	ID string
+	// Body is the message body
+	Body string
+	// Attributes are the message attributes. Binary attributes hold the raw bytes of their value.
+	Attributes map[string]string
+	// AttributeTypes holds the data type of the attributes that are not strings, such as "Number"
+	// or "Binary". Attributes missing from it are strings.
+	AttributeTypes map[string]string
+	// GroupID is the message group id, required for FIFO queues
+	GroupID string
+	// DeduplicationID is the deduplication id for FIFO queues
+	DeduplicationID string
+	// Delay postpones the first delivery of the message
+	Delay time.Duration
+}
+
+// Delivery is a message received from a queue. It stays invisible to other receivers until it is
+// acknowledged, negatively acknowledged, or its visibility timeout expires.
+type Delivery struct {
+	Message
+	// Queue is the queue the message was received from
+	Queue string
+	// ReceiptHandle identifies this delivery of the message
+	ReceiptHandle string
+	// ReceiveCount is the number of times the message was delivered, including this delivery
+	ReceiveCount int
+	// SystemAttributes holds the attributes the broker sets on the delivery, such as the
+	// SentTimestamp of SQS messages
+	SystemAttributes map[string]string
+}
+
+// ReceiveOptions configures a receive call
+type ReceiveOptions struct {
+	// MaxMessages is the maximum number of messages returned
+	MaxMessages int
+	// WaitTime is how long the call waits for messages when none is available
+	WaitTime time.Duration
+	// VisibilityTimeout is how long received messages stay invisible. Zero uses the queue default.
+	VisibilityTimeout time.Duration
+}
+
+// Publisher publishes messages to queues
+type Publisher interface {
+	// Publish sends the message to the queue and returns its id
+	Publish(ctx context.Context, queue string, msg Message) (string, error)
+}
+
+// Subscriber receives messages from queues
+type Subscriber interface {
+	// Receive returns up to MaxMessages visible messages, waiting up to WaitTime for one
+	Receive(ctx context.Context, queue string, opts ReceiveOptions) ([]*Delivery, error)
+	// Ack deletes a processed message from its queue
+	Ack(ctx context.Context, delivery *Delivery) error
+	// Nack makes the message visible again after delay so that it is redelivered
+	Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error
+}
+
+// Broker publishes and receives messages
+type Broker interface {
+	Publisher
+	Subscriber
+}
@@ -0,0 +1,7 @@
+/*
+Package broker defines a broker-neutral interface to publish and consume messages, with an SQS
+adapter and an in-memory broker. The in-memory broker honors visibility timeouts, redelivery,
+dead-letter queues and FIFO message groups, so services can run realistic end-to-end tests without
+network access.
+*/
+package broker // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
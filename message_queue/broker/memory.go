@@ -0,0 +1,356 @@
+package broker // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+)
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	// deduplicationInterval is the interval during which FIFO queues drop duplicate messages
+	deduplicationInterval = 5 * time.Minute
+)
+
+// QueueConfig configures a queue of the in-memory broker
+type QueueConfig struct {
+	// VisibilityTimeout is the default visibility timeout of received messages. Defaults to 30s.
+	VisibilityTimeout time.Duration
+	// MaxReceiveCount is the number of deliveries after which a message is moved to the dead-letter
+	// queue. Zero disables the dead-letter queue.
+	MaxReceiveCount int
+	// DeadLetterQueue is the name of the dead-letter queue
+	DeadLetterQueue string
+	// FIFO delivers messages of the same group in order, one batch at a time, and deduplicates them
+	FIFO bool
+	// ContentBasedDeduplication derives deduplication ids from the message body on FIFO queues
+	ContentBasedDeduplication bool
+}
+
+// MemoryBroker is an in-memory Broker. Queues are created on first use with the default
+// configuration, FIFO for names ending with .fifo, unless they were created with CreateQueue.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	nextID int64
+	// changed is closed and replaced whenever messages may have become available
+	changed chan struct{}
+}
+
+type memoryQueue struct {
+	config   QueueConfig
+	messages []*memoryMessage
+	// deduplicated holds the message id and send time by deduplication id
+	deduplicated map[string]deduplicatedMessage
+}
+
+type deduplicatedMessage struct {
+	id     string
+	sentAt time.Time
+}
+
+type memoryMessage struct {
+	Message
+	visibleAt    time.Time
+	receiveCount int
+	receipt      string
+}
+
+// NewMemoryBroker creates an empty in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:  make(map[string]*memoryQueue),
+		changed: make(chan struct{}),
+	}
+}
+
+// CreateQueue creates a queue, or updates the configuration of an existing one
+func (b *MemoryBroker) CreateQueue(name string, config QueueConfig) error {
+	if config.MaxReceiveCount > 0 && config.DeadLetterQueue == "" {
+		return fmt.Errorf("queue %s: a dead-letter queue is required with a max receive count", name)
+	}
+
+	if config.DeadLetterQueue == name {
+		return fmt.Errorf("queue %s: a queue cannot be its own dead-letter queue", name)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queue(name).config = withDefaults(config)
+	return nil
+}
+
+// Publish implements Publisher
+func (b *MemoryBroker) Publish(ctx context.Context, queue string, msg Message) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(queue)
+	now := time.Now()
+
+	if q.config.FIFO {
+		if msg.GroupID == "" {
+			return "", ErrMissingGroupID
+		}
+
+		if msg.DeduplicationID == "" {
+			if !q.config.ContentBasedDeduplication {
+				return "", ErrMissingDeduplicationID
+			}
+			msg.DeduplicationID = fifo.ContentDeduplicationID(msg.Body, nil)
+		}
+
+		if previous, ok := q.deduplicated[msg.DeduplicationID]; ok && now.Sub(previous.sentAt) < deduplicationInterval {
+			return previous.id, nil
+		}
+	}
+
+	b.nextID++
+	msg.ID = fmt.Sprintf("memory-%d", b.nextID)
+	msg = copyMessage(msg)
+
+	q.messages = append(q.messages, &memoryMessage{Message: msg, visibleAt: now.Add(msg.Delay)})
+	if q.config.FIFO {
+		q.deduplicated[msg.DeduplicationID] = deduplicatedMessage{id: msg.ID, sentAt: now}
+	}
+
+	b.notifyLocked()
+	return msg.ID, nil
+}
+
+// Receive implements Subscriber
+func (b *MemoryBroker) Receive(ctx context.Context, queue string, opts ReceiveOptions) ([]*Delivery, error) {
+	if opts.MaxMessages <= 0 {
+		opts.MaxMessages = 1
+	}
+
+	deadline := time.Now().Add(opts.WaitTime)
+
+	for {
+		b.mu.Lock()
+		deliveries, nextVisible := b.receiveLocked(queue, opts)
+		changed := b.changed
+		b.mu.Unlock()
+
+		if len(deliveries) > 0 {
+			return deliveries, nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+
+		if !nextVisible.IsZero() && time.Until(nextVisible) < wait {
+			wait = time.Until(nextVisible)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// receiveLocked delivers up to MaxMessages visible messages. It also returns the time the next
+// invisible message becomes visible, if any. It must be called with the lock held.
+func (b *MemoryBroker) receiveLocked(queue string, opts ReceiveOptions) ([]*Delivery, time.Time) {
+	q := b.queue(queue)
+	now := time.Now()
+
+	visibility := opts.VisibilityTimeout
+	if visibility <= 0 {
+		visibility = q.config.VisibilityTimeout
+	}
+
+	// groups with messages in flight or delayed are blocked to keep FIFO order
+	blocked := make(map[string]bool)
+	if q.config.FIFO {
+		for _, m := range q.messages {
+			if m.visibleAt.After(now) {
+				blocked[m.GroupID] = true
+			}
+		}
+	}
+
+	var (
+		deliveries  []*Delivery
+		nextVisible time.Time
+	)
+
+	for i := 0; i < len(q.messages) && len(deliveries) < opts.MaxMessages; {
+		m := q.messages[i]
+
+		if m.visibleAt.After(now) {
+			if nextVisible.IsZero() || m.visibleAt.Before(nextVisible) {
+				nextVisible = m.visibleAt
+			}
+			i++
+			continue
+		}
+
+		if q.config.FIFO && blocked[m.GroupID] {
+			i++
+			continue
+		}
+
+		if q.config.MaxReceiveCount > 0 && m.receiveCount >= q.config.MaxReceiveCount {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			b.moveToDeadLetterQueueLocked(q, m)
+			continue
+		}
+
+		m.receiveCount++
+		m.visibleAt = now.Add(visibility)
+		m.receipt = fmt.Sprintf("%s-%d", m.ID, m.receiveCount)
+
+		deliveries = append(deliveries, &Delivery{
+			Message:       copyMessage(m.Message),
+			Queue:         queue,
+			ReceiptHandle: m.receipt,
+			ReceiveCount:  m.receiveCount,
+		})
+		i++
+	}
+
+	return deliveries, nextVisible
+}
+
+// moveToDeadLetterQueueLocked appends the message to the dead-letter queue of q
+func (b *MemoryBroker) moveToDeadLetterQueueLocked(q *memoryQueue, m *memoryMessage) {
+	dlq := b.queue(q.config.DeadLetterQueue)
+
+	m.receiveCount = 0
+	m.receipt = ""
+	m.visibleAt = time.Now()
+	dlq.messages = append(dlq.messages, m)
+}
+
+// Ack implements Subscriber
+func (b *MemoryBroker) Ack(ctx context.Context, delivery *Delivery) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(delivery.Queue)
+	i, ok := q.find(delivery.ReceiptHandle)
+	if !ok {
+		return ErrInvalidReceiptHandle
+	}
+
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	b.notifyLocked()
+	return nil
+}
+
+// Nack implements Subscriber
+func (b *MemoryBroker) Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(delivery.Queue)
+	i, ok := q.find(delivery.ReceiptHandle)
+	if !ok {
+		return ErrInvalidReceiptHandle
+	}
+
+	q.messages[i].visibleAt = time.Now().Add(delay)
+	b.notifyLocked()
+	return nil
+}
+
+// Len returns the number of messages in the queue, visible or not
+func (b *MemoryBroker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.queue(queue).messages)
+}
+
+// Messages returns a copy of the messages in the queue, in order, without delivering them
+func (b *MemoryBroker) Messages(queue string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(queue)
+	messages := make([]Message, 0, len(q.messages))
+	for _, m := range q.messages {
+		messages = append(messages, copyMessage(m.Message))
+	}
+
+	return messages
+}
+
+// queue returns the queue, creating it with the default configuration if needed. It must be called
+// with the lock held.
+func (b *MemoryBroker) queue(name string) *memoryQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			config:       withDefaults(QueueConfig{FIFO: strings.HasSuffix(name, ".fifo")}),
+			deduplicated: make(map[string]deduplicatedMessage),
+		}
+		b.queues[name] = q
+	}
+
+	return q
+}
+
+// notifyLocked wakes up waiting receivers. It must be called with the lock held.
+func (b *MemoryBroker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// find returns the index of the in-flight message with the receipt handle
+func (q *memoryQueue) find(receipt string) (int, bool) {
+	now := time.Now()
+	for i, m := range q.messages {
+		if m.receipt == receipt && receipt != "" && m.visibleAt.After(now) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// withDefaults fills in the unset queue configuration values
+func withDefaults(config QueueConfig) QueueConfig {
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = defaultVisibilityTimeout
+	}
+
+	return config
+}
+
+// copyMessage returns a copy of the message that does not share its attributes
+func copyMessage(msg Message) Message {
+	msg.Attributes = copyAttributes(msg.Attributes)
+	msg.AttributeTypes = copyAttributes(msg.AttributeTypes)
+	return msg
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+// Static check to ensure MemoryBroker implements the Broker interface.
+var _ Broker = (*MemoryBroker)(nil)
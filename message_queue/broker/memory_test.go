@@ -0,0 +1,158 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, b *MemoryBroker, queue string, opts ReceiveOptions) []*Delivery {
+	t.Helper()
+
+	deliveries, err := b.Receive(context.Background(), queue, opts)
+	require.NoError(t, err)
+	return deliveries
+}
+
+func TestMemoryBrokerVisibilityTimeout(t *testing.T) {
+	b := NewMemoryBroker()
+	ctx := context.Background()
+
+	id, err := b.Publish(ctx, "orders", Message{Body: "order-1", Attributes: map[string]string{"k": "v"}})
+	require.NoError(t, err)
+
+	deliveries := receive(t, b, "orders", ReceiveOptions{MaxMessages: 10, VisibilityTimeout: 30 * time.Millisecond})
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, id, deliveries[0].ID)
+	assert.Equal(t, "v", deliveries[0].Attributes["k"])
+	assert.Equal(t, 1, deliveries[0].ReceiveCount)
+
+	// invisible while in flight
+	assert.Empty(t, receive(t, b, "orders", ReceiveOptions{MaxMessages: 10}))
+
+	// redelivered once the visibility timeout expires, with a new receipt handle
+	redelivered := receive(t, b, "orders", ReceiveOptions{MaxMessages: 10, WaitTime: time.Second})
+	require.Len(t, redelivered, 1)
+	assert.Equal(t, 2, redelivered[0].ReceiveCount)
+	assert.NotEqual(t, deliveries[0].ReceiptHandle, redelivered[0].ReceiptHandle)
+
+	assert.ErrorIs(t, b.Ack(ctx, deliveries[0]), ErrInvalidReceiptHandle)
+	require.NoError(t, b.Ack(ctx, redelivered[0]))
+	assert.Equal(t, 0, b.Len("orders"))
+}
+
+func TestMemoryBrokerNackAndDelay(t *testing.T) {
+	b := NewMemoryBroker()
+	ctx := context.Background()
+
+	_, err := b.Publish(ctx, "orders", Message{Body: "delayed", Delay: 20 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Empty(t, receive(t, b, "orders", ReceiveOptions{MaxMessages: 1}))
+
+	deliveries := receive(t, b, "orders", ReceiveOptions{MaxMessages: 1, WaitTime: time.Second})
+	require.Len(t, deliveries, 1)
+
+	require.NoError(t, b.Nack(ctx, deliveries[0], 0))
+	deliveries = receive(t, b, "orders", ReceiveOptions{MaxMessages: 1})
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 2, deliveries[0].ReceiveCount)
+}
+
+func TestMemoryBrokerDeadLetterQueue(t *testing.T) {
+	b := NewMemoryBroker()
+	ctx := context.Background()
+
+	require.Error(t, b.CreateQueue("orders", QueueConfig{MaxReceiveCount: 2}))
+	require.NoError(t, b.CreateQueue("orders", QueueConfig{MaxReceiveCount: 2, DeadLetterQueue: "orders-dlq"}))
+
+	_, err := b.Publish(ctx, "orders", Message{Body: "poison"})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		deliveries := receive(t, b, "orders", ReceiveOptions{MaxMessages: 1})
+		require.Len(t, deliveries, 1)
+		require.NoError(t, b.Nack(ctx, deliveries[0], 0))
+	}
+
+	assert.Empty(t, receive(t, b, "orders", ReceiveOptions{MaxMessages: 1}))
+	assert.Equal(t, 0, b.Len("orders"))
+
+	dead := b.Messages("orders-dlq")
+	require.Len(t, dead, 1)
+	assert.Equal(t, "poison", dead[0].Body)
+
+	deliveries := receive(t, b, "orders-dlq", ReceiveOptions{MaxMessages: 1})
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 1, deliveries[0].ReceiveCount)
+}
+
+func TestMemoryBrokerFIFO(t *testing.T) {
+	b := NewMemoryBroker()
+	ctx := context.Background()
+
+	_, err := b.Publish(ctx, "orders.fifo", Message{Body: "no-group"})
+	assert.ErrorIs(t, err, ErrMissingGroupID)
+	_, err = b.Publish(ctx, "orders.fifo", Message{Body: "no-dedup", GroupID: "a"})
+	assert.ErrorIs(t, err, ErrMissingDeduplicationID)
+
+	publish := func(body, group string) string {
+		id, err := b.Publish(ctx, "orders.fifo", Message{Body: body, GroupID: group, DeduplicationID: body})
+		require.NoError(t, err)
+		return id
+	}
+
+	first := publish("a1", "a")
+	publish("a2", "a")
+	publish("b1", "b")
+	assert.Equal(t, first, publish("a1", "a"), "duplicates are dropped")
+	assert.Equal(t, 3, b.Len("orders.fifo"))
+
+	deliveries := receive(t, b, "orders.fifo", ReceiveOptions{MaxMessages: 1})
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "a1", deliveries[0].Body)
+
+	// group a is blocked while a1 is in flight
+	blocked := receive(t, b, "orders.fifo", ReceiveOptions{MaxMessages: 10})
+	require.Len(t, blocked, 1)
+	assert.Equal(t, "b1", blocked[0].Body)
+
+	require.NoError(t, b.Ack(ctx, deliveries[0]))
+	next := receive(t, b, "orders.fifo", ReceiveOptions{MaxMessages: 10})
+	require.Len(t, next, 1)
+	assert.Equal(t, "a2", next[0].Body)
+}
+
+func TestMemoryBrokerContentBasedDeduplication(t *testing.T) {
+	b := NewMemoryBroker()
+	ctx := context.Background()
+	require.NoError(t, b.CreateQueue("events.fifo", QueueConfig{FIFO: true, ContentBasedDeduplication: true}))
+
+	first, err := b.Publish(ctx, "events.fifo", Message{Body: "same", GroupID: "g"})
+	require.NoError(t, err)
+	second, err := b.Publish(ctx, "events.fifo", Message{Body: "same", GroupID: "g"})
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, b.Len("events.fifo"))
+}
+
+func TestMemoryBrokerReceiveWaitsForPublish(t *testing.T) {
+	b := NewMemoryBroker()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = b.Publish(context.Background(), "orders", Message{Body: "late"})
+	}()
+
+	deliveries := receive(t, b, "orders", ReceiveOptions{MaxMessages: 1, WaitTime: time.Second})
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "late", deliveries[0].Body)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := b.Receive(ctx, "orders", ReceiveOptions{MaxMessages: 1, WaitTime: time.Second})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,252 @@
+package broker // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// maxSQSMessages is the maximum number of messages SQS returns per receive call
+	maxSQSMessages = 10
+	// maxSQSWaitTime is the longest long polling wait SQS accepts
+	maxSQSWaitTime = 20 * time.Second
+
+	stringDataType = "String"
+	binaryDataType = "Binary"
+)
+
+// SQSAPI is the subset of the SQS client used by SQSBroker
+type SQSAPI interface {
+	SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error)
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// SQSBroker is a Broker backed by SQS. Queues are identified by their URL. Redelivery and
+// dead-letter queues are handled by SQS according to the redrive policy of the queue.
+type SQSBroker struct {
+	sqs SQSAPI
+}
+
+// NewSQSBroker creates a broker using the SQS client
+func NewSQSBroker(client SQSAPI) *SQSBroker {
+	return &SQSBroker{sqs: client}
+}
+
+// Publish implements Publisher
+func (b *SQSBroker) Publish(ctx context.Context, queue string, msg Message) (string, error) {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queue),
+		MessageBody:       aws.String(msg.Body),
+		MessageAttributes: toSQSAttributes(msg.Attributes, msg.AttributeTypes),
+	}
+
+	if msg.GroupID != "" {
+		input.MessageGroupId = aws.String(msg.GroupID)
+	}
+
+	if msg.DeduplicationID != "" {
+		input.MessageDeduplicationId = aws.String(msg.DeduplicationID)
+	}
+
+	if msg.Delay > 0 {
+		input.DelaySeconds = aws.Int64(int64(msg.Delay / time.Second))
+	}
+
+	output, err := b.sqs.SendMessageWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish message to %s | %s", queue, err.Error())
+	}
+
+	return aws.StringValue(output.MessageId), nil
+}
+
+// Receive implements Subscriber
+func (b *SQSBroker) Receive(ctx context.Context, queue string, opts ReceiveOptions) ([]*Delivery, error) {
+	maxMessages := opts.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 1
+	}
+	if maxMessages > maxSQSMessages {
+		maxMessages = maxSQSMessages
+	}
+
+	waitTime := opts.WaitTime
+	if waitTime > maxSQSWaitTime {
+		waitTime = maxSQSWaitTime
+	}
+
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queue),
+		MaxNumberOfMessages:   aws.Int64(int64(maxMessages)),
+		WaitTimeSeconds:       aws.Int64(int64(waitTime / time.Second)),
+		MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	}
+
+	if opts.VisibilityTimeout > 0 {
+		input.VisibilityTimeout = aws.Int64(int64(opts.VisibilityTimeout / time.Second))
+	}
+
+	output, err := b.sqs.ReceiveMessageWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive messages from %s | %s", queue, err.Error())
+	}
+
+	deliveries := make([]*Delivery, 0, len(output.Messages))
+	for _, message := range output.Messages {
+		deliveries = append(deliveries, fromSQSMessage(queue, message))
+	}
+
+	return deliveries, nil
+}
+
+// Ack implements Subscriber
+func (b *SQSBroker) Ack(ctx context.Context, delivery *Delivery) error {
+	_, err := b.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(delivery.Queue),
+		ReceiptHandle: aws.String(delivery.ReceiptHandle),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete message %s | %s", delivery.ID, err.Error())
+	}
+
+	return nil
+}
+
+// Nack implements Subscriber
+func (b *SQSBroker) Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error {
+	_, err := b.sqs.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(delivery.Queue),
+		ReceiptHandle:     aws.String(delivery.ReceiptHandle),
+		VisibilityTimeout: aws.Int64(int64(delay / time.Second)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change visibility of message %s | %s", delivery.ID, err.Error())
+	}
+
+	return nil
+}
+
+// fromSQSMessage converts a received SQS message to a delivery
+func fromSQSMessage(queue string, message *sqs.Message) *Delivery {
+	delivery := &Delivery{
+		Message: Message{
+			ID:              aws.StringValue(message.MessageId),
+			Body:            aws.StringValue(message.Body),
+			GroupID:         aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]),
+			DeduplicationID: aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]),
+		},
+		Queue:         queue,
+		ReceiptHandle: aws.StringValue(message.ReceiptHandle),
+		ReceiveCount:  1,
+	}
+
+	if count, err := strconv.Atoi(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount])); err == nil && count > 0 {
+		delivery.ReceiveCount = count
+	}
+
+	if len(message.Attributes) > 0 {
+		delivery.SystemAttributes = aws.StringValueMap(message.Attributes)
+	}
+
+	delivery.Attributes, delivery.AttributeTypes = fromSQSAttributes(message.MessageAttributes)
+
+	return delivery
+}
+
+// SQSMessage converts a delivery back to the SQS message it was received as, for code written
+// against the SQS message type
+func SQSMessage(delivery *Delivery) *sqs.Message {
+	message := &sqs.Message{
+		MessageId:         aws.String(delivery.ID),
+		Body:              aws.String(delivery.Body),
+		ReceiptHandle:     aws.String(delivery.ReceiptHandle),
+		MessageAttributes: toSQSAttributes(delivery.Attributes, delivery.AttributeTypes),
+		Attributes:        aws.StringMap(delivery.SystemAttributes),
+	}
+
+	// deliveries of other brokers carry these in their fields only
+	if _, ok := message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; !ok {
+		message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String(strconv.Itoa(delivery.ReceiveCount))
+	}
+	if _, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; !ok && delivery.GroupID != "" {
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(delivery.GroupID)
+	}
+	if _, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]; !ok && delivery.DeduplicationID != "" {
+		message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId] = aws.String(delivery.DeduplicationID)
+	}
+
+	return message
+}
+
+// fromSQSAttributes converts SQS message attributes to attribute values and the data types of the
+// attributes that are not strings
+func fromSQSAttributes(attributes map[string]*sqs.MessageAttributeValue) (map[string]string, map[string]string) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]string, len(attributes))
+	var types map[string]string
+	for name, attribute := range attributes {
+		dataType := aws.StringValue(attribute.DataType)
+		if isBinary(dataType) {
+			values[name] = string(attribute.BinaryValue)
+		} else {
+			values[name] = aws.StringValue(attribute.StringValue)
+		}
+
+		if dataType != "" && dataType != stringDataType {
+			if types == nil {
+				types = make(map[string]string)
+			}
+			types[name] = dataType
+		}
+	}
+
+	return values, types
+}
+
+// toSQSAttributes converts attribute values to SQS message attributes of their data type, String
+// unless set in types
+func toSQSAttributes(attributes, types map[string]string) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	converted := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		dataType := types[name]
+		if dataType == "" {
+			dataType = stringDataType
+		}
+
+		attribute := &sqs.MessageAttributeValue{DataType: aws.String(dataType)}
+		if isBinary(dataType) {
+			attribute.BinaryValue = []byte(value)
+		} else {
+			attribute.StringValue = aws.String(value)
+		}
+		converted[name] = attribute
+	}
+
+	return converted
+}
+
+// isBinary tells whether the data type, possibly with a custom label such as "Binary.gzip", holds
+// binary values
+func isBinary(dataType string) bool {
+	return dataType == binaryDataType || strings.HasPrefix(dataType, binaryDataType+".")
+}
+
+// Static check to ensure SQSBroker implements the Broker interface.
+var _ Broker = (*SQSBroker)(nil)
@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSQS struct {
+	sent       []*sqs.SendMessageInput
+	received   []*sqs.ReceiveMessageInput
+	messages   []*sqs.Message
+	deleted    []string
+	visibility map[string]int64
+	err        error
+}
+
+func (f *fakeSQS) SendMessageWithContext(_ aws.Context, input *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("sqs-1")}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(_ aws.Context, input *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.received = append(f.received, input)
+	return &sqs.ReceiveMessageOutput{Messages: f.messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(_ aws.Context, input *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(_ aws.Context, input *sqs.ChangeMessageVisibilityInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if f.visibility == nil {
+		f.visibility = make(map[string]int64)
+	}
+	f.visibility[aws.StringValue(input.ReceiptHandle)] = aws.Int64Value(input.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func TestSQSBrokerPublish(t *testing.T) {
+	fake := &fakeSQS{}
+	b := NewSQSBroker(fake)
+
+	id, err := b.Publish(context.Background(), "https://sqs/queue.fifo", Message{
+		Body:            "body",
+		Attributes:      map[string]string{"k": "v", "n": "42", "b": "\x00\x01"},
+		AttributeTypes:  map[string]string{"n": "Number", "b": "Binary"},
+		GroupID:         "g",
+		DeduplicationID: "d",
+		Delay:           3 * time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sqs-1", id)
+
+	require.Len(t, fake.sent, 1)
+	input := fake.sent[0]
+	assert.Equal(t, "https://sqs/queue.fifo", aws.StringValue(input.QueueUrl))
+	assert.Equal(t, "g", aws.StringValue(input.MessageGroupId))
+	assert.Equal(t, "d", aws.StringValue(input.MessageDeduplicationId))
+	assert.Equal(t, int64(3), aws.Int64Value(input.DelaySeconds))
+	assert.Equal(t, map[string]*sqs.MessageAttributeValue{
+		"k": {DataType: aws.String("String"), StringValue: aws.String("v")},
+		"n": {DataType: aws.String("Number"), StringValue: aws.String("42")},
+		"b": {DataType: aws.String("Binary"), BinaryValue: []byte{0, 1}},
+	}, input.MessageAttributes)
+
+	fake.err = errors.New("throttled")
+	_, err = b.Publish(context.Background(), "q", Message{Body: "body"})
+	assert.Error(t, err)
+}
+
+func TestSQSBrokerReceiveAckNack(t *testing.T) {
+	fake := &fakeSQS{messages: []*sqs.Message{{
+		MessageId:     aws.String("m-1"),
+		ReceiptHandle: aws.String("r-1"),
+		Body:          aws.String("body"),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3"),
+			sqs.MessageSystemAttributeNameMessageGroupId:          aws.String("g"),
+		},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"k": {DataType: aws.String("String"), StringValue: aws.String("v")},
+			"n": {DataType: aws.String("Number.int"), StringValue: aws.String("42")},
+			"b": {DataType: aws.String("Binary"), BinaryValue: []byte{0, 1}},
+		},
+	}}}
+	b := NewSQSBroker(fake)
+
+	deliveries, err := b.Receive(context.Background(), "q", ReceiveOptions{
+		MaxMessages:       50,
+		WaitTime:          time.Minute,
+		VisibilityTimeout: 45 * time.Second,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, fake.received, 1)
+	assert.Equal(t, int64(10), aws.Int64Value(fake.received[0].MaxNumberOfMessages))
+	assert.Equal(t, int64(20), aws.Int64Value(fake.received[0].WaitTimeSeconds))
+	assert.Equal(t, int64(45), aws.Int64Value(fake.received[0].VisibilityTimeout))
+
+	require.Len(t, deliveries, 1)
+	d := deliveries[0]
+	assert.Equal(t, "m-1", d.ID)
+	assert.Equal(t, "q", d.Queue)
+	assert.Equal(t, "r-1", d.ReceiptHandle)
+	assert.Equal(t, 3, d.ReceiveCount)
+	assert.Equal(t, "g", d.GroupID)
+	assert.Equal(t, map[string]string{"k": "v", "n": "42", "b": "\x00\x01"}, d.Attributes)
+	assert.Equal(t, map[string]string{"n": "Number.int", "b": "Binary"}, d.AttributeTypes)
+
+	require.NoError(t, b.Nack(context.Background(), d, 7*time.Second))
+	assert.Equal(t, int64(7), fake.visibility["r-1"])
+
+	require.NoError(t, b.Ack(context.Background(), d))
+	assert.Equal(t, []string{"r-1"}, fake.deleted)
+}
@@ -0,0 +1,282 @@
+package broker // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConcurrency  = 1
+	defaultMaxMessages  = 10
+	defaultWaitTime     = 20 * time.Second
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 5 * time.Minute
+	receiveErrorBackoff = time.Second
+)
+
+// Handler processes a delivered message. Returning an error leaves the message on the queue so that
+// it is redelivered, or moved to the dead-letter queue once its receive count is exhausted.
+type Handler func(ctx context.Context, delivery *Delivery) error
+
+// BackoffFunc returns how long a message that failed on its receiveCount-th delivery stays invisible
+type BackoffFunc func(receiveCount int) time.Duration
+
+// ExponentialBackoff returns a BackoffFunc doubling initial on every delivery, capped at max
+func ExponentialBackoff(initial, max time.Duration) BackoffFunc {
+	return func(receiveCount int) time.Duration {
+		backoff := initial
+		for i := 1; i < receiveCount && backoff < max; i++ {
+			backoff *= 2
+		}
+
+		if backoff > max {
+			return max
+		}
+
+		return backoff
+	}
+}
+
+// ReceiveErrorFunc is called when receiving messages fails and returns how long to wait before
+// receiving again
+type ReceiveErrorFunc func(err error) time.Duration
+
+type subscription struct {
+	concurrency       int
+	maxMessages       int
+	waitTime          time.Duration
+	visibilityTimeout time.Duration
+	heartbeat         bool
+	backoff           BackoffFunc
+	onReceiveError    ReceiveErrorFunc
+}
+
+// SubscribeOption configures Subscribe
+type SubscribeOption func(*subscription)
+
+// WithConcurrency sets the number of messages handled concurrently. Defaults to 1.
+func WithConcurrency(concurrency int) SubscribeOption {
+	return func(s *subscription) {
+		if concurrency > 0 {
+			s.concurrency = concurrency
+		}
+	}
+}
+
+// WithMaxMessages sets the maximum number of messages received per call. Defaults to 10.
+func WithMaxMessages(maxMessages int) SubscribeOption {
+	return func(s *subscription) {
+		if maxMessages > 0 {
+			s.maxMessages = maxMessages
+		}
+	}
+}
+
+// WithWaitTime sets how long each receive call waits for messages. Defaults to 20s.
+func WithWaitTime(waitTime time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.waitTime = waitTime
+	}
+}
+
+// WithVisibilityTimeout overrides the visibility timeout of the queue for received messages
+func WithVisibilityTimeout(visibilityTimeout time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.visibilityTimeout = visibilityTimeout
+	}
+}
+
+// WithVisibilityHeartbeat extends the visibility timeout set with WithVisibilityTimeout every half
+// period, for as long as a message is being handled or waits for the messages before it in its FIFO
+// group, so that long handlers do not get their message redelivered.
+func WithVisibilityHeartbeat() SubscribeOption {
+	return func(s *subscription) {
+		s.heartbeat = true
+	}
+}
+
+// WithReceiveErrorHandler sets the function called when receiving messages fails. By default the
+// subscription waits one second before receiving again.
+func WithReceiveErrorHandler(onReceiveError ReceiveErrorFunc) SubscribeOption {
+	return func(s *subscription) {
+		if onReceiveError != nil {
+			s.onReceiveError = onReceiveError
+		}
+	}
+}
+
+// WithBackoff sets the delay before failed messages are redelivered. Defaults to an exponential
+// backoff from 1s to 5m.
+func WithBackoff(backoff BackoffFunc) SubscribeOption {
+	return func(s *subscription) {
+		if backoff != nil {
+			s.backoff = backoff
+		}
+	}
+}
+
+// Subscribe receives messages from the queue and passes them to handler until ctx is done, then waits
+// for the messages being handled. Processed messages are acknowledged and failed messages are
+// negatively acknowledged with the configured backoff. Messages of the same FIFO group received in
+// one batch are handled sequentially, in order.
+func Subscribe(ctx context.Context, subscriber Subscriber, queue string, handler Handler, opts ...SubscribeOption) error {
+	s := &subscription{
+		concurrency: defaultConcurrency,
+		maxMessages: defaultMaxMessages,
+		waitTime:    defaultWaitTime,
+		backoff:     ExponentialBackoff(defaultRetryBackoff, maxRetryBackoff),
+		onReceiveError: func(error) time.Duration {
+			return receiveErrorBackoff
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// handlers keep running with their own context when ctx is cancelled so that in-flight
+	// messages are completed
+	handlerCtx := context.WithoutCancel(ctx)
+	workers := make(chan struct{}, s.concurrency)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		// wait for an idle worker, then take every other idle worker
+		select {
+		case <-ctx.Done():
+			return nil
+		case workers <- struct{}{}:
+		}
+
+		available := 1
+	grab:
+		for available < s.maxMessages {
+			select {
+			case workers <- struct{}{}:
+				available++
+			default:
+				break grab
+			}
+		}
+
+		deliveries, err := subscriber.Receive(ctx, queue, ReceiveOptions{
+			MaxMessages:       available,
+			WaitTime:          s.waitTime,
+			VisibilityTimeout: s.visibilityTimeout,
+		})
+		for i := len(deliveries); i < available; i++ {
+			<-workers
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.onReceiveError(err)):
+			}
+			continue
+		}
+
+		for _, group := range groupDeliveries(deliveries) {
+			wg.Add(1)
+			go func(group []*Delivery) {
+				defer wg.Done()
+				s.handleGroup(handlerCtx, subscriber, handler, group, workers)
+			}(group)
+		}
+	}
+}
+
+// handleGroup handles deliveries in order, releasing one worker per delivery. When a FIFO message
+// fails, the following messages of its group are made visible again without being handled.
+func (s *subscription) handleGroup(ctx context.Context, subscriber Subscriber, handler Handler, group []*Delivery, workers <-chan struct{}) {
+	heartbeats := make([]func(), len(group))
+	for i, delivery := range group {
+		heartbeats[i] = s.extendVisibility(ctx, subscriber, delivery)
+	}
+
+	failed := false
+	for i, delivery := range group {
+		if failed {
+			heartbeats[i]()
+			_ = subscriber.Nack(ctx, delivery, 0)
+			<-workers
+			continue
+		}
+
+		err := handler(ctx, delivery)
+		heartbeats[i]()
+		if err != nil {
+			_ = subscriber.Nack(ctx, delivery, s.backoff(delivery.ReceiveCount))
+			failed = delivery.GroupID != ""
+		} else {
+			_ = subscriber.Ack(ctx, delivery)
+		}
+		<-workers
+	}
+}
+
+// extendVisibility extends the visibility timeout of the delivery every half period when the
+// heartbeat is enabled. The returned function stops the extensions and waits for an extension in
+// progress to return.
+func (s *subscription) extendVisibility(ctx context.Context, subscriber Subscriber, delivery *Delivery) func() {
+	interval := s.visibilityTimeout / 2
+	if !s.heartbeat || interval <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// failed extensions are retried on the next tick
+				_ = subscriber.Nack(ctx, delivery, s.visibilityTimeout)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// groupDeliveries groups deliveries by message group, keeping their order. Deliveries without a
+// group are returned on their own.
+func groupDeliveries(deliveries []*Delivery) [][]*Delivery {
+	var groups [][]*Delivery
+	index := make(map[string]int)
+
+	for _, delivery := range deliveries {
+		if delivery.GroupID == "" {
+			groups = append(groups, []*Delivery{delivery})
+			continue
+		}
+
+		i, ok := index[delivery.GroupID]
+		if !ok {
+			i = len(groups)
+			index[delivery.GroupID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], delivery)
+	}
+
+	return groups
+}
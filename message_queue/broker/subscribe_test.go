@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+
+	tests := []struct {
+		receiveCount int
+		want         time.Duration
+	}{
+		{receiveCount: 1, want: time.Second},
+		{receiveCount: 2, want: 2 * time.Second},
+		{receiveCount: 3, want: 4 * time.Second},
+		{receiveCount: 4, want: 5 * time.Second},
+		{receiveCount: 10, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, backoff(tt.receiveCount), "receive count %d", tt.receiveCount)
+	}
+}
+
+func TestSubscribeRetriesThenDeadLetters(t *testing.T) {
+	b := NewMemoryBroker()
+	require.NoError(t, b.CreateQueue("orders", QueueConfig{MaxReceiveCount: 3, DeadLetterQueue: "orders-dlq"}))
+
+	for _, body := range []string{"ok-1", "fail", "ok-2"} {
+		_, err := b.Publish(context.Background(), "orders", Message{Body: body})
+		require.NoError(t, err)
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, b, "orders", func(ctx context.Context, d *Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[d.Body]++
+			if d.Body == "fail" {
+				return errors.New("boom")
+			}
+			return nil
+		}, WithConcurrency(2), WithWaitTime(10*time.Millisecond), WithBackoff(func(int) time.Duration { return 0 }))
+	}()
+
+	require.Eventually(t, func() bool {
+		return b.Len("orders") == 0 && b.Len("orders-dlq") == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"ok-1": 1, "ok-2": 1, "fail": 3}, attempts)
+	assert.Equal(t, "fail", b.Messages("orders-dlq")[0].Body)
+}
+
+func TestSubscribeVisibilityHeartbeat(t *testing.T) {
+	b := NewMemoryBroker()
+	_, err := b.Publish(context.Background(), "reports", Message{Body: "slow"})
+	require.NoError(t, err)
+
+	var attempts atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, b, "reports", func(ctx context.Context, d *Delivery) error {
+			attempts.Add(1)
+			time.Sleep(300 * time.Millisecond)
+			return nil
+		}, WithConcurrency(2), WithWaitTime(10*time.Millisecond), WithVisibilityTimeout(100*time.Millisecond), WithVisibilityHeartbeat())
+	}()
+
+	require.Eventually(t, func() bool { return b.Len("reports") == 0 }, 2*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	// the message is not redelivered to the idle worker while it is being handled
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+// failingSubscriber fails every receive call
+type failingSubscriber struct {
+	Subscriber
+}
+
+func (failingSubscriber) Receive(context.Context, string, ReceiveOptions) ([]*Delivery, error) {
+	return nil, errors.New("throttled")
+}
+
+func TestSubscribeReceiveErrorHandler(t *testing.T) {
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, failingSubscriber{}, "orders", func(context.Context, *Delivery) error {
+			return nil
+		}, WithReceiveErrorHandler(func(err error) time.Duration {
+			calls.Add(1)
+			return time.Millisecond
+		}))
+	}()
+
+	require.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestSubscribeFIFOOrder(t *testing.T) {
+	b := NewMemoryBroker()
+
+	var want []string
+	for _, body := range []string{"a1", "b1", "a2", "a3", "b2"} {
+		_, err := b.Publish(context.Background(), "orders.fifo", Message{Body: body, GroupID: body[:1], DeduplicationID: body})
+		require.NoError(t, err)
+		if body[0] == 'a' {
+			want = append(want, body)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		got    []string
+		failed bool
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, b, "orders.fifo", func(ctx context.Context, d *Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			// fail a2 once: a3 must not be handled before a2 succeeds
+			if d.Body == "a2" && !failed {
+				failed = true
+				return errors.New("retry")
+			}
+			if d.GroupID == "a" {
+				got = append(got, d.Body)
+			}
+			return nil
+		}, WithConcurrency(4), WithWaitTime(10*time.Millisecond), WithBackoff(func(int) time.Duration { return 0 }))
+	}()
+
+	require.Eventually(t, func() bool { return b.Len("orders.fifo") == 0 }, 2*time.Second, 5*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, want, got)
+}
+
+func TestGroupDeliveries(t *testing.T) {
+	deliveries := []*Delivery{
+		{Message: Message{ID: "1", GroupID: "a"}},
+		{Message: Message{ID: "2"}},
+		{Message: Message{ID: "3", GroupID: "b"}},
+		{Message: Message{ID: "4", GroupID: "a"}},
+	}
+
+	groups := groupDeliveries(deliveries)
+	require.Len(t, groups, 3)
+	assert.Equal(t, []*Delivery{deliveries[0], deliveries[3]}, groups[0])
+	assert.Equal(t, []*Delivery{deliveries[1]}, groups[1])
+	assert.Equal(t, []*Delivery{deliveries[2]}, groups[2])
+}
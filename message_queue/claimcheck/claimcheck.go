@@ -0,0 +1,165 @@
+package claimcheck // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// PointerAttribute holds the key of the blob storing the message body
+	PointerAttribute = "x-claim-check"
+	// SizeAttribute holds the size in bytes of the offloaded body
+	SizeAttribute = "x-claim-check-size"
+
+	// maxMessageBytes is the SQS limit of the body plus the message attributes
+	maxMessageBytes = 256 * 1024
+	// DefaultThreshold is the message size above which bodies are offloaded. It leaves room for the
+	// pointer attributes below the SQS limit.
+	DefaultThreshold = maxMessageBytes - 1024
+)
+
+// ErrTooManyAttributes is returned when a message has no room left for the pointer attributes
+var ErrTooManyAttributes = errors.New("too many message attributes to offload the body")
+
+// Offloader moves large message bodies to a blob store and resolves them back
+type Offloader struct {
+	store     BlobStore
+	threshold int
+}
+
+// Option configures an Offloader
+type Option func(*Offloader)
+
+// WithThreshold sets the message size, body plus attributes, above which bodies are offloaded
+func WithThreshold(threshold int) Option {
+	return func(o *Offloader) {
+		o.threshold = threshold
+	}
+}
+
+// New creates an offloader storing bodies in store
+func New(store BlobStore, opts ...Option) (*Offloader, error) {
+	o := &Offloader{
+		store:     store,
+		threshold: DefaultThreshold,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.store == nil {
+		return nil, fmt.Errorf("invalid claim check offloader. store is required")
+	}
+
+	if o.threshold <= 0 || o.threshold > DefaultThreshold {
+		return nil, fmt.Errorf("invalid claim check offloader. threshold must be between 1 and %d", DefaultThreshold)
+	}
+
+	return o, nil
+}
+
+// Offload stores the body in the blob store if the message is larger than the threshold. It returns
+// the body and attributes to send: either the originals, or the blob key as body and a copy of the
+// attributes with the pointer added. A message without room for the pointer attributes below the
+// SQS attribute limit is not offloaded and fails with ErrTooManyAttributes.
+func (o *Offloader) Offload(ctx context.Context, body string, attributes map[string]*sqs.MessageAttributeValue) (string, map[string]*sqs.MessageAttributeValue, error) {
+	if Size(body, attributes) <= o.threshold {
+		return body, attributes, nil
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	offloaded := make(map[string]*sqs.MessageAttributeValue, len(attributes)+2)
+	for name, value := range attributes {
+		offloaded[name] = value
+	}
+
+	offloaded[PointerAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(key),
+	}
+	offloaded[SizeAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	}
+
+	// the count is checked before the body is stored, so that no blob is left behind
+	if len(offloaded) > sqslimits.MaxMessageAttributes {
+		return "", nil, fmt.Errorf("%w: %d attributes with the pointer, SQS accepts %d", ErrTooManyAttributes, len(offloaded), sqslimits.MaxMessageAttributes)
+	}
+
+	if err := o.store.Put(ctx, key, []byte(body)); err != nil {
+		return "", nil, err
+	}
+
+	return key, offloaded, nil
+}
+
+// Resolve replaces the body of an offloaded message with the stored body. It returns false for
+// messages that were not offloaded.
+func (o *Offloader) Resolve(ctx context.Context, message *sqs.Message) (bool, error) {
+	key, ok := Pointer(message.MessageAttributes)
+	if !ok {
+		return false, nil
+	}
+
+	data, err := o.store.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	message.Body = aws.String(string(data))
+	return true, nil
+}
+
+// Release deletes the blob of an offloaded message. It does nothing for other messages.
+func (o *Offloader) Release(ctx context.Context, attributes map[string]*sqs.MessageAttributeValue) error {
+	key, ok := Pointer(attributes)
+	if !ok {
+		return nil
+	}
+
+	return o.store.Delete(ctx, key)
+}
+
+// Pointer returns the blob key of an offloaded message
+func Pointer(attributes map[string]*sqs.MessageAttributeValue) (string, bool) {
+	value, ok := attributes[PointerAttribute]
+	if !ok || aws.StringValue(value.StringValue) == "" {
+		return "", false
+	}
+
+	return aws.StringValue(value.StringValue), true
+}
+
+// Size returns the size SQS accounts for a message: the body plus attribute names, types and values
+func Size(body string, attributes map[string]*sqs.MessageAttributeValue) int {
+	size := len(body)
+	for name, value := range attributes {
+		size += len(name) + len(aws.StringValue(value.DataType)) + len(aws.StringValue(value.StringValue)) + len(value.BinaryValue)
+	}
+
+	return size
+}
+
+// newKey returns a random blob key
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed generating claim check key | %s", err.Error())
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,126 @@
+package claimcheck
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOffloader(t *testing.T, opts ...Option) (*Offloader, *FileStore) {
+	t.Helper()
+
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	offloader, err := New(store, opts...)
+	require.NoError(t, err)
+
+	return offloader, store
+}
+
+func TestNew(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		store   BlobStore
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "defaults", store: store},
+		{name: "custom threshold", store: store, opts: []Option{WithThreshold(1024)}},
+		{name: "missing store", wantErr: true},
+		{name: "threshold above the SQS limit", store: store, opts: []Option{WithThreshold(maxMessageBytes)}, wantErr: true},
+		{name: "zero threshold", store: store, opts: []Option{WithThreshold(0)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.store, tt.opts...); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOffloader_RoundTrip(t *testing.T) {
+	offloader, store := newTestOffloader(t, WithThreshold(100))
+	ctx := context.Background()
+
+	attributes := map[string]*sqs.MessageAttributeValue{
+		"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+	}
+
+	t.Run("small bodies are kept", func(t *testing.T) {
+		body, got, err := offloader.Offload(ctx, "small", attributes)
+		require.NoError(t, err)
+		assert.Equal(t, "small", body)
+		assert.Equal(t, attributes, got)
+	})
+
+	t.Run("large bodies are offloaded", func(t *testing.T) {
+		large := strings.Repeat("x", 200)
+
+		body, got, err := offloader.Offload(ctx, large, attributes)
+		require.NoError(t, err)
+
+		key, ok := Pointer(got)
+		require.True(t, ok)
+		assert.Equal(t, key, body)
+		assert.Equal(t, "200", *got[SizeAttribute].StringValue)
+		assert.Equal(t, "acme", *got["tenant"].StringValue)
+		assert.NotContains(t, attributes, PointerAttribute, "the original attributes must not be modified")
+
+		message := &sqs.Message{Body: aws.String(body), MessageAttributes: got}
+		resolved, err := offloader.Resolve(ctx, message)
+		require.NoError(t, err)
+		assert.True(t, resolved)
+		assert.Equal(t, large, *message.Body)
+
+		require.NoError(t, offloader.Release(ctx, got))
+		_, err = store.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrBlobNotFound)
+
+		_, err = offloader.Resolve(ctx, &sqs.Message{Body: aws.String(body), MessageAttributes: got})
+		assert.ErrorIs(t, err, ErrBlobNotFound)
+	})
+
+	t.Run("messages without room for the pointer are rejected", func(t *testing.T) {
+		full := make(map[string]*sqs.MessageAttributeValue)
+		for i := 0; i < 9; i++ {
+			full[fmt.Sprintf("attribute-%d", i)] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("v")}
+		}
+
+		_, _, err := offloader.Offload(ctx, strings.Repeat("x", 200), full)
+		assert.ErrorIs(t, err, ErrTooManyAttributes)
+
+		blobs, err := os.ReadDir(store.dir)
+		require.NoError(t, err)
+		assert.Len(t, blobs, 0, "no blob is stored for a rejected message")
+	})
+
+	t.Run("plain messages are not resolved", func(t *testing.T) {
+		message := &sqs.Message{Body: aws.String("plain")}
+		resolved, err := offloader.Resolve(ctx, message)
+		require.NoError(t, err)
+		assert.False(t, resolved)
+		assert.Equal(t, "plain", *message.Body)
+		assert.NoError(t, offloader.Release(ctx, nil))
+	})
+}
+
+func TestSize(t *testing.T) {
+	attributes := map[string]*sqs.MessageAttributeValue{
+		"name": {DataType: aws.String("String"), StringValue: aws.String("value")},
+	}
+
+	assert.Equal(t, 4, Size("body", nil))
+	assert.Equal(t, 4+4+6+5, Size("body", attributes))
+}
@@ -0,0 +1,7 @@
+/*
+Package claimcheck implements the claim-check pattern for SQS messages. Bodies larger than a
+threshold are stored in a BlobStore, such as S3, and the message carries a pointer to the blob in a
+message attribute instead. Consumers resolve the pointer before handling the message and delete the
+blob once the message was processed.
+*/
+package claimcheck // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
@@ -0,0 +1,163 @@
+package claimcheck // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// ErrBlobNotFound is returned when the blob a message points to does not exist
+var ErrBlobNotFound = errors.New("claim check blob not found")
+
+// BlobStore stores message bodies by key
+type BlobStore interface {
+	// Put stores data under key
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data stored under key, or ErrBlobNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the data stored under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Store stores message bodies as objects of an S3 bucket
+type S3Store struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Store creates a store writing objects to bucket, with keys prefixed by prefix
+func NewS3Store(client s3iface.S3API, bucket, prefix string) (*S3Store, error) {
+	if client == nil || bucket == "" {
+		return nil, fmt.Errorf("invalid s3 store. client and bucket are required")
+	}
+
+	return &S3Store{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// Put implements BlobStore
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   bytes.NewReader(data),
+	}); err != nil {
+		return fmt.Errorf("failed storing claim check %s | %s", key, err.Error())
+	}
+
+	return nil
+}
+
+// Get implements BlobStore
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+		}
+
+		return nil, fmt.Errorf("failed loading claim check %s | %s", key, err.Error())
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading claim check %s | %s", key, err.Error())
+	}
+
+	return data, nil
+}
+
+// Delete implements BlobStore
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	}); err != nil {
+		return fmt.Errorf("failed deleting claim check %s | %s", key, err.Error())
+	}
+
+	return nil
+}
+
+// FileStore stores message bodies as files of a local directory. It is meant for tests and local
+// development, where producers and consumers share the filesystem.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a store writing files to dir, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed creating claim check directory | %s", err.Error())
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Put implements BlobStore
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Get implements BlobStore
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+
+	return data, err
+}
+
+// Delete implements BlobStore
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns the file of key, rejecting keys that would escape the directory
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid claim check key %q", key)
+	}
+
+	return filepath.Join(s.dir, key), nil
+}
+
+// Static checks to ensure the stores implement the BlobStore interface.
+var (
+	_ BlobStore = (*S3Store)(nil)
+	_ BlobStore = (*FileStore)(nil)
+)
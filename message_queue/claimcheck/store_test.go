@@ -0,0 +1,89 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 keeps objects in memory
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.objects[*input.Bucket+"/"+*input.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	data, ok := f.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	delete(f.objects, *input.Bucket+"/"+*input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	require.NoError(t, store.Put(ctx, "key", []byte("payload")))
+
+	data, err := store.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), data)
+
+	require.NoError(t, store.Delete(ctx, "key"))
+	require.NoError(t, store.Delete(ctx, "key"), "deleting a missing key is not an error")
+
+	_, err = store.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestS3Store(t *testing.T) {
+	client := &fakeS3{objects: map[string][]byte{}}
+
+	store, err := NewS3Store(client, "bucket", "claim-checks/")
+	require.NoError(t, err)
+	testBlobStore(t, store)
+
+	require.NoError(t, store.Put(context.Background(), "key", []byte("payload")))
+	assert.Contains(t, client.objects, "bucket/claim-checks/key")
+
+	_, err = NewS3Store(client, "", "")
+	assert.Error(t, err)
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	testBlobStore(t, store)
+
+	for _, key := range []string{"", "..", "../escape", "a/b"} {
+		assert.Error(t, store.Put(context.Background(), key, []byte("x")), key)
+	}
+}
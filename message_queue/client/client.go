@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -61,6 +62,10 @@ type Client struct {
 	// `AwsConfig    *AwsConfig` is a field in the `Client` struct that holds the AWS configuration for
 	// the SQS client. It is used to configure the AWS credentials and region for the SQS client.
 	AwsConfig *AwsConfig
+
+	// `ClaimCheck` offloads message bodies larger than its threshold to a blob store and resolves them
+	// back on receive. It is optional; large messages are sent as is when it is nil.
+	ClaimCheck *claimcheck.Offloader
 }
 
 // The MessageClientInterface is an interface that defines methods for sending, receiving, and deleting
@@ -91,6 +96,9 @@ type MessageClientInterface interface {
 	// and an error. The string returned is the message ID of the sent message, and the error returned
 	// indicates whether there was an error sending the message.
 	SendMessage(ctx context.Context, msg *sqs.SendMessageInput) (*string, error)
+	// The `SendBatch` method sends up to 10 messages to the same queue in a single call. It returns the
+	// message ids of the sent messages keyed by entry id, and an error listing the failed entries.
+	SendBatch(ctx context.Context, reqs []*SendRequest) (map[string]string, error)
 	// The `Receive` method is used to receive messages from an SQS queue. It takes a context and a queue
 	// URL as input, and returns a slice of `Message` structs and an error. The `Message` struct contains
 	// information about the received message, such as the message ID, receipt handle, and message body.
@@ -2,6 +2,7 @@ package client // import "github.com/SolomonAIEngineering/backend-core-library/m
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
@@ -38,6 +39,20 @@ func (*MockClient) SendMessage(ctx context.Context, msg *sqs.SendMessageInput) (
 	return &str, nil
 }
 
+// SendBatch implements MessageClientInterface.
+func (*MockClient) SendBatch(ctx context.Context, reqs []*SendRequest) (map[string]string, error) {
+	ids := make(map[string]string, len(reqs))
+	for i, req := range reqs {
+		id := req.ID
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+		ids[id] = "test-id"
+	}
+
+	return ids, nil
+}
+
 var _ MessageClientInterface = (*MockClient)(nil)
 
 func NewMockClient() (MessageClientInterface, error) {
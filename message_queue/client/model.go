@@ -28,6 +28,17 @@ type SendRequest struct {
 	// priority, or any other relevant information. The `Attribute` struct typically contains a `Key` and a
 	// `Value`, and an optional `Type` field.
 	Attributes []Attribute
+	// The `MessageGroupID` field is defining a string property named `MessageGroupID` for the
+	// `SendRequest` struct. It is required for FIFO queues; messages of the same group are delivered in
+	// order while different groups are delivered in parallel. It must be empty for standard queues.
+	MessageGroupID string
+	// The `DeduplicationID` field is defining a string property named `DeduplicationID` for the
+	// `SendRequest` struct. FIFO queues deliver a single copy of messages sharing this id within the 5
+	// minute deduplication interval. It can be left empty for queues with content based deduplication.
+	DeduplicationID string
+	// The `ID` field is the id of the entry within a batch sent with `SendBatch`. It defaults to the
+	// position of the request in the batch.
+	ID string
 }
 
 // The Attribute type represents an HTML attribute with a key, value, and type.
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
@@ -52,6 +53,13 @@ func WithAwsConfig(config *AwsConfig) Option {
 	}
 }
 
+// WithClaimCheck sets the offloader storing large message bodies in a blob store
+func WithClaimCheck(offloader *claimcheck.Offloader) Option {
+	return func(c *Client) {
+		c.ClaimCheck = offloader
+	}
+}
+
 // Validate validates the client
 func (c *Client) Validate() error {
 	if c.SqsClient == nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
@@ -14,7 +15,8 @@ import (
 // API call to the SQS service, passing in the `queueURL` and other parameters to retrieve a single
 // message from the queue. It then converts the received message into a `Message` struct and appends it
 // to the `messages` slice. Finally, it returns the `messages` slice or an error if there was a problem
-// receiving the message.
+// receiving the message. Bodies offloaded to the claim check blob store are resolved; call
+// `ReleaseClaimCheck` once the message was processed to delete the blob.
 func (h *Client) Receive(ctx context.Context, queueURL string) ([]*Message, error) {
 	ctx, cancel := context.WithTimeout(ctx, h.ReadTimeout)
 	defer cancel()
@@ -39,6 +41,12 @@ func (h *Client) Receive(ctx context.Context, queueURL string) ([]*Message, erro
 
 	messages := make([]*Message, 0)
 	for _, message := range res.Messages {
+		if h.ClaimCheck != nil {
+			if _, err := h.ClaimCheck.Resolve(ctx, message); err != nil {
+				return nil, fmt.Errorf("receive: %w", err)
+			}
+		}
+
 		attrs := make(map[string]string)
 		for key, attr := range message.MessageAttributes {
 			attrs[key] = *attr.StringValue
@@ -53,3 +61,16 @@ func (h *Client) Receive(ctx context.Context, queueURL string) ([]*Message, erro
 	}
 	return messages, nil
 }
+
+// ReleaseClaimCheck deletes the blob holding the body of a received message that was offloaded to the
+// claim check blob store. It does nothing for other messages or when no claim check is configured.
+func (h *Client) ReleaseClaimCheck(ctx context.Context, message *Message) error {
+	key, ok := message.Attributes[claimcheck.PointerAttribute]
+	if h.ClaimCheck == nil || !ok {
+		return nil
+	}
+
+	return h.ClaimCheck.Release(ctx, map[string]*sqs.MessageAttributeValue{
+		claimcheck.PointerAttribute: {StringValue: aws.String(key)},
+	})
+}
@@ -3,28 +3,38 @@ package client // import "github.com/SolomonAIEngineering/backend-core-library/m
 import (
 	"context"
 	"fmt"
+	"strconv"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+// maxBatchEntries is the maximum number of entries SQS accepts in a batch
+const maxBatchEntries = 10
+
 // Send sends a message to a target queue present in the request object
 func (h *Client) Send(ctx context.Context, req *SendRequest) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, h.WriteTimeout)
 	defer cancel()
 
-	attrs := make(map[string]*sqs.MessageAttributeValue, len(req.Attributes))
-	for _, attr := range req.Attributes {
-		attrs[attr.Key] = &sqs.MessageAttributeValue{
-			StringValue: aws.String(attr.Value),
-			DataType:    aws.String(attr.Type),
-		}
+	if err := validateFIFO(req.QueueURL, req); err != nil {
+		return "", fmt.Errorf("send: %w", err)
+	}
+
+	body, attributes, deduplicationID, err := h.offload(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("send: %w", err)
 	}
 
 	res, err := h.SqsClient.SendMessageWithContext(ctx, &sqs.SendMessageInput{
-		MessageAttributes: attrs,
-		MessageBody:       aws.String(req.Body),
-		QueueUrl:          aws.String(req.QueueURL),
+		MessageAttributes:      attributes,
+		MessageBody:            aws.String(body),
+		QueueUrl:               aws.String(req.QueueURL),
+		MessageGroupId:         optionalString(req.MessageGroupID),
+		MessageDeduplicationId: optionalString(deduplicationID),
 	})
 	if err != nil {
 		return "", fmt.Errorf("send: %w", err)
@@ -33,6 +43,75 @@ func (h *Client) Send(ctx context.Context, req *SendRequest) (string, error) {
 	return *res.MessageId, nil
 }
 
+// SendBatch sends up to 10 messages to the same queue in a single call. It returns the message ids
+// of the sent messages by entry id, and an error listing the entries that failed, if any.
+func (h *Client) SendBatch(ctx context.Context, reqs []*SendRequest) (map[string]string, error) {
+	if len(reqs) == 0 {
+		return map[string]string{}, nil
+	}
+
+	if len(reqs) > maxBatchEntries {
+		return nil, fmt.Errorf("send batch: at most %d entries are allowed, got %d", maxBatchEntries, len(reqs))
+	}
+
+	queueURL := reqs[0].QueueURL
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(reqs))
+	for i, req := range reqs {
+		if req.QueueURL != queueURL {
+			return nil, fmt.Errorf("send batch: all entries must target the same queue")
+		}
+
+		if err := validateFIFO(queueURL, req); err != nil {
+			return nil, fmt.Errorf("send batch: %w", err)
+		}
+
+		id := req.ID
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+
+		body, attributes, deduplicationID, err := h.offload(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("send batch: %w", err)
+		}
+
+		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                     aws.String(id),
+			MessageAttributes:      attributes,
+			MessageBody:            aws.String(body),
+			MessageGroupId:         optionalString(req.MessageGroupID),
+			MessageDeduplicationId: optionalString(deduplicationID),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.WriteTimeout)
+	defer cancel()
+
+	res, err := h.SqsClient.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("send batch: %w", err)
+	}
+
+	ids := make(map[string]string, len(res.Successful))
+	for _, entry := range res.Successful {
+		ids[aws.StringValue(entry.Id)] = aws.StringValue(entry.MessageId)
+	}
+
+	if len(res.Failed) > 0 {
+		failed := make([]string, 0, len(res.Failed))
+		for _, entry := range res.Failed {
+			failed = append(failed, fmt.Sprintf("%s (%s: %s)", aws.StringValue(entry.Id), aws.StringValue(entry.Code), aws.StringValue(entry.Message)))
+		}
+
+		return ids, fmt.Errorf("send batch: %d of %d entries failed: %v", len(res.Failed), len(entries), failed)
+	}
+
+	return ids, nil
+}
+
 // SendMessage sends a message to a queue.
 func (h *Client) SendMessage(ctx context.Context, msg *sqs.SendMessageInput) (*string, error) {
 	ctx, cancel := context.WithTimeout(ctx, h.WriteTimeout)
@@ -45,3 +124,71 @@ func (h *Client) SendMessage(ctx context.Context, msg *sqs.SendMessageInput) (*s
 
 	return res.MessageId, nil
 }
+
+// offload returns the body, attributes and deduplication id to send for the request, storing the
+// body in the claim check blob store if it is too large and adding the trace context of ctx. The
+// body of an offloaded message is a random blob key, so a FIFO message without deduplication id
+// gets one derived from its original body, as content based deduplication would.
+func (h *Client) offload(ctx context.Context, req *SendRequest) (string, map[string]*sqs.MessageAttributeValue, string, error) {
+	body, attributes, deduplicationID := req.Body, messageAttributes(req.Attributes), req.DeduplicationID
+	if h.ClaimCheck != nil {
+		var err error
+		body, attributes, err = h.ClaimCheck.Offload(ctx, body, attributes)
+		if err != nil {
+			return "", nil, "", err
+		}
+
+		if _, offloaded := claimcheck.Pointer(attributes); offloaded && deduplicationID == "" && fifo.IsQueue(req.QueueURL) {
+			deduplicationID = fifo.ContentDeduplicationID(req.Body, nil)
+		}
+	}
+
+	return body, tracing.Inject(ctx, attributes), deduplicationID, nil
+}
+
+// validateFIFO checks the group and deduplication ids of a request against the queue type
+func validateFIFO(queueURL string, req *SendRequest) error {
+	if !fifo.IsQueue(queueURL) {
+		if req.MessageGroupID != "" || req.DeduplicationID != "" {
+			return fmt.Errorf("message group and deduplication ids are only supported by FIFO queues")
+		}
+
+		return nil
+	}
+
+	if req.MessageGroupID == "" {
+		return fifo.ErrMissingGroupID
+	}
+
+	if err := fifo.ValidateID(req.MessageGroupID); err != nil {
+		return err
+	}
+
+	if req.DeduplicationID != "" {
+		return fifo.ValidateID(req.DeduplicationID)
+	}
+
+	return nil
+}
+
+// messageAttributes converts request attributes to SQS message attributes
+func messageAttributes(attributes []Attribute) map[string]*sqs.MessageAttributeValue {
+	attrs := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for _, attr := range attributes {
+		attrs[attr.Key] = &sqs.MessageAttributeValue{
+			StringValue: aws.String(attr.Value),
+			DataType:    aws.String(attr.Type),
+		}
+	}
+
+	return attrs
+}
+
+// optionalString returns nil for empty strings so that unset fields are omitted from requests
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return aws.String(s)
+}
@@ -3,9 +3,66 @@ package client
 import (
 	"context"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS records send calls and fails the batch entries listed in failIDs
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	sent    []*sqs.SendMessageInput
+	batches []*sqs.SendMessageBatchInput
+	failIDs map[string]bool
+}
+
+func (f *fakeSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("message-id")}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	messages := make([]*sqs.Message, 0, len(f.sent))
+	for i, sent := range f.sent {
+		messages = append(messages, &sqs.Message{
+			MessageId:         aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     aws.String(strconv.Itoa(i)),
+			Body:              sent.MessageBody,
+			MessageAttributes: sent.MessageAttributes,
+		})
+	}
+
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	f.batches = append(f.batches, input)
+
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if f.failIDs[*entry.Id] {
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("failed")})
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String("message-" + *entry.Id)})
+	}
+
+	return out, nil
+}
+
+const (
+	testStandardQueue = "https://sqs.us-east-1.amazonaws.com/123/accounts"
+	testFIFOQueue     = "https://sqs.us-east-1.amazonaws.com/123/accounts.fifo"
 )
 
 func TestClient_Send(t *testing.T) {
@@ -20,7 +77,38 @@ func TestClient_Send(t *testing.T) {
 		want    string
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "standard queue",
+			h:       &Client{SqsClient: &fakeSQS{}, WriteTimeout: time.Second},
+			args:    args{ctx: context.Background(), req: &SendRequest{QueueURL: testStandardQueue, Body: "body"}},
+			want:    "message-id",
+			wantErr: false,
+		},
+		{
+			name:    "group id on a standard queue",
+			h:       &Client{SqsClient: &fakeSQS{}, WriteTimeout: time.Second},
+			args:    args{ctx: context.Background(), req: &SendRequest{QueueURL: testStandardQueue, Body: "body", MessageGroupID: "42"}},
+			wantErr: true,
+		},
+		{
+			name:    "fifo queue with group id",
+			h:       &Client{SqsClient: &fakeSQS{}, WriteTimeout: time.Second},
+			args:    args{ctx: context.Background(), req: &SendRequest{QueueURL: testFIFOQueue, Body: "body", MessageGroupID: "42", DeduplicationID: "d-1"}},
+			want:    "message-id",
+			wantErr: false,
+		},
+		{
+			name:    "fifo queue without group id",
+			h:       &Client{SqsClient: &fakeSQS{}, WriteTimeout: time.Second},
+			args:    args{ctx: context.Background(), req: &SendRequest{QueueURL: testFIFOQueue, Body: "body"}},
+			wantErr: true,
+		},
+		{
+			name:    "fifo queue with invalid deduplication id",
+			h:       &Client{SqsClient: &fakeSQS{}, WriteTimeout: time.Second},
+			args:    args{ctx: context.Background(), req: &SendRequest{QueueURL: testFIFOQueue, Body: "body", MessageGroupID: "42", DeduplicationID: "has space"}},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -63,3 +151,143 @@ func TestClient_SendMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_SendFIFOFields(t *testing.T) {
+	fake := &fakeSQS{}
+	client := &Client{SqsClient: fake, WriteTimeout: time.Second}
+
+	_, err := client.Send(context.Background(), &SendRequest{QueueURL: testFIFOQueue, Body: "body", MessageGroupID: "42", DeduplicationID: "d-1"})
+	require.NoError(t, err)
+	require.Len(t, fake.sent, 1)
+	assert.Equal(t, "42", *fake.sent[0].MessageGroupId)
+	assert.Equal(t, "d-1", *fake.sent[0].MessageDeduplicationId)
+
+	_, err = client.Send(context.Background(), &SendRequest{QueueURL: testStandardQueue, Body: "body"})
+	require.NoError(t, err)
+	assert.Nil(t, fake.sent[1].MessageGroupId)
+	assert.Nil(t, fake.sent[1].MessageDeduplicationId)
+}
+
+func TestClient_SendBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqs    []*SendRequest
+		failIDs map[string]bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			want: map[string]string{},
+		},
+		{
+			name: "fifo entries",
+			reqs: []*SendRequest{
+				{QueueURL: testFIFOQueue, Body: "a", MessageGroupID: "1"},
+				{QueueURL: testFIFOQueue, Body: "b", MessageGroupID: "2", ID: "second"},
+			},
+			want: map[string]string{"0": "message-0", "second": "message-second"},
+		},
+		{
+			name: "partial failure",
+			reqs: []*SendRequest{
+				{QueueURL: testStandardQueue, Body: "a"},
+				{QueueURL: testStandardQueue, Body: "b"},
+			},
+			failIDs: map[string]bool{"1": true},
+			want:    map[string]string{"0": "message-0"},
+			wantErr: true,
+		},
+		{
+			name: "mixed queues",
+			reqs: []*SendRequest{
+				{QueueURL: testStandardQueue, Body: "a"},
+				{QueueURL: testFIFOQueue, Body: "b", MessageGroupID: "1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fifo entry without group",
+			reqs: []*SendRequest{
+				{QueueURL: testFIFOQueue, Body: "a"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "too many entries",
+			reqs:    make([]*SendRequest, 11),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{SqsClient: &fakeSQS{failIDs: tt.failIDs}, WriteTimeout: time.Second}
+
+			got, err := client.SendBatch(context.Background(), tt.reqs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.SendBatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.want != nil {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClient_ClaimCheck(t *testing.T) {
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	offloader, err := claimcheck.New(store, claimcheck.WithThreshold(64))
+	require.NoError(t, err)
+
+	fake := &fakeSQS{}
+	client := &Client{SqsClient: fake, WriteTimeout: time.Second, ReadTimeout: time.Second, ClaimCheck: offloader}
+
+	large := strings.Repeat("x", 100)
+	_, err = client.Send(context.Background(), &SendRequest{QueueURL: testStandardQueue, Body: large})
+	require.NoError(t, err)
+	assert.NotEqual(t, large, *fake.sent[0].MessageBody)
+
+	messages, err := client.Receive(context.Background(), testStandardQueue)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, large, messages[0].Body)
+
+	require.NoError(t, client.ReleaseClaimCheck(context.Background(), messages[0]))
+	_, err = store.Get(context.Background(), *fake.sent[0].MessageBody)
+	assert.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+}
+
+func TestClient_ClaimCheckFIFODeduplication(t *testing.T) {
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	offloader, err := claimcheck.New(store, claimcheck.WithThreshold(64))
+	require.NoError(t, err)
+
+	fake := &fakeSQS{}
+	client := &Client{SqsClient: fake, WriteTimeout: time.Second, ReadTimeout: time.Second, ClaimCheck: offloader}
+
+	large := strings.Repeat("x", 100)
+	for i := 0; i < 2; i++ {
+		_, err = client.Send(context.Background(), &SendRequest{QueueURL: testFIFOQueue, Body: large, MessageGroupID: "42"})
+		require.NoError(t, err)
+	}
+	_, err = client.SendBatch(context.Background(), []*SendRequest{
+		{QueueURL: testFIFOQueue, Body: large, MessageGroupID: "42"},
+		{QueueURL: testFIFOQueue, Body: large, MessageGroupID: "42", DeduplicationID: "explicit"},
+		{QueueURL: testFIFOQueue, Body: "small", MessageGroupID: "42"},
+	})
+	require.NoError(t, err)
+
+	// the blob keys differ, the deduplication ids derived from the original body do not
+	require.Len(t, fake.sent, 2)
+	assert.NotEqual(t, *fake.sent[0].MessageBody, *fake.sent[1].MessageBody)
+	assert.Equal(t, fifo.ContentDeduplicationID(large, nil), aws.StringValue(fake.sent[0].MessageDeduplicationId))
+	assert.Equal(t, fake.sent[0].MessageDeduplicationId, fake.sent[1].MessageDeduplicationId)
+
+	entries := fake.batches[0].Entries
+	assert.Equal(t, fake.sent[0].MessageDeduplicationId, entries[0].MessageDeduplicationId)
+	assert.Equal(t, "explicit", aws.StringValue(entries[1].MessageDeduplicationId))
+	assert.Nil(t, entries[2].MessageDeduplicationId, "small messages keep content based deduplication")
+}
@@ -0,0 +1,244 @@
+// Command dlq inspects, redrives and purges the messages of a dead-letter queue.
+//
+// Usage:
+//
+//	dlq list    -queue URL [filters] [-limit N] [-body]
+//	dlq redrive -queue URL [filters] [-limit N] [-target URL] [-rate N]
+//	dlq purge   -queue URL (filters | -all) [-limit N]
+//
+// Filters are -attr name=value (repeatable), -type, -reason and -failed-before. Use -endpoint to
+// target an SQS compatible local stand-in such as ElasticMQ or LocalStack.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/dlq"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// brokerFactory creates the broker for the endpoint and region
+type brokerFactory func(endpoint, region string) (broker.Broker, error)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr, newSQSBroker))
+}
+
+// newSQSBroker creates an SQS broker, using the endpoint if set
+func newSQSBroker(endpoint, region string) (broker.Broker, error) {
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aws session | %s", err.Error())
+	}
+
+	return broker.NewSQSBroker(sqs.New(sess)), nil
+}
+
+// attributeFlags collects repeated -attr name=value flags
+type attributeFlags map[string]string
+
+func (a attributeFlags) String() string {
+	pairs := make([]string, 0, len(a))
+	for name, value := range a {
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (a attributeFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+
+	a[name] = value
+	return nil
+}
+
+// listedMessage is the JSON representation of a listed message
+type listedMessage struct {
+	ID                string            `json:"id"`
+	Type              string            `json:"type,omitempty"`
+	GroupID           string            `json:"group_id,omitempty"`
+	FailureReason     string            `json:"failure_reason,omitempty"`
+	FailureCount      int               `json:"failure_count,omitempty"`
+	FailedAt          *time.Time        `json:"failed_at,omitempty"`
+	SourceQueue       string            `json:"source_queue,omitempty"`
+	OriginalMessageID string            `json:"original_message_id,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	Body              string            `json:"body,omitempty"`
+}
+
+// run executes the command and returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, newBroker brokerFactory) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: dlq <list|redrive|purge> -queue URL [flags]")
+		return 2
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("dlq "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	attributes := attributeFlags{}
+	var (
+		queue        = flags.String("queue", "", "URL or name of the dead-letter queue")
+		endpoint     = flags.String("endpoint", "", "SQS endpoint, for local stand-ins")
+		region       = flags.String("region", envOr("AWS_REGION", "us-east-1"), "AWS region")
+		messageType  = flags.String("type", "", "only messages of the envelope message type")
+		reason       = flags.String("reason", "", "only messages whose failure reason contains the text")
+		failedBefore = flags.Duration("failed-before", 0, "only messages moved to the DLQ longer than the duration ago")
+		limit        = flags.Int("limit", 0, "maximum number of messages, 0 for all")
+		visibility   = flags.Duration("visibility", 5*time.Minute, "how long scanned messages stay invisible")
+		wait         = flags.Duration("wait", time.Second, "how long each receive call waits for messages")
+		body         = flags.Bool("body", false, "list: print message bodies")
+		target       = flags.String("target", "", "redrive: target queue, defaults to the source queue of every message")
+		ratePerSec   = flags.Float64("rate", 0, "redrive: maximum messages per second, 0 for no limit")
+		all          = flags.Bool("all", false, "purge: allow purging without filters")
+	)
+	flags.Var(attributes, "attr", "only messages with the attribute value, as name=value (repeatable)")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *queue == "" {
+		fmt.Fprintln(stderr, "-queue is required")
+		return 2
+	}
+
+	var filters []dlq.Filter
+	for name, value := range attributes {
+		filters = append(filters, dlq.AttributeEquals(name, value))
+	}
+	if *messageType != "" {
+		filters = append(filters, dlq.TypeIs(*messageType))
+	}
+	if *reason != "" {
+		filters = append(filters, dlq.FailureReasonContains(*reason))
+	}
+	if *failedBefore > 0 {
+		filters = append(filters, dlq.FailedBefore(time.Now().Add(-*failedBefore)))
+	}
+	filter := dlq.All(filters...)
+
+	b, err := newBroker(*endpoint, *region)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	manager, err := dlq.New(b, *queue, dlq.WithVisibilityTimeout(*visibility), dlq.WithWaitTime(*wait))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	switch command {
+	case "list":
+		messages, err := manager.List(ctx, filter, *limit)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		encoder := json.NewEncoder(stdout)
+		for _, message := range messages {
+			if err := encoder.Encode(toListedMessage(message, *body)); err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+		}
+		return 0
+
+	case "redrive":
+		result, err := manager.Redrive(ctx, filter, dlq.RedriveOptions{
+			Target:        *target,
+			RatePerSecond: *ratePerSec,
+			Limit:         *limit,
+		})
+		return report(stdout, stderr, "redriven", result, err)
+
+	case "purge":
+		if len(filters) == 0 && !*all {
+			fmt.Fprintln(stderr, "refusing to purge every message without -all")
+			return 2
+		}
+
+		result, err := manager.Purge(ctx, filter, *limit)
+		return report(stdout, stderr, "purged", result, err)
+
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n", command)
+		return 2
+	}
+}
+
+// report prints the result of a redrive or purge and returns the exit code
+func report(stdout, stderr io.Writer, verb string, result dlq.Result, err error) int {
+	fmt.Fprintf(stdout, "matched %d, %s %d, failed %d\n", result.Matched, verb, result.Succeeded, len(result.Errors))
+	for _, e := range result.Errors {
+		fmt.Fprintln(stderr, e)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+	}
+
+	if err != nil || len(result.Errors) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func toListedMessage(message *dlq.Message, withBody bool) listedMessage {
+	listed := listedMessage{
+		ID:                message.ID,
+		Type:              message.Type,
+		GroupID:           message.GroupID,
+		FailureReason:     message.FailureReason,
+		FailureCount:      message.FailureCount,
+		SourceQueue:       message.SourceQueue,
+		OriginalMessageID: message.OriginalMessageID,
+		Attributes:        message.Attributes,
+	}
+
+	if !message.FailedAt.IsZero() {
+		listed.FailedAt = &message.FailedAt
+	}
+
+	if withBody {
+		listed.Body = message.Body
+	}
+
+	return listed
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBroker(t *testing.T) (*broker.MemoryBroker, brokerFactory) {
+	t.Helper()
+
+	b := broker.NewMemoryBroker()
+	for _, body := range []string{"a", "b"} {
+		_, err := b.Publish(context.Background(), "orders-dlq", broker.Message{
+			Body: body,
+			Attributes: map[string]string{
+				consumer.SourceQueueAttribute:   "orders",
+				consumer.FailureReasonAttribute: "failed " + body,
+				"tenant":                        "tenant-" + body,
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	return b, func(endpoint, region string) (broker.Broker, error) { return b, nil }
+}
+
+func runCommand(t *testing.T, factory brokerFactory, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	if len(args) > 1 {
+		args = append(args, "-wait", "0s")
+	}
+	code := run(context.Background(), args, &stdout, &stderr, factory)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunList(t *testing.T) {
+	_, factory := newTestBroker(t)
+
+	code, stdout, stderr := runCommand(t, factory, "list", "-queue", "orders-dlq", "-attr", "tenant=tenant-b", "-body")
+	require.Equal(t, 0, code, stderr)
+
+	var listed listedMessage
+	require.NoError(t, json.Unmarshal([]byte(stdout), &listed))
+	assert.Equal(t, "b", listed.Body)
+	assert.Equal(t, "failed b", listed.FailureReason)
+	assert.Equal(t, "orders", listed.SourceQueue)
+}
+
+func TestRunRedriveAndPurge(t *testing.T) {
+	b, factory := newTestBroker(t)
+
+	code, stdout, _ := runCommand(t, factory, "redrive", "-queue", "orders-dlq", "-reason", "failed a")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "matched 1, redriven 1, failed 0\n", stdout)
+	assert.Equal(t, 1, b.Len("orders"))
+
+	code, _, stderr := runCommand(t, factory, "purge", "-queue", "orders-dlq")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-all")
+
+	code, stdout, _ = runCommand(t, factory, "purge", "-queue", "orders-dlq", "-all")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "matched 1, purged 1, failed 0\n", stdout)
+	assert.Equal(t, 0, b.Len("orders-dlq"))
+}
+
+func TestRunUsage(t *testing.T) {
+	_, factory := newTestBroker(t)
+
+	tests := [][]string{
+		{},
+		{"list"},
+		{"unknown", "-queue", "orders-dlq"},
+		{"list", "-queue", "orders-dlq", "-attr", "invalid"},
+	}
+
+	for _, args := range tests {
+		code, _, _ := runCommand(t, factory, args...)
+		assert.Equal(t, 2, code, strings.Join(args, " "))
+	}
+}
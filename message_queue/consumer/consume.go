@@ -2,41 +2,61 @@ package consumer // import "github.com/SolomonAIEngineering/backend-core-library
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/newrelic/go-agent/v3/newrelic"
 	"go.uber.org/zap"
 )
 
-const maxBackoffDuration = 30 * time.Second
+const (
+	maxBackoffDuration = 30 * time.Second
 
-var wg sync.WaitGroup
+	// maxBatchSize is the maximum number of messages SQS returns per receive call
+	maxBatchSize = 10
+	// maxWaitTimeSeconds is the longest long-polling wait SQS accepts
+	maxWaitTimeSeconds = 20
+	// defaultVisibilityTimeout is the visibility timeout requested on receive and on every extension
+	defaultVisibilityTimeout = 40 * time.Second
+)
 
 // MessageProcessorFunc defines the function type that processes messages from SQS.
-// An error should be returned if processing fails, leading the message to be moved to the DLQ.
+// An error should be returned if processing fails. The message is retried according to the retry
+// policy and moved to the DLQ once it is exhausted, or right away if the error is permanent.
 type MessageProcessorFunc = func(ctx context.Context, message *sqs.Message) error
 
 // IConsumer provides an interface for consuming messages either concurrently or in a naive, sequential manner.
 // Start: Begins processing messages concurrently.
-// Stop: Halts the processing of messages.
+// Stop: Halts the processing of messages and waits for in-flight messages to finish.
 type IConsumer interface {
 	Start()
-	Stop()
+	Stop(ctx context.Context) error
 }
 
 type SQSAPI interface {
 	SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error)
 	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
 	DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error)
 }
 
 // ConsumerClient encapsulates fields related to a client that consumes messages from a queue.
 // It uses a logger for debugging, an instrumentation client for performance monitoring, and an SQS client to interact with the message queue.
+// Messages are received, acknowledged and released through a broker.SQSBroker by broker.Subscribe, so
+// the consumer adds the retry policy, the DLQ, the claim check and the tracing on top of the same
+// receive loop as the other subscribers. All polling state is held per instance so several consumers
+// can run in the same process.
 type ConsumerClient struct {
 	logger                *zap.Logger             // Logger instance for debugging and monitoring.
 	instrumentationClient *instrumentation.Client // New Relic client for application performance monitoring.
@@ -44,22 +64,31 @@ type ConsumerClient struct {
 	queueUrl              *string                 // URL of the queue to receive messages.
 	deadletterQueueUrl    *string                 // URL of the dead letter queue.
 	concurrencyFactor     int                     // Maximum number of concurrent message processing operations.
-	queuePollingDuration  time.Duration           // Duration for polling the queue for new messages.
 	messageProcessTimeout time.Duration           // Maximum duration for message processing before considering it as timed out.
+	visibilityTimeout     time.Duration           // Visibility timeout requested on receive and extended while a handler runs.
+	retryPolicy           RetryPolicy             // Retry schedule of failed messages before they are moved to the DLQ.
+	isRetryable           ErrorClassifierFunc     // Classifies handler errors as retryable or permanent.
+	claimCheck            *claimcheck.Offloader   // Resolves bodies offloaded to a blob store, nil to disable.
 
 	handler         MessageProcessorFunc // Function to process the received messages.
-	stopCh          chan bool            // Channel to signal stopping the client.
-	backoffDuration time.Duration        // Duration before attempting to re-process a failed message.
+	backoffDuration time.Duration        // Duration before attempting to receive again after an error.
 	batchSize       int64                // Number of messages to fetch in one call.
-	waitTimeSecond  int64                // Duration to wait between polling attempts.
-	pollCount       int
-	maxPolls        int // -1 for infinite, any other positive number for a max count
+	waitTimeSecond  int64                // Long polling wait time of a receive call.
+
+	broker       broker.Broker // Receives, acknowledges and publishes to the DLQ, backed by sqsClient.
+	deadLettered sync.Map      // Deliveries moved to the DLQ, acknowledged without being released.
+
+	mu      sync.Mutex
+	stopped bool               // set by Stop, a stopped consumer does not start again
+	cancel  context.CancelFunc // cancels the subscription
+	done    chan struct{}      // closed when the subscription returned
 }
 
 // Static check to ensure ConsumerClient implements the IConsumer interface.
 var _ IConsumer = (*ConsumerClient)(nil)
 
-// Start initiates the polling of the SQS queue in a separate goroutine.
+// Start initiates the polling of the SQS queue in a separate goroutine. Calling Start on a consumer
+// that is already running has no effect.
 //
 // Example:
 // poller := NewSQSPoller("us-west-2", "https://sqs.us-west-2.amazonaws.com/1234567890/myqueue",
@@ -68,10 +97,30 @@ var _ IConsumer = (*ConsumerClient)(nil)
 //
 // poller.Start()
 func (c *ConsumerClient) Start() {
-	go c.poll()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil || c.stopped {
+		return
+	}
+
+	if c.broker == nil {
+		c.broker = broker.NewSQSBroker(c.sqsClient)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		c.poll(ctx)
+	}(c.done)
 }
 
-// Stop sends a signal to the poller to stop polling.
+// Stop signals the poller to stop polling and waits until every in-flight message has been
+// processed or ctx expires. Messages that were received but not yet handed to a handler become
+// visible again once their visibility timeout elapses.
 //
 // Example:
 // poller := NewSQSPoller("us-west-2", "https://sqs.us-west-2.amazonaws.com/1234567890/myqueue",
@@ -80,107 +129,61 @@ func (c *ConsumerClient) Start() {
 //
 // poller.Start()
 // time.Sleep(10 * time.Second)  // Let it poll for 10 seconds
-// poller.Stop()
-func (c *ConsumerClient) Stop() {
-	c.stopCh <- true
-	wg.Wait() // wait for all messages to finish processing
+// poller.Stop(ctx)
+func (c *ConsumerClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	cancel, done := c.cancel, c.done
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	// the subscription returns once every message being handled was acknowledged
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// poll creates a limited parallel queue, and continues to poll AWS until all the limit is reached.
-// This is performed by implementing a token bucket” using a buffered channel hence this approach is only limited by aws throughput
-//
-// Some scenarios will require a different set of resources consumed, depending on the message type (Lets say you want your handler to be able to process from 1 to N emails in 1 message).
-//
-//	To maintain our limitations, we could introduce the timely based token bucket algorithm , which will ensure we don’t process more than N emails over a period of time (like 1 minute),
-//	by grabbing the exact amount of “worker tokens” from the pool, depending on emails count in message. Also, if your code can be timed out, there is a good approach to impose timeout and cancellation,
-//	based on golang context.WithCancel function. Check out the golang semaphore library to build the nuclear-resistant solution. (the mechanics are the same as in our example, abstracted to library,
-//
-// so instead of using channel for limiting our operation we will call semaphore.Acquire, which will also block our execution until “worker tokens” will be refilled).
+// poll subscribes to the queue until ctx is cancelled. broker.Subscribe keeps a token per worker and
+// long-polls for as many messages as there are free workers (capped at the batch size), so the
+// consumer never receives messages it cannot start processing right away. Messages of the same FIFO
+// message group are processed sequentially, in order, and the visibility of the messages being
+// processed or waiting for their turn is extended meanwhile.
 //
 // LINK - Ref: https://docs.microsoft.com/en-us/azure/architecture/microservices/model/domain-analysis
 // LINK - Ref: https://docs.microsoft.com/en-us/azure/architecture/microservices/design/interservice-communication
-func (c *ConsumerClient) poll() {
-	workerTokens := make(chan bool, c.concurrencyFactor)
-	for i := 0; i < c.concurrencyFactor; i++ {
-		workerTokens <- true
+func (c *ConsumerClient) poll(ctx context.Context) {
+	if c.queueUrl == nil {
+		c.logger.Error("Queue URL is nil")
+		return
 	}
 
-	// Create a new ticker that triggers every 5 minute
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop() // the ticker when the function exits
-
-	for {
-		select {
-		case <-c.stopCh:
-			return
-		case <-ticker.C: // Wait for the ticker to trigger
-			ctx, cancel := context.
-				WithTimeout(
-					context.Background(),
-					// TODO: make this configurable
-					30*time.Second)
-			defer cancel()
-
-			if c.queueUrl == nil {
-				c.logger.Error("Queue URL is nil")
-				return
-			}
-
-			var waitTimeInSeconds int64 = 0
-			// NOTE: we do this because aws throws a fit if the wait time in seconds is more than 20
-			if c.waitTimeSecond > 20 || c.waitTimeSecond <= 0 {
-				waitTimeInSeconds = 20
-			} else {
-				waitTimeInSeconds = int64(c.waitTimeSecond)
-			}
-
-			result, err := c.sqsClient.ReceiveMessageWithContext(ctx,
-				&sqs.ReceiveMessageInput{
-					QueueUrl: c.queueUrl,
-					AttributeNames: []*string{
-						aws.String(sqs.QueueAttributeNameAll),
-					},
-					MessageAttributeNames: []*string{
-						aws.String(sqs.QueueAttributeNameAll),
-					},
-					// TODO: make this configurable
-					WaitTimeSeconds: aws.Int64(waitTimeInSeconds),
-					// TODO: make this configurable
-					VisibilityTimeout: aws.Int64(40),
-				})
-			if err != nil {
-				c.handleAWSError(err)
-				c.logger.Error("Error while receiving message", zap.Error(err))
-				continue
-			}
-
-			for _, message := range result.Messages {
-				wg.Add(1)
-				<-workerTokens
-
-				go func(msg *sqs.Message) {
-					defer wg.Done()
-					c.process(ctx, msg, workerTokens)
-				}(message)
-			}
-
-			c.pollCount++
-			if c.maxPolls != -1 && c.pollCount > c.maxPolls {
-				return
-			}
-		default:
-			// just log
-			c.logger.Info("Waiting for messages")
-			time.Sleep(60 * time.Second)
-		}
-	}
+	_ = broker.Subscribe(ctx, &reportingSubscriber{Subscriber: c.broker, consumer: c}, aws.StringValue(c.queueUrl), c.process,
+		broker.WithConcurrency(c.concurrency()),
+		broker.WithMaxMessages(int(c.receiveBatchSize())),
+		broker.WithWaitTime(time.Duration(c.receiveWaitTimeSeconds())*time.Second),
+		broker.WithVisibilityTimeout(c.visibility()),
+		broker.WithVisibilityHeartbeat(),
+		broker.WithBackoff(c.policy().Backoff),
+		broker.WithReceiveErrorHandler(c.handleReceiveError),
+	)
 }
 
-// handleAWSError checks for AWS-specific errors and adjusts the backoff duration.
+// handleReceiveError logs a failed receive call and returns how long to wait before the next one,
+// doubling the backoff duration while SQS reports that the limit is exceeded.
 //
 // Not intended to be called directly by users, hence no public-facing example.
-func (c *ConsumerClient) handleAWSError(err error) {
-	if aerr, ok := err.(awserr.Error); ok {
+func (c *ConsumerClient) handleReceiveError(err error) time.Duration {
+	c.logger.Error("Error while receiving message", zap.Error(err))
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
 		switch aerr.Code() {
 		case sqs.ErrCodeOverLimit:
 			c.backoffDuration = c.backoffDuration * 2
@@ -191,56 +194,301 @@ func (c *ConsumerClient) handleAWSError(err error) {
 			c.backoffDuration = time.Second
 		}
 	}
-	time.Sleep(c.backoffDuration)
+
+	return c.backoffDuration
 }
 
-// moveToDLQ sends a failed message to the Dead Letter Queue (DLQ).
+// moveToDLQ publishes a failed message to the Dead Letter Queue (DLQ). The message attributes are
+// preserved and failure metadata is added as long as the SQS attribute limit allows it.
 //
 // Not intended to be called directly by users, hence no public-facing example.
-func (c *ConsumerClient) moveToDLQ(ctx context.Context, message *sqs.Message) error {
+func (c *ConsumerClient) moveToDLQ(ctx context.Context, delivery *broker.Delivery, cause error) error {
 	if c.deadletterQueueUrl == nil {
 		c.logger.Error("DLQ URL is nil")
 		return awserr.New(sqs.ErrCodeQueueDoesNotExist, "DLQ URL is nil", nil)
 	}
 
-	req := &sqs.SendMessageInput{
-		QueueUrl:    c.deadletterQueueUrl,
-		MessageBody: message.Body,
-	}
-
-	_, err := c.sqsClient.SendMessageWithContext(ctx, req)
+	attributes, types := c.failureAttributes(delivery, cause)
+	_, err := c.broker.Publish(ctx, aws.StringValue(c.deadletterQueueUrl), broker.Message{
+		Body:           delivery.Body,
+		Attributes:     attributes,
+		AttributeTypes: types,
+	})
 	if err != nil {
 		c.logger.Error("Failed to move message to DLQ", zap.Error(err))
 	}
 	return err
 }
 
-// process a single message .
-// It takes in a context, a message to process, and a channel of
-// booleans to synchronize the processing of messages. The function calls the provided message
-// processing function with the given context and message, and if there is an error, it reports it. If
-// there is no error, it deletes the message from the queue. Finally, it returns a boolean value to the
-// synchronization channel to indicate that the worker is available to process another message.
-func (c *ConsumerClient) process(ctx context.Context, message *sqs.Message, sync chan bool) {
-	err := c.handler(ctx, message)
-	if err != nil {
-		c.moveToDLQ(ctx, message)
-		c.reportErrorEvent("process_message", err)
-	} else {
-		if c.queueUrl == nil {
-			c.logger.Error("Queue URL is nil")
-			return
+// failureAttributes copies the attributes of the delivery and adds the failure metadata, returning
+// the attributes and the data types of those that are not strings. Metadata that does not fit in the
+// SQS limit of 10 attributes is dropped.
+func (c *ConsumerClient) failureAttributes(delivery *broker.Delivery, cause error) (map[string]string, map[string]string) {
+	attributes := make(map[string]string, len(delivery.Attributes)+5)
+	for name, value := range delivery.Attributes {
+		attributes[name] = value
+	}
+
+	types := make(map[string]string, len(delivery.AttributeTypes)+1)
+	for name, dataType := range delivery.AttributeTypes {
+		types[name] = dataType
+	}
+
+	metadata := []struct {
+		name     string
+		dataType string
+		value    string
+	}{
+		{FailureReasonAttribute, "String", failureReason(cause)},
+		{FailureCountAttribute, "Number", strconv.Itoa(delivery.ReceiveCount)},
+		{FailedAtAttribute, "String", time.Now().UTC().Format(time.RFC3339)},
+		{SourceQueueAttribute, "String", aws.StringValue(c.queueUrl)},
+		{OriginalMessageIDAttribute, "String", delivery.ID},
+	}
+
+	for _, m := range metadata {
+		if m.value == "" {
+			continue
+		}
+
+		if _, ok := attributes[m.name]; !ok && len(attributes) >= sqslimits.MaxMessageAttributes {
+			c.logger.Warn("Dropping DLQ failure metadata, message has too many attributes", zap.String("attribute", m.name))
+			continue
 		}
 
-		// delete message from queue if no error was encountered
-		if _, err := c.sqsClient.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
-			QueueUrl:      c.queueUrl,
-			ReceiptHandle: message.ReceiptHandle,
-		}); err != nil {
-			c.reportErrorEvent("delete_message", err)
+		attributes[m.name] = m.value
+		if m.dataType == "String" {
+			delete(types, m.name)
+		} else {
+			types[m.name] = m.dataType
 		}
 	}
 
-	// return "worker" to the "pool"
-	sync <- true
+	return attributes, types
+}
+
+// failureReason returns the truncated error message, or an empty string if there is no error
+func failureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return truncate(err.Error(), maxFailureReasonLength)
+}
+
+// handleFailure decides what happens to a message whose handler failed. Retryable failures are
+// returned so that the subscription makes the message visible again after the backoff of the
+// current attempt. Permanent failures and messages that reached the maximum number of attempts are
+// copied to the DLQ and nil is returned so that they are deleted from the source queue. If the copy
+// fails the failure is returned and the message is retried.
+func (c *ConsumerClient) handleFailure(ctx context.Context, delivery *broker.Delivery, cause error) error {
+	attempt := delivery.ReceiveCount
+	policy := c.policy()
+
+	// a message still deferred when the consumer stops is redelivered, but never dead-lettered
+	if errors.Is(cause, ErrDeferred) {
+		return cause
+	}
+
+	if c.retryable(cause) && attempt < policy.MaxAttempts {
+		c.logger.Warn("Message processing failed, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", policy.Backoff(attempt)),
+			zap.Error(cause))
+		return cause
+	}
+
+	if err := c.moveToDLQ(ctx, delivery, cause); err != nil {
+		c.reportErrorEvent("move_to_dlq", err)
+		return cause
+	}
+
+	c.deadLettered.Store(delivery, struct{}{})
+	return nil
+}
+
+// process a single delivery. It is the handler of the subscription: the handler of the consumer is
+// called with the SQS message of the delivery within a message consumer transaction continuing the
+// trace of the producer. A nil error acknowledges the delivery, which deletes the message from the
+// queue, while an error makes it visible again after the backoff of the retry policy. Failed messages
+// that are not retried are moved to the DLQ.
+func (c *ConsumerClient) process(ctx context.Context, delivery *broker.Delivery) error {
+	message := broker.SQSMessage(delivery)
+
+	txnCtx, txn := tracing.StartConsumerTransaction(ctx, c.newrelicApp(), aws.StringValue(c.queueUrl), message)
+	defer txn.End()
+
+	err := c.handleDeferred(txnCtx, message)
+	if err == nil {
+		return nil
+	}
+
+	txn.NoticeError(err)
+	c.reportErrorEvent("process_message", err)
+	return c.handleFailure(ctx, delivery, err)
+}
+
+// handleDeferred calls the handler until it returns an error that does not wrap ErrDeferred. The
+// visibility of a deferred message is extended while the consumer waits for the backoff of the retry
+// policy, so that the wait does not count as a receive of the message.
+func (c *ConsumerClient) handleDeferred(ctx context.Context, message *sqs.Message) error {
+	for deferrals := 1; ; deferrals++ {
+		err := c.handleWithTimeout(ctx, message)
+		if !errors.Is(err, ErrDeferred) {
+			return err
+		}
+
+		backoff := c.policy().Backoff(deferrals)
+		c.logger.Debug("Message deferred",
+			zap.String("message_id", aws.StringValue(message.MessageId)),
+			zap.Int("deferrals", deferrals),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// handleWithTimeout calls handle within the message process timeout
+func (c *ConsumerClient) handleWithTimeout(ctx context.Context, message *sqs.Message) error {
+	if c.messageProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.messageProcessTimeout)
+		defer cancel()
+	}
+
+	return c.handle(ctx, message)
+}
+
+// newrelicApp returns the New Relic application of the instrumentation client, if any
+func (c *ConsumerClient) newrelicApp() *newrelic.Application {
+	if c.instrumentationClient == nil {
+		return nil
+	}
+
+	return c.instrumentationClient.Client
+}
+
+// handle calls the handler with the message, resolving its body first if it was offloaded to the
+// claim check blob store. The handler gets a copy so that the DLQ receives the pointer rather than
+// the large body. A missing blob is a permanent error.
+func (c *ConsumerClient) handle(ctx context.Context, message *sqs.Message) error {
+	if c.claimCheck == nil {
+		return c.handler(ctx, message)
+	}
+
+	resolved := *message
+	if _, err := c.claimCheck.Resolve(ctx, &resolved); err != nil {
+		if errors.Is(err, claimcheck.ErrBlobNotFound) {
+			return Permanent(err)
+		}
+
+		return err
+	}
+
+	return c.handler(ctx, &resolved)
+}
+
+// reportingSubscriber acknowledges deliveries through the broker of the consumer. It reports the
+// failures, and releases the claim check blob of processed messages and counts them once they are
+// deleted. Messages moved to the DLQ keep their blob.
+type reportingSubscriber struct {
+	broker.Subscriber
+	consumer *ConsumerClient
+}
+
+// Ack implements broker.Subscriber
+func (s *reportingSubscriber) Ack(ctx context.Context, delivery *broker.Delivery) error {
+	_, deadLettered := s.consumer.deadLettered.LoadAndDelete(delivery)
+
+	if err := s.Subscriber.Ack(ctx, delivery); err != nil {
+		s.consumer.reportErrorEvent("delete_message", err)
+		return err
+	}
+
+	if deadLettered {
+		return nil
+	}
+
+	if s.consumer.claimCheck != nil {
+		if err := s.consumer.claimCheck.Release(ctx, broker.SQSMessage(delivery).MessageAttributes); err != nil {
+			s.consumer.logger.Warn("Failed to delete claim check blob", zap.Error(err))
+		}
+	}
+
+	s.consumer.reportProcessedMessageCount("process_message")
+	return nil
+}
+
+// Nack implements broker.Subscriber
+func (s *reportingSubscriber) Nack(ctx context.Context, delivery *broker.Delivery, delay time.Duration) error {
+	if err := s.Subscriber.Nack(ctx, delivery, delay); err != nil {
+		s.consumer.reportErrorEvent("change_message_visibility", err)
+		return err
+	}
+
+	return nil
+}
+
+// policy returns the configured retry policy, or the default one
+func (c *ConsumerClient) policy() RetryPolicy {
+	if c.retryPolicy.MaxAttempts == 0 {
+		return DefaultRetryPolicy()
+	}
+
+	return c.retryPolicy
+}
+
+// retryable classifies a handler error with the configured classifier
+func (c *ConsumerClient) retryable(err error) bool {
+	if c.isRetryable == nil {
+		return IsRetryable(err)
+	}
+
+	return c.isRetryable(err)
+}
+
+// concurrency returns the number of messages processed concurrently
+func (c *ConsumerClient) concurrency() int {
+	if c.concurrencyFactor <= 0 {
+		return 1
+	}
+
+	return c.concurrencyFactor
+}
+
+// receiveBatchSize returns the maximum number of messages requested per receive call
+func (c *ConsumerClient) receiveBatchSize() int64 {
+	if c.batchSize <= 0 {
+		return 1
+	}
+
+	if c.batchSize > maxBatchSize {
+		return maxBatchSize
+	}
+
+	return c.batchSize
+}
+
+// receiveWaitTimeSeconds returns the long polling wait time accepted by SQS
+func (c *ConsumerClient) receiveWaitTimeSeconds() int64 {
+	// NOTE: we do this because aws throws a fit if the wait time in seconds is more than 20
+	if c.waitTimeSecond > maxWaitTimeSeconds || c.waitTimeSecond <= 0 {
+		return maxWaitTimeSeconds
+	}
+
+	return c.waitTimeSecond
+}
+
+// visibility returns the visibility timeout requested for received messages
+func (c *ConsumerClient) visibility() time.Duration {
+	if c.visibilityTimeout < 2*time.Second {
+		return defaultVisibilityTimeout
+	}
+
+	return c.visibilityTimeout
 }
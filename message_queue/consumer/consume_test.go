@@ -2,15 +2,24 @@ package consumer
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -41,21 +50,22 @@ func TestMoveToDLQ(t *testing.T) {
 		sqsClient:          mockClient,
 		deadletterQueueUrl: aws.String("https://dlq.url"),
 		logger:             zap.NewNop(),
+		broker:             broker.NewSQSBroker(mockClient),
 	}
 
-	msg := &sqs.Message{
-		Body: aws.String("test message"),
+	msg := &broker.Delivery{
+		Message: broker.Message{Body: "test message"},
 	}
 
 	// Define behavior for mocked method
 	mockClient.On("SendMessageWithContext", mock.Anything, mock.Anything).Return(&sqs.SendMessageOutput{}, nil)
 
-	err := client.moveToDLQ(context.Background(), msg)
+	err := client.moveToDLQ(context.Background(), msg, errors.New("handler failed"))
 	assert.Nil(t, err, "moveToDLQ should not return an error with a valid DLQ URL")
 
 	// Test with nil DLQ URL
 	client.deadletterQueueUrl = nil
-	err = client.moveToDLQ(context.Background(), msg)
+	err = client.moveToDLQ(context.Background(), msg, errors.New("handler failed"))
 	assert.NotNil(t, err, "moveToDLQ should return an error with a nil DLQ URL")
 }
 
@@ -70,10 +80,7 @@ func TestConsumerClient_Start(t *testing.T) {
 	}
 
 	// Channel to signal when the dummy message is processed
-	messageProcessed := make(chan bool)
-
-	// Use a channel to signal when polling is done
-	doneCh := make(chan bool)
+	messageProcessed := make(chan bool, 1)
 
 	client := &ConsumerClient{
 		logger:                zap.NewNop(),
@@ -82,31 +89,532 @@ func TestConsumerClient_Start(t *testing.T) {
 		queueUrl:              aws.String("test-queue-url"),
 		deadletterQueueUrl:    aws.String("test-dlq-url"),
 		concurrencyFactor:     1,
-		queuePollingDuration:  time.Millisecond * 100,
 		messageProcessTimeout: 0,
 		handler: func(ctx context.Context, message *sqs.Message) error {
 			if *message.Body == "test message" {
-				messageProcessed <- true
+				select {
+				case messageProcessed <- true:
+				default:
+				}
 			}
 			return nil
 		},
-		stopCh:          make(chan bool),
 		backoffDuration: 3 * time.Second,
 		batchSize:       10,
 		waitTimeSecond:  1,
 	}
 
-	go func() {
-		client.Start()
-		close(doneCh) // Signal the main test routine that polling has finished
-	}()
+	client.Start()
 
 	select {
-	case <-doneCh:
+	case <-messageProcessed:
 		// Finished successfully
 	case <-time.After(25 * time.Second):
 		t.Fatal("Test timed out")
 	}
 
+	assert.NoError(t, client.Stop(context.Background()))
 	mockClient.AssertExpectations(t)
 }
+
+// fakeSQS serves messages from an in-memory slice and records the calls made by the consumer
+type fakeSQS struct {
+	mu               sync.Mutex
+	pending          []*sqs.Message
+	receiveSizes     []int64
+	deleted          []string
+	visibilityExtend int
+	visibilities     []int64
+	sent             []*sqs.SendMessageInput
+	sendErr          error
+}
+
+func (f *fakeSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("dlq-id")}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	f.receiveSizes = append(f.receiveSizes, *input.MaxNumberOfMessages)
+	n := int(*input.MaxNumberOfMessages)
+	if n > len(f.pending) {
+		n = len(f.pending)
+	}
+	messages := f.pending[:n]
+	f.pending = f.pending[n:]
+	f.mu.Unlock()
+
+	if len(messages) == 0 {
+		// emulate long polling
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, *input.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visibilityExtend++
+	f.visibilities = append(f.visibilities, *input.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// outcomes returns the number of messages deleted or whose visibility changed
+func (f *fakeSQS) outcomes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.deleted) + len(f.visibilities)
+}
+
+func newFakeMessages(n int) []*sqs.Message {
+	messages := make([]*sqs.Message, 0, n)
+	for i := 0; i < n; i++ {
+		messages = append(messages, &sqs.Message{
+			MessageId:     aws.String(fmt.Sprintf("id-%d", i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("rh-%d", i)),
+			Body:          aws.String(fmt.Sprintf("body-%d", i)),
+		})
+	}
+	return messages
+}
+
+func newTestConsumer(sqsClient SQSAPI, concurrency int, batchSize int64, handler MessageProcessorFunc) *ConsumerClient {
+	return &ConsumerClient{
+		logger:                zap.NewNop(),
+		instrumentationClient: &instrumentation.Client{},
+		sqsClient:             sqsClient,
+		queueUrl:              aws.String("queue"),
+		deadletterQueueUrl:    aws.String("dlq"),
+		concurrencyFactor:     concurrency,
+		messageProcessTimeout: 5 * time.Second,
+		handler:               handler,
+		backoffDuration:       time.Millisecond,
+		batchSize:             batchSize,
+		waitTimeSecond:        1,
+	}
+}
+
+// consume runs the client on the messages until outcomes messages were deleted or had their
+// visibility changed
+func consume(t *testing.T, client *ConsumerClient, fake *fakeSQS, messages []*sqs.Message, outcomes int) {
+	t.Helper()
+
+	fake.mu.Lock()
+	fake.pending = messages
+	fake.mu.Unlock()
+
+	client.Start()
+	assert.Eventually(t, func() bool { return fake.outcomes() >= outcomes }, 5*time.Second, time.Millisecond)
+	require.NoError(t, client.Stop(context.Background()))
+}
+
+func TestConsumerClient_StopDrainsInFlightMessages(t *testing.T) {
+	fake := &fakeSQS{pending: newFakeMessages(4)}
+
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	var processed int32
+
+	client := newTestConsumer(fake, 4, 10, func(ctx context.Context, message *sqs.Message) error {
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+	client.Start()
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers did not start")
+		}
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- client.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before in-flight messages finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after handlers finished")
+	}
+
+	assert.Equal(t, int32(4), atomic.LoadInt32(&processed))
+	assert.Len(t, fake.deleted, 4)
+}
+
+func TestConsumerClient_StopTimesOut(t *testing.T) {
+	fake := &fakeSQS{pending: newFakeMessages(1)}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	client.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, client.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestConsumerClient_ReceivesUpToFreeWorkers(t *testing.T) {
+	fake := &fakeSQS{pending: newFakeMessages(25)}
+	var processed int32
+
+	client := newTestConsumer(fake, 20, 10, func(ctx context.Context, message *sqs.Message) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+	client.Start()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&processed) == 25 }, 5*time.Second, 5*time.Millisecond)
+	assert.NoError(t, client.Stop(context.Background()))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, size := range fake.receiveSizes {
+		assert.LessOrEqual(t, size, int64(10))
+	}
+	assert.Equal(t, int64(10), fake.receiveSizes[0])
+}
+
+func TestConsumerClient_MultipleInstances(t *testing.T) {
+	first := &fakeSQS{pending: newFakeMessages(3)}
+	second := &fakeSQS{pending: newFakeMessages(3)}
+	var processed int32
+
+	handler := func(ctx context.Context, message *sqs.Message) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}
+
+	a := newTestConsumer(first, 2, 10, handler)
+	b := newTestConsumer(second, 2, 10, handler)
+	a.Start()
+	b.Start()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&processed) == 6 }, 5*time.Second, 5*time.Millisecond)
+
+	// stopping one consumer must not affect the other
+	assert.NoError(t, a.Stop(context.Background()))
+	second.mu.Lock()
+	second.pending = newFakeMessages(1)
+	second.mu.Unlock()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&processed) == 7 }, 5*time.Second, 5*time.Millisecond)
+	assert.NoError(t, b.Stop(context.Background()))
+}
+
+func TestConsumerClient_ExtendsVisibilityWhileProcessing(t *testing.T) {
+	fake := &fakeSQS{}
+	client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+		time.Sleep(2500 * time.Millisecond)
+		return nil
+	})
+	client.visibilityTimeout = 2 * time.Second
+
+	fake.pending = newFakeMessages(1)
+	client.Start()
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.deleted) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, client.Stop(context.Background()))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.GreaterOrEqual(t, fake.visibilityExtend, 2)
+	for _, visibility := range fake.visibilities {
+		assert.Equal(t, int64(2), visibility)
+	}
+}
+
+func TestConsumerClient_ProcessFailure(t *testing.T) {
+	receivedTimes := func(count string) *sqs.Message {
+		return &sqs.Message{
+			MessageId:     aws.String("id-1"),
+			ReceiptHandle: aws.String("rh-1"),
+			Body:          aws.String("body"),
+			Attributes: map[string]*string{
+				sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(count),
+			},
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+			},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		message        *sqs.Message
+		handlerErr     error
+		sendErr        error
+		wantVisibility []int64
+		wantSent       bool
+		wantDeleted    bool
+	}{
+		{
+			name:           "retryable error below max attempts changes visibility",
+			message:        receivedTimes("2"),
+			handlerErr:     errors.New("temporary"),
+			wantVisibility: []int64{20},
+		},
+		{
+			name:        "retryable error at max attempts moves to DLQ",
+			message:     receivedTimes("3"),
+			handlerErr:  errors.New("temporary"),
+			wantSent:    true,
+			wantDeleted: true,
+		},
+		{
+			name:        "permanent error moves to DLQ on first attempt",
+			message:     receivedTimes("1"),
+			handlerErr:  Permanent(errors.New("bad payload")),
+			wantSent:    true,
+			wantDeleted: true,
+		},
+		{
+			name:           "failed DLQ copy keeps the source message",
+			message:        receivedTimes("3"),
+			handlerErr:     errors.New("temporary"),
+			sendErr:        errors.New("dlq unavailable"),
+			wantVisibility: []int64{40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSQS{sendErr: tt.sendErr}
+			client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+				return tt.handlerErr
+			})
+			client.retryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Second, MaxBackoff: time.Minute, Multiplier: 2}
+
+			consume(t, client, fake, []*sqs.Message{tt.message}, 1)
+
+			assert.Equal(t, tt.wantVisibility, fake.visibilities)
+			assert.Equal(t, tt.wantSent, len(fake.sent) == 1)
+			assert.Equal(t, tt.wantDeleted, len(fake.deleted) == 1)
+
+			if tt.wantSent {
+				attributes := fake.sent[0].MessageAttributes
+				assert.Equal(t, "acme", *attributes["tenant"].StringValue)
+				assert.Equal(t, tt.handlerErr.Error(), *attributes[FailureReasonAttribute].StringValue)
+				assert.Equal(t, *tt.message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount], *attributes[FailureCountAttribute].StringValue)
+				assert.Equal(t, "queue", *attributes[SourceQueueAttribute].StringValue)
+				assert.Equal(t, "id-1", *attributes[OriginalMessageIDAttribute].StringValue)
+				assert.Contains(t, attributes, FailedAtAttribute)
+			}
+		})
+	}
+}
+
+func TestConsumerClient_DeferredMessagesKeepTheirAttempts(t *testing.T) {
+	message := newFakeMessages(1)[0]
+	message.Attributes = map[string]*string{
+		sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3"),
+	}
+
+	var calls atomic.Int32
+	fake := &fakeSQS{}
+	client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+		// the duplicate is deferred more times than the retry policy allows attempts
+		if calls.Add(1) <= 5 {
+			return ErrMessageInProgress
+		}
+		return nil
+	})
+	client.retryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Multiplier: 2}
+
+	consume(t, client, fake, []*sqs.Message{message}, 1)
+
+	assert.Equal(t, int32(6), calls.Load())
+	assert.Equal(t, []string{"rh-0"}, fake.deleted)
+	assert.Empty(t, fake.sent, "deferred messages are never moved to the DLQ")
+	assert.Empty(t, fake.visibilities)
+}
+
+func TestConsumerClient_FailureAttributesLimit(t *testing.T) {
+	delivery := &broker.Delivery{
+		Message: broker.Message{
+			ID:             "id-1",
+			Attributes:     map[string]string{},
+			AttributeTypes: map[string]string{"attr-0": "Number"},
+		},
+		ReceiveCount: 2,
+	}
+	for i := 0; i < 8; i++ {
+		delivery.Attributes[fmt.Sprintf("attr-%d", i)] = "1"
+	}
+
+	client := newTestConsumer(&fakeSQS{}, 1, 1, nil)
+	attributes, types := client.failureAttributes(delivery, errors.New("failed"))
+
+	assert.Len(t, attributes, sqslimits.MaxMessageAttributes)
+	for name := range delivery.Attributes {
+		assert.Contains(t, attributes, name)
+	}
+	assert.Equal(t, "failed", attributes[FailureReasonAttribute])
+	assert.Equal(t, "2", attributes[FailureCountAttribute])
+	assert.Equal(t, map[string]string{"attr-0": "Number", FailureCountAttribute: "Number"}, types)
+}
+
+func withGroup(message *sqs.Message, groupID string) *sqs.Message {
+	message.Attributes = map[string]*string{
+		sqs.MessageSystemAttributeNameMessageGroupId: aws.String(groupID),
+	}
+	return message
+}
+
+func TestConsumerClient_ProcessesGroupsSequentially(t *testing.T) {
+	messages := newFakeMessages(6)
+	for i, message := range messages {
+		withGroup(message, fmt.Sprintf("group-%d", i%2))
+	}
+	fake := &fakeSQS{pending: messages}
+
+	var (
+		mu       sync.Mutex
+		order    = map[string][]string{}
+		inFlight = map[string]int{}
+		overlap  bool
+	)
+
+	client := newTestConsumer(fake, 10, 10, func(ctx context.Context, message *sqs.Message) error {
+		group := fifo.GroupID(message)
+
+		mu.Lock()
+		inFlight[group]++
+		if inFlight[group] > 1 {
+			overlap = true
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight[group]--
+		order[group] = append(order[group], *message.MessageId)
+		mu.Unlock()
+		return nil
+	})
+	client.Start()
+
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.deleted) == 6
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.NoError(t, client.Stop(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.False(t, overlap, "messages of the same group were processed concurrently")
+	assert.Equal(t, []string{"id-0", "id-2", "id-4"}, order["group-0"])
+	assert.Equal(t, []string{"id-1", "id-3", "id-5"}, order["group-1"])
+}
+
+func TestConsumerClient_GroupFailureReleasesRemainingMessages(t *testing.T) {
+	messages := newFakeMessages(3)
+	for _, message := range messages {
+		withGroup(message, "account-1")
+	}
+	fake := &fakeSQS{}
+
+	var processed []string
+	client := newTestConsumer(fake, 3, 3, func(ctx context.Context, message *sqs.Message) error {
+		processed = append(processed, *message.MessageId)
+		if *message.MessageId == "id-1" {
+			return errors.New("temporary")
+		}
+		return nil
+	})
+
+	consume(t, client, fake, messages, 3)
+
+	assert.Equal(t, []string{"id-0", "id-1"}, processed)
+	assert.Equal(t, []string{"rh-0"}, fake.deleted)
+	// the failed message is scheduled for a retry and the last one is released right away
+	assert.Equal(t, []int64{5, 0}, fake.visibilities)
+}
+
+func TestConsumerClient_ClaimCheck(t *testing.T) {
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	offloader, err := claimcheck.New(store, claimcheck.WithThreshold(10))
+	require.NoError(t, err)
+
+	large := "a body larger than the threshold"
+	body, attributes, err := offloader.Offload(context.Background(), large, nil)
+	require.NoError(t, err)
+	key, _ := claimcheck.Pointer(attributes)
+
+	t.Run("resolves and releases", func(t *testing.T) {
+		fake := &fakeSQS{}
+		var received string
+		client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+			received = *message.Body
+			return nil
+		})
+		client.claimCheck = offloader
+
+		message := &sqs.Message{MessageId: aws.String("id"), ReceiptHandle: aws.String("rh"), Body: aws.String(body), MessageAttributes: attributes}
+		consume(t, client, fake, []*sqs.Message{message}, 1)
+
+		assert.Equal(t, large, received)
+		assert.Equal(t, []string{"rh"}, fake.deleted)
+		_, err := store.Get(context.Background(), key)
+		assert.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+	})
+
+	t.Run("missing blob goes to the DLQ with the pointer", func(t *testing.T) {
+		fake := &fakeSQS{}
+		client := newTestConsumer(fake, 1, 1, func(ctx context.Context, message *sqs.Message) error {
+			t.Fatal("handler must not be called")
+			return nil
+		})
+		client.claimCheck = offloader
+
+		message := &sqs.Message{MessageId: aws.String("id"), ReceiptHandle: aws.String("rh"), Body: aws.String(body), MessageAttributes: attributes}
+		consume(t, client, fake, []*sqs.Message{message}, 1)
+
+		require.Len(t, fake.sent, 1)
+		assert.Equal(t, body, *fake.sent[0].MessageBody)
+		assert.Contains(t, fake.sent[0].MessageAttributes, claimcheck.PointerAttribute)
+		assert.Equal(t, []string{"rh"}, fake.deleted)
+	})
+}
@@ -0,0 +1,250 @@
+package consumer // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultIdempotencyKeyPrefix = "idempotency:"
+	defaultInProgressTTL        = 5 * time.Minute
+	defaultCompletedTTL         = 24 * time.Hour
+	idempotencyInProgressMarker = "in-progress:"
+	idempotencyCompletedMarker  = "completed"
+)
+
+// ErrMessageInProgress is returned by the idempotency middleware when another consumer is processing a
+// message with the same idempotency key. It wraps ErrDeferred, so the duplicate waits without using
+// up an attempt, and is skipped once the other consumer completes it, or processed if the other
+// consumer crashed and its claim expired.
+var ErrMessageInProgress = fmt.Errorf("%w: message with the same idempotency key is being processed", ErrDeferred)
+
+// IdempotencyStatus is the state of an idempotency key
+type IdempotencyStatus int
+
+const (
+	// IdempotencyClaimed means the key was free and is now marked in progress by the caller
+	IdempotencyClaimed IdempotencyStatus = iota
+	// IdempotencyInProgress means another consumer is processing the key
+	IdempotencyInProgress
+	// IdempotencyCompleted means a message with the key was already processed
+	IdempotencyCompleted
+)
+
+// IdempotencyStore records the idempotency keys of processed messages
+type IdempotencyStore interface {
+	// Claim marks the key in progress by owner for ttl if it is free, and returns the status of the
+	// key
+	Claim(ctx context.Context, key, owner string, ttl time.Duration) (IdempotencyStatus, error)
+	// Complete marks the key completed for ttl
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+	// Release frees the key so that the message can be processed again, unless the claim of owner
+	// expired and the key was claimed by another owner since
+	Release(ctx context.Context, key, owner string) error
+}
+
+// IdempotencyKeyFunc returns the idempotency key of a message. An empty key disables deduplication for
+// the message.
+type IdempotencyKeyFunc func(message *sqs.Message) string
+
+// MessageIDKey uses the SQS message id as idempotency key. It deduplicates redeliveries of the same
+// message, but not messages sent twice by the producer.
+func MessageIDKey(message *sqs.Message) string {
+	return aws.StringValue(message.MessageId)
+}
+
+// AttributeKey uses the value of a message attribute as idempotency key, falling back to the message id
+// when the attribute is missing
+func AttributeKey(name string) IdempotencyKeyFunc {
+	return func(message *sqs.Message) string {
+		if value, ok := message.MessageAttributes[name]; ok && aws.StringValue(value.StringValue) != "" {
+			return aws.StringValue(value.StringValue)
+		}
+
+		return MessageIDKey(message)
+	}
+}
+
+type idempotency struct {
+	key           IdempotencyKeyFunc
+	prefix        string
+	inProgressTTL time.Duration
+	completedTTL  time.Duration
+}
+
+// IdempotencyOption configures the idempotency middleware
+type IdempotencyOption func(*idempotency)
+
+// WithIdempotencyKey sets the function computing the idempotency key. Defaults to MessageIDKey.
+func WithIdempotencyKey(key IdempotencyKeyFunc) IdempotencyOption {
+	return func(i *idempotency) {
+		if key != nil {
+			i.key = key
+		}
+	}
+}
+
+// WithIdempotencyKeyPrefix sets the prefix of the keys in the store. Defaults to "idempotency:".
+func WithIdempotencyKeyPrefix(prefix string) IdempotencyOption {
+	return func(i *idempotency) {
+		i.prefix = prefix
+	}
+}
+
+// WithInProgressTTL sets how long a message is marked in progress. It must exceed the time the
+// handler takes, since the marker expiring lets another consumer process the message concurrently.
+// It bounds how long redeliveries of a message whose consumer crashed are held back. Defaults to 5m.
+func WithInProgressTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *idempotency) {
+		if ttl > 0 {
+			i.inProgressTTL = ttl
+		}
+	}
+}
+
+// WithCompletedTTL sets how long processed messages are remembered. Defaults to 24h.
+func WithCompletedTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *idempotency) {
+		if ttl > 0 {
+			i.completedTTL = ttl
+		}
+	}
+}
+
+// Idempotent wraps handler so that messages with the same idempotency key are processed once. The key
+// is marked in progress while the handler runs, marked completed when it succeeds, and released when
+// it fails so that the retry is processed. Duplicates of completed messages are skipped and deleted;
+// duplicates of messages in progress fail with ErrMessageInProgress and are deferred. Store
+// errors are returned, so messages are retried rather than processed without deduplication.
+func Idempotent(store IdempotencyStore, handler MessageProcessorFunc, opts ...IdempotencyOption) MessageProcessorFunc {
+	i := &idempotency{
+		key:           MessageIDKey,
+		prefix:        defaultIdempotencyKeyPrefix,
+		inProgressTTL: defaultInProgressTTL,
+		completedTTL:  defaultCompletedTTL,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return func(ctx context.Context, message *sqs.Message) error {
+		key := i.key(message)
+		if key == "" {
+			return handler(ctx, message)
+		}
+		key = i.prefix + key
+		// the owner identifies this attempt, so that it only releases its own claim
+		owner := uuid.NewString()
+
+		status, err := store.Claim(ctx, key, owner, i.inProgressTTL)
+		if err != nil {
+			return fmt.Errorf("failed to claim idempotency key %s | %s", key, err.Error())
+		}
+
+		switch status {
+		case IdempotencyCompleted:
+			return nil
+		case IdempotencyInProgress:
+			return ErrMessageInProgress
+		}
+
+		if err := handler(ctx, message); err != nil {
+			// the in-progress marker expires anyway if the release fails
+			_ = store.Release(context.WithoutCancel(ctx), key, owner)
+			return err
+		}
+
+		// the message was processed, so a failure to record it must not trigger a retry. The
+		// in-progress marker keeps duplicates away until it expires.
+		_ = store.Complete(context.WithoutCancel(ctx), key, i.completedTTL)
+		return nil
+	}
+}
+
+// RedisClient is the subset of the database/redis client used by RedisIdempotencyStore
+type RedisClient interface {
+	WriteIfNotExists(ctx context.Context, key string, value []byte, cacheTTLInSeconds int) (bool, error)
+	WriteWithTTL(ctx context.Context, key string, value []byte, cacheTTLInSeconds int) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	DeleteIfEquals(ctx context.Context, key string, value []byte) (bool, error)
+}
+
+// RedisIdempotencyStore is an IdempotencyStore backed by Redis. The in-progress marker holds the owner
+// of the claim, and is released with a compare-and-delete so that an attempt whose claim expired does
+// not free the claim of another consumer. TTLs are rounded up to the second.
+type RedisIdempotencyStore struct {
+	client RedisClient
+}
+
+// NewRedisIdempotencyStore creates an idempotency store using the redis client
+func NewRedisIdempotencyStore(client RedisClient) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client}
+}
+
+// Claim implements IdempotencyStore
+func (s *RedisIdempotencyStore) Claim(ctx context.Context, key, owner string, ttl time.Duration) (IdempotencyStatus, error) {
+	// the key may expire between the write and the read, in which case the claim is attempted again
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := s.client.WriteIfNotExists(ctx, key, inProgressMarker(owner), ttlSeconds(ttl))
+		if err != nil {
+			return IdempotencyInProgress, err
+		}
+
+		if claimed {
+			return IdempotencyClaimed, nil
+		}
+
+		value, err := s.client.Get(ctx, key)
+		if errors.Is(err, redigo.ErrNil) {
+			continue
+		}
+
+		if err != nil {
+			return IdempotencyInProgress, err
+		}
+
+		if string(value) == idempotencyCompletedMarker {
+			return IdempotencyCompleted, nil
+		}
+
+		return IdempotencyInProgress, nil
+	}
+
+	return IdempotencyInProgress, nil
+}
+
+// Complete implements IdempotencyStore
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.WriteWithTTL(ctx, key, []byte(idempotencyCompletedMarker), ttlSeconds(ttl))
+}
+
+// Release implements IdempotencyStore
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.client.DeleteIfEquals(ctx, key, inProgressMarker(owner))
+	return err
+}
+
+// inProgressMarker returns the value of a key claimed by owner
+func inProgressMarker(owner string) []byte {
+	return []byte(idempotencyInProgressMarker + owner)
+}
+
+// ttlSeconds rounds ttl up to a whole number of seconds, at least one
+func ttlSeconds(ttl time.Duration) int {
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+
+	return seconds
+}
+
+// Static check to ensure RedisIdempotencyStore implements the IdempotencyStore interface.
+var _ IdempotencyStore = (*RedisIdempotencyStore)(nil)
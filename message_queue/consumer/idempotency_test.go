@@ -0,0 +1,156 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/database/redis"
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Static check to ensure the database/redis client can back the idempotency store.
+var _ RedisClient = (*redis.Client)(nil)
+
+func newTestIdempotencyStore(t *testing.T) (*RedisIdempotencyStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	client, err := redis.New(stopCh,
+		redis.WithURI("redis://"+server.Addr()),
+		redis.WithServiceName("consumer-test"),
+		redis.WithLogger(zap.NewNop()),
+		redis.WithTelemetrySdk(&instrumentation.Client{}),
+		redis.WithCacheTTLInSeconds(60),
+	)
+	require.NoError(t, err)
+
+	return NewRedisIdempotencyStore(client), server
+}
+
+func TestRedisIdempotencyStore(t *testing.T) {
+	store, server := newTestIdempotencyStore(t)
+	ctx := context.Background()
+
+	status, err := store.Claim(ctx, "k", "owner-1", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyClaimed, status)
+
+	status, err = store.Claim(ctx, "k", "owner-2", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyInProgress, status)
+
+	// a crashed consumer never completes the key: the marker expires and the key can be claimed again
+	server.FastForward(11 * time.Second)
+	status, err = store.Claim(ctx, "k", "owner-2", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyClaimed, status)
+
+	// the owner whose claim expired cannot release the claim of the new owner
+	require.NoError(t, store.Release(ctx, "k", "owner-1"))
+	status, err = store.Claim(ctx, "k", "owner-3", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyInProgress, status)
+
+	require.NoError(t, store.Release(ctx, "k", "owner-2"))
+	assert.False(t, server.Exists("k"))
+
+	status, err = store.Claim(ctx, "k", "owner-3", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyClaimed, status)
+
+	require.NoError(t, store.Complete(ctx, "k", time.Hour))
+	status, err = store.Claim(ctx, "k", "owner-4", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyCompleted, status)
+	assert.Equal(t, time.Hour, server.TTL("k"))
+
+	// a completed key is not released
+	require.NoError(t, store.Release(ctx, "k", "owner-3"))
+	status, err = store.Claim(ctx, "k", "owner-4", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyCompleted, status)
+}
+
+func TestIdempotent(t *testing.T) {
+	store, server := newTestIdempotencyStore(t)
+	ctx := context.Background()
+
+	var calls int32
+	fail := errors.New("boom")
+	shouldFail := false
+	handler := Idempotent(store, func(ctx context.Context, message *sqs.Message) error {
+		atomic.AddInt32(&calls, 1)
+		if shouldFail {
+			return fail
+		}
+		return nil
+	}, WithIdempotencyKey(AttributeKey("idempotency-key")), WithCompletedTTL(time.Hour))
+
+	message := func(id, key string) *sqs.Message {
+		m := &sqs.Message{MessageId: aws.String(id)}
+		if key != "" {
+			m.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+				"idempotency-key": {DataType: aws.String("String"), StringValue: aws.String(key)},
+			}
+		}
+		return m
+	}
+
+	// a failed attempt releases the key so the retry is processed
+	shouldFail = true
+	assert.ErrorIs(t, handler(ctx, message("m-1", "delete-account-42")), fail)
+	assert.False(t, server.Exists("idempotency:delete-account-42"))
+
+	shouldFail = false
+	require.NoError(t, handler(ctx, message("m-1", "delete-account-42")))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	// a second message with the same key is skipped
+	require.NoError(t, handler(ctx, message("m-2", "delete-account-42")))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	// a message in progress elsewhere is retried later
+	_, err := store.Claim(ctx, "idempotency:m-3", "other-consumer", time.Minute)
+	require.NoError(t, err)
+	assert.ErrorIs(t, handler(ctx, message("m-3", "")), ErrMessageInProgress)
+	assert.True(t, IsRetryable(ErrMessageInProgress))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	// store errors are returned without calling the handler
+	server.SetError("unavailable")
+	assert.Error(t, handler(ctx, message("m-4", "")))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestIdempotentEmptyKey(t *testing.T) {
+	store, _ := newTestIdempotencyStore(t)
+
+	var calls int
+	handler := Idempotent(store, func(ctx context.Context, message *sqs.Message) error {
+		calls++
+		return nil
+	}, WithIdempotencyKey(func(*sqs.Message) string { return "" }))
+
+	require.NoError(t, handler(context.Background(), &sqs.Message{}))
+	require.NoError(t, handler(context.Background(), &sqs.Message{}))
+	assert.Equal(t, 2, calls)
+}
+
+func TestTTLSeconds(t *testing.T) {
+	assert.Equal(t, 1, ttlSeconds(0))
+	assert.Equal(t, 1, ttlSeconds(200*time.Millisecond))
+	assert.Equal(t, 2, ttlSeconds(1500*time.Millisecond))
+	assert.Equal(t, 300, ttlSeconds(5*time.Minute))
+}
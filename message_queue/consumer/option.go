@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"go.uber.org/zap"
 )
@@ -47,10 +49,12 @@ func WithConcurrencyFactor(factor int) Option {
 	}
 }
 
+// WithQueuePollingDuration does nothing: the consumer long polls the queue continuously, waiting
+// up to WithWaitTimeSecond per receive call.
+//
+// Deprecated: the option is a no-op kept for compatibility and will be removed.
 func WithQueuePollingDuration(duration time.Duration) Option {
-	return func(opt *ConsumerClient) {
-		opt.queuePollingDuration = duration
-	}
+	return func(*ConsumerClient) {}
 }
 
 func WithMessageProcessTimeout(timeout time.Duration) Option {
@@ -83,10 +87,41 @@ func WithWaitTimeSecond(waitTime int64) Option {
 	}
 }
 
+// WithVisibilityTimeout sets the visibility timeout requested for received messages. The timeout is
+// extended by the same amount every half period while a handler is still running.
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(opt *ConsumerClient) {
+		opt.visibilityTimeout = timeout
+	}
+}
+
+// WithRetryPolicy sets how failed messages are retried before they are moved to the DLQ
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(opt *ConsumerClient) {
+		opt.retryPolicy = policy
+	}
+}
+
+// WithErrorClassifier sets the function deciding whether a handler error is retried. By default
+// every error is retried unless it wraps ErrPermanent.
+func WithErrorClassifier(classifier ErrorClassifierFunc) Option {
+	return func(opt *ConsumerClient) {
+		opt.isRetryable = classifier
+	}
+}
+
+// WithClaimCheck resolves message bodies offloaded to a blob store before calling the handler, and
+// deletes the blob once the message was processed
+func WithClaimCheck(offloader *claimcheck.Offloader) Option {
+	return func(opt *ConsumerClient) {
+		opt.claimCheck = offloader
+	}
+}
+
 // Validate validates whether all the required
 // parameters for the consumer client have been set. It checks if the `SqsClient`, `Logger`,
-// `NewRelicClient`, `QueueUrl`, `ConcurrencyFactor`, `MessageProcessTimeout`, and
-// `QueuePollingDuration` fields are not nil or zero. If any of these fields are nil or zero, it
+// `NewRelicClient`, `QueueUrl`, `ConcurrencyFactor` and `MessageProcessTimeout` fields are not nil
+// or zero. If any of these fields are nil or zero, it
 // returns an error indicating that the consumer client is invalid.
 func (c *ConsumerClient) Validate() error {
 	if c.logger == nil ||
@@ -95,15 +130,19 @@ func (c *ConsumerClient) Validate() error {
 		c.queueUrl == nil ||
 		c.deadletterQueueUrl == nil ||
 		c.concurrencyFactor == 0 ||
-		c.queuePollingDuration == 0 ||
 		c.messageProcessTimeout == 0 ||
 		c.handler == nil ||
 		c.backoffDuration == 0 ||
-		c.batchSize == 0 ||
+		c.batchSize <= 0 ||
+		c.batchSize > maxBatchSize ||
 		c.waitTimeSecond == 0 {
 		return fmt.Errorf("invalid consumer client. params: %v", c)
 	}
 
+	if err := c.retryPolicy.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -118,7 +157,6 @@ func (c *ConsumerClient) Validate() error {
 //	WithQueueURL(&yourQueueURL),
 //	WithDLQURL(&yourDLQURL),
 //	WithConcurrencyFactor(yourConcurrencyFactor),
-//	WithQueuePollingDuration(yourPollingDuration),
 //	WithMessageProcessTimeout(yourMessageTimeout),
 //	WithMessageHandler(yourMessageHandlerFunc),
 //	WithBackoffDuration(yourBackoffDuration),
@@ -128,7 +166,9 @@ func (c *ConsumerClient) Validate() error {
 // )
 func New(options ...Option) (*ConsumerClient, error) {
 	c := &ConsumerClient{
-		stopCh: make(chan bool),
+		visibilityTimeout: defaultVisibilityTimeout,
+		retryPolicy:       DefaultRetryPolicy(),
+		isRetryable:       IsRetryable,
 	}
 
 	for _, option := range options {
@@ -139,8 +179,7 @@ func New(options ...Option) (*ConsumerClient, error) {
 		return nil, err
 	}
 
-	// enable infinite polls if initialized through api
-	c.maxPolls = -1
+	c.broker = broker.NewSQSBroker(c.sqsClient)
 
 	return c, nil
 }
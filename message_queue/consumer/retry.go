@@ -0,0 +1,136 @@
+package consumer // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// maxVisibilityTimeout is the longest visibility timeout SQS accepts
+	maxVisibilityTimeout = 12 * time.Hour
+	// maxFailureReasonLength bounds the error message stored on messages moved to the DLQ
+	maxFailureReasonLength = 1024
+
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 5 * time.Second
+	defaultMaxBackoff     = 15 * time.Minute
+	defaultMultiplier     = 2.0
+)
+
+// Message attributes added to messages moved to the DLQ
+const (
+	// FailureReasonAttribute holds the error returned by the handler on the last attempt
+	FailureReasonAttribute = "x-failure-reason"
+	// FailureCountAttribute holds the number of times the message was received
+	FailureCountAttribute = "x-failure-count"
+	// FailedAtAttribute holds the RFC3339 time at which the message was moved to the DLQ
+	FailedAtAttribute = "x-failed-at"
+	// SourceQueueAttribute holds the URL of the queue the message was consumed from
+	SourceQueueAttribute = "x-source-queue"
+	// OriginalMessageIDAttribute holds the id of the message in the source queue
+	OriginalMessageIDAttribute = "x-original-message-id"
+)
+
+// ErrPermanent marks handler errors that must not be retried. Wrap errors with Permanent or
+// fmt.Errorf("...: %w", ErrPermanent) to move the message to the DLQ on the first failure.
+var ErrPermanent = errors.New("permanent error")
+
+// permanentError wraps an error so it matches ErrPermanent while keeping the original error
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() []error { return []error{e.err, ErrPermanent} }
+
+// Permanent wraps err so that the consumer moves the message to the DLQ without retrying it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// ErrDeferred marks handler errors that postpone a message without using up an attempt, such as a
+// duplicate of a message another consumer is processing. The consumer keeps the message invisible,
+// extending its visibility, and calls the handler again after the backoff of the retry policy, so
+// that the wait is not counted as a receive and never moves the message to the DLQ.
+var ErrDeferred = errors.New("message deferred")
+
+// ErrorClassifierFunc returns true if the message that failed with err should be retried
+type ErrorClassifierFunc func(err error) bool
+
+// IsRetryable is the default error classifier. Every error is retried unless it wraps ErrPermanent.
+func IsRetryable(err error) bool {
+	return err != nil && !errors.Is(err, ErrPermanent)
+}
+
+// RetryPolicy controls how failed messages are retried before they are moved to the DLQ. Retries are
+// scheduled by changing the visibility timeout of the failed message, so the attempt count is the
+// ApproximateReceiveCount attribute maintained by SQS and survives consumer restarts.
+type RetryPolicy struct {
+	// MaxAttempts is the number of receives after which a failing message is moved to the DLQ
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+	// Multiplier is applied to the delay after every attempt
+	Multiplier float64
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    defaultMaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		Multiplier:     defaultMultiplier,
+	}
+}
+
+// Validate checks the policy values
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("invalid retry policy: max attempts must be at least 1, got %d", p.MaxAttempts)
+	}
+
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.MaxBackoff > maxVisibilityTimeout {
+		return fmt.Errorf("invalid retry policy: backoff must be between 0 and %s", maxVisibilityTimeout)
+	}
+
+	if p.Multiplier < 1 {
+		return fmt.Errorf("invalid retry policy: multiplier must be at least 1, got %v", p.Multiplier)
+	}
+
+	return nil
+}
+
+// Backoff returns the delay before the next attempt of a message received attempt times
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n]
+}
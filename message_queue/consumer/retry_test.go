@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2}
+
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", attempt: 1, want: time.Second},
+		{name: "second attempt", attempt: 2, want: 2 * time.Second},
+		{name: "fourth attempt", attempt: 4, want: 8 * time.Second},
+		{name: "capped", attempt: 5, want: 10 * time.Second},
+		{name: "far past the cap", attempt: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.Backoff(tt.attempt))
+		})
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		wantErr bool
+	}{
+		{name: "default", policy: DefaultRetryPolicy()},
+		{name: "no attempts", policy: RetryPolicy{Multiplier: 1}, wantErr: true},
+		{name: "multiplier below one", policy: RetryPolicy{MaxAttempts: 1, Multiplier: 0.5}, wantErr: true},
+		{name: "backoff above visibility limit", policy: RetryPolicy{MaxAttempts: 1, Multiplier: 1, MaxBackoff: 13 * time.Hour}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cause := errors.New("bad payload")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain error", err: cause, want: true},
+		{name: "permanent", err: Permanent(cause), want: false},
+		{name: "wrapped sentinel", err: fmt.Errorf("decode: %w", ErrPermanent), want: false},
+		{name: "wrapped permanent", err: fmt.Errorf("handler: %w", Permanent(cause)), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+
+	assert.ErrorIs(t, Permanent(cause), cause)
+	assert.Nil(t, Permanent(nil))
+}
@@ -0,0 +1,242 @@
+package dlq // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/dlq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultVisibilityTimeout = 5 * time.Minute
+	defaultWaitTime          = time.Second
+	// maxReceiveMessages is the maximum number of messages SQS returns per receive call
+	maxReceiveMessages = 10
+)
+
+// ErrMissingTarget is returned when redriving a message without a target queue nor a source queue
+// attribute
+var ErrMissingTarget = errors.New("no target queue: the message has no source queue attribute")
+
+// Manager inspects, redrives and purges the messages of a dead-letter queue.
+//
+// Messages are scanned by receiving them until the queue returns no new message. Scanned messages stay
+// invisible to other consumers for the visibility timeout and are released at the end of the scan, so
+// the visibility timeout must exceed the duration of a scan.
+type Manager struct {
+	broker            broker.Broker
+	queue             string
+	visibilityTimeout time.Duration
+	waitTime          time.Duration
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithVisibilityTimeout sets how long scanned messages stay invisible. Defaults to 5m.
+func WithVisibilityTimeout(visibilityTimeout time.Duration) Option {
+	return func(m *Manager) {
+		if visibilityTimeout > 0 {
+			m.visibilityTimeout = visibilityTimeout
+		}
+	}
+}
+
+// WithWaitTime sets how long each receive call waits for messages. A scan ends on the first receive
+// returning no message, so short polling, which may miss messages on SQS, is not recommended.
+// Defaults to 1s.
+func WithWaitTime(waitTime time.Duration) Option {
+	return func(m *Manager) {
+		if waitTime >= 0 {
+			m.waitTime = waitTime
+		}
+	}
+}
+
+// New creates a manager of the dead-letter queue
+func New(b broker.Broker, queue string, opts ...Option) (*Manager, error) {
+	if b == nil {
+		return nil, fmt.Errorf("broker is required")
+	}
+
+	if queue == "" {
+		return nil, fmt.Errorf("queue is required")
+	}
+
+	m := &Manager{
+		broker:            b,
+		queue:             queue,
+		visibilityTimeout: defaultVisibilityTimeout,
+		waitTime:          defaultWaitTime,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m, nil
+}
+
+// Result reports the outcome of a redrive or purge
+type Result struct {
+	// Matched is the number of messages matching the filter
+	Matched int
+	// Succeeded is the number of messages redriven or purged
+	Succeeded int
+	// Errors lists the messages that could not be redriven or purged
+	Errors []error
+}
+
+// RedriveOptions configures a redrive
+type RedriveOptions struct {
+	// Target is the queue messages are sent to. Defaults to the source queue attribute of every
+	// message.
+	Target string
+	// RatePerSecond limits the number of messages redriven per second. Zero disables the limit.
+	RatePerSecond float64
+	// Limit is the maximum number of messages redriven. Zero redrives every matching message.
+	Limit int
+}
+
+// List returns up to limit messages matching filter without removing them from the queue. Zero lists
+// every matching message.
+func (m *Manager) List(ctx context.Context, filter Filter, limit int) ([]*Message, error) {
+	var messages []*Message
+	err := m.scan(ctx, filter, limit, func(message *Message) (bool, error) {
+		messages = append(messages, message)
+		return false, nil
+	})
+
+	return messages, err
+}
+
+// Redrive sends the messages matching filter back to their source queue, or to the target queue,
+// and deletes them from the dead-letter queue. The failure metadata attributes are removed so that
+// the retries of the consumer start over.
+func (m *Manager) Redrive(ctx context.Context, filter Filter, opts RedriveOptions) (Result, error) {
+	var limiter *rate.Limiter
+	if opts.RatePerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RatePerSecond), 1)
+	}
+
+	var result Result
+	err := m.scan(ctx, filter, opts.Limit, func(message *Message) (bool, error) {
+		result.Matched++
+
+		target := opts.Target
+		if target == "" {
+			target = message.SourceQueue
+		}
+
+		if target == "" {
+			result.Errors = append(result.Errors, fmt.Errorf("message %s | %w", message.ID, ErrMissingTarget))
+			return false, nil
+		}
+
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return false, err
+			}
+		}
+
+		if _, err := m.broker.Publish(ctx, target, message.redriveMessage()); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("message %s | %s", message.ID, err.Error()))
+			return false, nil
+		}
+
+		if err := m.broker.Ack(ctx, message.Delivery); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("message %s was redriven but not deleted | %s", message.ID, err.Error()))
+			return false, nil
+		}
+
+		result.Succeeded++
+		return true, nil
+	})
+
+	return result, err
+}
+
+// Purge deletes up to limit messages matching filter from the dead-letter queue. Zero purges every
+// matching message.
+func (m *Manager) Purge(ctx context.Context, filter Filter, limit int) (Result, error) {
+	var result Result
+	err := m.scan(ctx, filter, limit, func(message *Message) (bool, error) {
+		result.Matched++
+
+		if err := m.broker.Ack(ctx, message.Delivery); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("message %s | %s", message.ID, err.Error()))
+			return false, nil
+		}
+
+		result.Succeeded++
+		return true, nil
+	})
+
+	return result, err
+}
+
+// scan receives the messages of the queue and calls visit with up to limit messages matching filter.
+// visit returns true if it removed the message from the queue. Every other received message is made
+// visible again once the scan ends. An error returned by visit stops the scan.
+func (m *Manager) scan(ctx context.Context, filter Filter, limit int, visit func(*Message) (bool, error)) error {
+	if filter == nil {
+		filter = Any
+	}
+
+	var held []*broker.Delivery
+	defer func() {
+		releaseCtx := context.WithoutCancel(ctx)
+		for _, delivery := range held {
+			_ = m.broker.Nack(releaseCtx, delivery, 0)
+		}
+	}()
+
+	seen := make(map[string]bool)
+	matched := 0
+
+	for limit <= 0 || matched < limit {
+		deliveries, err := m.broker.Receive(ctx, m.queue, broker.ReceiveOptions{
+			MaxMessages:       maxReceiveMessages,
+			WaitTime:          m.waitTime,
+			VisibilityTimeout: m.visibilityTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to receive messages from %s | %s", m.queue, err.Error())
+		}
+
+		fresh := 0
+		for i, delivery := range deliveries {
+			if seen[delivery.ID] {
+				held = append(held, delivery)
+				continue
+			}
+			seen[delivery.ID] = true
+			fresh++
+
+			message := newMessage(delivery)
+			if (limit > 0 && matched >= limit) || !filter(message) {
+				held = append(held, delivery)
+				continue
+			}
+			matched++
+
+			removed, err := visit(message)
+			if !removed {
+				held = append(held, delivery)
+			}
+
+			if err != nil {
+				held = append(held, deliveries[i+1:]...)
+				return err
+			}
+		}
+
+		if fresh == 0 {
+			return nil
+		}
+	}
+
+	return nil
+}
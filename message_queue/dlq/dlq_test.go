@@ -0,0 +1,218 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDLQ    = "orders-dlq"
+	testSource = "orders"
+)
+
+func failed(body, messageType, reason string) broker.Message {
+	return broker.Message{
+		Body: body,
+		Attributes: map[string]string{
+			envelope.MessageTypeAttribute:       messageType,
+			consumer.FailureReasonAttribute:     reason,
+			consumer.FailureCountAttribute:      "5",
+			consumer.FailedAtAttribute:          "2026-01-02T03:04:05Z",
+			consumer.SourceQueueAttribute:       testSource,
+			consumer.OriginalMessageIDAttribute: "original-" + body,
+			"tenant":                            "acme",
+			"priority":                          "3",
+		},
+		AttributeTypes: map[string]string{
+			consumer.FailureCountAttribute: "Number",
+			"priority":                     "Number",
+		},
+	}
+}
+
+func newTestManager(t *testing.T, messages ...broker.Message) (*Manager, *broker.MemoryBroker) {
+	t.Helper()
+
+	b := broker.NewMemoryBroker()
+	for _, message := range messages {
+		_, err := b.Publish(context.Background(), testDLQ, message)
+		require.NoError(t, err)
+	}
+
+	m, err := New(b, testDLQ, WithWaitTime(0), WithVisibilityTimeout(time.Minute))
+	require.NoError(t, err)
+	return m, b
+}
+
+func bodies(messages []*Message) []string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Body)
+	}
+	return out
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, testDLQ)
+	assert.Error(t, err)
+	_, err = New(broker.NewMemoryBroker(), "")
+	assert.Error(t, err)
+}
+
+func TestList(t *testing.T) {
+	m, b := newTestManager(t,
+		failed("a", "billing.v1.Invoice", "timeout"),
+		failed("b", "users.v1.Delete", "not found"),
+		failed("c", "billing.v1.Invoice", "validation failed"),
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		limit  int
+		want   []string
+	}{
+		{name: "all", filter: nil, want: []string{"a", "b", "c"}},
+		{name: "limit", filter: Any, limit: 2, want: []string{"a", "b"}},
+		{name: "type", filter: TypeIs("billing.v1.Invoice"), want: []string{"a", "c"}},
+		{name: "reason", filter: FailureReasonContains("not found"), want: []string{"b"}},
+		{name: "attribute", filter: AttributeEquals("tenant", "other"), want: nil},
+		{name: "combined", filter: All(TypeIs("billing.v1.Invoice"), FailureReasonContains("timeout")), want: []string{"a"}},
+		{name: "failed before", filter: FailedBefore(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)), want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := m.List(ctx, tt.filter, tt.limit)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, bodies(messages))
+
+			// listing leaves the messages visible in the queue
+			assert.Len(t, b.Messages(testDLQ), 3)
+		})
+	}
+
+	messages, err := m.List(ctx, TypeIs("users.v1.Delete"), 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "not found", messages[0].FailureReason)
+	assert.Equal(t, 5, messages[0].FailureCount)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), messages[0].FailedAt)
+	assert.Equal(t, testSource, messages[0].SourceQueue)
+	assert.Equal(t, "original-b", messages[0].OriginalMessageID)
+}
+
+func TestRedrive(t *testing.T) {
+	m, b := newTestManager(t,
+		failed("a", "billing.v1.Invoice", "timeout"),
+		failed("b", "users.v1.Delete", "not found"),
+		broker.Message{Body: "no-source"},
+	)
+	ctx := context.Background()
+
+	result, err := m.Redrive(ctx, TypeIs("billing.v1.Invoice"), RedriveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Matched: 1, Succeeded: 1}, result)
+
+	redriven := b.Messages(testSource)
+	require.Len(t, redriven, 1)
+	assert.Equal(t, "a", redriven[0].Body)
+	assert.Equal(t, map[string]string{
+		envelope.MessageTypeAttribute: "billing.v1.Invoice",
+		"tenant":                      "acme",
+		"priority":                    "3",
+	}, redriven[0].Attributes)
+	assert.Equal(t, map[string]string{"priority": "Number"}, redriven[0].AttributeTypes)
+	assert.Len(t, b.Messages(testDLQ), 2)
+
+	// messages without source queue need a target
+	result, err = m.Redrive(ctx, nil, RedriveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Matched)
+	assert.Equal(t, 1, result.Succeeded)
+	require.Len(t, result.Errors, 1)
+	assert.True(t, errors.Is(result.Errors[0], ErrMissingTarget))
+	assert.Len(t, b.Messages(testDLQ), 1)
+
+	result, err = m.Redrive(ctx, nil, RedriveOptions{Target: "replay"})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Matched: 1, Succeeded: 1}, result)
+	assert.Equal(t, 0, b.Len(testDLQ))
+	assert.Equal(t, 1, b.Len("replay"))
+}
+
+func TestRedriveRateLimit(t *testing.T) {
+	m, b := newTestManager(t,
+		failed("a", "t", "r"),
+		failed("b", "t", "r"),
+		failed("c", "t", "r"),
+	)
+
+	start := time.Now()
+	result, err := m.Redrive(context.Background(), nil, RedriveOptions{RatePerSecond: 20})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Succeeded)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	assert.Equal(t, 3, b.Len(testSource))
+
+	// a cancelled context stops the redrive and releases the messages
+	m, b = newTestManager(t, failed("a", "t", "r"), failed("b", "t", "r"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Redrive(ctx, nil, RedriveOptions{RatePerSecond: 1})
+	assert.Error(t, err)
+	assert.Equal(t, 2, b.Len(testDLQ))
+}
+
+func TestRedriveFIFO(t *testing.T) {
+	b := broker.NewMemoryBroker()
+	ctx := context.Background()
+	msg := failed("a", "t", "r")
+	msg.GroupID = "account-42"
+	msg.DeduplicationID = "a"
+	msg.Attributes[consumer.SourceQueueAttribute] = "orders.fifo"
+	_, err := b.Publish(ctx, "orders-dlq.fifo", msg)
+	require.NoError(t, err)
+
+	m, err := New(b, "orders-dlq.fifo", WithWaitTime(0))
+	require.NoError(t, err)
+
+	result, err := m.Redrive(ctx, nil, RedriveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Succeeded)
+
+	redriven := b.Messages("orders.fifo")
+	require.Len(t, redriven, 1)
+	assert.Equal(t, "account-42", redriven[0].GroupID)
+}
+
+func TestPurge(t *testing.T) {
+	m, b := newTestManager(t,
+		failed("a", "billing.v1.Invoice", "timeout"),
+		failed("b", "users.v1.Delete", "not found"),
+		failed("c", "billing.v1.Invoice", "timeout"),
+	)
+	ctx := context.Background()
+
+	result, err := m.Purge(ctx, FailureReasonContains("timeout"), 1)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Matched: 1, Succeeded: 1}, result)
+	assert.Equal(t, 2, b.Len(testDLQ))
+
+	result, err = m.Purge(ctx, FailureReasonContains("timeout"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Matched: 1, Succeeded: 1}, result)
+
+	remaining, err := m.List(ctx, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, bodies(remaining))
+}
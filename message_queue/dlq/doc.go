@@ -0,0 +1,9 @@
+/*
+Package dlq inspects, redrives and purges the messages of a dead-letter queue. It works on any
+broker.Broker: SQS, an SQS compatible local stand-in such as ElasticMQ or LocalStack, or the
+in-memory broker used in tests.
+
+Messages moved to the DLQ by the consumer carry their failure metadata as attributes, which is
+exposed on Message and used to redrive messages back to the queue they came from.
+*/
+package dlq // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/dlq"
@@ -0,0 +1,140 @@
+package dlq // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/dlq"
+
+import (
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/broker"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
+)
+
+// failureAttributes are the attributes added by the consumer when it moves a message to the DLQ. They
+// are removed from redriven messages so that the retries start over.
+var failureAttributes = []string{
+	consumer.FailureReasonAttribute,
+	consumer.FailureCountAttribute,
+	consumer.FailedAtAttribute,
+	consumer.SourceQueueAttribute,
+	consumer.OriginalMessageIDAttribute,
+}
+
+// Message is a message of the dead-letter queue with its failure metadata. The metadata is empty for
+// messages moved by the redrive policy of the queue rather than by the consumer.
+type Message struct {
+	*broker.Delivery
+	// Type is the envelope message type, if any
+	Type string
+	// FailureReason is the error returned by the handler on the last attempt
+	FailureReason string
+	// FailureCount is the number of times the message was received before it was moved
+	FailureCount int
+	// FailedAt is the time the message was moved to the DLQ
+	FailedAt time.Time
+	// SourceQueue is the queue the message was consumed from
+	SourceQueue string
+	// OriginalMessageID is the id of the message in the source queue
+	OriginalMessageID string
+}
+
+// newMessage reads the failure metadata of a delivery
+func newMessage(delivery *broker.Delivery) *Message {
+	attributes := delivery.Attributes
+	message := &Message{
+		Delivery:          delivery,
+		Type:              attributes[envelope.MessageTypeAttribute],
+		FailureReason:     attributes[consumer.FailureReasonAttribute],
+		SourceQueue:       attributes[consumer.SourceQueueAttribute],
+		OriginalMessageID: attributes[consumer.OriginalMessageIDAttribute],
+	}
+
+	if count, err := strconv.Atoi(attributes[consumer.FailureCountAttribute]); err == nil {
+		message.FailureCount = count
+	}
+
+	if failedAt, err := time.Parse(time.RFC3339, attributes[consumer.FailedAtAttribute]); err == nil {
+		message.FailedAt = failedAt
+	}
+
+	return message
+}
+
+// redriveMessage returns the message to publish to the source queue, without the failure metadata
+func (m *Message) redriveMessage() broker.Message {
+	attributes := make(map[string]string, len(m.Attributes))
+	for name, value := range m.Attributes {
+		attributes[name] = value
+	}
+
+	// the data types are kept so that Number and Binary attributes are not republished as strings
+	types := maps.Clone(m.AttributeTypes)
+
+	for _, name := range failureAttributes {
+		delete(attributes, name)
+		delete(types, name)
+	}
+
+	msg := broker.Message{
+		Body:           m.Body,
+		Attributes:     attributes,
+		AttributeTypes: types,
+		GroupID:        m.GroupID,
+	}
+
+	if m.GroupID != "" {
+		// the same message redriven twice within the deduplication interval is sent once
+		msg.DeduplicationID = m.ID
+	}
+
+	return msg
+}
+
+// Filter selects messages
+type Filter func(m *Message) bool
+
+// Any matches every message
+func Any(*Message) bool { return true }
+
+// AttributeEquals matches messages whose attribute has the value
+func AttributeEquals(name, value string) Filter {
+	return func(m *Message) bool {
+		v, ok := m.Attributes[name]
+		return ok && v == value
+	}
+}
+
+// TypeIs matches messages of the envelope message type
+func TypeIs(messageType string) Filter {
+	return func(m *Message) bool {
+		return m.Type == messageType
+	}
+}
+
+// FailureReasonContains matches messages whose failure reason contains s
+func FailureReasonContains(s string) Filter {
+	return func(m *Message) bool {
+		return strings.Contains(m.FailureReason, s)
+	}
+}
+
+// FailedBefore matches messages moved to the DLQ before t
+func FailedBefore(t time.Time) Filter {
+	return func(m *Message) bool {
+		return !m.FailedAt.IsZero() && m.FailedAt.Before(t)
+	}
+}
+
+// All matches messages matching every filter
+func All(filters ...Filter) Filter {
+	return func(m *Message) bool {
+		for _, filter := range filters {
+			if filter != nil && !filter(m) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
@@ -0,0 +1,8 @@
+/*
+Package envelope wraps protobuf messages sent over SQS in a typed envelope. The message type, schema
+version, producer, trace id and publish time travel as message attributes, and the payload is the
+encoded protobuf message. Publish sends typed messages and Router dispatches received messages to
+typed handlers by message type. Messages implementing Validate() error, as generated by
+protoc-gen-validate, are validated both when they are published and when they are received.
+*/
+package envelope // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
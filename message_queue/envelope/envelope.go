@@ -0,0 +1,211 @@
+package envelope // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message attributes carrying the envelope metadata
+const (
+	// MessageTypeAttribute holds the fully qualified protobuf name of the payload
+	MessageTypeAttribute = "x-message-type"
+	// SchemaVersionAttribute holds the schema version of the payload
+	SchemaVersionAttribute = "x-schema-version"
+	// ProducerAttribute holds the name of the service that published the message
+	ProducerAttribute = "x-producer"
+	// TraceIDAttribute holds the distributed trace id active when the message was published
+	TraceIDAttribute = "x-trace-id"
+	// PublishedAtAttribute holds the RFC3339 publish time
+	PublishedAtAttribute = "x-published-at"
+	// ContentTypeAttribute holds the encoding of the message body
+	ContentTypeAttribute = "x-content-type"
+)
+
+// ContentType is the encoding of the message body
+type ContentType string
+
+const (
+	// ContentTypeProtobuf bodies are base64 encoded protobuf binaries. SQS bodies must be valid text.
+	ContentTypeProtobuf ContentType = "application/x-protobuf+base64"
+	// ContentTypeJSON bodies are protojson documents, useful when humans inspect the queue
+	ContentTypeJSON ContentType = "application/json"
+)
+
+var (
+	// ErrMissingMessageType is returned when a message has no message type attribute
+	ErrMissingMessageType = errors.New("message has no message type attribute")
+	// ErrUnknownMessageType is returned when no handler is registered for the message type
+	ErrUnknownMessageType = errors.New("unknown message type")
+	// ErrInvalidMessage is returned when a message fails its Validate() rules
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrTooManyAttributes is returned when the envelope and caller attributes exceed the SQS limit
+	ErrTooManyAttributes = errors.New("too many message attributes")
+)
+
+// validator is implemented by messages generated with protoc-gen-validate
+type validator interface {
+	Validate() error
+}
+
+// Envelope holds the metadata of a message and its encoded payload
+type Envelope struct {
+	// MessageID is the SQS message id, set on received envelopes only
+	MessageID string
+	// Type is the fully qualified protobuf name of the payload
+	Type string
+	// SchemaVersion is the version of the payload schema
+	SchemaVersion int
+	// Producer is the name of the service that published the message
+	Producer string
+	// TraceID is the distributed trace id active when the message was published
+	TraceID string
+	// PublishedAt is the time the message was published
+	PublishedAt time.Time
+	// ContentType is the encoding of Payload
+	ContentType ContentType
+	// Payload is the encoded message body
+	Payload string
+	// Attributes holds the message attributes that are not part of the envelope
+	Attributes map[string]*sqs.MessageAttributeValue
+}
+
+// TypeOf returns the message type of a protobuf message
+func TypeOf(msg proto.Message) string {
+	return string(msg.ProtoReflect().Descriptor().FullName())
+}
+
+// validate runs the protoc-gen-validate rules of msg if it has any
+func validate(msg proto.Message) error {
+	if v, ok := msg.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%w: %s | %s", ErrInvalidMessage, TypeOf(msg), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// encode serializes msg with the content type
+func encode(msg proto.Message, contentType ContentType) (string, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		body, err := protojson.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	case ContentTypeProtobuf:
+		body, err := proto.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(body), nil
+	default:
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// Decode unmarshals the payload of the envelope into msg and validates it
+func (e *Envelope) Decode(msg proto.Message) error {
+	if e.Type != TypeOf(msg) {
+		return fmt.Errorf("%w: envelope holds %s, not %s", ErrUnknownMessageType, e.Type, TypeOf(msg))
+	}
+
+	switch e.ContentType {
+	case ContentTypeJSON:
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(e.Payload), msg); err != nil {
+			return fmt.Errorf("failed decoding %s | %s", e.Type, err.Error())
+		}
+	case ContentTypeProtobuf, "":
+		body, err := base64.StdEncoding.DecodeString(e.Payload)
+		if err != nil {
+			return fmt.Errorf("failed decoding %s | %s", e.Type, err.Error())
+		}
+
+		if err := proto.Unmarshal(body, msg); err != nil {
+			return fmt.Errorf("failed decoding %s | %s", e.Type, err.Error())
+		}
+	default:
+		return fmt.Errorf("unsupported content type %q", e.ContentType)
+	}
+
+	return validate(msg)
+}
+
+// attributes returns the SQS message attributes of the envelope
+func (e *Envelope) attributes() map[string]*sqs.MessageAttributeValue {
+	attributes := make(map[string]*sqs.MessageAttributeValue, len(e.Attributes)+6)
+	for name, value := range e.Attributes {
+		attributes[name] = value
+	}
+
+	set := func(name, dataType, value string) {
+		if value != "" {
+			attributes[name] = &sqs.MessageAttributeValue{
+				DataType:    aws.String(dataType),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+
+	set(MessageTypeAttribute, "String", e.Type)
+	set(SchemaVersionAttribute, "Number", strconv.Itoa(e.SchemaVersion))
+	set(ProducerAttribute, "String", e.Producer)
+	set(TraceIDAttribute, "String", e.TraceID)
+	set(PublishedAtAttribute, "String", e.PublishedAt.UTC().Format(time.RFC3339Nano))
+	set(ContentTypeAttribute, "String", string(e.ContentType))
+
+	return attributes
+}
+
+// FromSQS reads the envelope of a received SQS message
+func FromSQS(message *sqs.Message) (*Envelope, error) {
+	e := &Envelope{
+		MessageID:  aws.StringValue(message.MessageId),
+		Payload:    aws.StringValue(message.Body),
+		Attributes: make(map[string]*sqs.MessageAttributeValue),
+	}
+
+	for name, value := range message.MessageAttributes {
+		v := aws.StringValue(value.StringValue)
+
+		switch name {
+		case MessageTypeAttribute:
+			e.Type = v
+		case SchemaVersionAttribute:
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schema version %q | %s", v, err.Error())
+			}
+			e.SchemaVersion = version
+		case ProducerAttribute:
+			e.Producer = v
+		case TraceIDAttribute:
+			e.TraceID = v
+		case PublishedAtAttribute:
+			publishedAt, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid publish time %q | %s", v, err.Error())
+			}
+			e.PublishedAt = publishedAt
+		case ContentTypeAttribute:
+			e.ContentType = ContentType(v)
+		default:
+			e.Attributes[name] = value
+		}
+	}
+
+	if e.Type == "" {
+		return nil, ErrMissingMessageType
+	}
+
+	return e, nil
+}
@@ -0,0 +1,144 @@
+package envelope
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	msgv1 "github.com/SolomonAIEngineering/backend-core-library/message_queue/generated/message-definition/message_definition/v1"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTypeOf(t *testing.T) {
+	assert.Equal(t, "message_definition.v1.DeleteAccountMessageFormat", TypeOf(&msgv1.DeleteAccountMessageFormat{}))
+	assert.Equal(t, "message_definition.v1.AlgoliaSearchRecordFormat", TypeOf(&msgv1.AlgoliaSearchRecordFormat{}))
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	msg := &msgv1.DeleteAccountMessageFormat{
+		AuthZeroId:  "auth0|123",
+		Email:       "user@example.com",
+		UserId:      42,
+		ProfileType: msgv1.DeleteAccountMessageFormat_PROFILE_TYPE_USER,
+	}
+	publishedAt := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	for _, contentType := range []ContentType{ContentTypeProtobuf, ContentTypeJSON} {
+		t.Run(string(contentType), func(t *testing.T) {
+			body, err := encode(msg, contentType)
+			require.NoError(t, err)
+
+			e := &Envelope{
+				Type:          TypeOf(msg),
+				SchemaVersion: 2,
+				Producer:      "user-service",
+				TraceID:       "trace",
+				PublishedAt:   publishedAt,
+				ContentType:   contentType,
+				Payload:       body,
+				Attributes: map[string]*sqs.MessageAttributeValue{
+					"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+				},
+			}
+
+			received, err := FromSQS(&sqs.Message{
+				MessageId:         aws.String("id-1"),
+				Body:              aws.String(e.Payload),
+				MessageAttributes: e.attributes(),
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, "id-1", received.MessageID)
+			assert.Equal(t, e.Type, received.Type)
+			assert.Equal(t, 2, received.SchemaVersion)
+			assert.Equal(t, "user-service", received.Producer)
+			assert.Equal(t, "trace", received.TraceID)
+			assert.True(t, publishedAt.Equal(received.PublishedAt))
+			assert.Equal(t, contentType, received.ContentType)
+			assert.Equal(t, e.Attributes, received.Attributes)
+
+			decoded := &msgv1.DeleteAccountMessageFormat{}
+			require.NoError(t, received.Decode(decoded))
+			assert.True(t, proto.Equal(msg, decoded))
+		})
+	}
+}
+
+func TestFromSQS_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]*sqs.MessageAttributeValue
+		wantErr    error
+	}{
+		{
+			name:    "missing type",
+			wantErr: ErrMissingMessageType,
+		},
+		{
+			name: "malformed schema version",
+			attributes: map[string]*sqs.MessageAttributeValue{
+				MessageTypeAttribute:   {StringValue: aws.String("a.B")},
+				SchemaVersionAttribute: {StringValue: aws.String("v1")},
+			},
+		},
+		{
+			name: "malformed publish time",
+			attributes: map[string]*sqs.MessageAttributeValue{
+				MessageTypeAttribute: {StringValue: aws.String("a.B")},
+				PublishedAtAttribute: {StringValue: aws.String("yesterday")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromSQS(&sqs.Message{Body: aws.String(""), MessageAttributes: tt.attributes})
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnvelope_Decode(t *testing.T) {
+	invalid, err := encode(&msgv1.DeleteAccountMessageFormat{Email: "not an email"}, ContentTypeProtobuf)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		envelope *Envelope
+		wantErr  error
+	}{
+		{
+			name:     "type mismatch",
+			envelope: &Envelope{Type: TypeOf(&msgv1.AlgoliaSearchRecordFormat{}), ContentType: ContentTypeProtobuf},
+			wantErr:  ErrUnknownMessageType,
+		},
+		{
+			name:     "fails validation",
+			envelope: &Envelope{Type: TypeOf(&msgv1.DeleteAccountMessageFormat{}), ContentType: ContentTypeProtobuf, Payload: invalid},
+			wantErr:  ErrInvalidMessage,
+		},
+		{
+			name:     "malformed payload",
+			envelope: &Envelope{Type: TypeOf(&msgv1.DeleteAccountMessageFormat{}), ContentType: ContentTypeProtobuf, Payload: "%%%"},
+		},
+		{
+			name:     "unsupported content type",
+			envelope: &Envelope{Type: TypeOf(&msgv1.DeleteAccountMessageFormat{}), ContentType: "text/xml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.envelope.Decode(&msgv1.DeleteAccountMessageFormat{})
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package envelope // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sender is the subset of the SQS API used to publish messages
+type Sender interface {
+	SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error)
+}
+
+// Publisher publishes enveloped protobuf messages to a queue
+type Publisher struct {
+	sender         Sender
+	queueURL       string
+	producer       string
+	contentType    ContentType
+	schemaVersions map[string]int
+	now            func() time.Time
+}
+
+// PublisherOption configures a Publisher
+type PublisherOption func(*Publisher)
+
+// WithProducer sets the producer name added to every message
+func WithProducer(name string) PublisherOption {
+	return func(p *Publisher) {
+		p.producer = name
+	}
+}
+
+// WithContentType sets the encoding of message bodies. Defaults to ContentTypeProtobuf.
+func WithContentType(contentType ContentType) PublisherOption {
+	return func(p *Publisher) {
+		p.contentType = contentType
+	}
+}
+
+// WithSchemaVersion sets the schema version published for a message type. Types without a version
+// are published as version 1.
+func WithSchemaVersion(msg proto.Message, version int) PublisherOption {
+	return func(p *Publisher) {
+		p.schemaVersions[TypeOf(msg)] = version
+	}
+}
+
+// NewPublisher creates a publisher sending to queueURL
+func NewPublisher(sender Sender, queueURL string, opts ...PublisherOption) (*Publisher, error) {
+	p := &Publisher{
+		sender:         sender,
+		queueURL:       queueURL,
+		contentType:    ContentTypeProtobuf,
+		schemaVersions: make(map[string]int),
+		now:            time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Validate checks the publisher configuration
+func (p *Publisher) Validate() error {
+	if p.sender == nil || p.queueURL == "" || p.producer == "" {
+		return fmt.Errorf("invalid publisher. sender, queue url and producer are required")
+	}
+
+	if p.contentType != ContentTypeProtobuf && p.contentType != ContentTypeJSON {
+		return fmt.Errorf("invalid publisher. unsupported content type %q", p.contentType)
+	}
+
+	return nil
+}
+
+// PublishOption configures a single publish call
+type PublishOption func(*publishConfig)
+
+type publishConfig struct {
+	attributes   map[string]*sqs.MessageAttributeValue
+	delaySeconds int64
+}
+
+// WithAttribute adds a string message attribute
+func WithAttribute(name, value string) PublishOption {
+	return func(c *publishConfig) {
+		c.attributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+}
+
+// WithDelay delays the delivery of the message
+func WithDelay(delay time.Duration) PublishOption {
+	return func(c *publishConfig) {
+		c.delaySeconds = int64(delay / time.Second)
+	}
+}
+
+// Publish validates msg, wraps it in an envelope and sends it. It returns the SQS message id.
+func Publish[T proto.Message](ctx context.Context, p *Publisher, msg T, opts ...PublishOption) (string, error) {
+	input, err := p.prepare(ctx, msg, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := p.sender.SendMessageWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed publishing %s | %s", TypeOf(msg), err.Error())
+	}
+
+	return aws.StringValue(res.MessageId), nil
+}
+
+// prepare builds the send request of msg
+func (p *Publisher) prepare(ctx context.Context, msg proto.Message, opts ...PublishOption) (*sqs.SendMessageInput, error) {
+	if err := validate(msg); err != nil {
+		return nil, err
+	}
+
+	cfg := &publishConfig{attributes: make(map[string]*sqs.MessageAttributeValue)}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	body, err := encode(msg, p.contentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding %s | %s", TypeOf(msg), err.Error())
+	}
+
+	e := &Envelope{
+		Type:          TypeOf(msg),
+		SchemaVersion: p.schemaVersion(msg),
+		Producer:      p.producer,
+		TraceID:       traceID(ctx),
+		PublishedAt:   p.now(),
+		ContentType:   p.contentType,
+		Payload:       body,
+		Attributes:    cfg.attributes,
+	}
+
+	attributes := e.attributes()
+	if len(attributes) > sqslimits.MaxMessageAttributes {
+		return nil, fmt.Errorf("%w: %s has %d attributes, SQS accepts %d", ErrTooManyAttributes, TypeOf(msg), len(attributes), sqslimits.MaxMessageAttributes)
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.queueURL),
+		MessageBody: aws.String(e.Payload),
+		// the trace context is only added while there is room left within the SQS limit
+		MessageAttributes: tracing.Inject(ctx, attributes),
+	}
+
+	if cfg.delaySeconds > 0 {
+		input.DelaySeconds = aws.Int64(cfg.delaySeconds)
+	}
+
+	return input, nil
+}
+
+// schemaVersion returns the configured schema version of the message type
+func (p *Publisher) schemaVersion(msg proto.Message) int {
+	if version, ok := p.schemaVersions[TypeOf(msg)]; ok {
+		return version
+	}
+
+	return 1
+}
+
+// traceID returns the id of the distributed trace in ctx, if any
+func traceID(ctx context.Context) string {
+	txn := newrelic.FromContext(ctx)
+	if txn == nil {
+		return ""
+	}
+
+	return txn.GetTraceMetadata().TraceID
+}
@@ -0,0 +1,119 @@
+package envelope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	msgv1 "github.com/SolomonAIEngineering/backend-core-library/message_queue/generated/message-definition/message_definition/v1"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSender struct {
+	inputs []*sqs.SendMessageInput
+	err    error
+}
+
+func (f *fakeSender) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	f.inputs = append(f.inputs, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("id-1")}, nil
+}
+
+func TestNewPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		sender  Sender
+		queue   string
+		opts    []PublisherOption
+		wantErr bool
+	}{
+		{name: "valid", sender: &fakeSender{}, queue: "queue", opts: []PublisherOption{WithProducer("svc")}},
+		{name: "missing producer", sender: &fakeSender{}, queue: "queue", wantErr: true},
+		{name: "missing sender", queue: "queue", opts: []PublisherOption{WithProducer("svc")}, wantErr: true},
+		{name: "missing queue", sender: &fakeSender{}, opts: []PublisherOption{WithProducer("svc")}, wantErr: true},
+		{name: "unsupported content type", sender: &fakeSender{}, queue: "queue", opts: []PublisherOption{WithProducer("svc"), WithContentType("text/xml")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPublisher(tt.sender, tt.queue, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewPublisher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	sender := &fakeSender{}
+	publisher, err := NewPublisher(sender, "queue",
+		WithProducer("user-service"),
+		WithSchemaVersion(&msgv1.DeleteAccountMessageFormat{}, 3))
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	publisher.now = func() time.Time { return now }
+
+	msg := &msgv1.DeleteAccountMessageFormat{Email: "user@example.com", UserId: 7}
+	id, err := Publish(context.Background(), publisher, msg, WithAttribute("tenant", "acme"), WithDelay(5*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", id)
+
+	require.Len(t, sender.inputs, 1)
+	input := sender.inputs[0]
+	assert.Equal(t, "queue", *input.QueueUrl)
+	assert.Equal(t, int64(5), *input.DelaySeconds)
+
+	attributes := input.MessageAttributes
+	assert.Equal(t, TypeOf(msg), *attributes[MessageTypeAttribute].StringValue)
+	assert.Equal(t, "3", *attributes[SchemaVersionAttribute].StringValue)
+	assert.Equal(t, "user-service", *attributes[ProducerAttribute].StringValue)
+	assert.Equal(t, now.Format(time.RFC3339Nano), *attributes[PublishedAtAttribute].StringValue)
+	assert.Equal(t, string(ContentTypeProtobuf), *attributes[ContentTypeAttribute].StringValue)
+	assert.Equal(t, "acme", *attributes["tenant"].StringValue)
+	assert.NotContains(t, attributes, TraceIDAttribute)
+}
+
+func TestPublish_Errors(t *testing.T) {
+	t.Run("invalid message is not sent", func(t *testing.T) {
+		sender := &fakeSender{}
+		publisher, err := NewPublisher(sender, "queue", WithProducer("svc"))
+		require.NoError(t, err)
+
+		_, err = Publish(context.Background(), publisher, &msgv1.DeleteAccountMessageFormat{Email: "invalid"})
+		assert.ErrorIs(t, err, ErrInvalidMessage)
+		assert.Empty(t, sender.inputs)
+	})
+
+	t.Run("too many attributes", func(t *testing.T) {
+		sender := &fakeSender{}
+		publisher, err := NewPublisher(sender, "queue", WithProducer("svc"))
+		require.NoError(t, err)
+
+		opts := make([]PublishOption, 0, 6)
+		for i := 0; i < 6; i++ {
+			opts = append(opts, WithAttribute(fmt.Sprintf("attribute-%d", i), "value"))
+		}
+
+		_, err = Publish(context.Background(), publisher, &msgv1.DeleteAccountMessageFormat{Email: "user@example.com"}, opts...)
+		assert.ErrorIs(t, err, ErrTooManyAttributes)
+		assert.Empty(t, sender.inputs)
+	})
+
+	t.Run("send failure", func(t *testing.T) {
+		publisher, err := NewPublisher(&fakeSender{err: errors.New("boom")}, "queue", WithProducer("svc"))
+		require.NoError(t, err)
+
+		_, err = Publish(context.Background(), publisher, &msgv1.DeleteAccountMessageFormat{Email: "user@example.com"})
+		assert.Error(t, err)
+	})
+}
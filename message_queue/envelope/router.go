@@ -0,0 +1,101 @@
+package envelope // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/envelope"
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"google.golang.org/protobuf/proto"
+)
+
+// HandlerFunc handles a decoded and validated message along with its envelope
+type HandlerFunc[T proto.Message] func(ctx context.Context, msg T, e *Envelope) error
+
+// route decodes a message of one type and calls its handler
+type route func(ctx context.Context, e *Envelope) error
+
+// Router dispatches received messages to the handler registered for their message type. Its Process
+// method is a consumer.MessageProcessorFunc, so a router can be used as the handler of a consumer.
+type Router struct {
+	mu       sync.RWMutex
+	routes   map[string]route
+	fallback func(ctx context.Context, e *Envelope) error
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]route)}
+}
+
+// Handle registers the handler of messages of type T. Registering a second handler for the same
+// type replaces the first one.
+func Handle[T proto.Message](r *Router, handler HandlerFunc[T]) {
+	var zero T
+	messageType := zero.ProtoReflect().Type()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes[string(messageType.Descriptor().FullName())] = func(ctx context.Context, e *Envelope) error {
+		msg := messageType.New().Interface().(T)
+		if err := e.Decode(msg); err != nil {
+			return consumer.Permanent(err)
+		}
+
+		return handler(ctx, msg, e)
+	}
+}
+
+// HandleUnknown registers the handler of messages without a registered type. Without it, such
+// messages fail permanently with ErrUnknownMessageType.
+func (r *Router) HandleUnknown(handler func(ctx context.Context, e *Envelope) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = handler
+}
+
+// Process reads the envelope of the message and calls the handler of its type. Messages that cannot
+// be decoded, fail validation or have no handler are returned as permanent errors so the consumer
+// moves them to the DLQ without retrying; handler errors are returned as is.
+func (r *Router) Process(ctx context.Context, message *sqs.Message) error {
+	e, err := FromSQS(message)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
+
+	r.mu.RLock()
+	handle, ok := r.routes[e.Type]
+	fallback := r.fallback
+	r.mu.RUnlock()
+
+	if !ok {
+		if fallback != nil {
+			return fallback(ctx, e)
+		}
+
+		return consumer.Permanent(fmt.Errorf("%w: %s", ErrUnknownMessageType, e.Type))
+	}
+
+	return handle(ctx, e)
+}
+
+// Types returns the sorted message types with a registered handler
+func (r *Router) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.routes))
+	for messageType := range r.routes {
+		types = append(types, messageType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Static check to ensure Process can be used as a consumer handler.
+var _ consumer.MessageProcessorFunc = (*Router)(nil).Process
@@ -0,0 +1,102 @@
+package envelope
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/consumer"
+	msgv1 "github.com/SolomonAIEngineering/backend-core-library/message_queue/generated/message-definition/message_definition/v1"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// publishToMessage publishes msg with a fake sender and returns it as a received SQS message
+func publishToMessage(t *testing.T, msg proto.Message) *sqs.Message {
+	t.Helper()
+
+	publisher, err := NewPublisher(&fakeSender{}, "queue", WithProducer("svc"))
+	require.NoError(t, err)
+
+	input, err := publisher.prepare(context.Background(), msg)
+	require.NoError(t, err)
+
+	return &sqs.Message{
+		MessageId:         aws.String("id-1"),
+		Body:              input.MessageBody,
+		MessageAttributes: input.MessageAttributes,
+	}
+}
+
+func TestRouter_Process(t *testing.T) {
+	router := NewRouter()
+
+	var deleted *msgv1.DeleteAccountMessageFormat
+	Handle(router, func(ctx context.Context, msg *msgv1.DeleteAccountMessageFormat, e *Envelope) error {
+		deleted = msg
+		assert.Equal(t, "svc", e.Producer)
+		return nil
+	})
+
+	handlerErr := errors.New("index unavailable")
+	Handle(router, func(ctx context.Context, msg *msgv1.AlgoliaSearchRecordFormat, e *Envelope) error {
+		return handlerErr
+	})
+
+	assert.Equal(t, []string{
+		"message_definition.v1.AlgoliaSearchRecordFormat",
+		"message_definition.v1.DeleteAccountMessageFormat",
+	}, router.Types())
+
+	t.Run("dispatches by type", func(t *testing.T) {
+		msg := &msgv1.DeleteAccountMessageFormat{Email: "user@example.com", UserId: 9}
+		require.NoError(t, router.Process(context.Background(), publishToMessage(t, msg)))
+		assert.True(t, proto.Equal(msg, deleted))
+	})
+
+	t.Run("handler errors are retryable", func(t *testing.T) {
+		err := router.Process(context.Background(), publishToMessage(t, &msgv1.AlgoliaSearchRecordFormat{Name: "n"}))
+		assert.ErrorIs(t, err, handlerErr)
+		assert.True(t, consumer.IsRetryable(err))
+	})
+
+	t.Run("invalid payload is permanent", func(t *testing.T) {
+		message := publishToMessage(t, &msgv1.DeleteAccountMessageFormat{Email: "user@example.com"})
+		message.Body = aws.String("%%%")
+
+		err := router.Process(context.Background(), message)
+		assert.False(t, consumer.IsRetryable(err))
+	})
+
+	t.Run("missing envelope is permanent", func(t *testing.T) {
+		err := router.Process(context.Background(), &sqs.Message{Body: aws.String("raw")})
+		assert.ErrorIs(t, err, ErrMissingMessageType)
+		assert.False(t, consumer.IsRetryable(err))
+	})
+
+	t.Run("unknown type is permanent", func(t *testing.T) {
+		message := publishToMessage(t, &msgv1.DeleteAccountMessageFormat{Email: "user@example.com"})
+		message.MessageAttributes[MessageTypeAttribute].StringValue = aws.String("other.v1.Message")
+
+		err := router.Process(context.Background(), message)
+		assert.ErrorIs(t, err, ErrUnknownMessageType)
+		assert.False(t, consumer.IsRetryable(err))
+	})
+}
+
+func TestRouter_HandleUnknown(t *testing.T) {
+	router := NewRouter()
+
+	var got string
+	router.HandleUnknown(func(ctx context.Context, e *Envelope) error {
+		got = e.Type
+		return nil
+	})
+
+	message := publishToMessage(t, &msgv1.DeleteAccountMessageFormat{Email: "user@example.com"})
+	require.NoError(t, router.Process(context.Background(), message))
+	assert.Equal(t, "message_definition.v1.DeleteAccountMessageFormat", got)
+}
@@ -0,0 +1,5 @@
+/*
+Package fifo provides helpers shared by the clients that send to and consume from SQS FIFO queues:
+queue detection, message group key extraction and deduplication ids.
+*/
+package fifo // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
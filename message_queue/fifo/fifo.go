@@ -0,0 +1,82 @@
+package fifo // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// queueSuffix is the suffix every FIFO queue name ends with
+	queueSuffix = ".fifo"
+	// maxIDLength is the maximum length of message group and deduplication ids
+	maxIDLength = 128
+)
+
+// ErrMissingGroupID is returned when a message sent to a FIFO queue has no message group id
+var ErrMissingGroupID = errors.New("message group id is required for FIFO queues")
+
+// GroupKeyFunc returns the message group id of a message. Messages of the same group are delivered
+// and processed in order, messages of different groups in parallel.
+type GroupKeyFunc func(body string, attributes map[string]*sqs.MessageAttributeValue) string
+
+// DeduplicationIDFunc returns the deduplication id of a message. Messages with the same id sent
+// within the 5 minute deduplication interval are delivered once.
+type DeduplicationIDFunc func(body string, attributes map[string]*sqs.MessageAttributeValue) string
+
+// IsQueue returns true if the queue url points to a FIFO queue
+func IsQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, queueSuffix)
+}
+
+// AttributeGroupKey groups messages by the value of a message attribute, such as an account id
+func AttributeGroupKey(name string) GroupKeyFunc {
+	return func(body string, attributes map[string]*sqs.MessageAttributeValue) string {
+		if value, ok := attributes[name]; ok {
+			return aws.StringValue(value.StringValue)
+		}
+
+		return ""
+	}
+}
+
+// StaticGroupKey puts every message in the same group, serializing the whole queue
+func StaticGroupKey(groupID string) GroupKeyFunc {
+	return func(string, map[string]*sqs.MessageAttributeValue) string {
+		return groupID
+	}
+}
+
+// ContentDeduplicationID derives the deduplication id from the SHA-256 hash of the body, like queues
+// with content based deduplication enabled do. Use it for queues where that setting is disabled.
+func ContentDeduplicationID(body string, _ map[string]*sqs.MessageAttributeValue) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateID checks that id can be used as a message group or deduplication id: at most 128
+// characters, all of them alphanumeric or punctuation.
+func ValidateID(id string) error {
+	if id == "" || len(id) > maxIDLength {
+		return fmt.Errorf("invalid id %q: length must be between 1 and %d", id, maxIDLength)
+	}
+
+	for _, r := range id {
+		if r > 126 || r < 33 {
+			return fmt.Errorf("invalid id %q: only alphanumeric and punctuation characters are allowed", id)
+		}
+	}
+
+	return nil
+}
+
+// GroupID returns the message group id of a received message, or an empty string if it was not
+// received from a FIFO queue
+func GroupID(message *sqs.Message) string {
+	return aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+}
@@ -0,0 +1,74 @@
+package fifo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		queueURL string
+		want     bool
+	}{
+		{name: "fifo", queueURL: "https://sqs.us-east-1.amazonaws.com/123/accounts.fifo", want: true},
+		{name: "standard", queueURL: "https://sqs.us-east-1.amazonaws.com/123/accounts", want: false},
+		{name: "empty", queueURL: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsQueue(tt.queueURL))
+		})
+	}
+}
+
+func TestGroupKeys(t *testing.T) {
+	attributes := map[string]*sqs.MessageAttributeValue{
+		"account_id": {DataType: aws.String("String"), StringValue: aws.String("42")},
+	}
+
+	assert.Equal(t, "42", AttributeGroupKey("account_id")("body", attributes))
+	assert.Equal(t, "", AttributeGroupKey("tenant")("body", attributes))
+	assert.Equal(t, "all", StaticGroupKey("all")("body", attributes))
+}
+
+func TestContentDeduplicationID(t *testing.T) {
+	first := ContentDeduplicationID("body", nil)
+	assert.Len(t, first, 64)
+	assert.Equal(t, first, ContentDeduplicationID("body", nil))
+	assert.NotEqual(t, first, ContentDeduplicationID("other body", nil))
+	assert.NoError(t, ValidateID(first))
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{name: "alphanumeric", id: "account-42"},
+		{name: "punctuation", id: "a!b#c{d}~"},
+		{name: "empty", id: "", wantErr: true},
+		{name: "too long", id: strings.Repeat("a", 129), wantErr: true},
+		{name: "space", id: "account 42", wantErr: true},
+		{name: "unicode", id: "compte-é", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateID(tt.id); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGroupID(t *testing.T) {
+	assert.Equal(t, "", GroupID(&sqs.Message{}))
+	assert.Equal(t, "g1", GroupID(&sqs.Message{Attributes: map[string]*string{
+		sqs.MessageSystemAttributeNameMessageGroupId: aws.String("g1"),
+	}}))
+}
@@ -0,0 +1,570 @@
+package producer // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/producer"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBatchEntries is the maximum number of entries SQS accepts per batch
+	maxBatchEntries = 10
+	// maxBatchBytes is the maximum total payload size SQS accepts per batch and per message
+	maxBatchBytes = 256 * 1024
+
+	defaultLinger = 50 * time.Millisecond
+)
+
+var (
+	// ErrMessageTooLarge is returned for messages whose body and attributes exceed 256KB
+	ErrMessageTooLarge = errors.New("message exceeds the 256KB SQS limit")
+	// ErrProducerClosed is returned for messages added after Close
+	ErrProducerClosed = errors.New("batch producer is closed")
+	// ErrPrecedingMessageFailed is returned for a FIFO message held back because a message sent
+	// before it in its group failed
+	ErrPrecedingMessageFailed = errors.New("a preceding message of the group failed")
+)
+
+// Message is a message sent by the BatchProducer
+type Message struct {
+	// Body is the message body
+	Body string
+	// Attributes are the message attributes
+	Attributes map[string]*sqs.MessageAttributeValue
+	// GroupID is the message group id for FIFO queues. Derived with the group key function if empty.
+	GroupID string
+	// DeduplicationID is the deduplication id for FIFO queues. Derived with the deduplication function
+	// if empty.
+	DeduplicationID string
+	// Delay postpones the delivery of the message on standard queues
+	Delay time.Duration
+}
+
+// size returns the size SQS accounts for the message: the body plus attribute names, types and values
+func (m *Message) size() int {
+	return claimcheck.Size(m.Body, m.Attributes)
+}
+
+// Result is the outcome of sending a single message
+type Result struct {
+	// MessageID is the SQS message id, set when the message was sent
+	MessageID string
+	// Err is set when the message could not be sent
+	Err error
+}
+
+// EntryError is the error SQS returned for a single entry of a batch
+type EntryError struct {
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("batch entry failed with %s: %s", e.Code, e.Message)
+}
+
+// BatchProducer accumulates messages and sends them with SendMessageBatch. Batches are split by the
+// SQS limits of 10 entries and 256KB, and are sent when full, when the linger time of the oldest
+// pending message elapses, or on Close. Entries that fail with a server side error are retried with
+// backoff; entries rejected because of the message itself fail right away. On FIFO queues batches
+// are sent one at a time, and a batch is only sent once the retries of the previous one are over.
+type BatchProducer struct {
+	producer       *ProducerClient
+	logger         *zap.Logger
+	linger         time.Duration
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu          sync.Mutex
+	pending     []*pendingMessage
+	pendingSize int
+	timer       *time.Timer
+	closed      bool
+	wg          sync.WaitGroup // tracks batches being sent
+
+	// queued holds the batches of a FIFO queue waiting for the sender, which sends them one after
+	// the other so that the messages of a group reach SQS in the order they were added
+	queued  [][]*pendingMessage
+	sending bool
+}
+
+// pendingMessage is a message waiting to be sent along with the channel its result is sent to
+type pendingMessage struct {
+	message Message
+	result  chan Result
+}
+
+// BatchOption configures a BatchProducer
+type BatchOption func(*BatchProducer)
+
+// WithLinger sets how long a message waits for the batch to fill up before it is sent
+func WithLinger(linger time.Duration) BatchOption {
+	return func(b *BatchProducer) {
+		b.linger = linger
+	}
+}
+
+// WithMaxRetries sets how many times failed entries are retried
+func WithMaxRetries(retries int) BatchOption {
+	return func(b *BatchProducer) {
+		b.maxRetries = retries
+	}
+}
+
+// WithRetryBackoff sets the initial and maximum backoff between retries of failed entries
+func WithRetryBackoff(initial, max time.Duration) BatchOption {
+	return func(b *BatchProducer) {
+		b.initialBackoff = initial
+		b.maxBackoff = max
+	}
+}
+
+// WithProducerOptions sets the options of the underlying producer, such as the FIFO group key and
+// deduplication functions
+func WithProducerOptions(opts ...Option) BatchOption {
+	return func(b *BatchProducer) {
+		for _, opt := range opts {
+			opt(b.producer)
+		}
+	}
+}
+
+// NewBatchProducer creates a batch producer sending to queueUrl
+func NewBatchProducer(sqsClient SQSAPI, queueUrl *string, logger *zap.Logger, opts ...BatchOption) *BatchProducer {
+	b := &BatchProducer{
+		producer:       NewProducerClient(sqsClient, queueUrl, logger),
+		logger:         logger,
+		linger:         defaultLinger,
+		maxRetries:     maxRetries,
+		initialBackoff: initialBackoffTime,
+		maxBackoff:     maxBackoffTime,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Add queues a message for sending and returns a channel receiving its result once the batch it
+// belongs to was sent. Large bodies are offloaded right away when a claim check is configured.
+func (b *BatchProducer) Add(message Message) <-chan Result {
+	return b.AddWithContext(context.Background(), message)
+}
+
+// AddWithContext is Add propagating the trace context of ctx to the message. The message is still
+// sent in the background and ctx does not bound the send.
+func (b *BatchProducer) AddWithContext(ctx context.Context, message Message) <-chan Result {
+	result := make(chan Result, 1)
+
+	message, err := b.prepare(ctx, message)
+	if err != nil {
+		result <- Result{Err: err}
+		return result
+	}
+
+	if message.size() > maxBatchBytes {
+		result <- Result{Err: ErrMessageTooLarge}
+		return result
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		result <- Result{Err: ErrProducerClosed}
+		return result
+	}
+
+	if b.pendingSize+message.size() > maxBatchBytes {
+		b.flushLocked()
+	}
+
+	b.pending = append(b.pending, &pendingMessage{message: message, result: result})
+	b.pendingSize += message.size()
+
+	switch {
+	case len(b.pending) >= maxBatchEntries || b.linger <= 0:
+		b.flushLocked()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.linger, b.Flush)
+	}
+
+	return result
+}
+
+// Flush sends the pending messages without waiting for the batch to fill up
+func (b *BatchProducer) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flushLocked()
+}
+
+// flushLocked sends the pending messages in the background. Batches of standard queues are sent
+// concurrently, while batches of FIFO queues are queued for a single sender. It must be called with
+// the lock held.
+func (b *BatchProducer) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	if len(b.pending) == 0 {
+		return
+	}
+
+	pending := b.pending
+	b.pending = nil
+	b.pendingSize = 0
+
+	if b.producer.isFIFO() {
+		b.queued = append(b.queued, pending)
+		if !b.sending {
+			b.sending = true
+			b.wg.Add(1)
+			go b.sendQueued()
+		}
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.send(pending)
+	}()
+}
+
+// sendQueued sends the queued FIFO batches in order, including their retries, until none is left
+func (b *BatchProducer) sendQueued() {
+	defer b.wg.Done()
+
+	for {
+		b.mu.Lock()
+		if len(b.queued) == 0 {
+			b.sending = false
+			b.mu.Unlock()
+			return
+		}
+
+		pending := b.queued[0]
+		b.queued = b.queued[1:]
+		b.mu.Unlock()
+
+		b.send(pending)
+	}
+}
+
+// send sends a batch of pending messages and delivers their results
+func (b *BatchProducer) send(pending []*pendingMessage) {
+	messages := make([]Message, len(pending))
+	for i, p := range pending {
+		messages[i] = p.message
+	}
+
+	for i, result := range b.sendChunk(context.Background(), messages) {
+		pending[i].result <- result
+	}
+}
+
+// Close sends the pending messages and waits until every batch was sent or ctx expires. Messages
+// added after Close fail with ErrProducerClosed.
+func (b *BatchProducer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.flushLocked()
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendBatch sends the messages synchronously, bypassing the accumulation, and returns their results
+// in order. Messages are split into chunks within the SQS limits and the chunks are sent one after
+// the other, which preserves the order of FIFO messages.
+func (b *BatchProducer) SendBatch(ctx context.Context, messages []Message) []Result {
+	results := make([]Result, len(messages))
+
+	// indices maps the prepared messages back to their position in messages
+	prepared := make([]Message, 0, len(messages))
+	indices := make([]int, 0, len(messages))
+	for i, message := range messages {
+		message, err := b.prepare(ctx, message)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		prepared = append(prepared, message)
+		indices = append(indices, i)
+	}
+
+	for _, chunk := range chunkMessages(prepared) {
+		batch := make([]Message, len(chunk))
+		for i, index := range chunk {
+			batch[i] = prepared[index]
+		}
+
+		for i, result := range b.sendChunk(ctx, batch) {
+			results[indices[chunk[i]]] = result
+		}
+	}
+
+	return results
+}
+
+// prepare offloads the body of large messages when a claim check is configured and adds the trace
+// context of ctx. The FIFO ids are derived first since they may depend on the original body.
+func (b *BatchProducer) prepare(ctx context.Context, message Message) (Message, error) {
+	if b.producer.offloader != nil {
+		if err := b.fifoFields(&message); err != nil {
+			return message, err
+		}
+
+		body, attributes, err := b.producer.offloader.Offload(ctx, message.Body, message.Attributes)
+		if err != nil {
+			return message, err
+		}
+
+		// the blob key replacing the body is random, so content based deduplication would not apply
+		if _, offloaded := claimcheck.Pointer(attributes); offloaded && b.producer.isFIFO() && message.DeduplicationID == "" {
+			message.DeduplicationID = fifo.ContentDeduplicationID(message.Body, nil)
+		}
+
+		message.Body = body
+		message.Attributes = attributes
+	}
+
+	message.Attributes = tracing.Inject(ctx, message.Attributes)
+	return message, nil
+}
+
+// chunkMessages splits messages into chunks of at most 10 entries and 256KB, returning the indices
+// of the messages of every chunk. Messages that are too large on their own get a chunk of their own
+// and fail when sent.
+func chunkMessages(messages []Message) [][]int {
+	var (
+		chunks [][]int
+		chunk  []int
+		size   int
+	)
+
+	for i := range messages {
+		messageSize := messages[i].size()
+		if len(chunk) > 0 && (len(chunk) == maxBatchEntries || size+messageSize > maxBatchBytes) {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+
+		chunk = append(chunk, i)
+		size += messageSize
+	}
+
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// sendChunk sends messages that fit in a single batch, retrying the entries that failed with a
+// server side error. On FIFO queues the later entries of the group of a failed entry are sent again
+// with it, in their original order.
+func (b *BatchProducer) sendChunk(ctx context.Context, messages []Message) []Result {
+	results := make([]Result, len(messages))
+	entries := make(map[string]*sqs.SendMessageBatchRequestEntry, len(messages))
+	remaining := make([]string, 0, len(messages))
+
+	for i := range messages {
+		id := strconv.Itoa(i)
+
+		entry, err := b.entry(id, &messages[i])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		entries[id] = entry
+		remaining = append(remaining, id)
+	}
+
+	for attempt := 0; len(remaining) > 0; attempt++ {
+		batch := make([]*sqs.SendMessageBatchRequestEntry, 0, len(remaining))
+		for _, id := range remaining {
+			batch = append(batch, entries[id])
+		}
+
+		out, err := b.producer.sqsClient.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: b.producer.queueUrl,
+			Entries:  batch,
+		})
+
+		var retry []string
+		if err != nil {
+			b.producer.handleAWSError(err)
+			retry = remaining
+			for _, id := range remaining {
+				results[index(id)].Err = err
+			}
+		} else {
+			for _, entry := range out.Successful {
+				results[index(aws.StringValue(entry.Id))] = Result{MessageID: aws.StringValue(entry.MessageId)}
+			}
+
+			for _, entry := range out.Failed {
+				id := aws.StringValue(entry.Id)
+				results[index(id)].Err = &EntryError{
+					Code:        aws.StringValue(entry.Code),
+					Message:     aws.StringValue(entry.Message),
+					SenderFault: aws.BoolValue(entry.SenderFault),
+				}
+
+				if !aws.BoolValue(entry.SenderFault) {
+					retry = append(retry, id)
+				}
+			}
+
+			if b.producer.isFIFO() {
+				retry = holdBackGroups(remaining, entries, retry, results)
+			}
+		}
+
+		if len(retry) == 0 || attempt >= b.maxRetries {
+			break
+		}
+
+		b.logger.Warn("Retrying failed batch entries", zap.Int("entries", len(retry)), zap.Int("attempt", attempt+1))
+
+		select {
+		case <-ctx.Done():
+			return results
+		case <-time.After(b.backoff(attempt)):
+		}
+
+		remaining = retry
+	}
+
+	return results
+}
+
+// holdBackGroups returns the entries of a FIFO batch to send again: the entries to retry and every
+// later entry of their message group, in their original order, so that a retried entry is not
+// delivered after the entries that followed it. The entries held back fail with
+// ErrPrecedingMessageFailed unless they are sent again successfully.
+func holdBackGroups(remaining []string, entries map[string]*sqs.SendMessageBatchRequestEntry, retry []string, results []Result) []string {
+	if len(retry) == 0 {
+		return retry
+	}
+
+	failed := make(map[string]bool, len(retry))
+	for _, id := range retry {
+		failed[id] = true
+	}
+
+	var (
+		blocked = make(map[string]bool)
+		held    = make([]string, 0, len(remaining))
+	)
+
+	for _, id := range remaining {
+		group := aws.StringValue(entries[id].MessageGroupId)
+		switch {
+		case failed[id]:
+			blocked[group] = true
+		case blocked[group]:
+			results[index(id)] = Result{Err: ErrPrecedingMessageFailed}
+		default:
+			continue
+		}
+
+		held = append(held, id)
+	}
+
+	return held
+}
+
+// entry builds the batch entry of a message
+func (b *BatchProducer) entry(id string, message *Message) (*sqs.SendMessageBatchRequestEntry, error) {
+	if message.size() > maxBatchBytes {
+		return nil, ErrMessageTooLarge
+	}
+
+	if err := b.fifoFields(message); err != nil {
+		return nil, err
+	}
+
+	entry := &sqs.SendMessageBatchRequestEntry{
+		Id:                     aws.String(id),
+		MessageBody:            aws.String(message.Body),
+		MessageAttributes:      message.Attributes,
+		MessageGroupId:         optionalString(message.GroupID),
+		MessageDeduplicationId: optionalString(message.DeduplicationID),
+	}
+
+	if message.Delay > 0 {
+		entry.DelaySeconds = aws.Int64(int64(message.Delay / time.Second))
+	}
+
+	return entry, nil
+}
+
+// fifoFields derives the group and deduplication ids of a message sent to a FIFO queue when they are
+// not set explicitly
+func (b *BatchProducer) fifoFields(message *Message) error {
+	if !b.producer.isFIFO() || (message.GroupID != "" && message.DeduplicationID != "") {
+		return nil
+	}
+
+	groupID, deduplicationID, err := b.producer.fifoIDs(message.Body, message.Attributes)
+	if message.GroupID == "" {
+		if err != nil {
+			return err
+		}
+		message.GroupID = aws.StringValue(groupID)
+	}
+
+	if message.DeduplicationID == "" {
+		message.DeduplicationID = aws.StringValue(deduplicationID)
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the retry following attempt
+func (b *BatchProducer) backoff(attempt int) time.Duration {
+	backoff := b.initialBackoff << uint(attempt)
+	if backoff > b.maxBackoff || backoff <= 0 {
+		return b.maxBackoff
+	}
+
+	return backoff
+}
+
+// index returns the message index encoded in a batch entry id
+func index(id string) int {
+	i, _ := strconv.Atoi(id)
+	return i
+}
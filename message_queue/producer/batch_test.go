@@ -0,0 +1,288 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// batchSQS records batches and fails entries by body: failures maps a body to the number of times
+// it fails before succeeding, and senderFaults lists bodies rejected permanently
+type batchSQS struct {
+	SQSAPI
+	mu           sync.Mutex
+	batches      [][]*sqs.SendMessageBatchRequestEntry
+	failures     map[string]int
+	senderFaults map[string]bool
+	callErr      error
+	delays       map[string]time.Duration
+	sent         []string // bodies sent successfully, in order
+}
+
+func (f *batchSQS) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	for _, entry := range input.Entries {
+		time.Sleep(f.delays[*entry.MessageBody])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, input.Entries)
+	if f.callErr != nil {
+		return nil, f.callErr
+	}
+
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		body := *entry.MessageBody
+		switch {
+		case f.senderFaults[body]:
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InvalidMessageContents"), Message: aws.String("invalid"), SenderFault: aws.Bool(true)})
+		case f.failures[body] > 0:
+			f.failures[body]--
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("retry"), SenderFault: aws.Bool(false)})
+		default:
+			out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String("id-" + body)})
+			f.sent = append(f.sent, body)
+		}
+	}
+
+	return out, nil
+}
+
+func (f *batchSQS) batchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.batches)
+}
+
+func newTestBatchProducer(client SQSAPI, queueUrl string, opts ...BatchOption) *BatchProducer {
+	opts = append([]BatchOption{WithRetryBackoff(time.Millisecond, 5*time.Millisecond)}, opts...)
+	return NewBatchProducer(client, aws.String(queueUrl), zap.NewNop(), opts...)
+}
+
+func Test_chunkMessages(t *testing.T) {
+	large := strings.Repeat("x", 100*1024)
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     [][]int
+	}{
+		{
+			name:     "empty",
+			messages: nil,
+			want:     nil,
+		},
+		{
+			name:     "split by count",
+			messages: make([]Message, 12),
+			want:     [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {10, 11}},
+		},
+		{
+			name:     "split by size",
+			messages: []Message{{Body: large}, {Body: large}, {Body: large}, {Body: "small"}},
+			want:     [][]int{{0, 1}, {2, 3}},
+		},
+		{
+			name:     "oversized message alone",
+			messages: []Message{{Body: "a"}, {Body: strings.Repeat("x", maxBatchBytes+1)}, {Body: "b"}},
+			want:     [][]int{{0}, {1}, {2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chunkMessages(tt.messages))
+		})
+	}
+}
+
+func TestBatchProducer_SendBatch(t *testing.T) {
+	fake := &batchSQS{
+		failures:     map[string]int{"retried": 2},
+		senderFaults: map[string]bool{"rejected": true},
+	}
+	producer := newTestBatchProducer(fake, "queue", WithMaxRetries(3))
+
+	messages := []Message{{Body: "ok"}, {Body: "retried"}, {Body: "rejected"}, {Body: strings.Repeat("x", maxBatchBytes+1)}}
+	for i := 0; i < 8; i++ {
+		messages = append(messages, Message{Body: "bulk"})
+	}
+
+	results := producer.SendBatch(context.Background(), messages)
+	require.Len(t, results, len(messages))
+
+	assert.Equal(t, Result{MessageID: "id-ok"}, results[0])
+	assert.Equal(t, Result{MessageID: "id-retried"}, results[1])
+
+	var entryErr *EntryError
+	require.ErrorAs(t, results[2].Err, &entryErr)
+	assert.True(t, entryErr.SenderFault)
+	assert.ErrorIs(t, results[3].Err, ErrMessageTooLarge)
+	for _, result := range results[4:] {
+		assert.Equal(t, "id-bulk", result.MessageID)
+	}
+
+	// the retries only resend the failed entry
+	retries := 0
+	for _, batch := range fake.batches {
+		if len(batch) == 1 && *batch[0].MessageBody == "retried" {
+			retries++
+		}
+	}
+	assert.Equal(t, 2, retries)
+}
+
+func TestBatchProducer_FIFOGroupRetry(t *testing.T) {
+	bodies := func(entries []*sqs.SendMessageBatchRequestEntry) []string {
+		var got []string
+		for _, entry := range entries {
+			got = append(got, *entry.MessageBody)
+		}
+		return got
+	}
+	messages := []Message{{Body: "a1", GroupID: "a"}, {Body: "b1", GroupID: "b"}, {Body: "a2", GroupID: "a"}, {Body: "a3", GroupID: "a"}}
+
+	t.Run("later entries of the group are sent again in order", func(t *testing.T) {
+		fake := &batchSQS{failures: map[string]int{"a1": 1}}
+		producer := newTestBatchProducer(fake, "accounts.fifo")
+
+		for _, result := range producer.SendBatch(context.Background(), messages) {
+			assert.NoError(t, result.Err)
+		}
+
+		require.Len(t, fake.batches, 2)
+		assert.Equal(t, []string{"a1", "a2", "a3"}, bodies(fake.batches[1]))
+	})
+
+	t.Run("held back entries fail once retries are exhausted", func(t *testing.T) {
+		fake := &batchSQS{failures: map[string]int{"a1": 2}}
+		producer := newTestBatchProducer(fake, "accounts.fifo", WithMaxRetries(1))
+
+		results := producer.SendBatch(context.Background(), messages)
+		var entryErr *EntryError
+		assert.ErrorAs(t, results[0].Err, &entryErr)
+		assert.Equal(t, Result{MessageID: "id-b1"}, results[1])
+		assert.ErrorIs(t, results[2].Err, ErrPrecedingMessageFailed)
+		assert.ErrorIs(t, results[3].Err, ErrPrecedingMessageFailed)
+	})
+}
+
+func TestBatchProducer_RetriesExhausted(t *testing.T) {
+	fake := &batchSQS{callErr: errors.New("unavailable")}
+	producer := newTestBatchProducer(fake, "queue", WithMaxRetries(2))
+
+	results := producer.SendBatch(context.Background(), []Message{{Body: "a"}, {Body: "b"}})
+	for _, result := range results {
+		assert.Error(t, result.Err)
+	}
+	assert.Equal(t, 3, fake.batchCount())
+}
+
+func TestBatchProducer_Add(t *testing.T) {
+	t.Run("flushes full batches", func(t *testing.T) {
+		fake := &batchSQS{}
+		producer := newTestBatchProducer(fake, "queue", WithLinger(time.Hour))
+
+		results := make([]<-chan Result, 0, maxBatchEntries)
+		for i := 0; i < maxBatchEntries; i++ {
+			results = append(results, producer.Add(Message{Body: "m"}))
+		}
+
+		for _, result := range results {
+			select {
+			case r := <-result:
+				assert.NoError(t, r.Err)
+			case <-time.After(time.Second):
+				t.Fatal("full batch was not sent")
+			}
+		}
+		assert.Equal(t, 1, fake.batchCount())
+	})
+
+	t.Run("flushes after linger", func(t *testing.T) {
+		fake := &batchSQS{}
+		producer := newTestBatchProducer(fake, "queue", WithLinger(20*time.Millisecond))
+
+		first := producer.Add(Message{Body: "a"})
+		second := producer.Add(Message{Body: "b"})
+
+		assert.Equal(t, "id-a", (<-first).MessageID)
+		assert.Equal(t, "id-b", (<-second).MessageID)
+		assert.Equal(t, 1, fake.batchCount())
+	})
+
+	t.Run("close flushes and rejects new messages", func(t *testing.T) {
+		fake := &batchSQS{}
+		producer := newTestBatchProducer(fake, "queue", WithLinger(time.Hour))
+
+		pending := producer.Add(Message{Body: "a"})
+		require.NoError(t, producer.Close(context.Background()))
+		assert.Equal(t, "id-a", (<-pending).MessageID)
+
+		assert.ErrorIs(t, (<-producer.Add(Message{Body: "b"})).Err, ErrProducerClosed)
+	})
+
+	t.Run("oversized message", func(t *testing.T) {
+		producer := newTestBatchProducer(&batchSQS{}, "queue")
+		assert.ErrorIs(t, (<-producer.Add(Message{Body: strings.Repeat("x", maxBatchBytes+1)})).Err, ErrMessageTooLarge)
+	})
+}
+
+func TestBatchProducer_FIFO(t *testing.T) {
+	fake := &batchSQS{}
+	producer := newTestBatchProducer(fake, "accounts.fifo",
+		WithProducerOptions(WithGroupKeyFunc(fifo.StaticGroupKey("all")), WithDeduplicationIDFunc(fifo.ContentDeduplicationID)))
+
+	results := producer.SendBatch(context.Background(), []Message{{Body: "a"}, {Body: "b", GroupID: "explicit"}})
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+
+	entries := fake.batches[0]
+	assert.Equal(t, "all", *entries[0].MessageGroupId)
+	assert.Equal(t, "explicit", *entries[1].MessageGroupId)
+	assert.Equal(t, fifo.ContentDeduplicationID("b", nil), *entries[1].MessageDeduplicationId)
+
+	missingGroup := newTestBatchProducer(&batchSQS{}, "accounts.fifo")
+	assert.ErrorIs(t, missingGroup.SendBatch(context.Background(), []Message{{Body: "a"}})[0].Err, fifo.ErrMissingGroupID)
+}
+
+func TestBatchProducer_FIFOOrder(t *testing.T) {
+	// the first batches are slow or fail once, so that later batches would overtake them if they were
+	// sent concurrently
+	fake := &batchSQS{
+		failures: map[string]int{"m0": 1, "m3": 1},
+		delays:   map[string]time.Duration{"m0": 20 * time.Millisecond, "m1": 10 * time.Millisecond},
+	}
+	producer := newTestBatchProducer(fake, "accounts.fifo", WithLinger(0),
+		WithProducerOptions(WithGroupKeyFunc(fifo.StaticGroupKey("account-42")), WithDeduplicationIDFunc(fifo.ContentDeduplicationID)))
+
+	want := make([]string, 0, 12)
+	results := make([]<-chan Result, 0, 12)
+	for i := 0; i < 12; i++ {
+		body := fmt.Sprintf("m%d", i)
+		want = append(want, body)
+		results = append(results, producer.Add(Message{Body: body}))
+	}
+
+	require.NoError(t, producer.Close(context.Background()))
+	for _, result := range results {
+		assert.NoError(t, (<-result).Err)
+	}
+
+	assert.Greater(t, fake.batchCount(), 1)
+	assert.Equal(t, want, fake.sent)
+}
@@ -0,0 +1,103 @@
+package producer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestOffloader(t *testing.T) (*claimcheck.Offloader, claimcheck.BlobStore) {
+	t.Helper()
+
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	offloader, err := claimcheck.New(store, claimcheck.WithThreshold(64))
+	require.NoError(t, err)
+
+	return offloader, store
+}
+
+func TestSendMessage_ClaimCheck(t *testing.T) {
+	offloader, store := newTestOffloader(t)
+	mockClient := &mockSQSClient{sendMessageOutput: &sqs.SendMessageOutput{}}
+	producer := NewProducerClient(mockClient, aws.String("accounts.fifo"), zap.NewNop(),
+		WithClaimCheck(offloader),
+		WithGroupKeyFunc(fifo.StaticGroupKey("all")),
+		WithDeduplicationIDFunc(fifo.ContentDeduplicationID))
+
+	large := strings.Repeat("x", 100)
+	require.NoError(t, producer.SendMessage(context.TODO(), large, nil))
+	require.NoError(t, producer.SendMessage(context.TODO(), "small", nil))
+
+	offloaded := mockClient.sentInputs[0]
+	key, ok := claimcheck.Pointer(offloaded.MessageAttributes)
+	require.True(t, ok)
+	assert.Equal(t, key, *offloaded.MessageBody)
+	assert.Equal(t, fifo.ContentDeduplicationID(large, nil), *offloaded.MessageDeduplicationId, "deduplication uses the original body")
+
+	data, err := store.Get(context.TODO(), key)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(data))
+
+	assert.Equal(t, "small", *mockClient.sentInputs[1].MessageBody)
+	assert.NotContains(t, mockClient.sentInputs[1].MessageAttributes, claimcheck.PointerAttribute)
+}
+
+func TestBatchProducer_ClaimCheck(t *testing.T) {
+	offloader, _ := newTestOffloader(t)
+	fake := &batchSQS{}
+	producer := newTestBatchProducer(fake, "queue", WithProducerOptions(WithClaimCheck(offloader)))
+
+	// larger than the SQS limit on their own, sent in a single batch once offloaded
+	huge := strings.Repeat("x", maxBatchBytes+1)
+	results := producer.SendBatch(context.Background(), []Message{{Body: huge}, {Body: huge}, {Body: "small"}})
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+
+	require.Equal(t, 1, fake.batchCount())
+	entries := fake.batches[0]
+	assert.Contains(t, entries[0].MessageAttributes, claimcheck.PointerAttribute)
+	assert.Contains(t, entries[1].MessageAttributes, claimcheck.PointerAttribute)
+	assert.Equal(t, "small", *entries[2].MessageBody)
+
+	assert.NoError(t, (<-producer.Add(Message{Body: huge})).Err)
+}
+
+func TestClaimCheck_FIFOContentDeduplication(t *testing.T) {
+	offloader, _ := newTestOffloader(t)
+	large := strings.Repeat("x", 100)
+	want := fifo.ContentDeduplicationID(large, nil)
+
+	// without deduplication function the queue would hash the random blob key
+	mockClient := &mockSQSClient{sendMessageOutput: &sqs.SendMessageOutput{}}
+	producer := NewProducerClient(mockClient, aws.String("accounts.fifo"), zap.NewNop(),
+		WithClaimCheck(offloader),
+		WithGroupKeyFunc(fifo.StaticGroupKey("all")))
+
+	require.NoError(t, producer.SendMessage(context.TODO(), large, nil))
+	require.NoError(t, producer.SendMessage(context.TODO(), "small", nil))
+	assert.Equal(t, want, aws.StringValue(mockClient.sentInputs[0].MessageDeduplicationId))
+	assert.Nil(t, mockClient.sentInputs[1].MessageDeduplicationId, "small messages keep content based deduplication")
+
+	fake := &batchSQS{}
+	batch := newTestBatchProducer(fake, "accounts.fifo", WithProducerOptions(
+		WithClaimCheck(offloader),
+		WithGroupKeyFunc(fifo.StaticGroupKey("all"))))
+
+	for _, result := range batch.SendBatch(context.Background(), []Message{{Body: large}, {Body: large, DeduplicationID: "explicit"}}) {
+		require.NoError(t, result.Err)
+	}
+	entries := fake.batches[0]
+	assert.Equal(t, want, aws.StringValue(entries[0].MessageDeduplicationId))
+	assert.Equal(t, "explicit", aws.StringValue(entries[1].MessageDeduplicationId))
+}
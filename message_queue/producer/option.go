@@ -0,0 +1,34 @@
+package producer // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/producer"
+
+import (
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+)
+
+// Option configures a ProducerClient
+type Option func(*ProducerClient)
+
+// WithGroupKeyFunc sets the function extracting the message group id of messages sent to a FIFO
+// queue, for example fifo.AttributeGroupKey("account_id") to order messages per account.
+func WithGroupKeyFunc(groupKey fifo.GroupKeyFunc) Option {
+	return func(p *ProducerClient) {
+		p.groupKey = groupKey
+	}
+}
+
+// WithDeduplicationIDFunc sets the function deriving the deduplication id of messages sent to a FIFO
+// queue. Use fifo.ContentDeduplicationID for queues without content based deduplication. Without it
+// the deduplication setting of the queue applies.
+func WithDeduplicationIDFunc(deduplicationID fifo.DeduplicationIDFunc) Option {
+	return func(p *ProducerClient) {
+		p.deduplicationID = deduplicationID
+	}
+}
+
+// WithClaimCheck offloads bodies of messages larger than the offloader threshold to its blob store,
+// sending a pointer to the blob instead
+func WithClaimCheck(offloader *claimcheck.Offloader) Option {
+	return func(p *ProducerClient) {
+		p.offloader = offloader
+	}
+}
@@ -2,8 +2,12 @@ package producer // import "github.com/SolomonAIEngineering/backend-core-library
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/claimcheck"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -26,17 +30,28 @@ type ProducerClient struct {
 	sqsClient SQSAPI
 	queueUrl  *string
 	logger    *zap.Logger
+
+	groupKey        fifo.GroupKeyFunc        // extracts the message group id for FIFO queues
+	deduplicationID fifo.DeduplicationIDFunc // derives deduplication ids for FIFO queues, nil to rely on the queue setting
+	offloader       *claimcheck.Offloader    // stores large bodies in a blob store, nil to disable
 }
 
-func NewProducerClient(sqsClient SQSAPI, queueUrl *string, logger *zap.Logger) *ProducerClient {
-	return &ProducerClient{
+func NewProducerClient(sqsClient SQSAPI, queueUrl *string, logger *zap.Logger, opts ...Option) *ProducerClient {
+	p := &ProducerClient{
 		sqsClient: sqsClient,
 		queueUrl:  queueUrl,
 		logger:    logger,
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
 }
 
-// Send a single message
+// Send a single message. For FIFO queues the message group id and deduplication id are derived with
+// the configured group key and deduplication functions.
 func (p *ProducerClient) SendMessage(ctx context.Context, body string, attributes map[string]*sqs.MessageAttributeValue) error {
 	req := &sqs.SendMessageInput{
 		QueueUrl:          p.queueUrl,
@@ -44,6 +59,62 @@ func (p *ProducerClient) SendMessage(ctx context.Context, body string, attribute
 		MessageAttributes: attributes,
 	}
 
+	if p.isFIFO() {
+		groupID, deduplicationID, err := p.fifoIDs(body, attributes)
+		if err != nil {
+			return err
+		}
+
+		req.MessageGroupId = groupID
+		req.MessageDeduplicationId = deduplicationID
+	}
+
+	if err := p.prepare(ctx, &req.MessageBody, &req.MessageAttributes, &req.MessageDeduplicationId); err != nil {
+		return err
+	}
+
+	return p.send(ctx, req)
+}
+
+// SendFIFOMessage sends a single message to a FIFO queue with an explicit message group id and
+// deduplication id. An empty deduplication id falls back to the configured deduplication function,
+// or to the content based deduplication of the queue.
+func (p *ProducerClient) SendFIFOMessage(ctx context.Context, body string, attributes map[string]*sqs.MessageAttributeValue, groupID, deduplicationID string) error {
+	if !p.isFIFO() {
+		return fmt.Errorf("queue %s is not a FIFO queue", aws.StringValue(p.queueUrl))
+	}
+
+	if err := fifo.ValidateID(groupID); err != nil {
+		return err
+	}
+
+	if deduplicationID == "" && p.deduplicationID != nil {
+		deduplicationID = p.deduplicationID(body, attributes)
+	}
+
+	if deduplicationID != "" {
+		if err := fifo.ValidateID(deduplicationID); err != nil {
+			return err
+		}
+	}
+
+	req := &sqs.SendMessageInput{
+		QueueUrl:               p.queueUrl,
+		MessageBody:            aws.String(body),
+		MessageAttributes:      attributes,
+		MessageGroupId:         aws.String(groupID),
+		MessageDeduplicationId: optionalString(deduplicationID),
+	}
+
+	if err := p.prepare(ctx, &req.MessageBody, &req.MessageAttributes, &req.MessageDeduplicationId); err != nil {
+		return err
+	}
+
+	return p.send(ctx, req)
+}
+
+// send sends the request, retrying with backoff on errors
+func (p *ProducerClient) send(ctx context.Context, req *sqs.SendMessageInput) error {
 	var lastErr error
 	for i := 0; i < maxRetries; i++ {
 		_, err := p.sqsClient.SendMessageWithContext(ctx, req)
@@ -65,8 +136,33 @@ func (p *ProducerClient) SendMessage(ctx context.Context, body string, attribute
 	return lastErr
 }
 
-// Send messages in batches
+// Send messages in batches. For FIFO queues, entries without a message group id or deduplication id
+// get the ones derived with the configured group key and deduplication functions.
 func (p *ProducerClient) SendMessagesBatch(ctx context.Context, messages []*sqs.SendMessageBatchRequestEntry) error {
+	if p.isFIFO() {
+		for _, entry := range messages {
+			body := aws.StringValue(entry.MessageBody)
+			groupID, deduplicationID, err := p.fifoIDs(body, entry.MessageAttributes)
+
+			if entry.MessageGroupId == nil {
+				if err != nil {
+					return fmt.Errorf("entry %s | %s", aws.StringValue(entry.Id), err.Error())
+				}
+				entry.MessageGroupId = groupID
+			}
+
+			if entry.MessageDeduplicationId == nil {
+				entry.MessageDeduplicationId = deduplicationID
+			}
+		}
+	}
+
+	for _, entry := range messages {
+		if err := p.prepare(ctx, &entry.MessageBody, &entry.MessageAttributes, &entry.MessageDeduplicationId); err != nil {
+			return fmt.Errorf("entry %s | %s", aws.StringValue(entry.Id), err.Error())
+		}
+	}
+
 	req := &sqs.SendMessageBatchInput{
 		QueueUrl: p.queueUrl,
 		Entries:  messages,
@@ -93,6 +189,69 @@ func (p *ProducerClient) SendMessagesBatch(ctx context.Context, messages []*sqs.
 	return lastErr
 }
 
+// prepare replaces large bodies with a claim check when an offloader is configured, then adds the
+// trace context of ctx to the attributes. An offloaded FIFO message without deduplication id gets
+// one derived from its original body, since the blob key replacing it is random.
+func (p *ProducerClient) prepare(ctx context.Context, body **string, attributes *map[string]*sqs.MessageAttributeValue, deduplicationID **string) error {
+	if p.offloader != nil {
+		original := aws.StringValue(*body)
+		offloadedBody, offloadedAttributes, err := p.offloader.Offload(ctx, original, *attributes)
+		if err != nil {
+			return err
+		}
+
+		if _, offloaded := claimcheck.Pointer(offloadedAttributes); offloaded && p.isFIFO() && *deduplicationID == nil {
+			*deduplicationID = aws.String(fifo.ContentDeduplicationID(original, nil))
+		}
+
+		*body = aws.String(offloadedBody)
+		*attributes = offloadedAttributes
+	}
+
+	*attributes = tracing.Inject(ctx, *attributes)
+	return nil
+}
+
+// isFIFO returns true if the producer sends to a FIFO queue
+func (p *ProducerClient) isFIFO() bool {
+	return fifo.IsQueue(aws.StringValue(p.queueUrl))
+}
+
+// fifoIDs derives the message group id and deduplication id of a message sent to a FIFO queue
+func (p *ProducerClient) fifoIDs(body string, attributes map[string]*sqs.MessageAttributeValue) (*string, *string, error) {
+	if p.groupKey == nil {
+		return nil, nil, fifo.ErrMissingGroupID
+	}
+
+	groupID := p.groupKey(body, attributes)
+	if groupID == "" {
+		return nil, nil, fifo.ErrMissingGroupID
+	}
+
+	if err := fifo.ValidateID(groupID); err != nil {
+		return nil, nil, err
+	}
+
+	var deduplicationID string
+	if p.deduplicationID != nil {
+		deduplicationID = p.deduplicationID(body, attributes)
+		if err := fifo.ValidateID(deduplicationID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return aws.String(groupID), optionalString(deduplicationID), nil
+}
+
+// optionalString returns nil for empty strings so that unset fields are omitted from requests
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return aws.String(s)
+}
+
 // Handle AWS-specific errors
 func (p *ProducerClient) handleAWSError(err error) {
 	if aerr, ok := err.(awserr.Error); ok {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/fifo"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -18,13 +19,17 @@ type mockSQSClient struct {
 	sendMessageBatchErr    error
 	sendMessageOutput      *sqs.SendMessageOutput
 	sendMessageBatchOutput *sqs.SendMessageBatchOutput
+	sentInputs             []*sqs.SendMessageInput
+	sentBatches            []*sqs.SendMessageBatchInput
 }
 
 func (m *mockSQSClient) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	m.sentInputs = append(m.sentInputs, input)
 	return m.sendMessageOutput, m.sendMessageErr
 }
 
 func (m *mockSQSClient) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	m.sentBatches = append(m.sentBatches, input)
 	return m.sendMessageBatchOutput, m.sendMessageBatchErr
 }
 
@@ -69,3 +74,107 @@ func TestHandleAWSError(t *testing.T) {
 	producer.handleAWSError(err)
 	// Here you might want to check if your logger received the expected error, using an in-memory or hook-based logger. This is just a basic test.
 }
+
+func TestSendMessage_FIFO(t *testing.T) {
+	fifoQueue := aws.String("https://sqs.us-east-1.amazonaws.com/123/accounts.fifo")
+	accountAttributes := map[string]*sqs.MessageAttributeValue{
+		"account_id": {DataType: aws.String("String"), StringValue: aws.String("42")},
+	}
+
+	tests := []struct {
+		name              string
+		queueUrl          *string
+		opts              []Option
+		attributes        map[string]*sqs.MessageAttributeValue
+		wantGroupID       *string
+		wantDeduplication bool
+		wantErr           bool
+	}{
+		{
+			name:        "group from attribute",
+			queueUrl:    fifoQueue,
+			opts:        []Option{WithGroupKeyFunc(fifo.AttributeGroupKey("account_id"))},
+			attributes:  accountAttributes,
+			wantGroupID: aws.String("42"),
+		},
+		{
+			name:              "content deduplication",
+			queueUrl:          fifoQueue,
+			opts:              []Option{WithGroupKeyFunc(fifo.StaticGroupKey("all")), WithDeduplicationIDFunc(fifo.ContentDeduplicationID)},
+			wantGroupID:       aws.String("all"),
+			wantDeduplication: true,
+		},
+		{
+			name:     "missing group key function",
+			queueUrl: fifoQueue,
+			wantErr:  true,
+		},
+		{
+			name:     "empty group key",
+			queueUrl: fifoQueue,
+			opts:     []Option{WithGroupKeyFunc(fifo.AttributeGroupKey("account_id"))},
+			wantErr:  true,
+		},
+		{
+			name:     "standard queue ignores group key",
+			queueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123/accounts"),
+			opts:     []Option{WithGroupKeyFunc(fifo.StaticGroupKey("all"))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &mockSQSClient{sendMessageOutput: &sqs.SendMessageOutput{}}
+			producer := NewProducerClient(mockClient, tt.queueUrl, zap.NewNop(), tt.opts...)
+
+			err := producer.SendMessage(context.TODO(), "test message", tt.attributes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assert.Empty(t, mockClient.sentInputs)
+				return
+			}
+
+			input := mockClient.sentInputs[0]
+			assert.Equal(t, tt.wantGroupID, input.MessageGroupId)
+			assert.Equal(t, tt.wantDeduplication, input.MessageDeduplicationId != nil)
+		})
+	}
+}
+
+func TestSendFIFOMessage(t *testing.T) {
+	mockClient := &mockSQSClient{sendMessageOutput: &sqs.SendMessageOutput{}}
+	producer := NewProducerClient(mockClient, aws.String("accounts.fifo"), zap.NewNop())
+
+	assert.NoError(t, producer.SendFIFOMessage(context.TODO(), "body", nil, "42", "dedup-1"))
+	assert.Equal(t, "42", *mockClient.sentInputs[0].MessageGroupId)
+	assert.Equal(t, "dedup-1", *mockClient.sentInputs[0].MessageDeduplicationId)
+
+	assert.NoError(t, producer.SendFIFOMessage(context.TODO(), "body", nil, "42", ""))
+	assert.Nil(t, mockClient.sentInputs[1].MessageDeduplicationId)
+
+	assert.Error(t, producer.SendFIFOMessage(context.TODO(), "body", nil, "", ""))
+
+	standard := NewProducerClient(mockClient, aws.String("accounts"), zap.NewNop())
+	assert.Error(t, standard.SendFIFOMessage(context.TODO(), "body", nil, "42", ""))
+}
+
+func TestSendMessagesBatch_FIFO(t *testing.T) {
+	mockClient := &mockSQSClient{sendMessageBatchOutput: &sqs.SendMessageBatchOutput{}}
+	producer := NewProducerClient(mockClient, aws.String("accounts.fifo"), zap.NewNop(),
+		WithGroupKeyFunc(fifo.StaticGroupKey("default")),
+		WithDeduplicationIDFunc(fifo.ContentDeduplicationID))
+
+	entries := []*sqs.SendMessageBatchRequestEntry{
+		{Id: aws.String("1"), MessageBody: aws.String("a")},
+		{Id: aws.String("2"), MessageBody: aws.String("b"), MessageGroupId: aws.String("explicit"), MessageDeduplicationId: aws.String("d-2")},
+	}
+
+	assert.NoError(t, producer.SendMessagesBatch(context.TODO(), entries))
+
+	sent := mockClient.sentBatches[0].Entries
+	assert.Equal(t, "default", *sent[0].MessageGroupId)
+	assert.Equal(t, fifo.ContentDeduplicationID("a", nil), *sent[0].MessageDeduplicationId)
+	assert.Equal(t, "explicit", *sent[1].MessageGroupId)
+	assert.Equal(t, "d-2", *sent[1].MessageDeduplicationId)
+}
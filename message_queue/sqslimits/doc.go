@@ -0,0 +1,5 @@
+/*
+Package sqslimits holds the limits SQS enforces on the messages sent to a queue, shared by the
+clients that build messages and their attributes.
+*/
+package sqslimits // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
@@ -0,0 +1,4 @@
+package sqslimits // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+
+// MaxMessageAttributes is the maximum number of message attributes SQS accepts per message
+const MaxMessageAttributes = 10
@@ -0,0 +1,7 @@
+/*
+Package tracing propagates distributed traces across SQS messages. Producers inject the New Relic and
+W3C trace context headers of the active transaction into the message attributes, and consumers start
+a message consumer transaction that continues the trace, so the queue shows up as a hop in the trace
+of the request that enqueued the work.
+*/
+package tracing // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
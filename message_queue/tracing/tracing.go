@@ -0,0 +1,134 @@
+package tracing // import "github.com/SolomonAIEngineering/backend-core-library/message_queue/tracing"
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// Message attributes holding the trace context
+const (
+	// NewRelicAttribute holds the New Relic distributed trace payload
+	NewRelicAttribute = "newrelic"
+	// TraceParentAttribute holds the W3C traceparent header
+	TraceParentAttribute = "traceparent"
+	// TraceStateAttribute holds the W3C tracestate header
+	TraceStateAttribute = "tracestate"
+)
+
+// traceAttributes lists the trace context attributes by priority. The W3C traceparent comes first
+// since it is understood by every tracer, and tracestate is useless without it.
+var traceAttributes = []string{TraceParentAttribute, NewRelicAttribute, TraceStateAttribute}
+
+// headerInserter writes the trace context of a transaction to headers
+type headerInserter interface {
+	InsertDistributedTraceHeaders(hdrs http.Header)
+}
+
+// headerAcceptor continues the trace described by headers
+type headerAcceptor interface {
+	AcceptDistributedTraceHeaders(t newrelic.TransportType, hdrs http.Header)
+}
+
+// Inject adds the trace context of the transaction in ctx to the message attributes and returns them.
+// The attributes are returned unchanged when ctx has no transaction, when distributed tracing is
+// disabled, or when the message has no room left for the trace context attributes. Attributes set by
+// the caller are never overwritten. The attributes map is copied before it is modified.
+func Inject(ctx context.Context, attributes map[string]*sqs.MessageAttributeValue) map[string]*sqs.MessageAttributeValue {
+	txn := newrelic.FromContext(ctx)
+	if txn == nil {
+		return attributes
+	}
+
+	return inject(txn, attributes)
+}
+
+func inject(txn headerInserter, attributes map[string]*sqs.MessageAttributeValue) map[string]*sqs.MessageAttributeValue {
+	headers := http.Header{}
+	txn.InsertDistributedTraceHeaders(headers)
+	if len(headers) == 0 {
+		return attributes
+	}
+
+	injected := make(map[string]*sqs.MessageAttributeValue, len(attributes)+len(traceAttributes))
+	for name, value := range attributes {
+		injected[name] = value
+	}
+
+	for _, name := range traceAttributes {
+		value := headers.Get(name)
+		if value == "" || len(injected) >= sqslimits.MaxMessageAttributes {
+			continue
+		}
+
+		if _, ok := injected[name]; ok {
+			continue
+		}
+
+		injected[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	return injected
+}
+
+// Headers returns the trace context carried by the message attributes as headers
+func Headers(attributes map[string]*sqs.MessageAttributeValue) http.Header {
+	headers := http.Header{}
+	for _, name := range traceAttributes {
+		value, ok := attributes[name]
+		if !ok || value == nil || aws.StringValue(value.StringValue) == "" {
+			continue
+		}
+
+		headers.Set(name, aws.StringValue(value.StringValue))
+	}
+
+	return headers
+}
+
+// StartConsumerTransaction starts a message consumer transaction for a message received from the
+// queue and continues the trace carried by its attributes. The returned context holds the
+// transaction, which the caller must end once the message is processed. With a nil application the
+// context is returned unchanged along with a nil transaction, which is safe to use.
+func StartConsumerTransaction(ctx context.Context, app *newrelic.Application, queueURL string, message *sqs.Message) (context.Context, *newrelic.Transaction) {
+	if app == nil {
+		return ctx, nil
+	}
+
+	queue := QueueName(queueURL)
+	txn := app.StartTransaction(TransactionName(queue))
+	accept(txn, message.MessageAttributes)
+
+	txn.AddAttribute("message.queueName", queue)
+	txn.AddAttribute("message.messageId", aws.StringValue(message.MessageId))
+
+	return newrelic.NewContext(ctx, txn), txn
+}
+
+// accept continues the trace carried by the attributes, if any
+func accept(txn headerAcceptor, attributes map[string]*sqs.MessageAttributeValue) {
+	headers := Headers(attributes)
+	if len(headers) == 0 {
+		return
+	}
+
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportQueue, headers)
+}
+
+// TransactionName returns the name of the consumer transaction of messages received from the queue
+func TransactionName(queue string) string {
+	return "Message/SQS/Queue/Named/" + queue
+}
+
+// QueueName returns the name of the queue from its URL
+func QueueName(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
+}
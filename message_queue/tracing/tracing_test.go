@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/message_queue/sqslimits"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type fakeTransaction struct {
+	headers   http.Header
+	accepted  http.Header
+	transport newrelic.TransportType
+}
+
+func (f *fakeTransaction) InsertDistributedTraceHeaders(hdrs http.Header) {
+	for name, values := range f.headers {
+		hdrs[name] = values
+	}
+}
+
+func (f *fakeTransaction) AcceptDistributedTraceHeaders(t newrelic.TransportType, hdrs http.Header) {
+	f.transport = t
+	f.accepted = hdrs
+}
+
+func stringAttribute(value string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func attributes(n int) map[string]*sqs.MessageAttributeValue {
+	attrs := make(map[string]*sqs.MessageAttributeValue, n)
+	for i := 0; i < n; i++ {
+		attrs[fmt.Sprintf("attr-%d", i)] = stringAttribute("v")
+	}
+	return attrs
+}
+
+func TestInject(t *testing.T) {
+	headers := http.Header{}
+	headers.Set(TraceParentAttribute, traceParent)
+	headers.Set(TraceStateAttribute, "nr=state")
+	headers.Set(NewRelicAttribute, "payload")
+
+	tests := []struct {
+		name       string
+		headers    http.Header
+		attributes map[string]*sqs.MessageAttributeValue
+		want       []string
+		notWant    []string
+	}{
+		{
+			name:       "adds every header",
+			headers:    headers,
+			attributes: nil,
+			want:       []string{TraceParentAttribute, NewRelicAttribute, TraceStateAttribute},
+		},
+		{
+			name:       "no trace context",
+			headers:    http.Header{},
+			attributes: attributes(1),
+			notWant:    []string{TraceParentAttribute, NewRelicAttribute, TraceStateAttribute},
+		},
+		{
+			name:       "keeps within the attribute limit by priority",
+			headers:    headers,
+			attributes: attributes(8),
+			want:       []string{TraceParentAttribute, NewRelicAttribute},
+			notWant:    []string{TraceStateAttribute},
+		},
+		{
+			name:       "full message",
+			headers:    headers,
+			attributes: attributes(10),
+			notWant:    []string{TraceParentAttribute, NewRelicAttribute, TraceStateAttribute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := len(tt.attributes)
+			got := inject(&fakeTransaction{headers: tt.headers}, tt.attributes)
+
+			assert.Len(t, tt.attributes, original, "the caller attributes are not modified")
+			assert.LessOrEqual(t, len(got), sqslimits.MaxMessageAttributes)
+			for _, name := range tt.want {
+				require.Contains(t, got, name)
+				assert.Equal(t, tt.headers.Get(name), aws.StringValue(got[name].StringValue))
+			}
+			for _, name := range tt.notWant {
+				assert.NotContains(t, got, name)
+			}
+		})
+	}
+}
+
+func TestInjectKeepsCallerAttributes(t *testing.T) {
+	headers := http.Header{}
+	headers.Set(TraceParentAttribute, traceParent)
+
+	got := inject(&fakeTransaction{headers: headers}, map[string]*sqs.MessageAttributeValue{
+		TraceParentAttribute: stringAttribute("explicit"),
+	})
+	assert.Equal(t, "explicit", aws.StringValue(got[TraceParentAttribute].StringValue))
+}
+
+func TestInjectWithoutTransaction(t *testing.T) {
+	attrs := attributes(2)
+	assert.Equal(t, attrs, Inject(context.Background(), attrs))
+}
+
+func TestAccept(t *testing.T) {
+	txn := &fakeTransaction{}
+	accept(txn, map[string]*sqs.MessageAttributeValue{
+		TraceParentAttribute: stringAttribute(traceParent),
+		NewRelicAttribute:    stringAttribute("payload"),
+		"other":              stringAttribute("ignored"),
+	})
+
+	assert.Equal(t, newrelic.TransportQueue, txn.transport)
+	assert.Equal(t, traceParent, txn.accepted.Get(TraceParentAttribute))
+	assert.Equal(t, "payload", txn.accepted.Get(NewRelicAttribute))
+	assert.Empty(t, txn.accepted.Get("other"))
+
+	untraced := &fakeTransaction{}
+	accept(untraced, attributes(2))
+	assert.Nil(t, untraced.accepted)
+}
+
+func TestStartConsumerTransaction(t *testing.T) {
+	ctx := context.Background()
+	message := &sqs.Message{MessageId: aws.String("m-1")}
+
+	got, txn := StartConsumerTransaction(ctx, nil, "https://sqs.us-east-1.amazonaws.com/123/orders", message)
+	assert.Equal(t, ctx, got)
+	assert.Nil(t, txn)
+
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("tracing-test"),
+		newrelic.ConfigLicense("0123456789012345678901234567890123456789"),
+		newrelic.ConfigEnabled(false),
+	)
+	require.NoError(t, err)
+
+	got, txn = StartConsumerTransaction(ctx, app, "https://sqs.us-east-1.amazonaws.com/123/orders", message)
+	require.NotNil(t, txn)
+	defer txn.End()
+	assert.Equal(t, txn, newrelic.FromContext(got))
+	assert.Equal(t, TransactionName("orders"), txn.Name())
+}
+
+func TestQueueName(t *testing.T) {
+	assert.Equal(t, "orders.fifo", QueueName("https://sqs.us-east-1.amazonaws.com/123/orders.fifo"))
+	assert.Equal(t, "orders", QueueName("orders"))
+}
@@ -16,6 +16,24 @@ type Shutdown struct {
 	logger                *zap.Logger
 	pool                  *redis.Pool
 	serverShutdownTimeout time.Duration
+	hooks                 []shutdownHook
+}
+
+// ShutdownFunc stops a component, such as a task processor, returning once it is stopped or the
+// context is done
+type ShutdownFunc func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	fn   ShutdownFunc
+}
+
+// OnShutdown registers a component stopped by Graceful once the servers are shut down. Components
+// are stopped in the order they were registered, within the server shutdown timeout.
+//
+//	shutdown.OnShutdown("task-processor", taskProcessor.Shutdown)
+func (s *Shutdown) OnShutdown(name string, fn ShutdownFunc) {
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
 }
 
 func NewShutdown(serverShutdownTimeout time.Duration, logger *zap.Logger) (*Shutdown, error) {
@@ -71,4 +89,16 @@ func (s *Shutdown) Graceful(stopCh <-chan struct{}, httpServer *http.Server, htt
 			s.logger.Warn("HTTPS server graceful shutdown failed", zap.Error(err))
 		}
 	}
+
+	s.shutdownHooks(ctx)
+}
+
+// shutdownHooks stops the components registered with OnShutdown
+func (s *Shutdown) shutdownHooks(ctx context.Context) {
+	for _, hook := range s.hooks {
+		s.logger.Info("Shutting down "+hook.name, zap.Duration("timeout", s.serverShutdownTimeout))
+		if err := hook.fn(ctx); err != nil {
+			s.logger.Warn(hook.name+" graceful shutdown failed", zap.Error(err))
+		}
+	}
 }
@@ -0,0 +1,97 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hibiken/asynq"
+)
+
+// TaskState is the state of a task in its queue
+type TaskState string
+
+const (
+	TaskStatePending   TaskState = "pending"
+	TaskStateActive    TaskState = "active"
+	TaskStateScheduled TaskState = "scheduled"
+	TaskStateRetry     TaskState = "retry"
+	TaskStateArchived  TaskState = "archived"
+	TaskStateCompleted TaskState = "completed"
+)
+
+const defaultListPageSize = 30
+
+// `ErrInvalidTaskState` is an error that is returned when listing tasks of an unknown state.
+var ErrInvalidTaskState = errors.New("invalid task state")
+
+// ListTasksOptions configures ListTasks
+type ListTasksOptions struct {
+	// Page is the page to return, starting at 1
+	Page int
+	// PageSize is the number of tasks per page. Defaults to 30.
+	PageSize int
+}
+
+// Queues returns the names of the queues holding tasks
+func (tp *TaskProcessor) Queues() ([]string, error) {
+	return tp.inspector.Queues()
+}
+
+// ListTasks returns the tasks of the queue in the state, one page at a time
+func (tp *TaskProcessor) ListTasks(queue string, state TaskState, opts ListTasksOptions) ([]*asynq.TaskInfo, error) {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+
+	if opts.PageSize < 1 {
+		opts.PageSize = defaultListPageSize
+	}
+
+	listOpts := []asynq.ListOption{asynq.Page(opts.Page), asynq.PageSize(opts.PageSize)}
+
+	switch state {
+	case TaskStatePending:
+		return tp.inspector.ListPendingTasks(queue, listOpts...)
+	case TaskStateActive:
+		return tp.inspector.ListActiveTasks(queue, listOpts...)
+	case TaskStateScheduled:
+		return tp.inspector.ListScheduledTasks(queue, listOpts...)
+	case TaskStateRetry:
+		return tp.inspector.ListRetryTasks(queue, listOpts...)
+	case TaskStateArchived:
+		return tp.inspector.ListArchivedTasks(queue, listOpts...)
+	case TaskStateCompleted:
+		return tp.inspector.ListCompletedTasks(queue, listOpts...)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTaskState, state)
+	}
+}
+
+// GetTask returns a task of the queue. `LastErr` and `LastFailedAt` hold the last error of failed
+// tasks.
+func (tp *TaskProcessor) GetTask(queue, id string) (*asynq.TaskInfo, error) {
+	return tp.inspector.GetTaskInfo(queue, id)
+}
+
+// DeleteTask deletes a task that is not active
+func (tp *TaskProcessor) DeleteTask(queue, id string) error {
+	return tp.inspector.DeleteTask(queue, id)
+}
+
+// RunTask moves a scheduled, retry or archived task to the pending state so that it is processed
+// right away
+func (tp *TaskProcessor) RunTask(queue, id string) error {
+	return tp.inspector.RunTask(queue, id)
+}
+
+// ArchiveTask archives a pending, scheduled or retry task. Archived tasks are not processed until
+// they are run again.
+func (tp *TaskProcessor) ArchiveTask(queue, id string) error {
+	return tp.inspector.ArchiveTask(queue, id)
+}
+
+// RetryArchivedTasks moves every archived task of the queue to the pending state and returns their
+// number
+func (tp *TaskProcessor) RetryArchivedTasks(queue string) (int, error) {
+	return tp.inspector.RunAllArchivedTasks(queue)
+}
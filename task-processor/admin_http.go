@@ -0,0 +1,205 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+)
+
+// taskResponse is the JSON representation of a task
+type taskResponse struct {
+	ID            string     `json:"id"`
+	Queue         string     `json:"queue"`
+	Type          string     `json:"type"`
+	Payload       []byte     `json:"payload,omitempty"`
+	State         string     `json:"state"`
+	MaxRetry      int        `json:"max_retry"`
+	Retried       int        `json:"retried"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailedAt  *time.Time `json:"last_failed_at,omitempty"`
+	NextProcessAt *time.Time `json:"next_process_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	Timeout       string     `json:"timeout,omitempty"`
+	Group         string     `json:"group,omitempty"`
+}
+
+// MountAdminHandler mounts the task admin API on the router under the path prefix, for example the
+// router returned by `instrumentation.Client.NewMuxRouter`. The API is not authenticated, so the
+// router must only be reachable by operators.
+//
+//	GET    {prefix}/queues
+//	POST   {prefix}/queues/{queue}/pause
+//	POST   {prefix}/queues/{queue}/unpause
+//	GET    {prefix}/queues/{queue}/tasks?state=pending&page=1&page_size=30
+//	GET    {prefix}/queues/{queue}/tasks/{id}
+//	DELETE {prefix}/queues/{queue}/tasks/{id}
+//	POST   {prefix}/queues/{queue}/tasks/{id}/run
+//	POST   {prefix}/queues/{queue}/tasks/{id}/archive
+//	POST   {prefix}/queues/{queue}/archived/retry
+//
+// ```go
+//
+//	router := instrumentationClient.NewMuxRouter()
+//	tp.MountAdminHandler(router, "/admin/tasks")
+//
+// ```
+func (tp *TaskProcessor) MountAdminHandler(router *mux.Router, prefix string) {
+	r := router.PathPrefix(strings.TrimSuffix(prefix, "/")).Subrouter()
+
+	r.HandleFunc("/queues", tp.handleListQueues).Methods(http.MethodGet)
+	r.HandleFunc("/queues/{queue}/pause", tp.handleQueueAction(tp.PauseQueue)).Methods(http.MethodPost)
+	r.HandleFunc("/queues/{queue}/unpause", tp.handleQueueAction(tp.UnpauseQueue)).Methods(http.MethodPost)
+	r.HandleFunc("/queues/{queue}/tasks", tp.handleListTasks).Methods(http.MethodGet)
+	r.HandleFunc("/queues/{queue}/tasks/{id}", tp.handleGetTask).Methods(http.MethodGet)
+	r.HandleFunc("/queues/{queue}/tasks/{id}", tp.handleTaskAction(tp.DeleteTask)).Methods(http.MethodDelete)
+	r.HandleFunc("/queues/{queue}/tasks/{id}/run", tp.handleTaskAction(tp.RunTask)).Methods(http.MethodPost)
+	r.HandleFunc("/queues/{queue}/tasks/{id}/archive", tp.handleTaskAction(tp.ArchiveTask)).Methods(http.MethodPost)
+	r.HandleFunc("/queues/{queue}/archived/retry", tp.handleRetryArchived).Methods(http.MethodPost)
+}
+
+func (tp *TaskProcessor) handleListQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := tp.Queues()
+	if err != nil {
+		tp.writeError(w, err)
+		return
+	}
+
+	tp.writeJSON(w, http.StatusOK, map[string][]string{"queues": queues})
+}
+
+func (tp *TaskProcessor) handleListTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	state := TaskState(query.Get("state"))
+	if state == "" {
+		state = TaskStatePending
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	pageSize, _ := strconv.Atoi(query.Get("page_size"))
+
+	tasks, err := tp.ListTasks(mux.Vars(r)["queue"], state, ListTasksOptions{Page: page, PageSize: pageSize})
+	if err != nil {
+		tp.writeError(w, err)
+		return
+	}
+
+	response := make([]taskResponse, 0, len(tasks))
+	for _, task := range tasks {
+		response = append(response, newTaskResponse(task))
+	}
+
+	tp.writeJSON(w, http.StatusOK, map[string][]taskResponse{"tasks": response})
+}
+
+func (tp *TaskProcessor) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	task, err := tp.GetTask(vars["queue"], vars["id"])
+	if err != nil {
+		tp.writeError(w, err)
+		return
+	}
+
+	tp.writeJSON(w, http.StatusOK, newTaskResponse(task))
+}
+
+func (tp *TaskProcessor) handleTaskAction(action func(queue, id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := action(vars["queue"], vars["id"]); err != nil {
+			tp.writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (tp *TaskProcessor) handleQueueAction(action func(queue string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := action(mux.Vars(r)["queue"]); err != nil {
+			tp.writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (tp *TaskProcessor) handleRetryArchived(w http.ResponseWriter, r *http.Request) {
+	n, err := tp.RetryArchivedTasks(mux.Vars(r)["queue"])
+	if err != nil {
+		tp.writeError(w, err)
+		return
+	}
+
+	tp.writeJSON(w, http.StatusOK, map[string]int{"retried": n})
+}
+
+// writeError writes the error with the status code matching it
+func (tp *TaskProcessor) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, asynq.ErrQueueNotFound), errors.Is(err, asynq.ErrTaskNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidTaskState):
+		status = http.StatusBadRequest
+	case strings.Contains(err.Error(), "FAILED_PRECONDITION"):
+		// asynq reports operations invalid in the current task or queue state as failed preconditions
+		status = http.StatusConflict
+	}
+
+	if status == http.StatusInternalServerError {
+		tp.logger.Error("task admin request failed", zap.Error(err))
+	}
+
+	tp.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (tp *TaskProcessor) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		tp.logger.Error("failed to write task admin response", zap.Error(err))
+	}
+}
+
+func newTaskResponse(task *asynq.TaskInfo) taskResponse {
+	response := taskResponse{
+		ID:        task.ID,
+		Queue:     task.Queue,
+		Type:      task.Type,
+		Payload:   task.Payload,
+		State:     task.State.String(),
+		MaxRetry:  task.MaxRetry,
+		Retried:   task.Retried,
+		LastError: task.LastErr,
+		Group:     task.Group,
+	}
+
+	if task.Timeout > 0 {
+		response.Timeout = task.Timeout.String()
+	}
+
+	response.LastFailedAt = optionalTime(task.LastFailedAt)
+	response.NextProcessAt = optionalTime(task.NextProcessAt)
+	response.CompletedAt = optionalTime(task.CompletedAt)
+
+	return response
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
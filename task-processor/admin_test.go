@@ -0,0 +1,106 @@
+package taskprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskAdmin(t *testing.T) {
+	tp, _ := newTestTaskProcessor(t, muxHandler{})
+	ctx := context.Background()
+
+	pending, err := tp.EnqueueTask(ctx, asynq.NewTask("email:welcome", []byte(`{}`)))
+	require.NoError(t, err)
+	scheduled, err := tp.EnqueueTask(ctx, asynq.NewTask("email:digest", nil), asynq.ProcessIn(time.Hour))
+	require.NoError(t, err)
+
+	queues, err := tp.Queues()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default"}, queues)
+
+	tasks, err := tp.ListTasks("default", TaskStatePending, ListTasksOptions{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, pending.ID, tasks[0].ID)
+
+	_, err = tp.ListTasks("default", TaskState("unknown"), ListTasksOptions{})
+	assert.ErrorIs(t, err, ErrInvalidTaskState)
+
+	require.NoError(t, tp.ArchiveTask("default", scheduled.ID))
+	task, err := tp.GetTask("default", scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, asynq.TaskStateArchived, task.State)
+
+	n, err := tp.RetryArchivedTasks("default")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	tasks, err = tp.ListTasks("default", TaskStatePending, ListTasksOptions{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2)
+
+	require.NoError(t, tp.DeleteTask("default", pending.ID))
+	// redis reports asynq.ErrTaskNotFound, the lua scripts of miniredis a generic error
+	_, err = tp.GetTask("default", pending.ID)
+	assert.Error(t, err)
+}
+
+func TestTaskAdminHandler(t *testing.T) {
+	tp, _ := newTestTaskProcessor(t, muxHandler{})
+	ctx := context.Background()
+
+	pending, err := tp.EnqueueTask(ctx, asynq.NewTask("email:welcome", []byte(`{}`)))
+	require.NoError(t, err)
+	scheduled, err := tp.EnqueueTask(ctx, asynq.NewTask("email:digest", nil), asynq.ProcessIn(time.Hour))
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	tp.MountAdminHandler(router, "/admin/tasks/")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{name: "list queues", method: http.MethodGet, path: "/queues", status: http.StatusOK, body: `"default"`},
+		{name: "list pending tasks", method: http.MethodGet, path: "/queues/default/tasks", status: http.StatusOK, body: pending.ID},
+		{name: "list scheduled tasks", method: http.MethodGet, path: "/queues/default/tasks?state=scheduled&page=1&page_size=5", status: http.StatusOK, body: scheduled.ID},
+		{name: "invalid state", method: http.MethodGet, path: "/queues/default/tasks?state=unknown", status: http.StatusBadRequest},
+		{name: "get task", method: http.MethodGet, path: "/queues/default/tasks/" + pending.ID, status: http.StatusOK, body: `"state":"pending"`},
+		{name: "unknown queue", method: http.MethodGet, path: "/queues/unknown/tasks/" + pending.ID, status: http.StatusNotFound},
+		{name: "archive task", method: http.MethodPost, path: "/queues/default/tasks/" + scheduled.ID + "/archive", status: http.StatusNoContent},
+		{name: "archive archived task", method: http.MethodPost, path: "/queues/default/tasks/" + scheduled.ID + "/archive", status: http.StatusConflict},
+		{name: "retry archived tasks", method: http.MethodPost, path: "/queues/default/archived/retry", status: http.StatusOK, body: `"retried":1`},
+		{name: "delete task", method: http.MethodDelete, path: "/queues/default/tasks/" + pending.ID, status: http.StatusNoContent},
+		{name: "pause queue", method: http.MethodPost, path: "/queues/default/pause", status: http.StatusNoContent},
+		{name: "unpause queue", method: http.MethodPost, path: "/queues/default/unpause", status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(tt.method, "/admin/tasks"+tt.path, nil))
+
+			assert.Equal(t, tt.status, recorder.Code, recorder.Body.String())
+			if tt.body != "" {
+				assert.Contains(t, recorder.Body.String(), tt.body)
+			}
+			if recorder.Code >= http.StatusBadRequest {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+				assert.NotEmpty(t, body["error"])
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/hibiken/asynq"
+)
+
+// `ErrRegistryNotSet` is an error that is returned when enqueueing a typed task with a `TaskProcessor`
+// whose task handler is not a `taskhandler.Registry`.
+var ErrRegistryNotSet = errors.New("task handler is not a task registry")
+
+// Enqueue encodes and validates the payload of a task type registered in the task registry of the
+// processor, then enqueues it with the queue, retry and timeout defaults of the task type. Options
+// passed in opts override the defaults.
+//
+// ```go
+//
+//	info, err := taskprocessor.Enqueue(ctx, tp, "email:welcome", WelcomeEmail{UserID: 42}, asynq.ProcessIn(time.Minute))
+//
+// ```
+func Enqueue[T any](ctx context.Context, tp *TaskProcessor, taskType string, payload T, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	registry, ok := tp.taskHandler.(*taskhandler.Registry)
+	if !ok {
+		return nil, ErrRegistryNotSet
+	}
+
+	task, err := taskhandler.NewTask(registry, taskType, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return tp.EnqueueTask(ctx, task)
+}
@@ -0,0 +1,91 @@
+package taskprocessor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type welcomeEmail struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+func (w welcomeEmail) Validate() error {
+	if w.Email == "" {
+		return errors.New("email is required")
+	}
+	return nil
+}
+
+// newTestTaskProcessor creates a task processor backed by an in-memory redis server
+func newTestTaskProcessor(t *testing.T, handler taskhandler.ITaskHandler, opts ...Option) (*TaskProcessor, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	concurrency := 2
+
+	tp, err := NewTaskProcessor(append([]Option{
+		WithRedisAddressOpt("redis://" + server.Addr()),
+		WithLoggerOpt(zap.NewNop()),
+		WithInstrumentationClientOpt(&instrumentation.Client{}),
+		WithConcurrencyFactorOpt(&concurrency),
+		WithTaskHandlerOpt(handler),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = tp.inspector.Close()
+		_ = tp.client.Close()
+		_ = tp.redisClient.Close()
+	})
+
+	return tp, server
+}
+
+func TestEnqueue(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+	require.NoError(t, taskhandler.Register(registry, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		return nil
+	}, taskhandler.WithQueue("critical"), taskhandler.WithMaxRetry(3), taskhandler.WithTimeout(time.Minute)))
+
+	tp, _ := newTestTaskProcessor(t, registry, WithQueuesOpt(map[string]int{"critical": 6, "default": 3, "low": 1}))
+	ctx := context.Background()
+
+	info, err := Enqueue(ctx, tp, "email:welcome", welcomeEmail{UserID: 42, Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "critical", info.Queue)
+	assert.Equal(t, 3, info.MaxRetry)
+	assert.Equal(t, time.Minute, info.Timeout)
+
+	// options passed to Enqueue override the defaults of the task type
+	info, err = Enqueue(ctx, tp, "email:welcome", welcomeEmail{Email: "user@example.com"}, asynq.Queue("low"))
+	require.NoError(t, err)
+	assert.Equal(t, "low", info.Queue)
+
+	_, err = Enqueue(ctx, tp, "email:welcome", welcomeEmail{UserID: 42})
+	assert.ErrorIs(t, err, taskhandler.ErrInvalidPayload)
+
+	_, err = Enqueue(ctx, tp, "unknown", welcomeEmail{Email: "user@example.com"})
+	assert.ErrorIs(t, err, taskhandler.ErrUnknownTaskType)
+}
+
+type muxHandler struct{}
+
+func (muxHandler) RegisterTaskHandler() *asynq.ServeMux { return asynq.NewServeMux() }
+func (muxHandler) Validate() error                      { return nil }
+
+func TestEnqueueWithoutRegistry(t *testing.T) {
+	tp, _ := newTestTaskProcessor(t, muxHandler{})
+
+	_, err := Enqueue(context.Background(), tp, "email:welcome", welcomeEmail{Email: "user@example.com"})
+	assert.ErrorIs(t, err, ErrRegistryNotSet)
+}
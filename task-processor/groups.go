@@ -0,0 +1,49 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// WithGroupAggregatorOpt sets the aggregator of the groups of tasks. With a task registry, the
+// aggregator of the registry is used by default and hands each batch task type its tasks as a slice.
+//
+// ```go
+//
+//	tp, err := NewTaskProcessor(
+//		WithGroupMaxSizeOpt(100),
+//		WithGroupGracePeriodOpt(5*time.Second),
+//		WithGroupMaxDelayOpt(time.Minute),
+//		...
+//	)
+//
+// ```
+func WithGroupAggregatorOpt(aggregator asynq.GroupAggregator) Option {
+	return func(tp *TaskProcessor) {
+		tp.groupAggregator = aggregator
+	}
+}
+
+// WithGroupMaxSizeOpt sets the number of tasks of a group aggregated at once
+func WithGroupMaxSizeOpt(size int) Option {
+	return func(tp *TaskProcessor) {
+		tp.groupMaxSize = size
+	}
+}
+
+// WithGroupGracePeriodOpt sets the time a group waits for another task before it is aggregated. It
+// is at least a second and defaults to a minute.
+func WithGroupGracePeriodOpt(period time.Duration) Option {
+	return func(tp *TaskProcessor) {
+		tp.groupGracePeriod = period
+	}
+}
+
+// WithGroupMaxDelayOpt sets the longest time the first task of a group waits for the group to be
+// aggregated
+func WithGroupMaxDelayOpt(delay time.Duration) Option {
+	return func(tp *TaskProcessor) {
+		tp.groupMaxDelay = delay
+	}
+}
@@ -0,0 +1,72 @@
+package taskprocessor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGroupAggregation(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+
+	var (
+		mu      sync.Mutex
+		batches [][]welcomeEmail
+		done    = make(chan struct{})
+	)
+	require.NoError(t, taskhandler.RegisterBatch(registry, "email:welcome", func(ctx context.Context, p []welcomeEmail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, p)
+		close(done)
+		return nil
+	}))
+
+	tp, _ := newTestTaskProcessor(t, registry, WithGroupMaxSizeOpt(3), WithGroupGracePeriodOpt(time.Second))
+	ctx := context.Background()
+
+	for id := 1; id <= 3; id++ {
+		info, err := Enqueue(ctx, tp, "email:welcome", welcomeEmail{UserID: id, Email: "a@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, "email:welcome", info.Group)
+	}
+
+	require.NoError(t, tp.worker.Start())
+	t.Cleanup(tp.worker.Stop)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the group was not aggregated")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, batches, 1)
+	assert.ElementsMatch(t, []welcomeEmail{
+		{UserID: 1, Email: "a@example.com"},
+		{UserID: 2, Email: "a@example.com"},
+		{UserID: 3, Email: "a@example.com"},
+	}, batches[0])
+}
+
+func TestInvalidGroupGracePeriod(t *testing.T) {
+	concurrency := 2
+	_, err := NewTaskProcessor(
+		WithRedisAddressOpt("redis://localhost:6379"),
+		WithLoggerOpt(zap.NewNop()),
+		WithConcurrencyFactorOpt(&concurrency),
+		WithTaskHandlerOpt(muxHandler{}),
+		WithInstrumentationClientOpt(&instrumentation.Client{}),
+		WithGroupGracePeriodOpt(time.Millisecond),
+	)
+	assert.ErrorIs(t, err, worker.ErrInvalidGroupGracePeriod)
+}
@@ -15,23 +15,34 @@ func (tp *TaskProcessor) EnqueueTask(ctx context.Context, task *asynq.Task, opts
 
 	tp.logger.Info("enqueueing task", zap.Any("task", task))
 
-	return tp.client.EnqueueContext(ctx, task, opts...)
+	return tp.client.EnqueueContext(ctx, task, tp.routeOptions(task, opts)...)
 }
 
 // The `EnqueueRecurringTask` function is used to enqueue a recurring task with a specified interval.
 // It takes in the context, the task to be enqueued, the interval at which the task should be repeated,
 // and optional options for the task. It returns a pointer to a string representing the entry ID of the
-// recurring task and an error if any.
+// recurring task and an error if any. The task is registered again whenever the task processor is
+// restarted, with a new entry ID.
 func (tp *TaskProcessor) EnqueueRecurringTask(ctx context.Context, task *asynq.Task, interval ProcessingInterval, opts ...asynq.Option) (*string, error) {
-	entryID, err := tp.scheduler.Register(interval.String(), task, opts...)
+	if task == nil {
+		return nil, ErrTaskNotSet
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	recurring := recurringTask{cronspec: interval.String(), task: task, opts: tp.routeOptions(task, opts)}
+	entryID, err := tp.ensureScheduler().Register(recurring.cronspec, recurring.task, recurring.opts...)
 	if err != nil {
 		return nil, err
 	}
+	tp.recurringTasks = append(tp.recurringTasks, recurring)
 
 	return &entryID, nil
 }
 
-// Start starts the task processor worker as well as the scheduler
+// Start starts the task processor worker as well as the scheduler. A task processor that was shut
+// down can be started again.
 // ```go
 //
 //			tp, err := NewTaskProcessor(...opts)
@@ -44,16 +55,29 @@ func (tp *TaskProcessor) EnqueueRecurringTask(ctx context.Context, task *asynq.T
 //
 // ```
 func (tp *TaskProcessor) Start() error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if tp.running {
+		return ErrAlreadyStarted
+	}
+
 	// start the worker
 	if err := tp.worker.Start(); err != nil {
 		return err
 	}
 
-	// start the scheduler
-	if err := tp.scheduler.Start(); err != nil {
+	// start the scheduler, stopping the worker if it fails so that both run or neither does
+	if err := tp.ensureScheduler().Start(); err != nil {
+		if shutdownErr := tp.worker.Shutdown(context.Background()); shutdownErr != nil {
+			tp.logger.Error("failed to shut down the worker", zap.Error(shutdownErr))
+		}
 		return err
 	}
 
+	tp.running = true
+	tp.logger.Info("task processor started")
+
 	return nil
 }
 
@@ -74,13 +98,23 @@ func (tp *TaskProcessor) Start() error {
 //
 // ```
 func (tp *TaskProcessor) Close() error {
-	tp.worker.Stop()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		return err
+	}
+
+	// close the redis connections
+	if err := tp.inspector.Close(); err != nil {
+		return err
+	}
 
-	// close the redis connection
 	if err := tp.client.Close(); err != nil {
 		return err
 	}
 
+	if err := tp.redisClient.Close(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
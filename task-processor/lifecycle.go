@@ -0,0 +1,123 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+)
+
+// `ErrAlreadyStarted` is an error that is returned when starting a task processor that is running.
+var ErrAlreadyStarted = errors.New("task processor already started")
+
+// recurringTask is a task registered with EnqueueRecurringTask
+type recurringTask struct {
+	cronspec string
+	task     *asynq.Task
+	opts     []asynq.Option
+}
+
+// WithShutdownTimeoutOpt sets the time the tasks in progress are given to finish on shutdown before
+// they are aborted and processed again later. It defaults to 8 seconds.
+func WithShutdownTimeoutOpt(timeout time.Duration) Option {
+	return func(tp *TaskProcessor) {
+		tp.shutdownTimeout = timeout
+	}
+}
+
+// Shutdown stops the scheduler, then stops the worker and waits for the tasks in progress to finish.
+// It returns the error of the context when it is done first, while the worker carries on shutting
+// down in the background. Shutdown can be registered with `signals.Shutdown.OnShutdown`.
+//
+// ```go
+//
+//	shutdown.OnShutdown("task-processor", tp.Shutdown)
+//
+// ```
+func (tp *TaskProcessor) Shutdown(ctx context.Context) error {
+	tp.mu.Lock()
+	if !tp.running {
+		tp.mu.Unlock()
+		return nil
+	}
+	tp.running = false
+
+	// a scheduler cannot be started again, so the next start creates a new one
+	scheduler := tp.scheduler
+	tp.scheduler = nil
+	tp.mu.Unlock()
+
+	tp.logger.Info("shutting down task processor")
+	scheduler.Shutdown()
+
+	if err := tp.worker.Shutdown(ctx); err != nil {
+		tp.logger.Warn("task processor shutdown timed out", zap.Error(err))
+		return err
+	}
+
+	tp.logger.Info("task processor shut down")
+
+	return nil
+}
+
+// ensureScheduler returns the scheduler, creating it with the recurring tasks when the previous one
+// was shut down. `tp.mu` must be held.
+func (tp *TaskProcessor) ensureScheduler() *asynq.Scheduler {
+	if tp.scheduler != nil {
+		return tp.scheduler
+	}
+
+	tp.scheduler = tp.newScheduler()
+	for _, recurring := range tp.recurringTasks {
+		if _, err := tp.scheduler.Register(recurring.cronspec, recurring.task, recurring.opts...); err != nil {
+			// the cronspec was valid when it was first registered
+			tp.logger.Error("failed to register recurring task",
+				zap.String("task_type", recurring.task.Type()),
+				zap.String("cronspec", recurring.cronspec),
+				zap.Error(err))
+		}
+	}
+
+	return tp.scheduler
+}
+
+// newScheduler creates the scheduler of the recurring tasks
+func (tp *TaskProcessor) newScheduler() *asynq.Scheduler {
+	return asynq.NewScheduler(tp.redisConnOpt, tp.SchedulerOpts())
+}
+
+// RedisConnOpt returns the redis connection of the task processor, for asynq components such as a
+// PeriodicTaskManager
+func (tp *TaskProcessor) RedisConnOpt() asynq.RedisConnOpt {
+	return tp.redisConnOpt
+}
+
+// SchedulerOpts returns scheduler options logging and recording a metric for every recurring task
+// the scheduler enqueues
+func (tp *TaskProcessor) SchedulerOpts() *asynq.SchedulerOpts {
+	return &asynq.SchedulerOpts{
+		PreEnqueueFunc: func(task *asynq.Task, opts []asynq.Option) {
+			tp.logger.Debug("enqueueing recurring task", zap.String("task_type", task.Type()))
+			tp.instrumentationClient.RecordMetric(worker.TaskMetricName(task.Type(), "scheduled"), 1)
+		},
+		PostEnqueueFunc: func(info *asynq.TaskInfo, err error) {
+			// failures are reported by the EnqueueErrorHandler, which knows the task
+			if err != nil {
+				return
+			}
+
+			tp.logger.Info("enqueued recurring task",
+				zap.String("task_type", info.Type),
+				zap.String("task_id", info.ID),
+				zap.String("queue", info.Queue))
+			tp.instrumentationClient.RecordMetric(worker.TaskMetricName(info.Type, "enqueued"), 1)
+		},
+		EnqueueErrorHandler: func(task *asynq.Task, opts []asynq.Option, err error) {
+			tp.logger.Error("failed to enqueue recurring task", zap.String("task_type", task.Type()), zap.Error(err))
+			tp.instrumentationClient.RecordMetric(worker.TaskMetricName(task.Type(), "enqueue_failed"), 1)
+		},
+	}
+}
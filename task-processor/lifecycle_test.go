@@ -0,0 +1,86 @@
+package taskprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+	processed := make(chan int, 10)
+	require.NoError(t, taskhandler.Register(registry, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		processed <- p.UserID
+		return nil
+	}))
+
+	tp, _ := newTestTaskProcessor(t, registry)
+	ctx := context.Background()
+
+	_, err := tp.EnqueueRecurringTask(ctx, asynq.NewTask("report:generate", nil), EveryDay)
+	require.NoError(t, err)
+
+	// the worker and scheduler can be restarted once shut down
+	for run := 1; run <= 2; run++ {
+		require.NoError(t, tp.Start())
+		assert.ErrorIs(t, tp.Start(), ErrAlreadyStarted)
+
+		_, err := Enqueue(ctx, tp, "email:welcome", welcomeEmail{UserID: run, Email: "a@example.com"})
+		require.NoError(t, err)
+
+		select {
+		case id := <-processed:
+			assert.Equal(t, run, id)
+		case <-time.After(10 * time.Second):
+			t.Fatal("the task was not processed")
+		}
+
+		if run == 2 {
+			// the scheduler records its entries every 5 seconds
+			assert.Eventually(t, func() bool {
+				entries, err := tp.inspector.SchedulerEntries()
+				return err == nil && len(entries) == 1 && entries[0].Task.Type() == "report:generate"
+			}, 10*time.Second, 100*time.Millisecond, "recurring tasks are registered again on restart")
+		}
+		require.NoError(t, tp.Shutdown(ctx))
+		assert.Nil(t, tp.scheduler)
+	}
+
+	// shutting down a stopped task processor does nothing
+	require.NoError(t, tp.Shutdown(ctx))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	require.NoError(t, taskhandler.Register(registry, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		close(started)
+		<-release
+		return nil
+	}))
+
+	tp, _ := newTestTaskProcessor(t, registry, WithShutdownTimeoutOpt(time.Minute))
+	require.NoError(t, tp.Start())
+
+	_, err := Enqueue(context.Background(), tp, "email:welcome", welcomeEmail{UserID: 1, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the task was not processed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tp.Shutdown(ctx), context.DeadlineExceeded)
+
+	// the worker finishes shutting down once the task in progress is done
+	close(release)
+}
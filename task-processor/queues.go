@@ -0,0 +1,108 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"fmt"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/hibiken/asynq"
+)
+
+// WithQueuesOpt sets the queues processed by the worker with their priority. With weighted
+// priority, a queue with priority 6 is processed 6 times as often as a queue with priority 1.
+//
+// ```go
+//
+//	tp, err := NewTaskProcessor(
+//		WithQueuesOpt(map[string]int{"critical": 6, "default": 3, "low": 1}),
+//		WithQueueConcurrencyOpt(map[string]int{"low": 2}),
+//		WithTaskQueueRoutingOpt(map[string]string{"report:generate": "low"}),
+//		...
+//	)
+//
+// ```
+func WithQueuesOpt(queues map[string]int) Option {
+	return func(tp *TaskProcessor) {
+		tp.queues = queues
+	}
+}
+
+// WithStrictPriorityOpt processes queues in strict priority order: tasks of a lower priority queue
+// are only processed once every higher priority queue is empty
+func WithStrictPriorityOpt(strict bool) Option {
+	return func(tp *TaskProcessor) {
+		tp.strictPriority = strict
+	}
+}
+
+// WithQueueConcurrencyOpt caps the number of tasks of a queue processed at the same time
+func WithQueueConcurrencyOpt(concurrency map[string]int) Option {
+	return func(tp *TaskProcessor) {
+		tp.queueConcurrency = concurrency
+	}
+}
+
+// WithTaskQueueRoutingOpt routes the tasks of a task type to a queue. The route applies to tasks
+// enqueued without a queue option, and whose task type has no default queue in the task registry.
+// Routes to a queue the worker does not process are rejected by NewTaskProcessor.
+func WithTaskQueueRoutingOpt(routes map[string]string) Option {
+	return func(tp *TaskProcessor) {
+		tp.queueRoutes = routes
+	}
+}
+
+// PauseQueue stops the processing of the tasks of the queue by every worker until it is unpaused.
+// Tasks can still be enqueued to a paused queue.
+func (tp *TaskProcessor) PauseQueue(queue string) error {
+	return tp.inspector.PauseQueue(queue)
+}
+
+// UnpauseQueue resumes the processing of a paused queue
+func (tp *TaskProcessor) UnpauseQueue(queue string) error {
+	return tp.inspector.UnpauseQueue(queue)
+}
+
+// routeOptions returns opts with the queue option of the route of the task type, if the task should
+// be routed
+func (tp *TaskProcessor) routeOptions(task *asynq.Task, opts []asynq.Option) []asynq.Option {
+	queue, ok := tp.queueRoutes[task.Type()]
+	if !ok || queue == "" {
+		return opts
+	}
+
+	for _, opt := range opts {
+		if opt.Type() == asynq.QueueOpt {
+			return opts
+		}
+	}
+
+	if registry, ok := tp.taskHandler.(*taskhandler.Registry); ok {
+		if config, ok := registry.Config(task.Type()); ok && config.Queue != "" {
+			return opts
+		}
+	}
+
+	return append([]asynq.Option{asynq.Queue(queue)}, opts...)
+}
+
+// validateQueueRoutes checks that the queues of the routes and of the task registry are processed by
+// the worker, since tasks enqueued to any other queue would never be processed
+func (tp *TaskProcessor) validateQueueRoutes() error {
+	queues := tp.worker.Queues()
+
+	for taskType, queue := range tp.queueRoutes {
+		if _, ok := queues[queue]; queue != "" && !ok {
+			return fmt.Errorf("task type %s is routed to queue %s which is not processed by the worker", taskType, queue)
+		}
+	}
+
+	if registry, ok := tp.taskHandler.(*taskhandler.Registry); ok {
+		for _, taskType := range registry.TaskTypes() {
+			config, _ := registry.Config(taskType)
+			if _, ok := queues[config.Queue]; config.Queue != "" && !ok {
+				return fmt.Errorf("task type %s defaults to queue %s which is not processed by the worker", taskType, config.Queue)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package taskprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTaskQueueRouting(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+	noop := func(ctx context.Context, p welcomeEmail) error { return nil }
+	require.NoError(t, taskhandler.Register(registry, "email:welcome", noop))
+	require.NoError(t, taskhandler.Register(registry, "email:digest", noop, taskhandler.WithQueue("critical")))
+
+	tp, _ := newTestTaskProcessor(t, registry,
+		WithQueuesOpt(map[string]int{"critical": 6, "default": 3, "low": 1}),
+		WithTaskQueueRoutingOpt(map[string]string{
+			"email:welcome":   "low",
+			"email:digest":    "low",
+			"report:generate": "low",
+		}),
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		task *asynq.Task
+		opts []asynq.Option
+		want string
+	}{
+		{name: "routed", task: asynq.NewTask("report:generate", nil), want: "low"},
+		{name: "not routed", task: asynq.NewTask("report:export", nil), want: "default"},
+		{name: "explicit queue", task: asynq.NewTask("report:generate", nil), opts: []asynq.Option{asynq.Queue("critical")}, want: "critical"},
+		{name: "registry without queue", task: asynq.NewTask("email:welcome", []byte(`{"email":"a@b.c"}`)), want: "low"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := tp.EnqueueTask(ctx, tt.task, tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, info.Queue)
+		})
+	}
+
+	// the default queue of the registry wins over the route
+	info, err := Enqueue(ctx, tp, "email:digest", welcomeEmail{Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "critical", info.Queue)
+}
+
+func TestInvalidQueues(t *testing.T) {
+	concurrency := 2
+	_, err := NewTaskProcessor(
+		WithRedisAddressOpt("redis://localhost:6379"),
+		WithLoggerOpt(zap.NewNop()),
+		WithConcurrencyFactorOpt(&concurrency),
+		WithTaskHandlerOpt(muxHandler{}),
+		WithInstrumentationClientOpt(&instrumentation.Client{}),
+		WithQueuesOpt(map[string]int{"critical": 0}),
+	)
+	assert.Error(t, err)
+}
+
+func TestUnprocessedQueues(t *testing.T) {
+	noop := func(ctx context.Context, p welcomeEmail) error { return nil }
+
+	tests := []struct {
+		name    string
+		handler func(t *testing.T) taskhandler.ITaskHandler
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name:    "route to a processed queue",
+			handler: func(*testing.T) taskhandler.ITaskHandler { return muxHandler{} },
+			opts: []Option{
+				WithQueuesOpt(map[string]int{"default": 3, "low": 1}),
+				WithTaskQueueRoutingOpt(map[string]string{"report:generate": "low"}),
+			},
+		},
+		{
+			name:    "route to an unprocessed queue",
+			handler: func(*testing.T) taskhandler.ITaskHandler { return muxHandler{} },
+			opts: []Option{
+				WithQueuesOpt(map[string]int{"default": 3}),
+				WithTaskQueueRoutingOpt(map[string]string{"report:generate": "low"}),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "route to the default queue without queues",
+			handler: func(*testing.T) taskhandler.ITaskHandler { return muxHandler{} },
+			opts:    []Option{WithTaskQueueRoutingOpt(map[string]string{"report:generate": "default"})},
+		},
+		{
+			name: "registry queue not processed",
+			handler: func(t *testing.T) taskhandler.ITaskHandler {
+				registry := taskhandler.NewRegistry()
+				require.NoError(t, taskhandler.Register(registry, "email:digest", noop, taskhandler.WithQueue("critical")))
+				return registry
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			concurrency := 2
+
+			tp, err := NewTaskProcessor(append([]Option{
+				WithRedisAddressOpt("redis://" + server.Addr()),
+				WithLoggerOpt(zap.NewNop()),
+				WithConcurrencyFactorOpt(&concurrency),
+				WithTaskHandlerOpt(tt.handler(t)),
+				WithInstrumentationClientOpt(&instrumentation.Client{}),
+			}, tt.opts...)...)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "not processed by the worker")
+				return
+			}
+
+			require.NoError(t, err)
+			_ = tp.inspector.Close()
+			_ = tp.client.Close()
+			_ = tp.redisClient.Close()
+		})
+	}
+}
+
+func TestPauseQueue(t *testing.T) {
+	tp, _ := newTestTaskProcessor(t, muxHandler{})
+
+	require.NoError(t, tp.PauseQueue("default"))
+	assert.Error(t, tp.PauseQueue("default"), "the queue is already paused")
+	require.NoError(t, tp.UnpauseQueue("default"))
+	assert.Error(t, tp.UnpauseQueue("default"), "the queue is not paused")
+}
@@ -3,11 +3,15 @@ package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-li
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
 	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
 	"github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -84,6 +88,40 @@ type TaskProcessor struct {
 	// queue at a specific time in the future. It allows you to delay the execution of tasks by specifying
 	// a delay duration or a specific time at which the task should be enqueued.
 	scheduler *asynq.Scheduler
+
+	// The `inspector *asynq.Inspector` property is used to inspect and manage the queues and tasks
+	// stored in Redis, such as pausing a queue at runtime.
+	inspector *asynq.Inspector
+
+	// `queues` holds the queues processed by the worker with their priority, and `strictPriority`
+	// whether they are processed in strict priority order rather than weighted by priority.
+	queues         map[string]int
+	strictPriority bool
+	// `queueConcurrency` caps the number of tasks of a queue processed at the same time.
+	queueConcurrency map[string]int
+	// `queueRoutes` holds the queue of task types enqueued without an explicit queue.
+	queueRoutes map[string]string
+
+	// `redisClient` holds the uniqueness locks of tasks enqueued with EnqueueUniqueTask.
+	redisClient redis.UniversalClient
+
+	// `groupAggregator` aggregates the tasks of a group, the aggregator of the task registry by
+	// default. `groupMaxSize`, `groupGracePeriod` and `groupMaxDelay` bound the size of a group and
+	// the time its tasks wait to be aggregated.
+	groupAggregator  asynq.GroupAggregator
+	groupMaxSize     int
+	groupGracePeriod time.Duration
+	groupMaxDelay    time.Duration
+
+	// `redisConnOpt` holds the redis connection of the schedulers created on start.
+	redisConnOpt asynq.RedisConnOpt
+	// `shutdownTimeout` is the time the tasks in progress are given to finish on shutdown.
+	shutdownTimeout time.Duration
+	// `recurringTasks` holds the recurring tasks registered on the schedulers created on start.
+	recurringTasks []recurringTask
+	// `running` tells whether the worker and scheduler are started, guarded by `mu`.
+	running bool
+	mu      sync.Mutex
 }
 
 // IProcessor is an interface that defines the methods that must be implemented by a task processor
@@ -161,18 +199,19 @@ func NewTaskProcessor(opts ...Option) (*TaskProcessor, error) {
 		asyncClientOpt,
 	)
 
-	schedulerOpts := &asynq.SchedulerOpts{
-		PreEnqueueFunc: func(task *asynq.Task, opts []asynq.Option) {
-			// TODO: we need to emit a metric to new relic that we are enqueuing a recurring task
-		},
-		PostEnqueueFunc: func(info *asynq.TaskInfo, err error) {
-			// TODO: we  need to emit a metric to new relic that we have processed a recurring task
-		},
-		EnqueueErrorHandler: func(task *asynq.Task, opts []asynq.Option, err error) {
-		},
+	tp.redisConnOpt = asyncClientOpt
+	tp.scheduler = tp.newScheduler()
+	tp.inspector = asynq.NewInspector(asyncClientOpt)
+
+	redisClient, ok := asyncClientOpt.MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		return nil, fmt.Errorf("unsupported redis client %T", asyncClientOpt.MakeRedisClient())
 	}
+	tp.redisClient = redisClient
 
-	tp.scheduler = asynq.NewScheduler(asyncClientOpt, schedulerOpts)
+	if registry, ok := tp.taskHandler.(*taskhandler.Registry); ok && tp.groupAggregator == nil {
+		tp.groupAggregator = registry.GroupAggregator()
+	}
 
 	// define the worker
 	worker, err := worker.NewWorker([]worker.Option{
@@ -180,6 +219,15 @@ func NewTaskProcessor(opts ...Option) (*TaskProcessor, error) {
 		worker.WithRedisAddress(tp.redisConnectionAddress),
 		worker.WithTaskHandler(tp.taskHandler),
 		worker.WithInstrumentationClient(tp.instrumentationClient),
+		worker.WithQueues(tp.queues),
+		worker.WithStrictPriority(tp.strictPriority),
+		worker.WithQueueConcurrency(tp.queueConcurrency),
+		worker.WithLogger(tp.logger),
+		worker.WithGroupAggregator(tp.groupAggregator),
+		worker.WithGroupMaxSize(tp.groupMaxSize),
+		worker.WithGroupGracePeriod(tp.groupGracePeriod),
+		worker.WithGroupMaxDelay(tp.groupMaxDelay),
+		worker.WithShutdownTimeout(tp.shutdownTimeout),
 	}...)
 	if err != nil {
 		return nil, err
@@ -188,6 +236,10 @@ func NewTaskProcessor(opts ...Option) (*TaskProcessor, error) {
 	// set the worker
 	tp.worker = worker
 
+	if err := tp.validateQueueRoutes(); err != nil {
+		return nil, err
+	}
+
 	// validate the task processor
 	if err := tp.Validate(); err != nil {
 		return nil, err
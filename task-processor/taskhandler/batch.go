@@ -0,0 +1,144 @@
+package taskhandler // import "github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/hibiken/asynq"
+)
+
+// BatchTaskType is the type of the tasks aggregated by the registry's group aggregator
+const BatchTaskType = "taskhandler:batch"
+
+// BatchHandler processes the decoded payloads of a group of tasks aggregated together
+type BatchHandler[T any] func(ctx context.Context, payloads []T) error
+
+// batchEntry is a task of an aggregated group
+type batchEntry struct {
+	Type    string `json:"type"`
+	Payload []byte `json:"payload"`
+}
+
+// RegisterBatch registers the handler of a task type whose tasks are aggregated into batches. Tasks
+// created with NewTask are enqueued to the group set with WithGroup, the task type by default, and
+// the worker aggregates each group with the GroupAggregator of the registry. The batch size and the
+// time tasks wait for a batch are set on the worker. A task processed on its own, for example when
+// group aggregation is disabled, reaches the handler as a batch of one.
+//
+// ```go
+//
+//	err := taskhandler.RegisterBatch(registry, "notification:push", func(ctx context.Context, p []Notification) error {
+//		return push.SendAll(ctx, p)
+//	})
+//
+// ```
+func RegisterBatch[T any](r *Registry, taskType string, handler BatchHandler[T], opts ...TaskOption) error {
+	if handler == nil {
+		return fmt.Errorf("task type %s: handler is required", taskType)
+	}
+
+	config, err := newTaskConfig[T](taskType, opts)
+	if err != nil {
+		return err
+	}
+
+	if config.Group == "" {
+		config.Group = taskType
+	}
+	encoding := config.Encoding
+
+	return r.register(taskType, &definition{
+		config:      config,
+		payloadType: reflect.TypeOf((*T)(nil)).Elem(),
+		batch:       true,
+		process: func(ctx context.Context, data [][]byte) error {
+			payloads := make([]T, 0, len(data))
+			var invalid []error
+			for _, d := range data {
+				payload, err := decode[T](encoding, d)
+				if err != nil {
+					invalid = append(invalid, err)
+					continue
+				}
+				payloads = append(payloads, payload)
+			}
+
+			if len(payloads) > 0 {
+				if err := handler(ctx, payloads); err != nil {
+					return err
+				}
+			}
+
+			return errors.Join(invalid...)
+		},
+	})
+}
+
+// GroupAggregator returns the aggregator of the groups of tasks of the registry. It aggregates the
+// tasks of a group into a task of type BatchTaskType with the retry and timeout options of the first
+// task type of the group. The batch task hands every registered task type its own tasks: the whole
+// batch to the handler of a batch task type, and each task in turn to any other handler.
+func (r *Registry) GroupAggregator() asynq.GroupAggregator {
+	return asynq.GroupAggregatorFunc(func(group string, tasks []*asynq.Task) *asynq.Task {
+		entries := make([]batchEntry, 0, len(tasks))
+		for _, task := range tasks {
+			entries = append(entries, batchEntry{Type: task.Type(), Payload: task.Payload()})
+		}
+
+		// a slice of strings and bytes always encodes
+		data, _ := json.Marshal(entries)
+
+		var opts []asynq.Option
+		if len(tasks) > 0 {
+			if d, err := r.definition(tasks[0].Type()); err == nil {
+				opts = d.options()
+			}
+		}
+
+		return asynq.NewTask(BatchTaskType, data, opts...)
+	})
+}
+
+// processBatch processes a task aggregated by GroupAggregator. The batch fails, and is retried as a
+// whole, when the handler of any of its task types fails.
+func (r *Registry) processBatch(ctx context.Context, task *asynq.Task) error {
+	var entries []batchEntry
+	if err := json.Unmarshal(task.Payload(), &entries); err != nil {
+		return fmt.Errorf("task %s | %w", task.Type(), invalidPayload(err))
+	}
+
+	// group the payloads by task type, keeping the order of the task types in the batch
+	var types []string
+	payloads := make(map[string][][]byte)
+	for _, entry := range entries {
+		if _, ok := payloads[entry.Type]; !ok {
+			types = append(types, entry.Type)
+		}
+		payloads[entry.Type] = append(payloads[entry.Type], entry.Payload)
+	}
+
+	var errs []error
+	for _, taskType := range types {
+		d, err := r.definition(taskType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task %s | %w", taskType, err))
+			continue
+		}
+
+		if err := d.process(ctx, payloads[taskType]); err != nil {
+			errs = append(errs, fmt.Errorf("task %s | %w", taskType, err))
+		}
+	}
+
+	// the batch is only not retried when no task type would succeed on a retry
+	for _, err := range errs {
+		if !errors.Is(err, asynq.SkipRetry) {
+			return errors.New(errors.Join(errs...).Error())
+		}
+	}
+
+	return errors.Join(errs...)
+}
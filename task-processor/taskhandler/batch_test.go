@@ -0,0 +1,139 @@
+package taskhandler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterBatch(t *testing.T) {
+	r := NewRegistry()
+
+	var batches [][]welcomeEmail
+	require.NoError(t, RegisterBatch(r, "email:welcome", func(ctx context.Context, p []welcomeEmail) error {
+		batches = append(batches, p)
+		return nil
+	}, WithMaxRetry(2), WithTimeout(time.Minute)))
+
+	var singles []welcomeEmail
+	require.NoError(t, Register(r, "email:single", func(ctx context.Context, p welcomeEmail) error {
+		singles = append(singles, p)
+		return nil
+	}))
+
+	task, err := NewTask(r, "email:welcome", welcomeEmail{UserID: 1, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	// a task processed on its own is a batch of one
+	mux := r.RegisterTaskHandler()
+	require.NoError(t, mux.ProcessTask(context.Background(), task))
+	assert.Equal(t, [][]welcomeEmail{{{UserID: 1, Email: "a@example.com"}}}, batches)
+
+	var tasks []*asynq.Task
+	for _, id := range []int{2, 3} {
+		task, err := NewTask(r, "email:welcome", welcomeEmail{UserID: id, Email: "a@example.com"})
+		require.NoError(t, err)
+		tasks = append(tasks, task)
+	}
+	single, err := NewTask(r, "email:single", welcomeEmail{UserID: 4, Email: "a@example.com"})
+	require.NoError(t, err)
+	tasks = append(tasks, single)
+
+	batch := r.GroupAggregator().Aggregate("email:welcome", tasks)
+	assert.Equal(t, BatchTaskType, batch.Type())
+
+	batches = nil
+	require.NoError(t, mux.ProcessTask(context.Background(), batch))
+	assert.Equal(t, [][]welcomeEmail{{{UserID: 2, Email: "a@example.com"}, {UserID: 3, Email: "a@example.com"}}}, batches)
+	assert.Equal(t, []welcomeEmail{{UserID: 4, Email: "a@example.com"}}, singles)
+}
+
+func TestBatchOptions(t *testing.T) {
+	r := NewRegistry()
+	noop := func(ctx context.Context, p []welcomeEmail) error { return nil }
+	require.NoError(t, RegisterBatch(r, "email:welcome", noop))
+	require.NoError(t, RegisterBatch(r, "email:digest", noop, WithGroup("digests")))
+
+	tests := []struct {
+		taskType string
+		want     string
+	}{
+		{taskType: "email:welcome", want: "email:welcome"},
+		{taskType: "email:digest", want: "digests"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.taskType, func(t *testing.T) {
+			config, ok := r.Config(tt.taskType)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, config.Group)
+
+			d, err := r.definition(tt.taskType)
+			require.NoError(t, err)
+			assert.Contains(t, d.enqueueOptions(), asynq.Group(tt.want))
+			assert.NotContains(t, d.options(), asynq.Group(tt.want))
+		})
+	}
+}
+
+func TestProcessBatchErrors(t *testing.T) {
+	errHandler := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		handler   BatchHandler[welcomeEmail]
+		payloads  []string
+		wantCalls int
+		skipRetry bool
+	}{
+		{
+			name:      "invalid payloads are dropped",
+			handler:   func(ctx context.Context, p []welcomeEmail) error { return nil },
+			payloads:  []string{`{"email":"a@example.com"}`, `{"user_id":1}`},
+			wantCalls: 1,
+			skipRetry: true,
+		},
+		{
+			name:      "handler failure is retried",
+			handler:   func(ctx context.Context, p []welcomeEmail) error { return errHandler },
+			payloads:  []string{`{"email":"a@example.com"}`, `{"user_id":1}`},
+			wantCalls: 1,
+		},
+		{
+			name:      "only invalid payloads",
+			handler:   func(ctx context.Context, p []welcomeEmail) error { return nil },
+			payloads:  []string{`{"user_id":1}`},
+			skipRetry: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			calls := 0
+			require.NoError(t, RegisterBatch(r, "email:welcome", func(ctx context.Context, p []welcomeEmail) error {
+				calls++
+				return tt.handler(ctx, p)
+			}))
+
+			var tasks []*asynq.Task
+			for _, payload := range tt.payloads {
+				tasks = append(tasks, asynq.NewTask("email:welcome", []byte(payload)))
+			}
+			// a task of an unregistered type fails the batch without skipping the retry
+			if tt.name == "handler failure is retried" {
+				tasks = append(tasks, asynq.NewTask("unknown", nil))
+			}
+
+			err := r.RegisterTaskHandler().ProcessTask(context.Background(), r.GroupAggregator().Aggregate("g", tasks))
+			require.Error(t, err)
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.skipRetry, errors.Is(err, asynq.SkipRetry))
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package taskhandler // import "github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrNoTaskRegistered is returned when validating a registry without any task type
+	ErrNoTaskRegistered = errors.New("no task type registered")
+	// ErrUnknownTaskType is returned when creating a task of a type that was not registered
+	ErrUnknownTaskType = errors.New("unknown task type")
+	// ErrPayloadType is returned when the payload does not have the type the task type was registered with
+	ErrPayloadType = errors.New("payload type does not match the registered type")
+	// ErrInvalidPayload is returned when a payload cannot be decoded or fails validation. Tasks failing
+	// with it are not retried.
+	ErrInvalidPayload = errors.New("invalid task payload")
+)
+
+// Encoding is the encoding of task payloads
+type Encoding int
+
+const (
+	// EncodingAuto uses protobuf for proto.Message payloads and JSON otherwise
+	EncodingAuto Encoding = iota
+	// EncodingJSON encodes payloads as JSON
+	EncodingJSON
+	// EncodingProtobuf encodes payloads with the protobuf wire format. The payload type must
+	// implement proto.Message.
+	EncodingProtobuf
+)
+
+// validator is implemented by payloads with validation rules, such as messages generated with
+// protoc-gen-validate
+type validator interface {
+	Validate() error
+}
+
+// Handler processes the decoded payload of a task
+type Handler[T any] func(ctx context.Context, payload T) error
+
+// TaskOption configures a task type
+type TaskOption func(*TaskConfig)
+
+// TaskConfig holds the configuration of a task type
+type TaskConfig struct {
+	// Queue is the queue tasks are enqueued to by default
+	Queue string
+	// MaxRetry is the number of retries of failed tasks, -1 to use the asynq default
+	MaxRetry int
+	// Timeout is the processing timeout of tasks, zero to use the asynq default
+	Timeout time.Duration
+	// Encoding is the payload encoding
+	Encoding Encoding
+	// Group is the group tasks of a batch task type are aggregated in, the task type by default
+	Group string
+}
+
+// WithQueue sets the queue tasks of the type are enqueued to
+func WithQueue(queue string) TaskOption {
+	return func(c *TaskConfig) {
+		c.Queue = queue
+	}
+}
+
+// WithMaxRetry sets the number of retries of failed tasks of the type
+func WithMaxRetry(maxRetry int) TaskOption {
+	return func(c *TaskConfig) {
+		c.MaxRetry = maxRetry
+	}
+}
+
+// WithTimeout sets the processing timeout of tasks of the type
+func WithTimeout(timeout time.Duration) TaskOption {
+	return func(c *TaskConfig) {
+		c.Timeout = timeout
+	}
+}
+
+// WithEncoding sets the payload encoding of the type
+func WithEncoding(encoding Encoding) TaskOption {
+	return func(c *TaskConfig) {
+		c.Encoding = encoding
+	}
+}
+
+// WithGroup sets the group tasks of a batch task type are aggregated in. Tasks are only aggregated
+// with the tasks of the same group and queue.
+func WithGroup(group string) TaskOption {
+	return func(c *TaskConfig) {
+		c.Group = group
+	}
+}
+
+// definition is a registered task type
+type definition struct {
+	config      TaskConfig
+	payloadType reflect.Type
+	handler     asynq.Handler
+	// process decodes and handles the encoded payloads of one or more tasks of the type
+	process func(ctx context.Context, payloads [][]byte) error
+	// batch is set for task types registered with RegisterBatch
+	batch bool
+}
+
+// Registry is an ITaskHandler built from typed handlers. Payloads are decoded and validated before
+// they reach the handler, and encoded and validated when tasks are created with NewTask.
+//
+// ```go
+//
+//	registry := taskhandler.NewRegistry()
+//	err := taskhandler.Register(registry, "email:welcome", func(ctx context.Context, p WelcomeEmail) error {
+//		return send(ctx, p.UserID)
+//	}, taskhandler.WithQueue("critical"), taskhandler.WithMaxRetry(5))
+//
+// ```
+type Registry struct {
+	mu          sync.RWMutex
+	definitions map[string]*definition
+	middlewares []asynq.MiddlewareFunc
+}
+
+var _ ITaskHandler = (*Registry)(nil)
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{definitions: make(map[string]*definition)}
+}
+
+// Register registers the handler of a task type. Registering a task type twice is an error.
+func Register[T any](r *Registry, taskType string, handler Handler[T], opts ...TaskOption) error {
+	if handler == nil {
+		return fmt.Errorf("task type %s: handler is required", taskType)
+	}
+
+	config, err := newTaskConfig[T](taskType, opts)
+	if err != nil {
+		return err
+	}
+	encoding := config.Encoding
+
+	return r.register(taskType, &definition{
+		config:      config,
+		payloadType: reflect.TypeOf((*T)(nil)).Elem(),
+		process: func(ctx context.Context, payloads [][]byte) error {
+			var invalid []error
+			for _, data := range payloads {
+				payload, err := decode[T](encoding, data)
+				if err != nil {
+					invalid = append(invalid, err)
+					continue
+				}
+
+				if err := handler(ctx, payload); err != nil {
+					return err
+				}
+			}
+
+			return errors.Join(invalid...)
+		},
+	})
+}
+
+// newTaskConfig applies the options of a task type and resolves its encoding
+func newTaskConfig[T any](taskType string, opts []TaskOption) (TaskConfig, error) {
+	if taskType == "" {
+		return TaskConfig{}, fmt.Errorf("task type is required")
+	}
+
+	config := TaskConfig{MaxRetry: -1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	encoding, err := resolveEncoding[T](config.Encoding)
+	if err != nil {
+		return config, fmt.Errorf("task type %s | %s", taskType, err.Error())
+	}
+	config.Encoding = encoding
+
+	return config, nil
+}
+
+// register adds the definition of a task type
+func (r *Registry) register(taskType string, d *definition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[taskType]; ok {
+		return fmt.Errorf("task type %s is already registered", taskType)
+	}
+
+	d.handler = asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		if err := d.process(ctx, [][]byte{task.Payload()}); err != nil {
+			return fmt.Errorf("task %s | %w", task.Type(), err)
+		}
+
+		return nil
+	})
+	r.definitions[taskType] = d
+
+	return nil
+}
+
+// NewTask encodes and validates the payload and returns a task of the type with the default options
+// of the type followed by opts
+func NewTask[T any](r *Registry, taskType string, payload T, opts ...asynq.Option) (*asynq.Task, error) {
+	d, err := r.definition(taskType)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.payloadType != reflect.TypeOf((*T)(nil)).Elem() {
+		return nil, fmt.Errorf("task type %s expects %s, got %T | %w", taskType, d.payloadType, payload, ErrPayloadType)
+	}
+
+	data, err := encode(d.config.Encoding, payload)
+	if err != nil {
+		return nil, fmt.Errorf("task type %s | %w", taskType, err)
+	}
+
+	return asynq.NewTask(taskType, data, append(d.enqueueOptions(), opts...)...), nil
+}
+
+// Use adds middlewares applied to every task type
+func (r *Registry) Use(middlewares ...asynq.MiddlewareFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// RegisterTaskHandler implements ITaskHandler
+func (r *Registry) RegisterTaskHandler() *asynq.ServeMux {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mux := asynq.NewServeMux()
+	mux.Use(r.middlewares...)
+	for taskType, d := range r.definitions {
+		mux.Handle(taskType, d.handler)
+	}
+	mux.Handle(BatchTaskType, asynq.HandlerFunc(r.processBatch))
+
+	return mux
+}
+
+// Validate implements ITaskHandler
+func (r *Registry) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.definitions) == 0 {
+		return ErrNoTaskRegistered
+	}
+
+	return nil
+}
+
+// Config returns the configuration of a task type
+func (r *Registry) Config(taskType string) (TaskConfig, bool) {
+	d, err := r.definition(taskType)
+	if err != nil {
+		return TaskConfig{}, false
+	}
+
+	return d.config, true
+}
+
+// TaskTypes returns the registered task types, sorted
+func (r *Registry) TaskTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.definitions))
+	for taskType := range r.definitions {
+		types = append(types, taskType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+func (r *Registry) definition(taskType string) (*definition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.definitions[taskType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTaskType, taskType)
+	}
+
+	return d, nil
+}
+
+// enqueueOptions returns the default enqueue options of the task type, including the group of batch
+// task types
+func (d *definition) enqueueOptions() []asynq.Option {
+	opts := d.options()
+	if d.batch {
+		opts = append(opts, asynq.Group(d.config.Group))
+	}
+
+	return opts
+}
+
+// options returns the default queue, retry and timeout options of the task type
+func (d *definition) options() []asynq.Option {
+	var opts []asynq.Option
+	if d.config.Queue != "" {
+		opts = append(opts, asynq.Queue(d.config.Queue))
+	}
+
+	if d.config.MaxRetry >= 0 {
+		opts = append(opts, asynq.MaxRetry(d.config.MaxRetry))
+	}
+
+	if d.config.Timeout > 0 {
+		opts = append(opts, asynq.Timeout(d.config.Timeout))
+	}
+
+	return opts
+}
+
+// resolveEncoding checks that T supports the encoding, choosing one for EncodingAuto
+func resolveEncoding[T any](encoding Encoding) (Encoding, error) {
+	var zero T
+	_, isProto := any(zero).(proto.Message)
+
+	switch encoding {
+	case EncodingAuto:
+		if isProto {
+			return EncodingProtobuf, nil
+		}
+		return EncodingJSON, nil
+	case EncodingProtobuf:
+		if !isProto {
+			return encoding, fmt.Errorf("%T does not implement proto.Message", zero)
+		}
+		return encoding, nil
+	case EncodingJSON:
+		return encoding, nil
+	default:
+		return encoding, fmt.Errorf("unknown encoding %d", encoding)
+	}
+}
+
+// encode validates and encodes the payload
+func encode[T any](encoding Encoding, payload T) ([]byte, error) {
+	if err := validate(payload); err != nil {
+		return nil, err
+	}
+
+	if encoding == EncodingProtobuf {
+		data, err := proto.Marshal(any(payload).(proto.Message))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload | %s", err.Error())
+		}
+		return data, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload | %s", err.Error())
+	}
+
+	return data, nil
+}
+
+// decode decodes and validates the payload. Errors wrap ErrInvalidPayload and asynq.SkipRetry since
+// retrying would fail the same way.
+func decode[T any](encoding Encoding, data []byte) (T, error) {
+	var payload T
+
+	if encoding == EncodingProtobuf {
+		message := any(payload).(proto.Message).ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(data, message); err != nil {
+			return payload, invalidPayload(err)
+		}
+		payload = message.(T)
+	} else if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, invalidPayload(err)
+	}
+
+	if err := validate(payload); err != nil {
+		return payload, invalidPayload(err)
+	}
+
+	return payload, nil
+}
+
+// validate calls the Validate method of payloads that have one
+func validate(payload any) error {
+	v, ok := payload.(validator)
+	if !ok {
+		return nil
+	}
+
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPayload, err.Error())
+	}
+
+	return nil
+}
+
+func invalidPayload(err error) error {
+	if errors.Is(err, ErrInvalidPayload) {
+		return fmt.Errorf("%w | %w", err, asynq.SkipRetry)
+	}
+
+	return fmt.Errorf("%w: %s | %w", ErrInvalidPayload, err.Error(), asynq.SkipRetry)
+}
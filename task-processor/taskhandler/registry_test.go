@@ -0,0 +1,148 @@
+package taskhandler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	msgv1 "github.com/SolomonAIEngineering/backend-core-library/message_queue/generated/message-definition/message_definition/v1"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type welcomeEmail struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+func (w welcomeEmail) Validate() error {
+	if w.Email == "" {
+		return errors.New("email is required")
+	}
+	return nil
+}
+
+func TestRegisterAndProcessJSON(t *testing.T) {
+	r := NewRegistry()
+	assert.ErrorIs(t, r.Validate(), ErrNoTaskRegistered)
+
+	var got welcomeEmail
+	require.NoError(t, Register(r, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		got = p
+		return nil
+	}, WithQueue("critical"), WithMaxRetry(3), WithTimeout(time.Minute)))
+	require.NoError(t, r.Validate())
+
+	assert.Error(t, Register(r, "email:welcome", func(ctx context.Context, p welcomeEmail) error { return nil }))
+	assert.Equal(t, []string{"email:welcome"}, r.TaskTypes())
+
+	config, ok := r.Config("email:welcome")
+	require.True(t, ok)
+	assert.Equal(t, TaskConfig{Queue: "critical", MaxRetry: 3, Timeout: time.Minute, Encoding: EncodingJSON}, config)
+
+	task, err := NewTask(r, "email:welcome", welcomeEmail{UserID: 42, Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"user_id":42,"email":"user@example.com"}`, string(task.Payload()))
+
+	require.NoError(t, r.RegisterTaskHandler().ProcessTask(context.Background(), task))
+	assert.Equal(t, welcomeEmail{UserID: 42, Email: "user@example.com"}, got)
+}
+
+func TestNewTaskErrors(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, Register(r, "email:welcome", func(ctx context.Context, p welcomeEmail) error { return nil }))
+
+	_, err := NewTask(r, "unknown", welcomeEmail{Email: "user@example.com"})
+	assert.ErrorIs(t, err, ErrUnknownTaskType)
+
+	_, err = NewTask(r, "email:welcome", "not a welcome email")
+	assert.ErrorIs(t, err, ErrPayloadType)
+
+	_, err = NewTask(r, "email:welcome", welcomeEmail{UserID: 42})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestProcessInvalidPayloadSkipsRetry(t *testing.T) {
+	r := NewRegistry()
+	called := false
+	require.NoError(t, Register(r, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		called = true
+		return nil
+	}))
+	mux := r.RegisterTaskHandler()
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{name: "malformed", payload: "{"},
+		{name: "invalid", payload: `{"user_id":42}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mux.ProcessTask(context.Background(), asynq.NewTask("email:welcome", []byte(tt.payload)))
+			assert.ErrorIs(t, err, ErrInvalidPayload)
+			assert.ErrorIs(t, err, asynq.SkipRetry)
+			assert.False(t, called)
+		})
+	}
+}
+
+func TestRegisterProtobuf(t *testing.T) {
+	r := NewRegistry()
+
+	var got *msgv1.DeleteAccountMessageFormat
+	require.NoError(t, Register(r, "account:delete", func(ctx context.Context, p *msgv1.DeleteAccountMessageFormat) error {
+		got = p
+		return nil
+	}))
+
+	config, _ := r.Config("account:delete")
+	assert.Equal(t, EncodingProtobuf, config.Encoding)
+
+	msg := &msgv1.DeleteAccountMessageFormat{UserId: 42, Email: "user@example.com"}
+	task, err := NewTask(r, "account:delete", msg)
+	require.NoError(t, err)
+
+	require.NoError(t, r.RegisterTaskHandler().ProcessTask(context.Background(), task))
+	assert.True(t, proto.Equal(msg, got))
+
+	_, err = NewTask(r, "account:delete", &msgv1.DeleteAccountMessageFormat{UserId: 42})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	r := NewRegistry()
+
+	err := Register(r, "json", func(ctx context.Context, p welcomeEmail) error { return nil }, WithEncoding(EncodingProtobuf))
+	assert.Error(t, err)
+
+	require.NoError(t, Register(r, "proto-as-json", func(ctx context.Context, p *msgv1.DeleteAccountMessageFormat) error { return nil }, WithEncoding(EncodingJSON)))
+	config, _ := r.Config("proto-as-json")
+	assert.Equal(t, EncodingJSON, config.Encoding)
+
+	assert.Error(t, Register[welcomeEmail](r, "", func(ctx context.Context, p welcomeEmail) error { return nil }))
+	assert.Error(t, Register[welcomeEmail](r, "nil", nil))
+}
+
+func TestRegistryMiddleware(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, Register(r, "email:welcome", func(ctx context.Context, p welcomeEmail) error { return nil }))
+
+	var seen []string
+	r.Use(func(next asynq.Handler) asynq.Handler {
+		return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+			seen = append(seen, task.Type())
+			return next.ProcessTask(ctx, task)
+		})
+	})
+
+	task, err := NewTask(r, "email:welcome", welcomeEmail{Email: "user@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterTaskHandler().ProcessTask(context.Background(), task))
+	assert.Equal(t, []string{"email:welcome"}, seen)
+}
@@ -0,0 +1,96 @@
+package taskprocessor // import "github.com/SolomonAIEngineering/backend-core-library/task-processor"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/hibiken/asynq"
+)
+
+// uniqueKeyPrefix prefixes the redis keys of the uniqueness locks
+const uniqueKeyPrefix = "taskprocessor:unique:"
+
+// `ErrInvalidUniqueWindow` is an error that is returned when enqueueing a unique task with a window
+// shorter than a second.
+var ErrInvalidUniqueWindow = errors.New("unique window must be at least a second")
+
+// EnqueueUniqueTask enqueues the task unless a task of the same type was enqueued with the same key
+// within the window, in which case it returns an error wrapping `asynq.ErrDuplicateTask`. Unlike
+// `asynq.Unique`, which compares payloads, the key identifies the task, for example to send at most
+// one digest per user every 10 minutes. The window starts when the task is enqueued and holds
+// whether or not the task was processed since.
+//
+// ```go
+//
+//	_, err := tp.EnqueueUniqueTask(ctx, task, userID, 10*time.Minute)
+//	if errors.Is(err, asynq.ErrDuplicateTask) {
+//		// a digest was already enqueued in the last 10 minutes
+//	}
+//
+// ```
+func (tp *TaskProcessor) EnqueueUniqueTask(ctx context.Context, task *asynq.Task, key string, window time.Duration, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if task == nil {
+		return nil, ErrTaskNotSet
+	}
+
+	if window < time.Second {
+		return nil, ErrInvalidUniqueWindow
+	}
+
+	lock := UniqueLockKey(task.Type(), key)
+	acquired, err := tp.redisClient.SetNX(ctx, lock, time.Now().Unix(), window).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire the unique lock of task %s | %s", task.Type(), err.Error())
+	}
+
+	if !acquired {
+		return nil, fmt.Errorf("task %s with key %s | %w", task.Type(), key, asynq.ErrDuplicateTask)
+	}
+
+	info, err := tp.EnqueueTask(ctx, task, opts...)
+	if err != nil {
+		// the task was not enqueued, so the key is free again
+		if releaseErr := tp.redisClient.Del(context.WithoutCancel(ctx), lock).Err(); releaseErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to release the unique lock of task %s | %s", task.Type(), releaseErr.Error()))
+		}
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// EnqueueUnique encodes and validates the payload of a task type registered in the task registry of
+// the processor, then enqueues it with EnqueueUniqueTask
+//
+// ```go
+//
+//	info, err := taskprocessor.EnqueueUnique(ctx, tp, "email:digest", strconv.Itoa(userID), 10*time.Minute, Digest{UserID: userID})
+//
+// ```
+func EnqueueUnique[T any](ctx context.Context, tp *TaskProcessor, taskType, key string, window time.Duration, payload T, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	registry, ok := tp.taskHandler.(*taskhandler.Registry)
+	if !ok {
+		return nil, ErrRegistryNotSet
+	}
+
+	task, err := taskhandler.NewTask(registry, taskType, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return tp.EnqueueUniqueTask(ctx, task, key, window)
+}
+
+// UniqueLockKey returns the redis key holding the uniqueness lock of a task type and key
+func UniqueLockKey(taskType, key string) string {
+	return uniqueKeyPrefix + taskType + ":" + key
+}
+
+// ReleaseUniqueTask frees the key of a task type before its window ends, for example once the task
+// is processed
+func (tp *TaskProcessor) ReleaseUniqueTask(ctx context.Context, taskType, key string) error {
+	return tp.redisClient.Del(ctx, UniqueLockKey(taskType, key)).Err()
+}
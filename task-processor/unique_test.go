@@ -0,0 +1,59 @@
+package taskprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnqueueUnique(t *testing.T) {
+	registry := taskhandler.NewRegistry()
+	require.NoError(t, taskhandler.Register(registry, "email:welcome", func(ctx context.Context, p welcomeEmail) error {
+		return nil
+	}))
+
+	tp, server := newTestTaskProcessor(t, registry)
+	ctx := context.Background()
+
+	_, err := EnqueueUnique(ctx, tp, "email:welcome", "42", 10*time.Minute, welcomeEmail{UserID: 42, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	// the key identifies the task whatever its payload
+	_, err = EnqueueUnique(ctx, tp, "email:welcome", "42", 10*time.Minute, welcomeEmail{UserID: 42, Email: "b@example.com"})
+	assert.ErrorIs(t, err, asynq.ErrDuplicateTask)
+
+	_, err = EnqueueUnique(ctx, tp, "email:welcome", "43", 10*time.Minute, welcomeEmail{UserID: 43, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	// the window holds after the task is processed, until it ends
+	server.FastForward(10 * time.Minute)
+	_, err = EnqueueUnique(ctx, tp, "email:welcome", "42", 10*time.Minute, welcomeEmail{UserID: 42, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, tp.ReleaseUniqueTask(ctx, "email:welcome", "42"))
+	_, err = EnqueueUnique(ctx, tp, "email:welcome", "42", 10*time.Minute, welcomeEmail{UserID: 42, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	_, err = tp.EnqueueUniqueTask(ctx, asynq.NewTask("email:welcome", nil), "44", time.Millisecond)
+	assert.ErrorIs(t, err, ErrInvalidUniqueWindow)
+}
+
+func TestEnqueueUniqueReleasesLockOnFailure(t *testing.T) {
+	tp, server := newTestTaskProcessor(t, muxHandler{})
+	ctx := context.Background()
+
+	task := asynq.NewTask("email:welcome", nil)
+	_, err := tp.EnqueueUniqueTask(ctx, task, "42", time.Minute, asynq.TaskID("conflict"))
+	require.NoError(t, err)
+	require.NoError(t, tp.ReleaseUniqueTask(ctx, "email:welcome", "42"))
+
+	// the task id conflicts, so the task is not enqueued and the key stays free
+	_, err = tp.EnqueueUniqueTask(ctx, task, "42", time.Minute, asynq.TaskID("conflict"))
+	assert.ErrorIs(t, err, asynq.ErrTaskIDConflict)
+	assert.False(t, server.Exists(UniqueLockKey("email:welcome", "42")))
+}
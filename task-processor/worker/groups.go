@@ -0,0 +1,60 @@
+package worker // import "github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+var (
+	// ErrInvalidGroupGracePeriod is returned for a group grace period shorter than a second
+	ErrInvalidGroupGracePeriod = errors.New("group grace period must be at least a second")
+	// ErrInvalidGroupLimit is returned for a negative group max size or max delay
+	ErrInvalidGroupLimit = errors.New("group max size and max delay must not be negative")
+)
+
+// WithGroupAggregator sets the aggregator of the groups of tasks. Tasks enqueued to a group are only
+// aggregated when an aggregator is set.
+func WithGroupAggregator(aggregator asynq.GroupAggregator) Option {
+	return func(w *Worker) {
+		w.groupAggregator = aggregator
+	}
+}
+
+// WithGroupMaxSize sets the number of tasks of a group aggregated at once. A group reaching it is
+// aggregated right away.
+func WithGroupMaxSize(size int) Option {
+	return func(w *Worker) {
+		w.groupMaxSize = size
+	}
+}
+
+// WithGroupGracePeriod sets the time a group waits for another task before it is aggregated. It is
+// at least a second and defaults to a minute.
+func WithGroupGracePeriod(period time.Duration) Option {
+	return func(w *Worker) {
+		w.groupGracePeriod = period
+	}
+}
+
+// WithGroupMaxDelay sets the longest time the first task of a group waits for the group to be
+// aggregated
+func WithGroupMaxDelay(delay time.Duration) Option {
+	return func(w *Worker) {
+		w.groupMaxDelay = delay
+	}
+}
+
+// validateGroups checks the group aggregation settings, which asynq panics on
+func (w *Worker) validateGroups() error {
+	if w.groupGracePeriod != 0 && w.groupGracePeriod < time.Second {
+		return ErrInvalidGroupGracePeriod
+	}
+
+	if w.groupMaxSize < 0 || w.groupMaxDelay < 0 {
+		return ErrInvalidGroupLimit
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{name: "defaults"},
+		{name: "limits", opts: []Option{WithGroupMaxSize(100), WithGroupGracePeriod(time.Second), WithGroupMaxDelay(time.Minute)}},
+		{name: "grace period under a second", opts: []Option{WithGroupGracePeriod(time.Millisecond)}, wantErr: ErrInvalidGroupGracePeriod},
+		{name: "negative max size", opts: []Option{WithGroupMaxSize(-1)}, wantErr: ErrInvalidGroupLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWorker(append([]Option{
+				WithRedisAddress("redis://localhost:6379"),
+				WithConcurrencyFactor(1),
+				WithTaskHandler(muxHandler{mux: asynq.NewServeMux()}),
+				WithInstrumentationClient(&instrumentation.Client{}),
+			}, tt.opts...)...)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
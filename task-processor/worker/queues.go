@@ -0,0 +1,105 @@
+package worker // import "github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hibiken/asynq"
+)
+
+// DefaultQueue is the queue tasks are enqueued to when no queue is specified
+const DefaultQueue = "default"
+
+var (
+	ErrInvalidQueuePriority    = errors.New("queue priority must be positive")
+	ErrInvalidQueueConcurrency = errors.New("queue concurrency must be positive")
+)
+
+// WithQueues sets the queues processed by the worker with their priority. With weighted priority,
+// a queue with priority 6 is processed 6 times as often as a queue with priority 1. Defaults to the
+// default queue only.
+func WithQueues(queues map[string]int) Option {
+	return func(w *Worker) {
+		w.queues = queues
+	}
+}
+
+// WithStrictPriority processes queues in strict priority order: tasks of a lower priority queue are
+// only processed once every higher priority queue is empty
+func WithStrictPriority(strict bool) Option {
+	return func(w *Worker) {
+		w.strictPriority = strict
+	}
+}
+
+// WithQueueConcurrency caps the number of tasks of a queue processed at the same time. Tasks waiting
+// for a slot of their queue hold one of the concurrency factor workers meanwhile, so caps are meant
+// to keep a queue from starving the others rather than to reserve capacity.
+func WithQueueConcurrency(concurrency map[string]int) Option {
+	return func(w *Worker) {
+		w.queueConcurrency = concurrency
+	}
+}
+
+// Queues returns the queues processed by the worker with their priority
+func (w *Worker) Queues() map[string]int {
+	if len(w.queues) == 0 {
+		return map[string]int{DefaultQueue: 1}
+	}
+
+	return w.queues
+}
+
+// validateQueues checks the queue priorities and concurrency caps
+func (w *Worker) validateQueues() error {
+	for queue, priority := range w.queues {
+		if priority <= 0 {
+			return fmt.Errorf("queue %s | %w", queue, ErrInvalidQueuePriority)
+		}
+	}
+
+	queues := w.Queues()
+	for queue, concurrency := range w.queueConcurrency {
+		if concurrency <= 0 {
+			return fmt.Errorf("queue %s | %w", queue, ErrInvalidQueueConcurrency)
+		}
+
+		if _, ok := queues[queue]; !ok {
+			return fmt.Errorf("queue %s has a concurrency cap but is not processed by the worker", queue)
+		}
+	}
+
+	return nil
+}
+
+// newQueueSlots creates the semaphores enforcing the queue concurrency caps
+func newQueueSlots(concurrency map[string]int) map[string]chan struct{} {
+	slots := make(map[string]chan struct{}, len(concurrency))
+	for queue, n := range concurrency {
+		slots[queue] = make(chan struct{}, n)
+	}
+
+	return slots
+}
+
+// queueConcurrencyMiddleware holds tasks until their queue has a free slot. asynq applies the
+// middlewares for every task, so the slots are created once with the worker.
+func (w *Worker) queueConcurrencyMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		queue, _ := asynq.GetQueueName(ctx)
+		slot, ok := w.queueSlots[queue]
+		if !ok {
+			return next.ProcessTask(ctx, t)
+		}
+
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-slot }()
+
+		return next.ProcessTask(ctx, t)
+	})
+}
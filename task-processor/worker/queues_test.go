@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type muxHandler struct {
+	mux *asynq.ServeMux
+}
+
+func (h muxHandler) RegisterTaskHandler() *asynq.ServeMux { return h.mux }
+func (h muxHandler) Validate() error                      { return nil }
+
+func TestValidateQueues(t *testing.T) {
+	tests := []struct {
+		name        string
+		queues      map[string]int
+		concurrency map[string]int
+		wantErr     bool
+	}{
+		{name: "default queue"},
+		{name: "weighted", queues: map[string]int{"critical": 6, "low": 1}, concurrency: map[string]int{"low": 1}},
+		{name: "cap on the default queue", concurrency: map[string]int{DefaultQueue: 2}},
+		{name: "invalid priority", queues: map[string]int{"critical": 0}, wantErr: true},
+		{name: "invalid cap", queues: map[string]int{"low": 1}, concurrency: map[string]int{"low": 0}, wantErr: true},
+		{name: "cap on unknown queue", queues: map[string]int{"low": 1}, concurrency: map[string]int{"other": 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWorker(
+				WithRedisAddress("redis://localhost:6379"),
+				WithConcurrencyFactor(4),
+				WithTaskHandler(muxHandler{mux: asynq.NewServeMux()}),
+				WithInstrumentationClient(&instrumentation.Client{}),
+				WithQueues(tt.queues),
+				WithQueueConcurrency(tt.concurrency),
+			)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestQueueConcurrency(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisOpt := asynq.RedisClientOpt{Addr: server.Addr()}
+
+	var (
+		running, maxRunning int32
+		wg                  sync.WaitGroup
+	)
+	mux := asynq.NewServeMux()
+	mux.HandleFunc("slow", func(ctx context.Context, t *asynq.Task) error {
+		defer wg.Done()
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	w, err := NewWorker(
+		WithRedisAddress("redis://"+server.Addr()),
+		WithConcurrencyFactor(4),
+		WithTaskHandler(muxHandler{mux: mux}),
+		WithInstrumentationClient(&instrumentation.Client{}),
+		WithQueues(map[string]int{"low": 1}),
+		WithQueueConcurrency(map[string]int{"low": 1}),
+	)
+	require.NoError(t, err)
+
+	client := asynq.NewClient(redisOpt)
+	defer client.Close()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		_, err := client.Enqueue(asynq.NewTask("slow", nil), asynq.Queue("low"))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, w.Start())
+	defer w.Stop()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("tasks were not processed")
+	}
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&maxRunning))
+}
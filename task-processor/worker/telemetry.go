@@ -0,0 +1,102 @@
+package worker // import "github.com/SolomonAIEngineering/backend-core-library/task-processor/worker"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"go.uber.org/zap"
+)
+
+// ErrTaskPanicked is returned for a task whose handler panicked
+var ErrTaskPanicked = errors.New("task panicked")
+
+// Task outcomes recorded as metrics
+const (
+	TaskOutcomeSucceeded = "succeeded"
+	TaskOutcomeFailed    = "failed"
+	TaskOutcomePanicked  = "panicked"
+)
+
+// Attributes of the transaction of a task
+const (
+	AttributeTaskID          = "task.id"
+	AttributeTaskType        = "task.type"
+	AttributeTaskQueue       = "task.queue"
+	AttributeTaskRetryCount  = "task.retryCount"
+	AttributeTaskMaxRetry    = "task.maxRetry"
+	AttributeTaskPayloadSize = "task.payloadSize"
+	AttributeTaskOutcome     = "task.outcome"
+)
+
+// TaskTransactionName returns the name of the transaction of a task type
+func TaskTransactionName(taskType string) string {
+	return "Task/" + taskType
+}
+
+// TaskMetricName returns the name of a metric of a task type: `duration` holds the processing time in
+// seconds and every outcome counts the tasks ending with it.
+func TaskMetricName(taskType, name string) string {
+	return fmt.Sprintf("Custom/TaskProcessor/%s/%s", taskType, name)
+}
+
+// instrumentationMiddleware processes every task in its own background transaction, records the
+// processing time and outcome of the task type, and turns a panic into a failed task.
+func (w *Worker) instrumentationMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) (err error) {
+		txn := w.instrumentationClient.StartTransaction(TaskTransactionName(t.Type()))
+		defer txn.End()
+		addTaskAttributes(ctx, txn, t)
+
+		start := time.Now()
+		outcome := TaskOutcomeSucceeded
+		defer func() {
+			if r := recover(); r != nil {
+				outcome = TaskOutcomePanicked
+				err = fmt.Errorf("%w: %v", ErrTaskPanicked, r)
+				w.logger.Error("task panicked",
+					zap.String("task_type", t.Type()),
+					zap.Any("panic", r),
+					zap.ByteString("stack", debug.Stack()))
+			} else if err != nil {
+				outcome = TaskOutcomeFailed
+				w.logger.Warn("task failed", zap.String("task_type", t.Type()), zap.Error(err))
+			}
+
+			if err != nil {
+				txn.NoticeError(err)
+			}
+			txn.AddAttribute(AttributeTaskOutcome, outcome)
+
+			w.recordMetric(TaskMetricName(t.Type(), "duration"), time.Since(start).Seconds())
+			w.recordMetric(TaskMetricName(t.Type(), outcome), 1)
+		}()
+
+		return next.ProcessTask(newrelic.NewContext(ctx, txn), t)
+	})
+}
+
+func addTaskAttributes(ctx context.Context, txn *newrelic.Transaction, t *asynq.Task) {
+	txn.AddAttribute(AttributeTaskType, t.Type())
+	txn.AddAttribute(AttributeTaskPayloadSize, len(t.Payload()))
+
+	if id, ok := asynq.GetTaskID(ctx); ok {
+		txn.AddAttribute(AttributeTaskID, id)
+	}
+
+	if queue, ok := asynq.GetQueueName(ctx); ok {
+		txn.AddAttribute(AttributeTaskQueue, queue)
+	}
+
+	if retryCount, ok := asynq.GetRetryCount(ctx); ok {
+		txn.AddAttribute(AttributeTaskRetryCount, retryCount)
+	}
+
+	if maxRetry, ok := asynq.GetMaxRetry(ctx); ok {
+		txn.AddAttribute(AttributeTaskMaxRetry, maxRetry)
+	}
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type metricRecorder struct {
+	mu      sync.Mutex
+	metrics map[string]float64
+}
+
+func (r *metricRecorder) record(name string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] += value
+}
+
+func TestInstrumentationMiddleware(t *testing.T) {
+	errTask := errors.New("boom")
+
+	tests := []struct {
+		name    string
+		handler asynq.HandlerFunc
+		outcome string
+		wantErr error
+		logged  string
+	}{
+		{
+			name:    "succeeded",
+			handler: func(ctx context.Context, t *asynq.Task) error { return nil },
+			outcome: TaskOutcomeSucceeded,
+		},
+		{
+			name:    "failed",
+			handler: func(ctx context.Context, t *asynq.Task) error { return errTask },
+			outcome: TaskOutcomeFailed,
+			wantErr: errTask,
+			logged:  "task failed",
+		},
+		{
+			name:    "panicked",
+			handler: func(ctx context.Context, t *asynq.Task) error { panic("boom") },
+			outcome: TaskOutcomePanicked,
+			wantErr: ErrTaskPanicked,
+			logged:  "task panicked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			recorder := &metricRecorder{metrics: map[string]float64{}}
+
+			w, err := NewWorker(
+				WithRedisAddress("redis://localhost:6379"),
+				WithConcurrencyFactor(1),
+				WithTaskHandler(muxHandler{mux: asynq.NewServeMux()}),
+				WithInstrumentationClient(&instrumentation.Client{}),
+				WithLogger(zap.New(core)),
+			)
+			require.NoError(t, err)
+			w.recordMetric = recorder.record
+
+			err = w.instrumentationMiddleware(tt.handler).ProcessTask(context.Background(), asynq.NewTask("email:welcome", []byte("{}")))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, float64(1), recorder.metrics[TaskMetricName("email:welcome", tt.outcome)])
+			assert.Contains(t, recorder.metrics, TaskMetricName("email:welcome", "duration"))
+			assert.Len(t, recorder.metrics, 2)
+
+			if tt.logged != "" {
+				entries := logs.FilterMessage(tt.logged).All()
+				require.Len(t, entries, 1)
+				if tt.outcome == TaskOutcomePanicked {
+					assert.Contains(t, entries[0].ContextMap()["stack"], "runtime/debug.Stack")
+				}
+			} else {
+				assert.Zero(t, logs.Len())
+			}
+		})
+	}
+}
@@ -3,11 +3,13 @@ package worker // import "github.com/SolomonAIEngineering/backend-core-library/t
 import (
 	"context"
 	"errors"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/SolomonAIEngineering/backend-core-library/instrumentation"
 	"github.com/SolomonAIEngineering/backend-core-library/task-processor/taskhandler"
 	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
 )
 
 // `type Worker struct` is defining a new struct type called `Worker`. This struct type has three
@@ -44,6 +46,39 @@ type Worker struct {
 	// `Worker` struct, the worker can send metrics and traces to a monitoring system to help diagnose and
 	// troubleshoot issues with the task processing system.
 	instrumentationClient *instrumentation.Client
+	// `logger` logs the failed and panicking tasks. It defaults to a no-op logger.
+	logger *zap.Logger
+	// `recordMetric` records the task metrics. It defaults to the instrumentation client.
+	recordMetric func(name string, value float64)
+
+	// `queues` holds the queues processed by the worker with their priority. The `default` queue is
+	// processed when it is empty.
+	queues map[string]int
+	// `strictPriority` processes the queues in strict priority order rather than weighted by priority.
+	strictPriority bool
+	// `queueConcurrency` caps the number of tasks of a queue processed at the same time.
+	queueConcurrency map[string]int
+	// `queueSlots` holds a semaphore per queue with a concurrency cap.
+	queueSlots map[string]chan struct{}
+
+	// `groupAggregator` aggregates the tasks of a group into one task. Groups are not aggregated
+	// without it.
+	groupAggregator asynq.GroupAggregator
+	// `groupMaxSize` is the number of tasks of a group aggregated at once.
+	groupMaxSize int
+	// `groupGracePeriod` is the time a group waits for another task before it is aggregated.
+	groupGracePeriod time.Duration
+	// `groupMaxDelay` is the longest time a task waits for its group to be aggregated.
+	groupMaxDelay time.Duration
+
+	// `shutdownTimeout` is the time the tasks in progress are given to finish on shutdown.
+	shutdownTimeout time.Duration
+	// `redisConnOpt` holds the redis connection of the servers created on start.
+	redisConnOpt asynq.RedisConnOpt
+	// `mux` is the handler of the tasks, built on the first start.
+	mux *asynq.ServeMux
+	// `mu` guards `srv` and `mux` across starts and shutdowns.
+	mu sync.Mutex
 }
 
 var (
@@ -91,16 +126,42 @@ func NewWorker(opts ...Option) (*Worker, error) {
 		return nil, err
 	}
 
-	// create a new worker srv instance
-	w.srv = asynq.NewServer(
-		asyncClientOpt,
-		asynq.Config{Concurrency: w.concurrencyFactor},
-	)
+	if w.logger == nil {
+		w.logger = zap.NewNop()
+	}
+
+	if w.recordMetric == nil {
+		w.recordMetric = w.instrumentationClient.RecordMetric
+	}
+
+	w.queueSlots = newQueueSlots(w.queueConcurrency)
+
+	w.redisConnOpt = asyncClientOpt
+	w.srv = w.newServer()
 
 	return w, nil
 }
 
-// Start starts the worker
+// newServer creates the asynq server processing the tasks. A server cannot be started again once
+// it is shut down, so the worker creates one per run.
+func (w *Worker) newServer() *asynq.Server {
+	return asynq.NewServer(
+		w.redisConnOpt,
+		asynq.Config{
+			Concurrency:     w.concurrencyFactor,
+			Queues:          w.Queues(),
+			StrictPriority:  w.strictPriority,
+			ShutdownTimeout: w.shutdownTimeout,
+
+			GroupAggregator:  w.groupAggregator,
+			GroupMaxSize:     w.groupMaxSize,
+			GroupGracePeriod: w.groupGracePeriod,
+			GroupMaxDelay:    w.groupMaxDelay,
+		},
+	)
+}
+
+// Start starts the worker. A worker that was shut down can be started again.
 // ```go
 //
 //	worker, err := NewWorker(
@@ -118,38 +179,52 @@ func NewWorker(opts ...Option) (*Worker, error) {
 //
 // ```
 func (w *Worker) Start() error {
-	mux := w.taskHandler.RegisterTaskHandler()
-	mux.Use(w.instrumentationMiddleware)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// the handler is built once since the middlewares would be added to it again on every run
+	if w.mux == nil {
+		w.mux = w.taskHandler.RegisterTaskHandler()
+		w.mux.Use(w.queueConcurrencyMiddleware, w.instrumentationMiddleware)
+	}
 
-	return w.srv.Start(mux)
+	if w.srv == nil {
+		w.srv = w.newServer()
+	}
+
+	return w.srv.Start(w.mux)
 }
 
-// `func (w *Worker) instrumentationMiddleware(next asynq.Handler) asynq.Handler` is a middleware
-// function that wraps the `asynq.Handler` passed as an argument to it. It returns a new
-// `asynq.Handler` that adds instrumentation to the processing of tasks by the worker. Specifically, it
-// creates a new trace span for each task processed by the worker and sends metrics to a monitoring
-// system to track the success or failure of each task. The middleware function takes the
-// `asynq.Handler` passed as an argument and returns a new `asynq.Handler` that wraps the original
-// handler. The new handler executes the original handler and then sends metrics and traces to the
-// monitoring system based on the success or failure of the task.
-func (w *Worker) instrumentationMiddleware(next asynq.Handler) asynq.Handler {
-	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
-		tnxName := fmt.Sprintf("processing_task/%s", t.Type())
-		txn := w.instrumentationClient.GetTraceFromContext(ctx)
-		span := w.instrumentationClient.StartSegment(txn, tnxName)
-		defer span.End()
-
-		err := next.ProcessTask(ctx, t)
-		// TODO: decrement task in progress counter metric
-		if err != nil {
-			txnName := fmt.Sprintf("task_failed/%s", t.Type())
-			w.instrumentationClient.RecordMetric(txnName, 1)
-		}
-
-		txnName := fmt.Sprintf("task_suceeded/%s", t.Type())
-		w.instrumentationClient.RecordMetric(txnName, 1)
-		return err
-	})
+// Shutdown stops pulling tasks off the queues and waits for the tasks in progress to finish. Tasks
+// still running after the shutdown timeout of the worker are aborted and processed again later.
+// Shutdown returns the error of the context when it is done before the worker is shut down, while
+// the shutdown carries on in the background.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	srv := w.srv
+	w.srv = nil
+	w.mu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// signals the server to stop pulling new tasks off queues before shutting it down, so that
+		// the tasks in progress are processed before the server shuts down
+		srv.Stop()
+		srv.Shutdown()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Stop stops the worker
@@ -165,13 +240,7 @@ func (w *Worker) instrumentationMiddleware(next asynq.Handler) asynq.Handler {
 //
 //	defer worker.Stop()
 func (w *Worker) Stop() {
-	// signals the server to stop pulling new tasks off queues.
-	// Stop is used before shutting down the server to ensure that all currently active tasks are
-	// processed before server shutdown.
-	w.srv.Stop()
-
-	// shutdown the server
-	w.srv.Shutdown()
+	_ = w.Shutdown(context.Background())
 }
 
 // `func (w *Worker) Validate() error` is a method of the `Worker` struct that validates whether the
@@ -195,6 +264,14 @@ func (w *Worker) Validate() error {
 		return ErrInstrumentationClientNotSet
 	}
 
+	if err := w.validateQueues(); err != nil {
+		return err
+	}
+
+	if err := w.validateGroups(); err != nil {
+		return err
+	}
+
 	// validate the worker
 	return nil
 }
@@ -226,3 +303,18 @@ func WithInstrumentationClient(instrumentationClient *instrumentation.Client) Op
 		w.instrumentationClient = instrumentationClient
 	}
 }
+
+// WithLogger sets the logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(w *Worker) {
+		w.logger = logger
+	}
+}
+
+// WithShutdownTimeout sets the time the tasks in progress are given to finish on shutdown. It
+// defaults to 8 seconds.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(w *Worker) {
+		w.shutdownTimeout = timeout
+	}
+}